@@ -3,32 +3,6 @@ create database "unittests";
 
 \c "unittests";
 
--- resource & journal creates
-drop table if exists "Journal";
-drop table if exists "Resources";
-drop index if exists "IX_Resources_OwnerId";
-
-create table if not exists "Journal" (
-	"Clock" bigint not null generated by default as identity,
-	"Resource" text null,
-	"UpdatedAt" timestamp without time zone not null,
-	"PartitionName" varchar(20) null,
-	constraint "PK_Journal" primary key ("Clock", "PartitionName")
-);
-
-create table if not exists "Resources" (
-	"Id" varchar(50) not null,
-	"OwnerId" varchar(50) not null,
-	"Version" integer not null,
-	"UpdatedAt" timestamp without time zone not null,
-	"Deleted" boolean not null,
-	"Resource" text null,
-	constraint "PK_Resources" primary key ("Id")
-);
-
-create index if not exists "IX_Resources_OwnerId" ON "Resources" ("OwnerId");
-
---select * from public."Journal";
---select * from public."Resources";
-
-select max("Clock") as "Clock" from public."Journal";
+-- the Resources and Journal tables (and those of any other noun), and the JournalCheckpoints table of the journal
+-- followers, are created by the resource store's embedded migrations (pkg/resourceStore/migrations) the first time
+-- a service starts against this database
@@ -0,0 +1,150 @@
+// siftd-journal backs up, restores and repairs the tables of a noun service's resource store. It reads the same
+// configuration as the service (SIFTD_ENV_FILE or -env, overridden by environment variables), e.g.
+//
+//	siftd-journal -env app.env export -file employees.ndjson
+//	siftd-journal -env app.env export -from 1000 -to 2000 > range.ndjson
+//	siftd-journal -env new.app.env import -file employees.ndjson
+//	siftd-journal -env app.env check
+//	siftd-journal -env app.env rebuild
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// anyResource lets the store be opened without knowing the noun - the commands only handle raw JSON
+type anyResource struct {
+	resourceStore.ResourceBase
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: siftd-journal [-env file] <command> [flags]
+
+commands:
+  export   write the journal as NDJSON (-file, default stdout; -from and -to select a clock range)
+  import   load an export into empty tables and rebuild the resources from it (-file, default stdin)
+  rebuild  replace the resources with the latest journal entry of each
+  check    list the resources that don't match their latest journal entry (exits 1 if any)
+
+global flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	envFile := flag.String("env", os.Getenv("SIFTD_ENV_FILE"), "the service's config file")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+
+	configuration := viper.New()
+	if *envFile != "" {
+		configuration.SetConfigFile(*envFile)
+		if err := configuration.ReadInConfig(); err != nil {
+			logger.Fatalf("siftd-journal - failed to read config %s: %v", *envFile, err)
+		}
+	}
+	configuration.AutomaticEnv()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, flag.Arg(0), flag.Args()[1:], configuration, logger); err != nil {
+		logger.Errorf("siftd-journal - %s failed: %v", flag.Arg(0), err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, args []string, configuration *viper.Viper, logger *logrus.Logger) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	file := flags.String("file", "", "the NDJSON file (default stdout for export, stdin for import)")
+	from := flags.Uint64("from", 0, "the first clock to export (0 for the start of the journal)")
+	to := flags.Uint64("to", 0, "the last clock to export (0 for the end of the journal)")
+	flags.Parse(args)
+
+	switch command {
+	case "export", "import", "rebuild", "check":
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[anyResource](configuration, logger, resourceStore.TableNamesFromConfig(configuration))
+	if err != nil {
+		return err
+	}
+	defer store.Close(context.Background())
+
+	switch command {
+	case "export":
+		var w io.Writer = os.Stdout
+		if *file != "" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		count, err := store.ExportJournal(ctx, resourceStore.JournalRange{FromClock: *from, ToClock: *to}, w)
+		if err != nil {
+			return err
+		}
+		logger.Infof("siftd-journal - exported %d journal entries", count)
+
+	case "import":
+		var r io.Reader = os.Stdin
+		if *file != "" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		count, err := store.ImportJournal(ctx, r)
+		if err != nil {
+			return err
+		}
+		logger.Infof("siftd-journal - imported %d journal entries", count)
+
+	case "rebuild":
+		count, err := store.RebuildResources(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Infof("siftd-journal - rebuilt %d resources", count)
+
+	case "check":
+		var inconsistencies []resourceStore.ResourceInconsistency
+		if err := store.CheckConsistency(ctx, &inconsistencies); err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		for _, inconsistency := range inconsistencies {
+			encoder.Encode(inconsistency)
+		}
+		if len(inconsistencies) > 0 {
+			return fmt.Errorf("found %d inconsistent resources (rebuild repairs them)", len(inconsistencies))
+		}
+		logger.Info("siftd-journal - the resources match the journal")
+	}
+	return nil
+}
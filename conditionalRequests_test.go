@@ -0,0 +1,221 @@
+package unittests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/helpers"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+)
+
+func TestParseETagMatch(t *testing.T) {
+	if serviceBase.VersionETag(3) != `"3"` {
+		t.Fatalf("Expected a quoted version, got %s", serviceBase.VersionETag(3))
+	}
+
+	match, err := serviceBase.ParseETagMatch(` "1", W/"2" ,"x"`)
+	if err != nil || match.Any || len(match.Tags) != 3 || !match.Tags[1].Weak || match.Tags[2].Opaque != "x" {
+		t.Fatalf("Expected three tags, got %+v, %v", match, err)
+	}
+	if !match.MatchesVersion(1, true) || match.MatchesVersion(2, true) || !match.MatchesVersion(2, false) || match.MatchesVersion(3, false) {
+		t.Fatalf("Expected a weak tag to only match with the weak comparison, got %+v", match)
+	}
+
+	if match, err := serviceBase.ParseETagMatch("*"); err != nil || !match.Any || !match.MatchesVersion(7, true) {
+		t.Fatalf("Expected * to match any version, got %+v, %v", match, err)
+	}
+	for _, invalid := range []string{"", "1", `"1`, `"1",`, `W/1`, `"a"b"`} {
+		if _, err := serviceBase.ParseETagMatch(invalid); err == nil {
+			t.Fatalf("Expected an error parsing '%s'", invalid)
+		}
+	}
+}
+
+// newConditionalTestService is a service exposing every verb of the employees noun over a memory store
+func newConditionalTestService(t *testing.T, requireIfMatch bool) *serviceBase.ServiceBase {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	// the configuration is the global viper instance, which the other tests share
+	service.Configuration.Set(constants.HTTP_REQUIRE_IF_MATCH, requireIfMatch)
+	t.Cleanup(func() { service.Configuration.Set(constants.HTTP_REQUIRE_IF_MATCH, false) })
+	noAuthModel, err := service.NewAuthModel(security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize AuthModel: %v", err)
+	}
+
+	router := helpers.NewNounResourceRouterWithStore[EmployeeResource](service, newMemoryStore(t), "employees",
+		helpers.NounResourceAuthModels{Get: noAuthModel, Post: noAuthModel, Put: noAuthModel, Patch: noAuthModel, Delete: noAuthModel})
+	if router == nil {
+		t.Fatal("Expected non-nil NounResourceRouter")
+	}
+	return service
+}
+
+func conditionalCall(service *serviceBase.ServiceBase, method string, url string, body []byte, headers ...string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, url, bytes.NewReader(body))
+	request.Header.Set("X-AuthToken", "1234:")
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	service.Router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestNounResourceRouterConditionalRequests(t *testing.T) {
+	service := newConditionalTestService(t, false)
+	collection := "/v1/identities/1234/employees"
+
+	body, _ := json.Marshal(EmployeeResource{Employee: Employee{Name: "Alice", Age: 30}})
+	response := conditionalCall(service, http.MethodPost, collection, body, "If-None-Match", "*")
+	if response.Code != http.StatusCreated || response.Header().Get("ETag") != `"1"` {
+		t.Fatalf("Expected 201 with ETag \"1\" from POST, got %d, %s: %s", response.Code, response.Header().Get("ETag"), response.Body.String())
+	}
+	var alice EmployeeResource
+	json.Unmarshal(response.Body.Bytes(), &alice)
+	item := collection + "/" + alice.Id
+
+	// create-only
+	body, _ = json.Marshal(alice)
+	if response = conditionalCall(service, http.MethodPost, collection, body, "If-None-Match", "*"); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 creating a taken id with If-None-Match: *, got %d", response.Code)
+	}
+	if response = conditionalCall(service, http.MethodPost, collection, body); response.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 creating a taken id, got %d", response.Code)
+	}
+	if response = conditionalCall(service, http.MethodPost, collection, body, "If-None-Match", `"1"`); response.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an If-None-Match other than * on POST, got %d", response.Code)
+	}
+
+	// GET
+	if response = conditionalCall(service, http.MethodGet, item, nil); response.Code != http.StatusOK || response.Header().Get("ETag") != `"1"` {
+		t.Fatalf("Expected 200 with ETag \"1\" from GET, got %d, %s", response.Code, response.Header().Get("ETag"))
+	}
+	if response = conditionalCall(service, http.MethodGet, item, nil, "If-None-Match", `W/"1"`); response.Code != http.StatusNotModified || response.Body.Len() != 0 {
+		t.Fatalf("Expected 304 with no body from GET, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = conditionalCall(service, http.MethodGet, item, nil, "If-None-Match", `"0", "2"`); response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from GET when If-None-Match doesn't match, got %d", response.Code)
+	}
+
+	// PUT takes the version from If-Match instead of the body
+	alice.Version = 0
+	alice.Employee.Age = 31
+	body, _ = json.Marshal(alice)
+	if response = conditionalCall(service, http.MethodPut, item, body, "If-Match", `"2"`); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 from PUT with a stale If-Match, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = conditionalCall(service, http.MethodPut, item, body, "If-Match", `W/"1"`); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 from PUT with a weak If-Match, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = conditionalCall(service, http.MethodPut, item, body, "If-Match", `"1"`); response.Code != http.StatusOK || response.Header().Get("ETag") != `"2"` {
+		t.Fatalf("Expected 200 with ETag \"2\" from PUT, got %d, %s: %s", response.Code, response.Header().Get("ETag"), response.Body.String())
+	}
+
+	// PATCH with * or a list of tags
+	patch := []byte(`{"employee":{"age":32}}`)
+	if response = conditionalCall(service, http.MethodPatch, item, patch, "Content-Type", "application/merge-patch+json", "If-Match", `"1", "3"`); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 from PATCH when no tag matches, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = conditionalCall(service, http.MethodPatch, item, patch, "Content-Type", "application/merge-patch+json", "If-Match", `"1", "2"`); response.Code != http.StatusOK || response.Header().Get("ETag") != `"3"` {
+		t.Fatalf("Expected 200 with ETag \"3\" from PATCH, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = conditionalCall(service, http.MethodPatch, item, patch, "Content-Type", "application/merge-patch+json", "If-Match", "*"); response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from PATCH with If-Match: *, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = conditionalCall(service, http.MethodPatch, item, patch, "Content-Type", "application/merge-patch+json"); response.Code != http.StatusPreconditionRequired {
+		t.Fatalf("Expected 428 from PATCH without a version, got %d", response.Code)
+	}
+
+	// DELETE
+	if response = conditionalCall(service, http.MethodDelete, item, nil, "If-Match", `"3"`); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 from DELETE with a stale If-Match, got %d", response.Code)
+	}
+	if response = conditionalCall(service, http.MethodDelete, item, nil, "If-Match", `"4"`); response.Code != http.StatusOK || response.Header().Get("ETag") != `"5"` {
+		t.Fatalf("Expected 200 with ETag \"5\" from DELETE, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = conditionalCall(service, http.MethodDelete, item, nil, "If-Match", "*"); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 from DELETE with If-Match: * once the resource is gone, got %d", response.Code)
+	}
+}
+
+func TestNounResourceRouterRequireIfMatch(t *testing.T) {
+	service := newConditionalTestService(t, true)
+	collection := "/v1/identities/1234/employees"
+
+	body, _ := json.Marshal(EmployeeResource{Employee: Employee{Name: "Alice", Age: 30}})
+	response := conditionalCall(service, http.MethodPost, collection, body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 from POST, got %d: %s", response.Code, response.Body.String())
+	}
+	var alice EmployeeResource
+	json.Unmarshal(response.Body.Bytes(), &alice)
+	item := collection + "/" + alice.Id
+
+	body, _ = json.Marshal(alice)
+	if response = conditionalCall(service, http.MethodPut, item, body); response.Code != http.StatusPreconditionRequired {
+		t.Fatalf("Expected 428 from PUT without If-Match, got %d", response.Code)
+	}
+	if response = conditionalCall(service, http.MethodDelete, fmt.Sprintf("%s?version=%d", item, alice.Version), nil); response.Code != http.StatusPreconditionRequired {
+		t.Fatalf("Expected 428 from DELETE without If-Match, got %d", response.Code)
+	}
+	if response = conditionalCall(service, http.MethodPut, item, body, "If-Match", "1"); response.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 from PUT with an unquoted If-Match, got %d", response.Code)
+	}
+	if response = conditionalCall(service, http.MethodPut, item, body, "If-Match", `"1"`); response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from PUT with If-Match, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestNounResourceRouterPutKeepsDeleted(t *testing.T) {
+	service := newConditionalTestService(t, false)
+	collection := "/v1/identities/1234/employees"
+
+	body, _ := json.Marshal(EmployeeResource{Employee: Employee{Name: "Alice", Age: 30}})
+	response := conditionalCall(service, http.MethodPost, collection, body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 from POST, got %d: %s", response.Code, response.Body.String())
+	}
+	var alice EmployeeResource
+	json.Unmarshal(response.Body.Bytes(), &alice)
+	item := collection + "/" + alice.Id
+
+	// a PUT can't delete the resource by sending deleted: true
+	alice.Deleted = true
+	body, _ = json.Marshal(alice)
+	if response = conditionalCall(service, http.MethodPut, item, body, "If-Match", `"1"`); response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from PUT, got %d: %s", response.Code, response.Body.String())
+	}
+	var updated EmployeeResource
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if updated.Deleted || updated.LastAction != constants.RESOURCE_ACTION_UPDATE {
+		t.Fatalf("Expected the PUT to update without deleting, got %+v", updated.ResourceBase)
+	}
+	if response = conditionalCall(service, http.MethodGet, item, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from GET after the PUT, got %d", response.Code)
+	}
+
+	// nor edit or restore a deleted one
+	if response = conditionalCall(service, http.MethodDelete, item, nil, "If-Match", `"2"`); response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from DELETE, got %d: %s", response.Code, response.Body.String())
+	}
+	alice.Deleted = false
+	body, _ = json.Marshal(alice)
+	if response = conditionalCall(service, http.MethodPut, item, body, "If-Match", `"3"`); response.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 from PUT of a deleted resource, got %d: %s", response.Code, response.Body.String())
+	}
+	if response = conditionalCall(service, http.MethodGet, item, nil); response.Code != http.StatusNotFound {
+		t.Fatalf("Expected the resource to stay deleted, got %d", response.Code)
+	}
+}
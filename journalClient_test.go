@@ -0,0 +1,254 @@
+package unittests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/helpers"
+	"github.com/geraldhinson/siftd-base/pkg/journalClient"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+// newJournalTestServer serves the journal routes of a memory store. The first failures requests get a 500.
+func newJournalTestServer(t *testing.T, failures int32) (*httptest.Server, *resourceStore.MemoryResourceStore[EmployeeResource], *atomic.Value) {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+
+	store := newMemoryStore(t)
+	router := helpers.NewNounJournalRouterWithStore[EmployeeResource](service, store, security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if router == nil {
+		t.Fatal("Expected non-nil NounJournalRouter")
+	}
+
+	var lastAuthorization atomic.Value
+	var remainingFailures atomic.Int32
+	remainingFailures.Store(failures)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastAuthorization.Store(r.Header.Get("Authorization"))
+		if remainingFailures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		service.Router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, store, &lastAuthorization
+}
+
+func createEmployees(t *testing.T, store resourceStore.IResourceStore[EmployeeResource], names ...string) {
+	for _, name := range names {
+		resource := &EmployeeResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Employee: Employee{Name: name}}
+		if _, status, err := store.CreateResource(context.Background(), resource, "1234:"); status != constants.RESOURCE_OK_CODE {
+			t.Fatalf("Error creating resource: %d, %v", status, err)
+		}
+	}
+}
+
+func TestJournalFollower(t *testing.T) {
+	server, store, lastAuthorization := newJournalTestServer(t, 1)
+	createEmployees(t, store, "Alice", "Bob", "Carol")
+
+	checkpoints, err := journalClient.NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint"))
+	if err != nil {
+		t.Fatalf("Error creating checkpoint store: %v", err)
+	}
+
+	var mutex sync.Mutex
+	var handled []string
+	failedOnce := false
+	handler := func(ctx context.Context, entry journalClient.JournalEntry[EmployeeResource]) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		// fail Bob once - he must be delivered again rather than skipped
+		if entry.Resource.Employee.Name == "Bob" && !failedOnce {
+			failedOnce = true
+			return errors.New("projection unavailable")
+		}
+		handled = append(handled, entry.Resource.Employee.Name)
+		return nil
+	}
+
+	follower, err := journalClient.NewFollower[EmployeeResource](
+		journalClient.FollowerConfig{BaseURL: server.URL, PageSize: 2, PollInterval: 10 * time.Millisecond, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond},
+		journalClient.StaticTokenSource("machine-token"), checkpoints, handler, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating follower: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- follower.Run(ctx) }()
+
+	waitFor := func(expected int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if clock, _ := checkpoints.Load(context.Background()); clock == uint64(expected) {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for the checkpoint to reach %d", expected)
+	}
+
+	waitFor(3)
+	createEmployees(t, store, "Dave")
+	waitFor(4)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Run to return context.Canceled, got %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []string{"Alice", "Bob", "Carol", "Dave"}
+	if len(handled) != len(expected) {
+		t.Fatalf("Expected %v to be handled, got %v", expected, handled)
+	}
+	for i := range expected {
+		if handled[i] != expected[i] {
+			t.Fatalf("Expected %v to be handled in order, got %v", expected, handled)
+		}
+	}
+	if lastAuthorization.Load() != "machine-token" {
+		t.Fatalf("Expected the machine token in the Authorization header, got %v", lastAuthorization.Load())
+	}
+
+	maxClock, err := follower.MaxClock(context.Background())
+	if err != nil || maxClock != 4 {
+		t.Fatalf("Expected max clock 4, got %d, %v", maxClock, err)
+	}
+}
+
+func TestJournalFollowerResumesFromCheckpoint(t *testing.T) {
+	server, store, _ := newJournalTestServer(t, 0)
+	createEmployees(t, store, "Alice", "Bob", "Carol")
+
+	checkpoints, _ := journalClient.NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint"))
+	if err := checkpoints.Save(context.Background(), 2); err != nil {
+		t.Fatalf("Error saving checkpoint: %v", err)
+	}
+
+	handled := make(chan string, 10)
+	handler := func(ctx context.Context, entry journalClient.JournalEntry[EmployeeResource]) error {
+		handled <- entry.Resource.Employee.Name
+		return nil
+	}
+	follower, err := journalClient.NewFollower[EmployeeResource](
+		journalClient.FollowerConfig{BaseURL: server.URL, PollInterval: 10 * time.Millisecond},
+		journalClient.StaticTokenSource("machine-token"), checkpoints, handler, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating follower: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go follower.Run(ctx)
+
+	select {
+	case name := <-handled:
+		if name != "Carol" {
+			t.Fatalf("Expected to resume after the checkpoint with Carol, got %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the follower")
+	}
+}
+
+func TestJournalFollowerFails(t *testing.T) {
+	checkpoints, _ := journalClient.NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint"))
+	handler := func(ctx context.Context, entry journalClient.JournalEntry[EmployeeResource]) error { return nil }
+	tokens := journalClient.StaticTokenSource("machine-token")
+
+	if _, err := journalClient.NewFollower[EmployeeResource](journalClient.FollowerConfig{}, tokens, checkpoints, handler, logrus.New()); err == nil {
+		t.Fatal("Expected an error for a missing base URL")
+	}
+	if _, err := journalClient.NewFollower[EmployeeResource](journalClient.FollowerConfig{BaseURL: "http://localhost"}, nil, checkpoints, handler, logrus.New()); err == nil {
+		t.Fatal("Expected an error for a missing token source")
+	}
+	if _, err := journalClient.NewFollower[EmployeeResource](journalClient.FollowerConfig{BaseURL: "http://localhost"}, tokens, nil, handler, logrus.New()); err == nil {
+		t.Fatal("Expected an error for a missing checkpoint store")
+	}
+	if _, err := journalClient.NewFileCheckpointStore(""); err == nil {
+		t.Fatal("Expected an error for a missing checkpoint file path")
+	}
+	if _, err := journalClient.StaticTokenSource("").Token(context.Background()); err == nil {
+		t.Fatal("Expected an error for an empty static token")
+	}
+}
+
+func TestPostgresCheckpointStore(t *testing.T) {
+	// this file runs before resourceStore_test.go sets up gServiceBase, so read the configuration directly
+	configuration := setupEnvVars(t)
+	if configuration == nil {
+		t.Fatal("Failed to read config for service")
+	}
+
+	// the checkpoints table is created by the migrations of a store in the schema
+	store, err := resourceStore.NewPostgresResourceStoreWithJournal[EmployeeResource](configuration, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating the resource store: %v", err)
+	}
+	defer store.Close(context.Background())
+
+	checkpoints, err := journalClient.NewPostgresCheckpointStore(configuration, logrus.New(), "unittests-follower")
+	if err != nil {
+		t.Fatalf("Error creating PostgresCheckpointStore: %v", err)
+	}
+	defer checkpoints.Close()
+	expectCheckpoints(t, checkpoints, 41, 42)
+
+	// and in another schema
+	schema := "unittestsCheckpoints"
+	other, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](configuration, logrus.New(), resourceStore.NounTableNames(schema, "checkpointed"))
+	if err != nil {
+		t.Fatalf("Error creating the resource store in schema %s: %v", schema, err)
+	}
+	defer other.Close(context.Background())
+	dbPool, err := pgxpool.New(context.Background(), configuration.GetString(constants.DB_CONNECTION_STRING))
+	if err != nil {
+		t.Fatalf("Error creating the database pool: %v", err)
+	}
+	defer dbPool.Close()
+	schemaCheckpoints, err := journalClient.NewPostgresCheckpointStoreWithPool(dbPool, logrus.New(), schema, "unittests-follower")
+	if err != nil {
+		t.Fatalf("Error creating PostgresCheckpointStore in schema %s: %v", schema, err)
+	}
+	expectCheckpoints(t, schemaCheckpoints, 7)
+	// a checkpoint of the same name in another schema is a separate one
+	if loaded, err := checkpoints.Load(context.Background()); err != nil || loaded != 42 {
+		t.Fatalf("Expected the checkpoint in public to stay 42, got %d, %v", loaded, err)
+	}
+}
+
+// expectCheckpoints saves each clock in turn and checks that it loads back
+func expectCheckpoints(t *testing.T, checkpoints journalClient.CheckpointStore, clocks ...uint64) {
+	t.Helper()
+	for _, clock := range clocks {
+		if err := checkpoints.Save(context.Background(), clock); err != nil {
+			t.Fatalf("Error saving checkpoint: %v", err)
+		}
+		loaded, err := checkpoints.Load(context.Background())
+		if err != nil || loaded != clock {
+			t.Fatalf("Expected checkpoint %d, got %d, %v", clock, loaded, err)
+		}
+	}
+}
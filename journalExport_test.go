@@ -0,0 +1,178 @@
+package unittests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+)
+
+func expectHttpStatus(t *testing.T, err error, httpStatus int) {
+	t.Helper()
+	var resourceErr *resourceStore.ResourceError
+	if !errors.As(err, &resourceErr) || resourceErr.HttpStatus() != httpStatus {
+		t.Fatalf("Expected a %d error, got %v", httpStatus, err)
+	}
+}
+
+func TestMemoryJournalExportImport(t *testing.T) {
+	ctx := context.Background()
+	source := newMemoryStore(t)
+	versions, _ := createResourceHistory(t, source) // clocks 1 to 4 for Alice
+	createEmployees(t, source, "Bob", "Carol")      // clocks 5 and 6
+	var owned []EmployeeResource
+	source.GetByOwnerId(ctx, "1234", &owned)
+	carol := owned[slices.IndexFunc(owned, func(e EmployeeResource) bool { return e.Employee.Name == "Carol" })]
+	if _, status, err := source.DeleteResource(ctx, "1234", carol.Id, carol.Version, "1234:"); status != constants.RESOURCE_OK_CODE { // clock 7
+		t.Fatalf("Error deleting resource: %d, %v", status, err)
+	}
+
+	var export bytes.Buffer
+	if count, err := source.ExportJournal(ctx, resourceStore.JournalRange{}, &export); err != nil || count != 7 || strings.Count(export.String(), "\n") != 7 {
+		t.Fatalf("Expected 7 NDJSON entries to be exported, got %d, %v: %s", count, err, export.String())
+	}
+	var partial bytes.Buffer
+	if count, err := source.ExportJournal(ctx, resourceStore.JournalRange{FromClock: 2, ToClock: 3}, &partial); err != nil || count != 2 {
+		t.Fatalf("Expected clocks 2 and 3 to be exported, got %d, %v", count, err)
+	}
+
+	target := newMemoryStore(t)
+	if count, err := target.ImportJournal(ctx, bytes.NewReader(export.Bytes())); err != nil || count != 7 {
+		t.Fatalf("Expected 7 entries to be imported, got %d, %v", count, err)
+	}
+	if clocks := journalClocks(t, target); !slices.Equal(clocks, journalClocks(t, source)) {
+		t.Fatalf("Expected the clocks to be preserved, got %v", clocks)
+	}
+
+	// the resources are rebuilt at their latest version, including the deleted one
+	var imported []EmployeeResource
+	target.GetByOwnerId(ctx, "1234", &imported)
+	alice := slices.IndexFunc(imported, func(e EmployeeResource) bool { return e.Id == versions[3].Id })
+	if len(imported) != 2 || alice < 0 || imported[alice].Version != versions[3].Version || imported[alice].Employee.Age != 31 {
+		t.Fatalf("Expected Alice at version %d and Bob, got %+v", versions[3].Version, imported)
+	}
+	var deleted EmployeeResource
+	if status, err := target.GetByIdIncludingDeleted(ctx, "1234", carol.Id, &deleted); status != constants.RESOURCE_OK_CODE || !deleted.Deleted {
+		t.Fatalf("Expected Carol to be imported as deleted, got %d, %v, %+v", status, err, deleted)
+	}
+	var inconsistencies []resourceStore.ResourceInconsistency
+	if err := target.CheckConsistency(ctx, &inconsistencies); err != nil || len(inconsistencies) != 0 {
+		t.Fatalf("Expected the imported store to be consistent, got %+v, %v", inconsistencies, err)
+	}
+
+	// writes continue after the imported clocks
+	createEmployees(t, target, "Dave")
+	var maxClock uint64
+	if err := target.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != 8 {
+		t.Fatalf("Expected the next write at clock 8, got %d, %v", maxClock, err)
+	}
+
+	_, err := target.ImportJournal(ctx, bytes.NewReader(export.Bytes()))
+	expectHttpStatus(t, err, http.StatusConflict)
+}
+
+// journalEntryLine is an exported journal entry for the partition and clock given
+func journalEntryLine(clock int, partitionName string, id string) string {
+	return fmt.Sprintf(`{"clock":%d,"resource":{"id":%q,"ownerId":"1234","version":1},"updatedAt":"2024-01-01T00:00:00Z","partitionName":%q}`, clock, id, partitionName) + "\n"
+}
+
+func TestMemoryJournalImportPartitions(t *testing.T) {
+	// the journal is keyed by (clock, partition), so shards of a service can share a clock
+	export := journalEntryLine(1, "US-EAST", "a") + journalEntryLine(1, "US-WEST", "b") + journalEntryLine(2, "US-EAST", "c")
+	if count, err := newMemoryStore(t).ImportJournal(context.Background(), strings.NewReader(export)); err != nil || count != 3 {
+		t.Fatalf("Expected 3 entries to be imported, got %d, %v", count, err)
+	}
+
+	// a subscription only streams the store's own partition, like the postgres store
+	store := newMemoryStore(t)
+	export = journalEntryLine(1, "US-WEST", "a") + journalEntryLine(1, "memory", "b") + journalEntryLine(2, "US-WEST", "c") + journalEntryLine(3, "memory", "d")
+	if _, err := store.ImportJournal(context.Background(), strings.NewReader(export)); err != nil {
+		t.Fatalf("Error importing journal: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries := store.SubscribeJournal(ctx, 1)
+	for _, expectedClock := range []uint64{1, 3} {
+		select {
+		case entry := <-entries:
+			if entry.Clock != expectedClock || entry.PartitionName != "memory" {
+				t.Fatalf("Expected entry %d of the store's partition, got %d of %s", expectedClock, entry.Clock, entry.PartitionName)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for journal entry %d", expectedClock)
+		}
+	}
+
+	for name, invalid := range map[string]string{
+		"partitions out of order": journalEntryLine(1, "US-WEST", "b") + journalEntryLine(1, "US-EAST", "a"),
+		"duplicate entry":         journalEntryLine(1, "US-EAST", "a") + journalEntryLine(1, "US-EAST", "b"),
+	} {
+		_, err := newMemoryStore(t).ImportJournal(context.Background(), strings.NewReader(invalid))
+		if err == nil {
+			t.Fatalf("%s: expected the import to fail", name)
+		}
+		expectHttpStatus(t, err, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestMemoryJournalImportInvalid(t *testing.T) {
+	ctx := context.Background()
+	source := newMemoryStore(t)
+	createEmployees(t, source, "Alice", "Bob")
+	var export bytes.Buffer
+	source.ExportJournal(ctx, resourceStore.JournalRange{}, &export)
+	lines := strings.SplitAfter(strings.TrimSpace(export.String()), "\n")
+
+	for name, invalid := range map[string]string{
+		"not JSON":            "{",
+		"clocks out of order": lines[1] + "\n" + lines[0],
+		"no partition":        strings.Replace(lines[0], `"partitionName":"memory"`, `"partitionName":""`, 1),
+		"no resource id":      `{"clock":1,"resource":{"ownerId":"1234"},"updatedAt":"2024-01-01T00:00:00Z","partitionName":"memory"}`,
+	} {
+		target := newMemoryStore(t)
+		_, err := target.ImportJournal(ctx, strings.NewReader(invalid))
+		expectHttpStatus(t, err, http.StatusUnprocessableEntity)
+		if len(journalClocks(t, target)) != 0 {
+			t.Fatalf("%s: expected nothing to be imported", name)
+		}
+	}
+}
+
+func TestMemoryRebuildResources(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(t)
+	createResourceHistory(t, store)
+	createEmployees(t, store, "Bob")
+
+	if count, err := store.RebuildResources(ctx); err != nil || count != 2 {
+		t.Fatalf("Expected 2 resources to be rebuilt, got %d, %v", count, err)
+	}
+	var inconsistencies []resourceStore.ResourceInconsistency
+	if err := store.CheckConsistency(ctx, &inconsistencies); err != nil || len(inconsistencies) != 0 {
+		t.Fatalf("Expected the rebuilt store to be consistent, got %+v, %v", inconsistencies, err)
+	}
+
+	// once the journal has been trimmed it no longer holds every resource
+	time.Sleep(2 * retentionTestWindow)
+	createEmployees(t, store, "Carol")
+	if _, err := store.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_DELETE, Window: retentionTestWindow}); err != nil {
+		t.Fatalf("Error applying retention: %v", err)
+	}
+	_, err := store.RebuildResources(ctx)
+	expectHttpStatus(t, err, http.StatusConflict)
+
+	// and the resources whose entries were removed are reported as not journaled
+	inconsistencies = nil
+	if err := store.CheckConsistency(ctx, &inconsistencies); err != nil || len(inconsistencies) != 2 ||
+		inconsistencies[0].Kind != resourceStore.INCONSISTENCY_NOT_JOURNALED || inconsistencies[1].Kind != resourceStore.INCONSISTENCY_NOT_JOURNALED {
+		t.Fatalf("Expected Alice and Bob to be reported as not journaled, got %+v, %v", inconsistencies, err)
+	}
+}
@@ -0,0 +1,169 @@
+package unittests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/journalClient"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/sirupsen/logrus"
+)
+
+func TestJournalClockVector(t *testing.T) {
+	vector, err := resourceStore.ParseJournalClockVector("US-WEST:9,US-EAST:12,zone:a:3")
+	if err != nil || len(vector) != 3 || vector["US-EAST"] != 12 || vector["US-WEST"] != 9 || vector["zone:a"] != 3 {
+		t.Fatalf("Expected three partitions, got %v, %v", vector, err)
+	}
+	if vector.String() != "US-EAST:12,US-WEST:9,zone:a:3" {
+		t.Fatalf("Expected the partitions sorted by name, got %s", vector.String())
+	}
+
+	vector.Advance([]resourceStore.ResourceJournalEntry{{Clock: 15, PartitionName: "US-EAST"}, {Clock: 4, PartitionName: "US-WEST"}, {Clock: 1, PartitionName: "EU"}})
+	if vector["US-EAST"] != 15 || vector["US-WEST"] != 9 || vector["EU"] != 1 {
+		t.Fatalf("Expected the clocks to only move forward, got %v", vector)
+	}
+
+	if vector, err := resourceStore.ParseJournalClockVector(""); err != nil || len(vector) != 0 {
+		t.Fatalf("Expected an empty vector, got %v, %v", vector, err)
+	}
+	for _, invalid := range []string{"US-EAST", ":5", "US-EAST:", "US-EAST:-1", "US-EAST:5,"} {
+		if _, err := resourceStore.ParseJournalClockVector(invalid); err == nil {
+			t.Fatalf("Expected an error parsing '%s'", invalid)
+		}
+	}
+}
+
+func TestMemoryResourceStoreJournalPartitions(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(t)
+	createEmployees(t, store, "Alice", "Bob", "Carol")
+
+	var entries []resourceStore.ResourceJournalEntry
+	if err := store.GetPartitionJournalChanges(ctx, "memory", 2, 10, &entries); err != nil || len(entries) != 2 || entries[0].Clock != 2 {
+		t.Fatalf("Expected clocks 2 and 3 of the memory partition, got %+v, %v", entries, err)
+	}
+	entries = nil
+	if err := store.GetPartitionJournalChanges(ctx, "other", 1, 10, &entries); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected no entries in another partition, got %+v, %v", entries, err)
+	}
+
+	var maxClock uint64
+	if err := store.GetPartitionJournalMaxClock(ctx, "memory", &maxClock); err != nil || maxClock != 3 {
+		t.Fatalf("Expected a max clock of 3, got %d, %v", maxClock, err)
+	}
+	if err := store.GetPartitionJournalMaxClock(ctx, "other", &maxClock); err != nil || maxClock != 0 {
+		t.Fatalf("Expected a max clock of 0 in another partition, got %d, %v", maxClock, err)
+	}
+
+	var clocks resourceStore.JournalClockVector
+	if err := store.GetJournalClocks(ctx, &clocks); err != nil || len(clocks) != 1 || clocks["memory"] != 3 {
+		t.Fatalf("Expected the clocks {memory: 3}, got %v, %v", clocks, err)
+	}
+
+	entries = nil
+	since := resourceStore.JournalClockVector{"other": 7}
+	next, err := store.GetMergedJournalChanges(ctx, since, 2, &entries)
+	if err != nil || len(entries) != 2 || next["memory"] != 2 || next["other"] != 7 {
+		t.Fatalf("Expected the first 2 entries and the clocks {memory: 2, other: 7}, got %+v, %v, %v", entries, next, err)
+	}
+	if len(since) != 1 {
+		t.Fatalf("Expected the since vector to be unchanged, got %v", since)
+	}
+	entries = nil
+	if next, err = store.GetMergedJournalChanges(ctx, next, 2, &entries); err != nil || len(entries) != 1 || entries[0].Clock != 3 || next["memory"] != 3 {
+		t.Fatalf("Expected the last entry, got %+v, %v, %v", entries, next, err)
+	}
+}
+
+func TestNounJournalRouterPartitions(t *testing.T) {
+	server, store, _ := newJournalTestServer(t, 0)
+	createEmployees(t, store, "Alice", "Bob", "Carol")
+
+	get := func(path string, expectedStatus int, v any) {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Error calling GET %s: %v", path, err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != expectedStatus {
+			t.Fatalf("Expected %d from GET %s, got %d", expectedStatus, path, res.StatusCode)
+		}
+		if v != nil {
+			if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Fatalf("Error decoding response of GET %s: %v", path, err)
+			}
+		}
+	}
+
+	var entries []resourceStore.ResourceJournalEntry
+	get("/v1/journal?clock=1&limit=10&partition=memory", http.StatusOK, &entries)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries in the memory partition, got %d", len(entries))
+	}
+	entries = nil
+	get("/v1/journal?clock=1&limit=10&partition=other", http.StatusOK, &entries)
+	if entries == nil || len(entries) != 0 {
+		t.Fatalf("Expected an empty array for another partition, got %+v", entries)
+	}
+
+	var maxClock resourceStore.JournalMaxClock
+	get("/v1/journalMaxClock?partition=other", http.StatusOK, &maxClock)
+	if maxClock.MaxClock != 0 {
+		t.Fatalf("Expected a max clock of 0 for another partition, got %d", maxClock.MaxClock)
+	}
+
+	var clocks resourceStore.JournalClocks
+	get("/v1/journalClocks", http.StatusOK, &clocks)
+	if clocks.Clocks["memory"] != 3 {
+		t.Fatalf("Expected the clocks {memory: 3}, got %v", clocks.Clocks)
+	}
+
+	var changes resourceStore.MergedJournalChanges
+	get("/v1/journal/merged?limit=2", http.StatusOK, &changes)
+	if len(changes.Entries) != 2 || changes.Clocks["memory"] != 2 {
+		t.Fatalf("Expected the first 2 entries, got %+v", changes)
+	}
+	since := changes.Clocks.String()
+	changes = resourceStore.MergedJournalChanges{}
+	get("/v1/journal/merged?limit=2&since="+since, http.StatusOK, &changes)
+	if len(changes.Entries) != 1 || changes.Entries[0].Clock != 3 || changes.Clocks["memory"] != 3 {
+		t.Fatalf("Expected the last entry after %s, got %+v", since, changes)
+	}
+	get("/v1/journal/merged?limit=2&since=memory", http.StatusBadRequest, nil)
+	get("/v1/journal/merged?limit=0", http.StatusBadRequest, nil)
+
+	// a follower of one partition
+	checkpoints, _ := journalClient.NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint"))
+	handled := make(chan string, 10)
+	handler := func(ctx context.Context, entry journalClient.JournalEntry[EmployeeResource]) error {
+		handled <- entry.PartitionName
+		return nil
+	}
+	follower, err := journalClient.NewFollower[EmployeeResource](
+		journalClient.FollowerConfig{BaseURL: server.URL, Partition: "memory", PollInterval: 10 * time.Millisecond},
+		journalClient.StaticTokenSource("machine-token"), checkpoints, handler, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating follower: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go follower.Run(ctx)
+
+	for range 3 {
+		select {
+		case partition := <-handled:
+			if partition != "memory" {
+				t.Fatalf("Expected entries of the memory partition, got %s", partition)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the follower")
+		}
+	}
+	if maxClock, err := follower.MaxClock(context.Background()); err != nil || maxClock != 3 {
+		t.Fatalf("Expected the partition's max clock of 3, got %d, %v", maxClock, err)
+	}
+}
@@ -0,0 +1,177 @@
+package unittests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/journalClient"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const retentionTestWindow = 50 * time.Millisecond
+
+func journalClocks(t *testing.T, store resourceStore.IResourceStore[EmployeeResource]) []uint64 {
+	var entries []resourceStore.ResourceJournalEntry
+	if err := store.GetJournalChanges(context.Background(), 1, 100, &entries); err != nil {
+		t.Fatalf("Error getting journal changes: %v", err)
+	}
+	var clocks []uint64
+	for _, entry := range entries {
+		clocks = append(clocks, entry.Clock)
+	}
+	return clocks
+}
+
+func TestRetentionPolicyFromConfig(t *testing.T) {
+	configuration := viper.New()
+	policy, err := resourceStore.RetentionPolicyFromConfig(configuration)
+	if err != nil || policy.Mode != resourceStore.RETENTION_MODE_NONE {
+		t.Fatalf("Expected retention to be off by default, got %+v, %v", policy, err)
+	}
+
+	configuration.Set(constants.JOURNAL_RETENTION_MODE, "delete")
+	configuration.Set(constants.JOURNAL_RETENTION_WINDOW, "720h")
+	policy, err = resourceStore.RetentionPolicyFromConfig(configuration)
+	if err != nil || policy.Window != 720*time.Hour || policy.Interval != resourceStore.RETENTION_DEFAULT_INTERVAL {
+		t.Fatalf("Expected a 720h window with the default interval, got %+v, %v", policy, err)
+	}
+
+	for _, invalid := range []map[string]string{
+		{constants.JOURNAL_RETENTION_MODE: "truncate"},
+		{constants.JOURNAL_RETENTION_WINDOW: ""},
+		{constants.JOURNAL_RETENTION_WINDOW: "-1h"},
+		{constants.JOURNAL_RETENTION_INTERVAL: "often"},
+	} {
+		configuration := viper.New()
+		configuration.Set(constants.JOURNAL_RETENTION_MODE, "compact")
+		configuration.Set(constants.JOURNAL_RETENTION_WINDOW, "24h")
+		for key, value := range invalid {
+			configuration.Set(key, value)
+		}
+		if _, err := resourceStore.RetentionPolicyFromConfig(configuration); err == nil {
+			t.Fatalf("Expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestMemoryJournalRetentionDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(t)
+	createEmployees(t, store, "Alice", "Bob")
+	time.Sleep(2 * retentionTestWindow)
+	createEmployees(t, store, "Carol")
+
+	removed, err := store.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_DELETE, Window: retentionTestWindow})
+	if err != nil || removed != 2 {
+		t.Fatalf("Expected 2 entries to be removed, got %d, %v", removed, err)
+	}
+	if clocks := journalClocks(t, store); len(clocks) != 1 || clocks[0] != 3 {
+		t.Fatalf("Expected only clock 3 to be retained, got %v", clocks)
+	}
+	var minClock uint64
+	if err := store.GetJournalMinClock(ctx, &minClock); err != nil || minClock != 3 {
+		t.Fatalf("Expected a min clock of 3, got %d, %v", minClock, err)
+	}
+
+	// the newest entry is kept even when it is older than the window so the max clock never goes back
+	time.Sleep(2 * retentionTestWindow)
+	if removed, _ = store.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_DELETE, Window: retentionTestWindow}); removed != 0 {
+		t.Fatalf("Expected the newest entry to be kept, got %d removed", removed)
+	}
+	var maxClock uint64
+	if err := store.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != 3 {
+		t.Fatalf("Expected a max clock of 3, got %d, %v", maxClock, err)
+	}
+
+	if _, err := store.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_DELETE}); err == nil {
+		t.Fatal("Expected an error for a policy without a window")
+	}
+}
+
+func TestMemoryJournalRetentionCompact(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(t)
+	versions, _ := createResourceHistory(t, store) // clocks 1 to 4 for the same resource
+	createEmployees(t, store, "Bob")               // clock 5
+	time.Sleep(2 * retentionTestWindow)
+	updated := versions[3]
+	updated.Employee.Age = 32
+	if _, status, err := store.UpdateResource(ctx, &updated, "1234", updated.Id, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error updating resource: %d, %v", status, err)
+	}
+
+	removed, err := store.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_COMPACT, Window: retentionTestWindow})
+	if err != nil || removed != 3 {
+		t.Fatalf("Expected 3 entries to be removed, got %d, %v", removed, err)
+	}
+	if clocks := journalClocks(t, store); fmt.Sprint(clocks) != "[4 5 6]" {
+		t.Fatalf("Expected the latest entry of each resource below the watermark to be retained, got %v", clocks)
+	}
+	var minClock uint64
+	if err := store.GetJournalMinClock(ctx, &minClock); err != nil || minClock != 0 {
+		t.Fatalf("Expected compaction to leave the min clock at 0, got %d, %v", minClock, err)
+	}
+
+	var history []EmployeeResource
+	if status, _ := store.GetHistory(ctx, "1234", updated.Id, resourceStore.HistoryPage{}, &history); status != constants.RESOURCE_OK_CODE || len(history) != 2 {
+		t.Fatalf("Expected the compacted history to have 2 versions, got %d, %+v", status, history)
+	}
+}
+
+func TestNounJournalRouterRetention(t *testing.T) {
+	server, store, _ := newJournalTestServer(t, 0)
+	createEmployees(t, store, "Alice", "Bob")
+	time.Sleep(2 * retentionTestWindow)
+	createEmployees(t, store, "Carol")
+	if _, err := store.ApplyJournalRetention(context.Background(), resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_DELETE, Window: retentionTestWindow}); err != nil {
+		t.Fatalf("Error applying journal retention: %v", err)
+	}
+
+	get := func(path string, expectedStatus int) []byte {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Error calling GET %s: %v", path, err)
+		}
+		defer res.Body.Close()
+		var body json.RawMessage
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		if res.StatusCode != expectedStatus {
+			t.Fatalf("Expected %d from GET %s, got %d: %s", expectedStatus, path, res.StatusCode, body)
+		}
+		return body
+	}
+
+	var minClock struct {
+		MinClock uint64 `json:"minClock"`
+	}
+	if err := json.Unmarshal(get("/v1/journalMinClock", http.StatusOK), &minClock); err != nil || minClock.MinClock != 3 {
+		t.Fatalf("Expected a min clock of 3, got %+v, %v", minClock, err)
+	}
+	get("/v1/journal?clock=2&limit=10", http.StatusGone)
+	get("/v1/journal?clock=3&limit=10", http.StatusOK)
+	get("/v1/journal/stream?clock=1", http.StatusGone)
+
+	checkpoints, _ := journalClient.NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint"))
+	handler := func(ctx context.Context, entry journalClient.JournalEntry[EmployeeResource]) error { return nil }
+	follower, err := journalClient.NewFollower[EmployeeResource](
+		journalClient.FollowerConfig{BaseURL: server.URL, PollInterval: 10 * time.Millisecond},
+		journalClient.StaticTokenSource("machine-token"), checkpoints, handler, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating follower: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := follower.Run(ctx); !errors.Is(err, journalClient.ErrResyncRequired) {
+		t.Fatalf("Expected the follower to stop with ErrResyncRequired, got %v", err)
+	}
+}
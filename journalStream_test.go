@@ -0,0 +1,137 @@
+package unittests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/helpers"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+)
+
+type sseEvent struct {
+	id      string
+	event   string
+	data    string
+	comment string
+}
+
+// readSSEEvent reads lines up to the blank line that ends an event
+func readSSEEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event
+		case strings.HasPrefix(line, ":"):
+			event.comment = strings.TrimSpace(line[1:])
+		case strings.HasPrefix(line, "id: "):
+			event.id = line[len("id: "):]
+		case strings.HasPrefix(line, "event: "):
+			event.event = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			event.data = line[len("data: "):]
+		}
+	}
+}
+
+func TestJournalStream(t *testing.T) {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	store := newMemoryStore(t)
+	router := helpers.NewNounJournalRouterWithStore[EmployeeResource](service, store, security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if router == nil {
+		t.Fatal("Expected non-nil NounJournalRouter")
+	}
+	router.StreamHeartbeatInterval = 50 * time.Millisecond
+
+	server := httptest.NewServer(service.Router)
+	defer server.Close()
+
+	createEmployees(t, store, "Alice", "Bob")
+
+	openStream := func(query string, lastEventId string) (*http.Response, *bufio.Reader, context.CancelFunc) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/v1/journal/stream"+query, nil)
+		if lastEventId != "" {
+			req.Header.Set("Last-Event-ID", lastEventId)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			cancel()
+			t.Fatalf("Error opening journal stream: %v", err)
+		}
+		return res, bufio.NewReader(res.Body), cancel
+	}
+
+	res, reader, cancel := openStream("?clock=1", "")
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected a 200 event stream, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	nextJournalEvent := func(reader *bufio.Reader) sseEvent {
+		for {
+			event := readSSEEvent(t, reader)
+			if event.event == "journal" {
+				return event
+			}
+		}
+	}
+
+	// catch up, then a live entry, then a heartbeat while idle
+	for _, expectedId := range []string{"1", "2"} {
+		if event := nextJournalEvent(reader); event.id != expectedId {
+			t.Fatalf("Expected event id %s, got %s", expectedId, event.id)
+		}
+	}
+	createEmployees(t, store, "Carol")
+	event := nextJournalEvent(reader)
+	var entry resourceStore.ResourceJournalEntry
+	if err := json.Unmarshal([]byte(event.data), &entry); err != nil {
+		t.Fatalf("Error decoding journal event: %v", err)
+	}
+	if event.id != "3" || entry.Clock != 3 {
+		t.Fatalf("Expected the live entry with clock 3, got id %s clock %d", event.id, entry.Clock)
+	}
+	if heartbeat := readSSEEvent(t, reader); heartbeat.comment != "heartbeat" {
+		t.Fatalf("Expected a heartbeat on the idle stream, got %+v", heartbeat)
+	}
+	cancel()
+	res.Body.Close()
+
+	// a reconnect with Last-Event-ID resumes after that entry, whatever the clock parameter says
+	res, reader, cancel = openStream("?clock=1", "2")
+	if event := nextJournalEvent(reader); event.id != "3" {
+		t.Fatalf("Expected to resume at event id 3, got %s", event.id)
+	}
+	cancel()
+	res.Body.Close()
+
+	for _, query := range []string{"", "?clock=0", "?clock=abc"} {
+		res, err := http.Get(server.URL + "/v1/journal/stream" + query)
+		if err != nil {
+			t.Fatalf("Error calling journal stream: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for '%s', got %d", query, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package unittests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/helpers"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func newMemoryStore(t *testing.T) *resourceStore.MemoryResourceStore[EmployeeResource] {
+	configuration := viper.New()
+	configuration.Set(constants.JOURNAL_PARTITION_NAME, "memory")
+
+	store, err := resourceStore.NewMemoryResourceStore[EmployeeResource](configuration, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating MemoryResourceStore: %v", err)
+	}
+	return store
+}
+
+func TestMemoryResourceStoreFail(t *testing.T) {
+	if _, err := resourceStore.NewMemoryResourceStore[Employee](viper.New(), logrus.New()); err == nil {
+		t.Fatal("Expected an error for a type without an embedded ResourceBase")
+	}
+	if _, err := resourceStore.NewMemoryResourceStore[EmployeeResource](viper.New(), logrus.New()); err == nil {
+		t.Fatal("Expected an error when the journal partition name is missing")
+	}
+}
+
+func TestMemoryResourceStoreCRUD(t *testing.T) {
+	store := newMemoryStore(t)
+	ctx := context.Background()
+
+	resourceA := &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Alice", Age: 30},
+	}
+	created, status, err := store.CreateResource(ctx, resourceA, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error creating resource: %d, %v", status, err)
+	}
+	id := created.GetResourceBase().Id
+	if id == "" || created.GetResourceBase().Version != 1 || created.GetResourceBase().LastAction != constants.RESOURCE_ACTION_CREATE {
+		t.Fatalf("Unexpected created resource: %+v", created.GetResourceBase())
+	}
+
+	duplicate := &EmployeeResource{ResourceBase: resourceStore.ResourceBase{Id: id, OwnerId: "5678"}}
+	if _, status, _ := store.CreateResource(ctx, duplicate, "5678:"); status != constants.RESOURCE_ALREADY_EXISTS_CODE {
+		t.Fatalf("Expected already exists for a duplicate id, got %d", status)
+	}
+
+	var fetched EmployeeResource
+	if status, err := store.GetById(ctx, "1234", id, &fetched); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error getting resource: %d, %v", status, err)
+	}
+	if fetched.Employee.Name != "Alice" {
+		t.Fatalf("Expected Alice, got %s", fetched.Employee.Name)
+	}
+	if status, _ := store.GetById(ctx, "5678", id, &fetched); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected not found for a different owner, got %d", status)
+	}
+
+	fetched.Employee.Age = 31
+	updated, status, err := store.UpdateResource(ctx, &fetched, "1234", id, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error updating resource: %d, %v", status, err)
+	}
+	if updated.GetResourceBase().Version != 2 {
+		t.Fatalf("Expected version 2, got %d", updated.GetResourceBase().Version)
+	}
+
+	// an update carrying an old version is rejected
+	stale := fetched
+	stale.Version = 1
+	if _, status, _ := store.UpdateResource(ctx, &stale, "1234", id, "1234:"); status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected bad request for a stale version, got %d", status)
+	}
+
+	deleted, status, err := store.DeleteResource(ctx, "1234", id, 2, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error deleting resource: %d, %v", status, err)
+	}
+	if !deleted.GetResourceBase().Deleted || deleted.GetResourceBase().Version != 3 {
+		t.Fatalf("Unexpected deleted resource: %+v", deleted.GetResourceBase())
+	}
+	if deleted.(*EmployeeResource).Employee.Age != 31 {
+		t.Fatal("Expected the resource fields to survive the delete")
+	}
+	if status, _ := store.GetById(ctx, "1234", id, &fetched); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected not found for a deleted resource, got %d", status)
+	}
+	if status, _ := store.GetByIdIncludingDeleted(ctx, "1234", id, &fetched); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Expected the deleted resource to be readable, got %d", status)
+	}
+	if _, status, _ := store.DeleteResource(ctx, "1234", id, 3, "1234:"); status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected bad request deleting an already deleted resource, got %d", status)
+	}
+	if _, status, _ := store.DeleteResource(ctx, "1234", "no-such-id", 1, "1234:"); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected not found deleting a missing resource, got %d", status)
+	}
+
+	if _, status, err := store.UndeleteResource(ctx, "1234", id, 3, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error undeleting resource: %d, %v", status, err)
+	}
+
+	var journalEntries []resourceStore.ResourceJournalEntry
+	if err := store.GetJournalChanges(ctx, 1, 100, &journalEntries); err != nil {
+		t.Fatalf("Error getting journal changes: %v", err)
+	}
+	if len(journalEntries) != 4 {
+		t.Fatalf("Expected 4 journal entries (create, update, delete, undelete), got %d", len(journalEntries))
+	}
+	for i, entry := range journalEntries {
+		if entry.Clock != uint64(i+1) || entry.PartitionName != "memory" {
+			t.Fatalf("Unexpected journal entry %d: clock %d partition %s", i, entry.Clock, entry.PartitionName)
+		}
+	}
+	var maxClock uint64
+	if err := store.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != 4 {
+		t.Fatalf("Expected max clock 4, got %d, %v", maxClock, err)
+	}
+}
+
+func TestMemoryResourceStoreQuery(t *testing.T) {
+	store := newMemoryStore(t)
+	ctx := context.Background()
+
+	for i, name := range []string{"Alice", "Bob", "Carol", "Dave", "Eve", "Albert"} {
+		resource := &EmployeeResource{
+			ResourceBase: resourceStore.ResourceBase{Id: fmt.Sprintf("id-%d", i), OwnerId: "1234"},
+			Employee:     Employee{Name: name, Age: 30 + i%3},
+		}
+		if _, status, err := store.CreateResource(ctx, resource, "1234:"); status != constants.RESOURCE_OK_CODE {
+			t.Fatalf("Error creating resource: %d, %v", status, err)
+		}
+	}
+
+	var resources []EmployeeResource
+	query := resourceStore.ResourceQuery{
+		Filters: []resourceStore.QueryFilter{{Field: "employee.age", Op: resourceStore.QUERY_OP_RANGE, Values: []any{31.0, nil}}},
+		Sort:    []resourceStore.QuerySortKey{{Field: "employee.age", Descending: true}, {Field: "employee.name"}},
+		Limit:   2,
+	}
+	nextCursor, status, err := store.QueryByOwnerId(ctx, "1234", query, &resources)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error querying resources: %d, %v", status, err)
+	}
+	if len(resources) != 2 || resources[0].Employee.Name != "Albert" || resources[1].Employee.Name != "Carol" || nextCursor == "" {
+		t.Fatalf("Unexpected first page: %+v (cursor %q)", resources, nextCursor)
+	}
+
+	resources = nil
+	query.Cursor = nextCursor
+	nextCursor, status, err = store.QueryByOwnerId(ctx, "1234", query, &resources)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error querying resources: %d, %v", status, err)
+	}
+	if len(resources) != 2 || resources[0].Employee.Name != "Bob" || resources[1].Employee.Name != "Eve" || nextCursor != "" {
+		t.Fatalf("Unexpected second page: %+v (cursor %q)", resources, nextCursor)
+	}
+
+	resources = nil
+	prefixQuery := resourceStore.ResourceQuery{
+		Filters: []resourceStore.QueryFilter{{Field: "employee.name", Op: resourceStore.QUERY_OP_PREFIX, Values: []any{"Al"}}},
+	}
+	if _, status, err := store.QueryByOwnerId(ctx, "1234", prefixQuery, &resources); status != constants.RESOURCE_OK_CODE || len(resources) != 2 {
+		t.Fatalf("Expected 2 resources with the prefix 'Al', got %d: %d, %v", len(resources), status, err)
+	}
+
+	resources = nil
+	eqQuery := resourceStore.ResourceQuery{
+		Filters: []resourceStore.QueryFilter{{Field: "employee.age", Op: resourceStore.QUERY_OP_EQ, Values: []any{30}}},
+	}
+	if _, status, err := store.QueryByOwnerId(ctx, "1234", eqQuery, &resources); status != constants.RESOURCE_OK_CODE || len(resources) != 2 {
+		t.Fatalf("Expected 2 resources aged 30, got %d: %d, %v", len(resources), status, err)
+	}
+
+	query.Cursor = "not-a-cursor"
+	if _, status, _ := store.QueryByOwnerId(ctx, "1234", query, &resources); status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected bad request for an invalid cursor, got %d", status)
+	}
+}
+
+func TestMemoryResourceStoreInTx(t *testing.T) {
+	store := newMemoryStore(t)
+	ctx := context.Background()
+
+	status, err := store.InTx(ctx, func(tx resourceStore.ResourceTx[EmployeeResource]) error {
+		for _, name := range []string{"Alice", "Bob"} {
+			resource := &EmployeeResource{ResourceBase: resourceStore.ResourceBase{Id: name, OwnerId: "1234"}, Employee: Employee{Name: name}}
+			if _, _, err := tx.CreateResource(resource, "1234:"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error in InTx: %d, %v", status, err)
+	}
+
+	// a failed operation rolls back the whole unit of work even though fn ignores the error
+	status, _ = store.InTx(ctx, func(tx resourceStore.ResourceTx[EmployeeResource]) error {
+		tx.DeleteResource("1234", "Alice", 1, "1234:")
+		tx.CreateResource(&EmployeeResource{ResourceBase: resourceStore.ResourceBase{Id: "Bob", OwnerId: "1234"}}, "1234:")
+		return nil
+	})
+	if status != constants.RESOURCE_ALREADY_EXISTS_CODE {
+		t.Fatalf("Expected already exists from the failed unit of work, got %d", status)
+	}
+
+	status, _ = store.InTx(ctx, func(tx resourceStore.ResourceTx[EmployeeResource]) error {
+		tx.DeleteResource("1234", "Bob", 1, "1234:")
+		return errors.New("changed my mind")
+	})
+	if status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected bad request when fn returns an error, got %d", status)
+	}
+
+	var resources []EmployeeResource
+	if status, err := store.GetByOwnerId(ctx, "1234", &resources); status != constants.RESOURCE_OK_CODE || len(resources) != 2 {
+		t.Fatalf("Expected both resources to survive the rolled back units of work, got %d: %d, %v", len(resources), status, err)
+	}
+	var maxClock uint64
+	if err := store.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != 2 {
+		t.Fatalf("Expected max clock 2 after the rollbacks, got %d, %v", maxClock, err)
+	}
+}
+
+func TestMemoryResourceStoreClose(t *testing.T) {
+	store := newMemoryStore(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var resources []EmployeeResource
+	if status, _ := store.GetByOwnerId(ctx, "1234", &resources); status != constants.RESOURCE_INTERNAL_ERROR_CODE {
+		t.Fatalf("Expected internal error status for a cancelled context, got %d", status)
+	}
+
+	if err := store.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing store: %v", err)
+	}
+	if err := store.HealthCheck(context.Background()); err == nil {
+		t.Fatal("Expected health check to fail on a closed store")
+	}
+}
+
+func TestNounResourceRouterWithMemoryStore(t *testing.T) {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	noAuthModel, err := service.NewAuthModel(security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize AuthModel: %v", err)
+	}
+
+	router := helpers.NewNounResourceRouterWithStore[EmployeeResource](service, newMemoryStore(t), "employees",
+		helpers.NounResourceAuthModels{Get: noAuthModel, Post: noAuthModel, Put: noAuthModel, Delete: noAuthModel})
+	if router == nil {
+		t.Fatal("Expected non-nil NounResourceRouter")
+	}
+
+	call := func(method string, url string, body []byte) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, bytes.NewReader(body))
+		request.Header.Set("X-AuthToken", "1234:")
+		recorder := httptest.NewRecorder()
+		service.Router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	body, _ := json.Marshal(EmployeeResource{Employee: Employee{Name: "Alice", Age: 30}})
+	response := call(http.MethodPost, "/v1/identities/1234/employees", body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 from POST, got %d: %s", response.Code, response.Body.String())
+	}
+	var created EmployeeResource
+	json.Unmarshal(response.Body.Bytes(), &created)
+
+	response = call(http.MethodGet, "/v1/identities/1234/employees/"+created.Id, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from GET, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = call(http.MethodGet, "/v1/identities/1234/employees?filter=employee.name:eq:Alice&limit=1", nil)
+	if response.Code != http.StatusOK || response.Header().Get(helpers.NEXT_CURSOR_HEADER) != "" {
+		t.Fatalf("Expected a single page from the query, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = call(http.MethodDelete, fmt.Sprintf("/v1/identities/1234/employees/%s?version=%d", created.Id, created.Version), nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from DELETE, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = call(http.MethodGet, "/v1/identities/1234/employees/"+created.Id, nil)
+	if response.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 after DELETE, got %d", response.Code)
+	}
+}
+
+func TestMemoryResourceStoreSubscribeJournal(t *testing.T) {
+	store := newMemoryStore(t)
+	ctx := context.Background()
+
+	create := func(name string) {
+		resource := &EmployeeResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Employee: Employee{Name: name}}
+		if _, status, err := store.CreateResource(ctx, resource, "1234:"); status != constants.RESOURCE_OK_CODE {
+			t.Fatalf("Error creating resource: %d, %v", status, err)
+		}
+	}
+	receive := func(entries <-chan resourceStore.ResourceJournalEntry, expectedClock uint64) {
+		select {
+		case entry, ok := <-entries:
+			if !ok || entry.Clock != expectedClock {
+				t.Fatalf("Expected journal entry %d, got %d (open %v)", expectedClock, entry.Clock, ok)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for journal entry %d", expectedClock)
+		}
+	}
+
+	create("Alice")
+	create("Bob")
+
+	subscriptionCtx, cancel := context.WithCancel(ctx)
+	entries := store.SubscribeJournal(subscriptionCtx, 2)
+
+	// catch up from the journal first, then live entries
+	receive(entries, 2)
+	create("Carol")
+	receive(entries, 3)
+
+	cancel()
+	select {
+	case _, ok := <-entries:
+		if ok {
+			t.Fatal("Expected no more journal entries after the subscription was cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the subscription to close")
+	}
+
+	// closing the store ends the remaining subscriptions
+	entries = store.SubscribeJournal(ctx, 4)
+	store.Close(ctx)
+	if _, ok := <-entries; ok {
+		t.Fatal("Expected the subscription to close with the store")
+	}
+}
+
+func TestStoreRegistryWithMemoryStore(t *testing.T) {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	store := newMemoryStore(t)
+	if err := resourceStore.RegisterStore[EmployeeResource](service.Stores, store); err != nil {
+		t.Fatalf("Error registering store: %v", err)
+	}
+	if err := resourceStore.RegisterStore[EmployeeResource](service.Stores, newMemoryStore(t)); err == nil {
+		t.Fatal("Expected an error registering a second store for the same type")
+	}
+
+	// the default constructors all use the registered store rather than opening their own
+	noAuthModel, err := service.NewAuthModel(security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize AuthModel: %v", err)
+	}
+	if helpers.NewNounResourceRouter[EmployeeResource](service, "employees", helpers.NounResourceAuthModels{Get: noAuthModel}) == nil {
+		t.Fatal("Expected non-nil NounResourceRouter")
+	}
+	if helpers.NewNounJournalRouter[EmployeeResource](service, security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil) == nil {
+		t.Fatal("Expected non-nil NounJournalRouter")
+	}
+	if helpers.NewNounHealthCheckRouter[EmployeeResource](service, security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil) == nil {
+		t.Fatal("Expected non-nil HealthCheckRouter")
+	}
+
+	createEmployees(t, store, "Alice")
+	var resources []EmployeeResource
+	response := conditionalCall(service, http.MethodGet, "/v1/identities/1234/employees", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &resources); err != nil || len(resources) != 1 {
+		t.Fatalf("Expected the resource router to read the registered store, got %d: %s", response.Code, response.Body.String())
+	}
+
+	store.Close(context.Background())
+	response = conditionalCall(service, http.MethodGet, "/v1/health", nil)
+	var health serviceBase.HealthStatus
+	if err := json.Unmarshal(response.Body.Bytes(), &health); err != nil || health.DependencyStatus["database"] != constants.HEALTH_STATUS_UNHEALTHY {
+		t.Fatalf("Expected the health check to report on the registered store, got %d: %s", response.Code, response.Body.String())
+	}
+}
@@ -0,0 +1,21 @@
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Exponential is the wait before retry number retry (1 for the first) of a policy that starts at initial and
+// doubles for each retry up to maxBackoff. It has jitter - somewhere between half and all of that - so that
+// callers that failed together don't retry together.
+func Exponential(initial time.Duration, maxBackoff time.Duration, retry int) time.Duration {
+	backoff := initial
+	for i := 1; i < retry && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
@@ -5,32 +5,64 @@ const (
 )
 
 const (
-	SERVICE_INSTANCE_NAME  = "SERVICE_INSTANCE_NAME"
-	DB_CONNECTION_STRING   = "DB_CONNECTSTRING"
-	JOURNAL_PARTITION_NAME = "JOURNAL_PARTITION_NAME"
-	IDENTITY_SERVICE       = "IDENTITY_SERVICE"
-	LISTEN_ADDRESS         = "LISTEN_ADDRESS"
-	HTTPS_CERT_FILENAME    = "HTTPS_CERT_FILENAME"
-	HTTPS_KEY_FILENAME     = "HTTPS_KEY_FILENAME"
-	CALLED_SERVICES        = "CALLED_SERVICES"
+	SERVICE_INSTANCE_NAME         = "SERVICE_INSTANCE_NAME"
+	DB_CONNECTION_STRING          = "DB_CONNECTSTRING"
+	JOURNAL_PARTITION_NAME        = "JOURNAL_PARTITION_NAME"
+	DB_SCHEMA_NAME                = "DB_SCHEMA"                  // optional - defaults to public
+	DB_RESOURCES_TABLE            = "DB_RESOURCES_TABLE"         // optional - defaults to Resources
+	DB_JOURNAL_TABLE              = "DB_JOURNAL_TABLE"           // optional - defaults to Journal
+	DB_SKIP_MIGRATIONS            = "DB_SKIP_MIGRATIONS"         // optional - set to true to apply the schema migrations separately
+	DB_REPLICA_CONNECTION_STRINGS = "DB_REPLICA_CONNECTSTRINGS"  // optional - comma separated read replicas (reads all go to the primary when not set)
+	DB_REPLICA_MAX_LAG            = "DB_REPLICA_MAX_LAG"         // optional - a replica lagging more than this is skipped (defaults to 5s)
+	DB_REPLICA_CHECK_INTERVAL     = "DB_REPLICA_CHECK_INTERVAL"  // optional - how often replica health and lag are checked (defaults to 5s)
+	DB_RETRY_MAX_ATTEMPTS         = "DB_RETRY_MAX_ATTEMPTS"      // optional - attempts at a store operation that fails transiently, 1 to turn retries off (defaults to 3)
+	DB_RETRY_INITIAL_BACKOFF      = "DB_RETRY_INITIAL_BACKOFF"   // optional - the wait before the first retry, doubled for each retry after it (defaults to 50ms)
+	DB_RETRY_MAX_BACKOFF          = "DB_RETRY_MAX_BACKOFF"       // optional - the longest wait between retries (defaults to 1s)
+	JOURNAL_RETENTION_MODE        = "JOURNAL_RETENTION_MODE"     // optional - delete or compact (off when not set)
+	JOURNAL_RETENTION_WINDOW      = "JOURNAL_RETENTION_WINDOW"   // e.g. 720h - entries older than this are deleted or compacted
+	JOURNAL_RETENTION_INTERVAL    = "JOURNAL_RETENTION_INTERVAL" // optional - how often retention runs (defaults to 1h)
+	HTTP_REQUIRE_IF_MATCH         = "HTTP_REQUIRE_IF_MATCH"      // optional - set to true to reject PUT, PATCH and DELETE requests without If-Match (428)
+	WEBHOOK_MAX_ATTEMPTS          = "WEBHOOK_MAX_ATTEMPTS"       // optional - deliveries of a journal entry to a subscriber before it is dead-lettered (defaults to 5)
+	WEBHOOK_INITIAL_BACKOFF       = "WEBHOOK_INITIAL_BACKOFF"    // optional - the wait before the first redelivery, doubled for each one after it (defaults to 1s)
+	WEBHOOK_MAX_BACKOFF           = "WEBHOOK_MAX_BACKOFF"        // optional - the longest wait between deliveries (defaults to 1m)
+	WEBHOOK_TIMEOUT               = "WEBHOOK_TIMEOUT"            // optional - how long a subscriber has to reply to a delivery (defaults to 10s)
+	WEBHOOK_POLL_INTERVAL         = "WEBHOOK_POLL_INTERVAL"      // optional - how often the journal is read for new entries once caught up (defaults to 1s)
+	IDENTITY_SERVICE              = "IDENTITY_SERVICE"
+	LISTEN_ADDRESS                = "LISTEN_ADDRESS"
+	HTTPS_CERT_FILENAME           = "HTTPS_CERT_FILENAME"
+	HTTPS_KEY_FILENAME            = "HTTPS_KEY_FILENAME"
+	CALLED_SERVICES               = "CALLED_SERVICES"
 )
 
 const (
 	HTTP_GET    = "GET"
 	HTTP_POST   = "POST"
 	HTTP_PUT    = "PUT"
+	HTTP_PATCH  = "PATCH"
 	HTTP_DELETE = "DELETE"
 )
 
 const (
-	INTERNAL_SERVER_ERROR          = "a backend system error occurred - please check the service logs"
-	PRIMARY_KEY_VIOLATION_SQL_CODE = "23505" // Conflict on primary key
-	RESOURCE_NOT_FOUND_ERROR_CODE  = -404    // Resource not found (note similarity to HTTP status codes)
-	RESOURCE_UNAUTHORIZED_CODE     = -403    // Change not authorized (note similarity to HTTP status codes)
-	RESOURCE_BAD_REQUEST_CODE      = -400    // Bad request (note similarity to HTTP status codes)
-	RESOURCE_ALREADY_EXISTS_CODE   = -409    // Resource already exists (note similarity to HTTP status codes)
-	RESOURCE_INTERNAL_ERROR_CODE   = -500    // Internal server error (note similarity to HTTP status codes)
-	RESOURCE_OK_CODE               = 0       // OK
+	INTERNAL_SERVER_ERROR               = "a backend system error occurred - please check the service logs"
+	PRIMARY_KEY_VIOLATION_SQL_CODE      = "23505" // Conflict on primary key
+	RESOURCE_NOT_FOUND_ERROR_CODE       = -404    // Resource not found (note similarity to HTTP status codes)
+	RESOURCE_UNAUTHORIZED_CODE          = -403    // Change not authorized (note similarity to HTTP status codes)
+	RESOURCE_BAD_REQUEST_CODE           = -400    // Bad request (note similarity to HTTP status codes)
+	RESOURCE_ALREADY_EXISTS_CODE        = -409    // Resource already exists (note similarity to HTTP status codes)
+	RESOURCE_GONE_CODE                  = -410    // Journal entries no longer retained - the client must resync (note similarity to HTTP status codes)
+	RESOURCE_PRECONDITION_FAILED_CODE   = -412    // If-Match or If-None-Match did not match (note similarity to HTTP status codes)
+	RESOURCE_UNSUPPORTED_TYPE_CODE      = -415    // Unsupported media type, e.g. of a patch (note similarity to HTTP status codes)
+	RESOURCE_PRECONDITION_REQUIRED_CODE = -428    // A conditional header is required (note similarity to HTTP status codes)
+	RESOURCE_INTERNAL_ERROR_CODE        = -500    // Internal server error (note similarity to HTTP status codes)
+	RESOURCE_OK_CODE                    = 0       // OK
+)
+
+// values written to ResourceBase.LastAction by the resource store
+const (
+	RESOURCE_ACTION_CREATE   = "create"
+	RESOURCE_ACTION_UPDATE   = "update"
+	RESOURCE_ACTION_DELETE   = "delete"
+	RESOURCE_ACTION_UNDELETE = "undelete"
 )
 
 const (
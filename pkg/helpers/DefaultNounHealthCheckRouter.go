@@ -17,7 +17,7 @@ import (
 
 type HealthCheckRouter[R any] struct {
 	*serviceBase.ServiceBase
-	store *resourceStore.PostgresResourceStoreWithJournal[R]
+	store resourceStore.IResourceStore[R]
 }
 
 func NewNounHealthCheckRouter[R any](
@@ -27,17 +27,34 @@ func NewNounHealthCheckRouter[R any](
 	timeout security.AuthTimeout,
 	approvedList []string) *HealthCheckRouter[R] {
 
-	authModel, err := serviceBase.NewAuthModel(realm, authType, timeout, approvedList)
+	// the store (and its pool) is shared with the service's other routers over R, and closed by the service
+	store, err := resourceStore.SharedStore[R](serviceBase.Stores, resourceStore.TableNamesFromConfig(serviceBase.Configuration))
 	if err != nil {
-		serviceBase.Logger.Info("noun healthcheck router - failed to initialize AuthModel with ", err)
+		serviceBase.Logger.Info("noun healthcheck router - error getting the shared resource store with ", err)
 		return nil
 	}
 
-	store, err := resourceStore.NewPostgresResourceStoreWithJournal[R](
-		serviceBase.Configuration,
-		serviceBase.Logger)
+	return NewNounHealthCheckRouterWithStore[R](serviceBase, store, realm, authType, timeout, approvedList)
+}
+
+// NewNounHealthCheckRouterWithStore is NewNounHealthCheckRouter over a store supplied by the caller (e.g. a
+// resourceStore.MemoryResourceStore in unit tests). The caller remains responsible for closing the store.
+func NewNounHealthCheckRouterWithStore[R any](
+	serviceBase *serviceBase.ServiceBase,
+	store resourceStore.IResourceStore[R],
+	realm string,
+	authType security.AuthTypes,
+	timeout security.AuthTimeout,
+	approvedList []string) *HealthCheckRouter[R] {
+
+	if store == nil {
+		serviceBase.Logger.Info("noun healthcheck router - a resource store is required")
+		return nil
+	}
+
+	authModel, err := serviceBase.NewAuthModel(realm, authType, timeout, approvedList)
 	if err != nil {
-		serviceBase.Logger.Info("noun healthcheck router - error creating PostgresResourceStoreWithJournal with ", err)
+		serviceBase.Logger.Info("noun healthcheck router - failed to initialize AuthModel with ", err)
 		return nil
 	}
 
@@ -67,7 +84,7 @@ func (h *HealthCheckRouter[R]) GetHealthStandalone(w http.ResponseWriter, r *htt
 		Status:           constants.HEALTH_STATUS_HEALTHY,
 		DependencyStatus: map[string]string{}}
 
-	err := h.store.HealthCheck()
+	err := h.store.HealthCheck(r.Context())
 	if err != nil {
 		h.Logger.Info("noun healthcheck router - the call to the resource store HealthCheck() in GetHealthStandalone failed with: ", err)
 		health.DependencyStatus["database"] = constants.HEALTH_STATUS_UNHEALTHY
@@ -4,11 +4,13 @@ package helpers
 // since the code is likely to be identical for each noun service.
 //
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/geraldhinson/siftd-base/pkg/constants"
 	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
@@ -16,9 +18,15 @@ import (
 	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
 )
 
+// JOURNAL_STREAM_HEARTBEAT_INTERVAL is the default time between heartbeats on an idle /v1/journal/stream
+const JOURNAL_STREAM_HEARTBEAT_INTERVAL = 15 * time.Second
+
 type NounJournalRouter[R any] struct {
 	*serviceBase.ServiceBase
-	store *resourceStore.PostgresResourceStoreWithJournal[R]
+	store resourceStore.IResourceStore[R]
+	// StreamHeartbeatInterval is how often an SSE comment is sent on an idle stream so that proxies and
+	// clients don't time the connection out
+	StreamHeartbeatInterval time.Duration
 }
 
 func NewNounJournalRouter[R any](
@@ -28,23 +36,47 @@ func NewNounJournalRouter[R any](
 	timeout security.AuthTimeout,
 	approvedList []string) *NounJournalRouter[R] {
 
-	authModel, err := serviceBase.NewAuthModel(realm, authType, timeout, approvedList)
+	// the store (and its pool) is shared with the service's other routers over R, and closed by the service
+	store, err := resourceStore.SharedStore[R](serviceBase.Stores, resourceStore.TableNamesFromConfig(serviceBase.Configuration))
 	if err != nil {
-		serviceBase.Logger.Info("noun journal router - failed to initialize AuthModel with ", err)
+		serviceBase.Logger.Info("noun journal router - error getting the shared resource store with ", err)
+		return nil
+	}
+
+	return NewNounJournalRouterWithStore[R](serviceBase, store, realm, authType, timeout, approvedList)
+}
+
+// NewNounJournalRouterWithStore is NewNounJournalRouter over a store supplied by the caller (e.g. a
+// resourceStore.MemoryResourceStore in unit tests). The caller remains responsible for closing the store.
+func NewNounJournalRouterWithStore[R any](
+	serviceBase *serviceBase.ServiceBase,
+	store resourceStore.IResourceStore[R],
+	realm string,
+	authType security.AuthTypes,
+	timeout security.AuthTimeout,
+	approvedList []string) *NounJournalRouter[R] {
+
+	if store == nil {
+		serviceBase.Logger.Info("noun journal router - a resource store is required")
 		return nil
 	}
 
-	store, err := resourceStore.NewPostgresResourceStoreWithJournal[R](
-		serviceBase.Configuration,
-		serviceBase.Logger)
+	authModel, err := serviceBase.NewAuthModel(realm, authType, timeout, approvedList)
 	if err != nil {
-		serviceBase.Logger.Info("noun journal router - error creating PostgresResourceStoreWithJournal with ", err)
+		serviceBase.Logger.Info("noun journal router - failed to initialize AuthModel with ", err)
 		return nil
 	}
 
 	nounJournalRouter := &NounJournalRouter[R]{
-		ServiceBase: serviceBase,
-		store:       store,
+		ServiceBase:             serviceBase,
+		store:                   store,
+		StreamHeartbeatInterval: JOURNAL_STREAM_HEARTBEAT_INTERVAL,
+	}
+
+	retentionPolicy, err := resourceStore.RetentionPolicyFromConfig(serviceBase.Configuration)
+	if err != nil {
+		serviceBase.Logger.Info("noun journal router - invalid journal retention configuration: ", err)
+		return nil
 	}
 
 	nounJournalRouter.setupRoutes(authModel)
@@ -53,6 +85,15 @@ func NewNounJournalRouter[R any](
 		return nil
 	}
 
+	if retentionPolicy.Mode != resourceStore.RETENTION_MODE_NONE {
+		serviceBase.Logger.Infof("noun journal router - applying journal retention (%s) to entries older than %v every %v",
+			retentionPolicy.Mode, retentionPolicy.Window, retentionPolicy.Interval)
+		serviceBase.StartBackgroundJob("noun journal router retention", retentionPolicy.Interval, func(ctx context.Context) error {
+			_, err := store.ApplyJournalRetention(ctx, retentionPolicy)
+			return err
+		})
+	}
+
 	return nounJournalRouter
 }
 
@@ -63,6 +104,18 @@ func (j *NounJournalRouter[R]) setupRoutes(authModel *security.AuthModel) {
 	routeString = "/v1/journalMaxClock"
 	j.RegisterRoute(constants.HTTP_GET, routeString, authModel, j.GetJournalMaxClock)
 
+	routeString = "/v1/journalMinClock"
+	j.RegisterRoute(constants.HTTP_GET, routeString, authModel, j.GetJournalMinClock)
+
+	routeString = "/v1/journalClocks"
+	j.RegisterRoute(constants.HTTP_GET, routeString, authModel, j.GetJournalClocks)
+
+	routeString = "/v1/journal/merged"
+	j.RegisterRoute(constants.HTTP_GET, routeString, authModel, j.GetMergedJournalChanges)
+
+	routeString = "/v1/journal/stream"
+	j.RegisterRoute(constants.HTTP_GET, routeString, authModel, j.GetJournalStream)
+
 }
 
 func (j *NounJournalRouter[R]) GetJournalChanges(w http.ResponseWriter, r *http.Request) {
@@ -95,8 +148,20 @@ func (j *NounJournalRouter[R]) GetJournalChanges(w http.ResponseWriter, r *http.
 		return
 	}
 
+	// the optional 'partition' parameter limits the changes to one journal partition
+	partition := params["partition"]
+	if partition == "" || partition == j.store.JournalPartitionName() {
+		if !j.checkClockRetained(w, r, uint64(clock), "GetJournalChanges") {
+			return
+		}
+	}
+
 	var journalEntries []resourceStore.ResourceJournalEntry
-	err = j.store.GetJournalChanges(clock, limit, &journalEntries)
+	if partition != "" {
+		err = j.store.GetPartitionJournalChanges(r.Context(), partition, clock, limit, &journalEntries)
+	} else {
+		err = j.store.GetJournalChanges(r.Context(), clock, limit, &journalEntries)
+	}
 	//	START HERE with GetJournalChanges returning error code like the other methods do the noun router
 	if err != nil {
 		j.Logger.Info("noun journal router - call to resource store GetJournalChanges() in GetJournalChanges failed with: ", err)
@@ -120,7 +185,13 @@ func (j *NounJournalRouter[R]) GetJournalChanges(w http.ResponseWriter, r *http.
 
 func (j *NounJournalRouter[R]) GetJournalMaxClock(w http.ResponseWriter, r *http.Request) {
 	var maxClock uint64
-	err := j.store.GetJournalMaxClock(&maxClock)
+	var err error
+	// the optional 'partition' parameter returns the max clock of one journal partition
+	if partition := j.GetQueryParams(r)["partition"]; partition != "" {
+		err = j.store.GetPartitionJournalMaxClock(r.Context(), partition, &maxClock)
+	} else {
+		err = j.store.GetJournalMaxClock(r.Context(), &maxClock)
+	}
 	//	START HERE with GetJournalChanges returning error code like the other methods do the noun router
 	if err != nil {
 		j.Logger.Info("noun journal router - call to resource store get the journal's max clock in GetJournalMaxClock failed with: ", err)
@@ -132,3 +203,191 @@ func (j *NounJournalRouter[R]) GetJournalMaxClock(w http.ResponseWriter, r *http
 
 	j.WriteHttpOK(w, jsonResults)
 }
+
+// GetJournalClocks returns the max clock of each journal partition, which a consumer of the merged journal can
+// compare with its own clocks to measure its lag per partition
+func (j *NounJournalRouter[R]) GetJournalClocks(w http.ResponseWriter, r *http.Request) {
+	var clocks resourceStore.JournalClockVector
+	err := j.store.GetJournalClocks(r.Context(), &clocks)
+	if err != nil {
+		j.Logger.Info("noun journal router - call to resource store GetJournalClocks() in GetJournalClocks failed with: ", err)
+		j.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, err)
+		return
+	}
+
+	jsonResults, errmsg := json.Marshal(resourceStore.JournalClocks{Clocks: clocks})
+	if errmsg != nil {
+		j.Logger.Info("noun journal router - call to json marshall journal clocks in GetJournalClocks failed with : ", errmsg)
+		j.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	j.WriteHttpOK(w, jsonResults)
+}
+
+// GetMergedJournalChanges returns the next entries of all partitions after the clocks in the 'since' parameter
+// (partition:clock pairs, e.g. US-EAST:12,US-WEST:9 - partitions that are left out are read from the start) along
+// with the clocks to pass as 'since' on the next call.
+func (j *NounJournalRouter[R]) GetMergedJournalChanges(w http.ResponseWriter, r *http.Request) {
+	params := j.GetQueryParams(r)
+	since, err := resourceStore.ParseJournalClockVector(params["since"])
+	if err != nil {
+		j.Logger.Info("noun journal router - failed to parse 'since' parameter in GetMergedJournalChanges: ", err)
+		j.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+	limit, err := strconv.ParseInt(params["limit"], 10, 64)
+	if err != nil {
+		j.Logger.Info("noun journal router - failed to parse 'limit' parameter in GetMergedJournalChanges: ", err)
+		j.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+	if limit < 1 {
+		err := errors.New("invalid < 1 'limit' parameter in GetMergedJournalChanges")
+		j.Logger.Info("noun journal router - ", err)
+		j.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+
+	if !j.checkClockRetained(w, r, since[j.store.JournalPartitionName()]+1, "GetMergedJournalChanges") {
+		return
+	}
+
+	changes := resourceStore.MergedJournalChanges{Entries: []resourceStore.ResourceJournalEntry{}}
+	changes.Clocks, err = j.store.GetMergedJournalChanges(r.Context(), since, limit, &changes.Entries)
+	if err != nil {
+		j.Logger.Info("noun journal router - call to resource store GetMergedJournalChanges() in GetMergedJournalChanges failed with: ", err)
+		j.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, err)
+		return
+	}
+
+	jsonResults, errmsg := json.Marshal(changes)
+	if errmsg != nil {
+		j.Logger.Info("noun journal router - call to json marshall journal entries in GetMergedJournalChanges failed with : ", errmsg)
+		j.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	j.WriteHttpOK(w, jsonResults)
+}
+
+// GetJournalMinClock returns the lowest clock that can still be read from the journal. It is 0 unless journal
+// retention has deleted entries - a follower behind it has to resync from the resources instead.
+func (j *NounJournalRouter[R]) GetJournalMinClock(w http.ResponseWriter, r *http.Request) {
+	var minClock uint64
+	err := j.store.GetJournalMinClock(r.Context(), &minClock)
+	if err != nil {
+		j.Logger.Info("noun journal router - call to resource store get the journal's min clock in GetJournalMinClock failed with: ", err)
+		j.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, err)
+		return
+	}
+
+	var jsonResults = []byte(fmt.Sprintf("{\"minClock\": %d}", minClock))
+
+	j.WriteHttpOK(w, jsonResults)
+}
+
+// checkClockRetained writes 410 Gone and returns false if journal retention has deleted entries from clock onwards
+func (j *NounJournalRouter[R]) checkClockRetained(w http.ResponseWriter, r *http.Request, clock uint64, methodName string) bool {
+	var minClock uint64
+	if err := j.store.GetJournalMinClock(r.Context(), &minClock); err != nil {
+		j.Logger.Infof("noun journal router - call to resource store GetJournalMinClock() in %s failed with: %v", methodName, err)
+		j.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, err)
+		return false
+	}
+	if clock < minClock {
+		err := fmt.Errorf("clock %d is older than the oldest retained journal entry (%d) - resync required", clock, minClock)
+		j.Logger.Infof("noun journal router - %s: %v", methodName, err)
+		j.WriteHttpError(w, constants.RESOURCE_GONE_CODE, err)
+		return false
+	}
+	return true
+}
+
+// GetJournalStream tails the journal as Server-Sent Events. Each entry is sent as a 'journal' event whose id is
+// the entry's clock, starting at the 'clock' parameter. A reconnecting EventSource sends the Last-Event-ID
+// header, which takes precedence so the stream resumes after the last entry the client received.
+func (j *NounJournalRouter[R]) GetJournalStream(w http.ResponseWriter, r *http.Request) {
+	var fromClock uint64
+	if lastEventId := r.Header.Get("Last-Event-ID"); lastEventId != "" {
+		lastClock, err := strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			j.Logger.Info("noun journal router - failed to parse 'Last-Event-ID' header in GetJournalStream: ", err)
+			j.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+			return
+		}
+		fromClock = lastClock + 1
+	} else {
+		params := j.GetQueryParams(r)
+		clock, err := strconv.ParseUint(params["clock"], 10, 64)
+		if err != nil {
+			j.Logger.Info("noun journal router - failed to parse 'clock' parameter in GetJournalStream: ", err)
+			j.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+			return
+		}
+		if clock < 1 {
+			err := errors.New("invalid < 1 'clock' parameter in GetJournalStream")
+			j.Logger.Info("noun journal router - ", err)
+			j.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+			return
+		}
+		fromClock = clock
+	}
+
+	if !j.checkClockRetained(w, r, fromClock, "GetJournalStream") {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := errors.New("streaming is not supported by the response writer in GetJournalStream")
+		j.Logger.Info("noun journal router - ", err)
+		j.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // stops nginx style proxies from buffering the events
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	entries := j.store.SubscribeJournal(ctx, fromClock)
+
+	heartbeatInterval := j.StreamHeartbeatInterval
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = JOURNAL_STREAM_HEARTBEAT_INTERVAL
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				// the store was closed
+				return
+			}
+			jsonEntry, errmsg := json.Marshal(entry)
+			if errmsg != nil {
+				j.Logger.Info("noun journal router - call to json marshall journal entry in GetJournalStream failed with : ", errmsg)
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: journal\ndata: %s\n\n", entry.Clock, jsonEntry)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-ctx.Done():
+			return
+		case <-j.ShutdownStarted():
+			return
+		}
+		if err != nil {
+			// the client has gone away
+			return
+		}
+		flusher.Flush()
+	}
+}
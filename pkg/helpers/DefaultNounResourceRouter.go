@@ -0,0 +1,554 @@
+package helpers
+
+// It is not required to use this helper implementation, but it is provided as a convenience
+// since the code is likely to be identical for each noun service.
+//
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+	"github.com/gorilla/mux"
+)
+
+// NEXT_CURSOR_HEADER carries the cursor for the next page of a filtered/sorted/paged GET of a noun's resources
+const NEXT_CURSOR_HEADER = "X-Next-Cursor"
+
+// NounResourceAuthModels holds the AuthModel used to secure each verb exposed by the NounResourceRouter.
+// A nil AuthModel means the verb is not exposed at all (e.g. leave Delete nil for a noun that can't be deleted).
+type NounResourceAuthModels struct {
+	Get    *security.AuthModel
+	Post   *security.AuthModel
+	Put    *security.AuthModel
+	Patch  *security.AuthModel
+	Delete *security.AuthModel
+}
+
+type NounResourceRouter[R any] struct {
+	*serviceBase.ServiceBase
+	noun           string
+	store          resourceStore.IResourceStore[R]
+	requireIfMatch bool
+}
+
+// NewNounResourceRouter registers the standard CRUD routes for a noun:
+//
+//	GET    /v1/identities/{identityId}/<noun>        - all resources owned by the identity, or a page of them
+//	                                                   when filter/sort/limit/cursor are passed (see resourceStore.ParseResourceQuery)
+//	POST   /v1/identities/{identityId}/<noun>        - create a resource owned by the identity
+//	GET    /v1/identities/{identityId}/<noun>/{id}   - a single resource, or the resource as it was at a point in
+//	                                                   the journal when asOfClock=<clock> or asOfTime=<RFC 3339 time> is passed
+//	GET    /v1/identities/{identityId}/<noun>/{id}/history             - the versions of a resource, oldest first
+//	                                                                     (paged with afterVersion and limit)
+//	GET    /v1/identities/{identityId}/<noun>/{id}/versions/{version}  - one version of a resource
+//	PUT    /v1/identities/{identityId}/<noun>/{id}   - replace a resource (version in the body must match)
+//	PATCH  /v1/identities/{identityId}/<noun>/{id}   - patch a resource (?version= must match) with a body of type
+//	                                                   application/merge-patch+json or application/json-patch+json
+//	DELETE /v1/identities/{identityId}/<noun>/{id}   - soft-delete a resource (?version= must match)
+//
+// A single resource is returned with an ETag of its version. A GET with a matching If-None-Match gets a 304, a
+// POST with If-None-Match: * only ever creates (412 if the id is taken), and PUT, PATCH and DELETE take the version
+// that must match from If-Match instead of the body or ?version= (412 when it doesn't). Set HTTP_REQUIRE_IF_MATCH
+// to true to make If-Match mandatory (428 without it).
+//
+// Example usage from a service:
+//
+//	memberAuth, err := service.NewAuthModel(security.REALM_MEMBER, security.MATCHING_IDENTITY, security.ONE_DAY, nil)
+//	router := helpers.NewNounResourceRouter[EmployeeResource](service, "employees",
+//		helpers.NounResourceAuthModels{Get: memberAuth, Post: memberAuth, Put: memberAuth, Delete: memberAuth})
+//
+// The store is the shared store for R over the tables in the configuration. A service with more than one resource
+// type gives each of the others tables of its own and builds its routers WithStore:
+//
+//	invoices, err := resourceStore.SharedStore[InvoiceResource](service.Stores, resourceStore.NounTableNames("public", "invoices"))
+//	router := helpers.NewNounResourceRouterWithStore[InvoiceResource](service, invoices, "invoices", authModels)
+func NewNounResourceRouter[R any](
+	serviceBase *serviceBase.ServiceBase,
+	noun string,
+	authModels NounResourceAuthModels) *NounResourceRouter[R] {
+
+	if noun == "" {
+		serviceBase.Logger.Info("noun resource router - the noun name used to build the routes is required")
+		return nil
+	}
+
+	// the store (and its pool) is shared with the service's other routers over R, and closed by the service
+	store, err := resourceStore.SharedStore[R](serviceBase.Stores, resourceStore.TableNamesFromConfig(serviceBase.Configuration))
+	if err != nil {
+		serviceBase.Logger.Info("noun resource router - error getting the shared resource store with ", err)
+		return nil
+	}
+
+	return NewNounResourceRouterWithStore[R](serviceBase, store, noun, authModels)
+}
+
+// NewNounResourceRouterWithStore is NewNounResourceRouter over a store supplied by the caller (e.g. a
+// resourceStore.MemoryResourceStore in unit tests). The caller remains responsible for closing the store.
+func NewNounResourceRouterWithStore[R any](
+	serviceBase *serviceBase.ServiceBase,
+	store resourceStore.IResourceStore[R],
+	noun string,
+	authModels NounResourceAuthModels) *NounResourceRouter[R] {
+
+	if noun == "" {
+		serviceBase.Logger.Info("noun resource router - the noun name used to build the routes is required")
+		return nil
+	}
+	if store == nil {
+		serviceBase.Logger.Info("noun resource router - a resource store is required")
+		return nil
+	}
+
+	nounResourceRouter := &NounResourceRouter[R]{
+		ServiceBase:    serviceBase,
+		noun:           noun,
+		store:          store,
+		requireIfMatch: serviceBase.Configuration.GetBool(constants.HTTP_REQUIRE_IF_MATCH),
+	}
+
+	nounResourceRouter.setupRoutes(authModels)
+	if nounResourceRouter.Router == nil {
+		serviceBase.Logger.Info("noun resource router - error creating NounResourceRouter")
+		return nil
+	}
+
+	return nounResourceRouter
+}
+
+func (n *NounResourceRouter[R]) setupRoutes(authModels NounResourceAuthModels) {
+	var collectionRoute = fmt.Sprintf("/v1/identities/{identityId}/%s", n.noun)
+	var itemRoute = collectionRoute + "/{id}"
+
+	if authModels.Get != nil {
+		n.RegisterRoute(constants.HTTP_GET, collectionRoute, authModels.Get, n.GetResources)
+		n.RegisterRoute(constants.HTTP_GET, itemRoute, authModels.Get, n.GetResource)
+		n.RegisterRoute(constants.HTTP_GET, itemRoute+"/history", authModels.Get, n.GetResourceHistory)
+		n.RegisterRoute(constants.HTTP_GET, itemRoute+"/versions/{version}", authModels.Get, n.GetResourceVersion)
+	}
+	if authModels.Post != nil {
+		n.RegisterRoute(constants.HTTP_POST, collectionRoute, authModels.Post, n.CreateResource)
+	}
+	if authModels.Put != nil {
+		n.RegisterRoute(constants.HTTP_PUT, itemRoute, authModels.Put, n.UpdateResource)
+	}
+	if authModels.Patch != nil {
+		n.RegisterRoute(constants.HTTP_PATCH, itemRoute, authModels.Patch, n.PatchResource)
+	}
+	if authModels.Delete != nil {
+		n.RegisterRoute(constants.HTTP_DELETE, itemRoute, authModels.Delete, n.DeleteResource)
+	}
+}
+
+func (n *NounResourceRouter[R]) GetResources(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	identityId := params["identityId"]
+
+	var resources []R
+	if resourceStore.HasResourceQueryParams(r.URL.Query()) {
+		query, err := resourceStore.ParseResourceQuery(r.URL.Query())
+		if err != nil {
+			n.Logger.Info("noun resource router - invalid query parameters in GetResources: ", err)
+			n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+			return
+		}
+
+		nextCursor, status, err := n.store.QueryByOwnerId(r.Context(), identityId, query, &resources)
+		if err != nil {
+			n.Logger.Info("noun resource router - call to resource store QueryByOwnerId() in GetResources failed with: ", err)
+			n.WriteHttpError(w, status, err)
+			return
+		}
+		if nextCursor != "" {
+			w.Header().Set(NEXT_CURSOR_HEADER, nextCursor)
+		}
+	} else {
+		status, err := n.store.GetByOwnerId(r.Context(), identityId, &resources)
+		if err != nil {
+			n.Logger.Info("noun resource router - call to resource store GetByOwnerId() in GetResources failed with: ", err)
+			n.WriteHttpError(w, status, err)
+			return
+		}
+	}
+
+	jsonResults, errmsg := json.Marshal(resources)
+	if errmsg != nil {
+		n.Logger.Info("noun resource router - call to json marshal resources in GetResources failed with: ", errmsg)
+		n.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+	// make empty array if no results found - it's friendlier to the client
+	if string(jsonResults) == "null" {
+		jsonResults = []byte("[]")
+	}
+
+	n.WriteHttpOK(w, jsonResults)
+}
+
+func (n *NounResourceRouter[R]) GetResource(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	identityId := params["identityId"]
+	id := params["id"]
+
+	var resource R
+	queryParams := r.URL.Query()
+	if queryParams.Has("asOfClock") || queryParams.Has("asOfTime") {
+		asOf, err := parseAsOf(queryParams.Get("asOfClock"), queryParams.Get("asOfTime"))
+		if err != nil {
+			n.Logger.Info("noun resource router - invalid point-in-time parameters in GetResource: ", err)
+			n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+			return
+		}
+
+		status, err := n.store.GetByIdAsOf(r.Context(), identityId, id, asOf, &resource)
+		if err != nil {
+			n.Logger.Info("noun resource router - call to resource store GetByIdAsOf() in GetResource failed with: ", err)
+			n.WriteHttpError(w, status, err)
+			return
+		}
+	} else {
+		status, err := n.store.GetById(r.Context(), identityId, id, &resource)
+		if err != nil {
+			n.Logger.Info("noun resource router - call to resource store GetById() in GetResource failed with: ", err)
+			n.WriteHttpError(w, status, err)
+			return
+		}
+	}
+
+	setETag(w, &resource)
+	if matched, err := notModified(r, &resource); err != nil {
+		n.Logger.Info("noun resource router - invalid If-None-Match header in GetResource: ", err)
+		n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	} else if matched {
+		n.WriteHttpNotModified(w)
+		return
+	}
+
+	jsonResults, errmsg := json.Marshal(resource)
+	if errmsg != nil {
+		n.Logger.Info("noun resource router - call to json marshal resource in GetResource failed with: ", errmsg)
+		n.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	n.WriteHttpOK(w, jsonResults)
+}
+
+func (n *NounResourceRouter[R]) GetResourceHistory(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	identityId := params["identityId"]
+	id := params["id"]
+
+	var page resourceStore.HistoryPage
+	queryParams := r.URL.Query()
+	if value := queryParams.Get("afterVersion"); value != "" {
+		afterVersion, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			n.Logger.Info("noun resource router - failed to parse 'afterVersion' parameter in GetResourceHistory: ", err)
+			n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+			return
+		}
+		page.AfterVersion = uint(afterVersion)
+	}
+	if value := queryParams.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			n.Logger.Info("noun resource router - failed to parse 'limit' parameter in GetResourceHistory: ", err)
+			n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+			return
+		}
+		page.Limit = limit
+	}
+
+	var history []R
+	status, err := n.store.GetHistory(r.Context(), identityId, id, page, &history)
+	if err != nil {
+		n.Logger.Info("noun resource router - call to resource store GetHistory() in GetResourceHistory failed with: ", err)
+		n.WriteHttpError(w, status, err)
+		return
+	}
+
+	jsonResults, errmsg := json.Marshal(history)
+	if errmsg != nil {
+		n.Logger.Info("noun resource router - call to json marshal resources in GetResourceHistory failed with: ", errmsg)
+		n.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+	// make empty array if no results found - it's friendlier to the client
+	if string(jsonResults) == "null" {
+		jsonResults = []byte("[]")
+	}
+
+	n.WriteHttpOK(w, jsonResults)
+}
+
+func (n *NounResourceRouter[R]) GetResourceVersion(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	identityId := params["identityId"]
+	id := params["id"]
+
+	version, err := strconv.ParseUint(params["version"], 10, 64)
+	if err != nil {
+		n.Logger.Info("noun resource router - failed to parse 'version' in GetResourceVersion: ", err)
+		n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+
+	var resource R
+	status, err := n.store.GetVersion(r.Context(), identityId, id, uint(version), &resource)
+	if err != nil {
+		n.Logger.Info("noun resource router - call to resource store GetVersion() in GetResourceVersion failed with: ", err)
+		n.WriteHttpError(w, status, err)
+		return
+	}
+
+	setETag(w, &resource)
+	jsonResults, errmsg := json.Marshal(resource)
+	if errmsg != nil {
+		n.Logger.Info("noun resource router - call to json marshal resource in GetResourceVersion failed with: ", errmsg)
+		n.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	n.WriteHttpOK(w, jsonResults)
+}
+
+func (n *NounResourceRouter[R]) CreateResource(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	identityId := params["identityId"]
+
+	resource, err := n.decodeResource(r)
+	if err != nil {
+		n.Logger.Info("noun resource router - failed to decode request body in CreateResource: ", err)
+		n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+
+	// the owner is always the identity in the URL (which the AuthModel has vetted)
+	resourceBase := resource.GetResourceBase()
+	if resourceBase.OwnerId != "" && resourceBase.OwnerId != identityId {
+		err := errors.New("owner id in body does not match the identity in the request in CreateResource")
+		n.Logger.Info("noun resource router - ", err)
+		n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+	resourceBase.OwnerId = identityId
+
+	// a create never replaces a resource, so If-None-Match: * only changes the status of a taken id to 412
+	createOnly := false
+	if header := r.Header.Get(IF_NONE_MATCH_HEADER); header != "" {
+		if match, err := serviceBase.ParseETagMatch(header); err != nil || !match.Any {
+			err := fmt.Errorf("only If-None-Match: * is supported in CreateResource")
+			n.Logger.Info("noun resource router - ", err)
+			n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+			return
+		}
+		createOnly = true
+	}
+
+	createdResource, status, err := n.store.CreateResource(r.Context(), resource, security.GetAuthHeader(r))
+	if err != nil {
+		n.Logger.Info("noun resource router - call to resource store CreateResource() in CreateResource failed with: ", err)
+		if createOnly && errors.Is(err, resourceStore.ErrConflict) {
+			n.WriteHttpError(w, constants.RESOURCE_PRECONDITION_FAILED_CODE, errors.New("If-None-Match: * failed - a resource with this id already exists"))
+			return
+		}
+		n.WriteHttpError(w, status, err)
+		return
+	}
+	setETag(w, createdResource)
+
+	jsonResults, errmsg := json.Marshal(createdResource)
+	if errmsg != nil {
+		n.Logger.Info("noun resource router - call to json marshal resource in CreateResource failed with: ", errmsg)
+		n.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	n.WriteHttpCreated(w, jsonResults)
+}
+
+func (n *NounResourceRouter[R]) UpdateResource(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	identityId := params["identityId"]
+	id := params["id"]
+
+	resource, err := n.decodeResource(r)
+	if err != nil {
+		n.Logger.Info("noun resource router - failed to decode request body in UpdateResource: ", err)
+		n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+
+	resourceBase := resource.GetResourceBase()
+	version, status, err := n.expectedVersion(r, identityId, id, resourceBase.Version, true)
+	if err != nil {
+		n.Logger.Info("noun resource router - precondition failed in UpdateResource: ", err)
+		n.WriteHttpError(w, status, err)
+		return
+	}
+	resourceBase.Version = version
+
+	updatedResource, status, err := n.store.UpdateResource(r.Context(), resource, identityId, id, security.GetAuthHeader(r))
+	if err != nil {
+		n.Logger.Info("noun resource router - call to resource store UpdateResource() in UpdateResource failed with: ", err)
+		n.WriteHttpError(w, status, err)
+		return
+	}
+	setETag(w, updatedResource)
+
+	jsonResults, errmsg := json.Marshal(updatedResource)
+	if errmsg != nil {
+		n.Logger.Info("noun resource router - call to json marshal resource in UpdateResource failed with: ", errmsg)
+		n.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	n.WriteHttpOK(w, jsonResults)
+}
+
+func (n *NounResourceRouter[R]) PatchResource(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	identityId := params["identityId"]
+	id := params["id"]
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != string(resourceStore.PATCH_TYPE_MERGE_PATCH) && mediaType != string(resourceStore.PATCH_TYPE_JSON_PATCH)) {
+		err := fmt.Errorf("unsupported Content-Type '%s' - expected %s or %s", r.Header.Get("Content-Type"),
+			resourceStore.PATCH_TYPE_MERGE_PATCH, resourceStore.PATCH_TYPE_JSON_PATCH)
+		n.Logger.Info("noun resource router - ", err)
+		n.WriteHttpError(w, constants.RESOURCE_UNSUPPORTED_TYPE_CODE, err)
+		return
+	}
+
+	queryVersion, hasQueryVersion, err := n.versionParam(r)
+	if err != nil {
+		n.Logger.Info("noun resource router - failed to parse 'version' parameter in PatchResource: ", err)
+		n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+	version, status, err := n.expectedVersion(r, identityId, id, queryVersion, hasQueryVersion)
+	if err != nil {
+		n.Logger.Info("noun resource router - precondition failed in PatchResource: ", err)
+		n.WriteHttpError(w, status, err)
+		return
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		n.Logger.Info("noun resource router - failed to read request body in PatchResource: ", err)
+		n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+
+	patchedResource, status, err := n.store.PatchResource(r.Context(), identityId, id, version, patch,
+		resourceStore.PatchType(mediaType), security.GetAuthHeader(r))
+	if err != nil {
+		n.Logger.Info("noun resource router - call to resource store PatchResource() in PatchResource failed with: ", err)
+		n.WriteHttpError(w, status, err)
+		return
+	}
+	setETag(w, patchedResource)
+
+	jsonResults, errmsg := json.Marshal(patchedResource)
+	if errmsg != nil {
+		n.Logger.Info("noun resource router - call to json marshal resource in PatchResource failed with: ", errmsg)
+		n.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	n.WriteHttpOK(w, jsonResults)
+}
+
+func (n *NounResourceRouter[R]) DeleteResource(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	identityId := params["identityId"]
+	id := params["id"]
+
+	queryVersion, hasQueryVersion, err := n.versionParam(r)
+	if err != nil {
+		n.Logger.Info("noun resource router - failed to parse 'version' parameter in DeleteResource: ", err)
+		n.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+	version, status, err := n.expectedVersion(r, identityId, id, queryVersion, hasQueryVersion)
+	if err != nil {
+		n.Logger.Info("noun resource router - precondition failed in DeleteResource: ", err)
+		n.WriteHttpError(w, status, err)
+		return
+	}
+
+	deletedResource, status, err := n.store.DeleteResource(r.Context(), identityId, id, version, security.GetAuthHeader(r))
+	if err != nil {
+		n.Logger.Info("noun resource router - call to resource store DeleteResource() in DeleteResource failed with: ", err)
+		n.WriteHttpError(w, status, err)
+		return
+	}
+	setETag(w, deletedResource)
+
+	jsonResults, errmsg := json.Marshal(deletedResource)
+	if errmsg != nil {
+		n.Logger.Info("noun resource router - call to json marshal resource in DeleteResource failed with: ", errmsg)
+		n.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	n.WriteHttpOK(w, jsonResults)
+}
+
+// versionParam returns the ?version= parameter of a PATCH or DELETE, which If-Match can be used instead of
+func (n *NounResourceRouter[R]) versionParam(r *http.Request) (uint, bool, error) {
+	value := n.GetQueryParams(r)["version"]
+	if value == "" {
+		return 0, false, nil
+	}
+	version, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(version), true, nil
+}
+
+// parseAsOf builds the point in the journal for a GetResource call from its asOfClock or asOfTime parameter
+func parseAsOf(clockParam string, timeParam string) (resourceStore.AsOf, error) {
+	var asOf resourceStore.AsOf
+	if clockParam != "" {
+		clock, err := strconv.ParseUint(clockParam, 10, 64)
+		if err != nil {
+			return asOf, fmt.Errorf("invalid 'asOfClock' parameter: %w", err)
+		}
+		asOf.Clock = clock
+	}
+	if timeParam != "" {
+		asOfTime, err := time.Parse(time.RFC3339Nano, timeParam)
+		if err != nil {
+			return asOf, fmt.Errorf("invalid 'asOfTime' parameter (expected an RFC 3339 time): %w", err)
+		}
+		asOf.Time = asOfTime
+	}
+	return asOf, asOf.Validate()
+}
+
+// decodeResource decodes the request body into a new R and returns it as an IResource
+func (n *NounResourceRouter[R]) decodeResource(r *http.Request) (resourceStore.IResource, error) {
+	resource := new(R)
+	if err := json.NewDecoder(r.Body).Decode(resource); err != nil {
+		return nil, fmt.Errorf("invalid JSON in request body: %w", err)
+	}
+
+	iResource, ok := any(resource).(resourceStore.IResource)
+	if !ok {
+		return nil, errors.New("the resource type is missing an embedded ResourceBase struct")
+	}
+
+	return iResource, nil
+}
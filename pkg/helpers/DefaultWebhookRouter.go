@@ -0,0 +1,295 @@
+package helpers
+
+// It is not required to use this helper implementation, but it is provided as a convenience
+// since the code is likely to be identical for each noun service.
+//
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/journalClient"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+	"github.com/geraldhinson/siftd-base/pkg/webhooks"
+	"github.com/gorilla/mux"
+)
+
+// WebhookRouter manages the subscriptions and dead letters of a webhooks.Dispatcher
+type WebhookRouter struct {
+	*serviceBase.ServiceBase
+	dispatcher *webhooks.Dispatcher
+}
+
+// NewWebhookRouter delivers the journal of the shared store for R to webhook subscribers. The subscriptions and
+// dead letters are kept in the "WebhookSubscriptions" and "WebhookDeadLetters" tables (with their journals) of
+// the configured schema, and the checkpoint of each subscription in its "JournalCheckpoints" table (see
+// PostgresSQL/README) under the name webhooks-<partition>-<subscription id>. The dispatcher runs as a background
+// job of the service.
+func NewWebhookRouter[R any](
+	serviceBase *serviceBase.ServiceBase,
+	realm string,
+	authType security.AuthTypes,
+	timeout security.AuthTimeout,
+	approvedList []string) *WebhookRouter {
+
+	// the store (and its pool) is shared with the service's other routers over R, and closed by the service
+	journal, err := resourceStore.SharedStore[R](serviceBase.Stores, resourceStore.TableNamesFromConfig(serviceBase.Configuration))
+	if err != nil {
+		serviceBase.Logger.Info("webhook router - error getting the shared resource store with ", err)
+		return nil
+	}
+	dbPool, err := serviceBase.Stores.Pool(serviceBase.Configuration.GetString(constants.DB_CONNECTION_STRING))
+	if err != nil {
+		serviceBase.Logger.Info("webhook router - error getting the shared database pool with ", err)
+		return nil
+	}
+
+	schema := serviceBase.Configuration.GetString(constants.DB_SCHEMA_NAME)
+	subscriptions, err := resourceStore.NewPostgresResourceStoreWithPool[webhooks.SubscriptionResource](
+		serviceBase.Configuration, serviceBase.Logger, resourceStore.NounTableNames(schema, "webhookSubscriptions"), dbPool)
+	if err != nil {
+		serviceBase.Logger.Info("webhook router - error creating the subscription store with ", err)
+		return nil
+	}
+	serviceBase.RegisterShutdownHook("webhook subscriptions store", subscriptions.Close)
+
+	deadLetters, err := resourceStore.NewPostgresResourceStoreWithPool[webhooks.DeadLetterResource](
+		serviceBase.Configuration, serviceBase.Logger, resourceStore.NounTableNames(schema, "webhookDeadLetters"), dbPool)
+	if err != nil {
+		serviceBase.Logger.Info("webhook router - error creating the dead letter store with ", err)
+		return nil
+	}
+	serviceBase.RegisterShutdownHook("webhook dead letters store", deadLetters.Close)
+
+	// every subscription has a checkpoint of its own, all sharing the pool
+	checkpoints := func(subscriptionId string) (journalClient.CheckpointStore, error) {
+		return journalClient.NewPostgresCheckpointStoreWithPool(dbPool, serviceBase.Logger, schema, "webhooks-"+journal.JournalPartitionName()+"-"+subscriptionId)
+	}
+
+	policy, err := webhooks.DeliveryPolicyFromConfig(serviceBase.Configuration)
+	if err != nil {
+		serviceBase.Logger.Info("webhook router - invalid webhook delivery configuration: ", err)
+		return nil
+	}
+	dispatcher, err := webhooks.NewDispatcher(journal, subscriptions, deadLetters, checkpoints, policy, serviceBase.Logger)
+	if err != nil {
+		serviceBase.Logger.Info("webhook router - error creating the webhook dispatcher with ", err)
+		return nil
+	}
+
+	webhookRouter := NewWebhookRouterWithDispatcher(serviceBase, dispatcher, realm, authType, timeout, approvedList)
+	if webhookRouter == nil {
+		return nil
+	}
+
+	// Run only returns when the service shuts down, so the interval only matters if it stops early
+	serviceBase.StartBackgroundJob("webhook dispatcher", policy.PollInterval, dispatcher.Run)
+
+	return webhookRouter
+}
+
+// NewWebhookRouterWithDispatcher is NewWebhookRouter over a dispatcher supplied by the caller (e.g. over
+// resourceStore.MemoryResourceStores in unit tests). The caller runs the dispatcher and closes its stores.
+func NewWebhookRouterWithDispatcher(
+	serviceBase *serviceBase.ServiceBase,
+	dispatcher *webhooks.Dispatcher,
+	realm string,
+	authType security.AuthTypes,
+	timeout security.AuthTimeout,
+	approvedList []string) *WebhookRouter {
+
+	if dispatcher == nil {
+		serviceBase.Logger.Info("webhook router - a webhook dispatcher is required")
+		return nil
+	}
+
+	authModel, err := serviceBase.NewAuthModel(realm, authType, timeout, approvedList)
+	if err != nil {
+		serviceBase.Logger.Info("webhook router - failed to initialize AuthModel with ", err)
+		return nil
+	}
+
+	webhookRouter := &WebhookRouter{
+		ServiceBase: serviceBase,
+		dispatcher:  dispatcher,
+	}
+
+	webhookRouter.setupRoutes(authModel)
+	if webhookRouter.Router == nil {
+		serviceBase.Logger.Info("webhook router - error creating WebhookRouter")
+		return nil
+	}
+
+	return webhookRouter
+}
+
+func (wr *WebhookRouter) setupRoutes(authModel *security.AuthModel) {
+	var routeString = "/v1/webhooks/subscriptions"
+	wr.RegisterRoute(constants.HTTP_GET, routeString, authModel, wr.GetSubscriptions)
+	wr.RegisterRoute(constants.HTTP_POST, routeString, authModel, wr.CreateSubscription)
+
+	routeString = "/v1/webhooks/subscriptions/{id}"
+	wr.RegisterRoute(constants.HTTP_GET, routeString, authModel, wr.GetSubscription)
+	wr.RegisterRoute(constants.HTTP_DELETE, routeString, authModel, wr.DeleteSubscription)
+
+	routeString = "/v1/webhooks/deadLetters"
+	wr.RegisterRoute(constants.HTTP_GET, routeString, authModel, wr.GetDeadLetters)
+
+	routeString = "/v1/webhooks/deadLetters/{id}"
+	wr.RegisterRoute(constants.HTTP_DELETE, routeString, authModel, wr.DeleteDeadLetter)
+
+	routeString = "/v1/webhooks/deadLetters/{id}/redeliver"
+	wr.RegisterRoute(constants.HTTP_POST, routeString, authModel, wr.RedeliverDeadLetter)
+
+}
+
+func (wr *WebhookRouter) GetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	var subscriptions []webhooks.SubscriptionResource
+	status, err := wr.dispatcher.Subscriptions().GetByOwnerId(r.Context(), webhooks.WEBHOOK_OWNER_ID, &subscriptions)
+	if err != nil {
+		wr.Logger.Info("webhook router - call to resource store GetByOwnerId() in GetSubscriptions failed with: ", err)
+		wr.WriteHttpError(w, status, err)
+		return
+	}
+
+	// the secrets are only returned when the subscriptions are created
+	for i := range subscriptions {
+		subscriptions[i].Subscription.Secret = ""
+	}
+	wr.writeJson(w, subscriptions, "GetSubscriptions")
+}
+
+func (wr *WebhookRouter) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	var subscription webhooks.SubscriptionResource
+	status, err := wr.dispatcher.Subscriptions().GetById(r.Context(), webhooks.WEBHOOK_OWNER_ID, mux.Vars(r)["id"], &subscription)
+	if err != nil {
+		wr.Logger.Info("webhook router - call to resource store GetById() in GetSubscription failed with: ", err)
+		wr.WriteHttpError(w, status, err)
+		return
+	}
+
+	subscription.Subscription.Secret = ""
+	wr.writeJson(w, subscription, "GetSubscription")
+}
+
+// CreateSubscription registers a subscriber, which gets the journal entries written from then on. A secret is
+// generated when the body doesn't have one, and the reply is the only time it is returned.
+func (wr *WebhookRouter) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var subscription webhooks.SubscriptionResource
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		wr.Logger.Info("webhook router - failed to decode request body in CreateSubscription: ", err)
+		wr.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+	if subscription.OwnerId != "" && subscription.OwnerId != webhooks.WEBHOOK_OWNER_ID {
+		err := errors.New("the owner id of a subscription must be empty in CreateSubscription")
+		wr.Logger.Info("webhook router - ", err)
+		wr.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, err)
+		return
+	}
+	subscription.OwnerId = webhooks.WEBHOOK_OWNER_ID
+
+	if subscription.Subscription.Secret == "" {
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			wr.Logger.Info("webhook router - ", err)
+			wr.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, err)
+			return
+		}
+		subscription.Subscription.Secret = secret
+	}
+
+	createdSubscription, status, err := wr.dispatcher.CreateSubscription(r.Context(), &subscription, security.GetAuthHeader(r))
+	if err != nil {
+		wr.Logger.Info("webhook router - call to dispatcher CreateSubscription() in CreateSubscription failed with: ", err)
+		wr.WriteHttpError(w, status, err)
+		return
+	}
+
+	jsonResults, errmsg := json.Marshal(createdSubscription)
+	if errmsg != nil {
+		wr.Logger.Info("webhook router - call to json marshal subscription in CreateSubscription failed with: ", errmsg)
+		wr.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	wr.WriteHttpCreated(w, jsonResults)
+}
+
+func (wr *WebhookRouter) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var subscription webhooks.SubscriptionResource
+	status, err := wr.dispatcher.Subscriptions().GetById(r.Context(), webhooks.WEBHOOK_OWNER_ID, id, &subscription)
+	if err == nil {
+		_, status, err = wr.dispatcher.Subscriptions().DeleteResource(r.Context(), webhooks.WEBHOOK_OWNER_ID, id, subscription.Version, security.GetAuthHeader(r))
+	}
+	if err != nil {
+		wr.Logger.Info("webhook router - call to resource store in DeleteSubscription failed with: ", err)
+		wr.WriteHttpError(w, status, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (wr *WebhookRouter) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	var deadLetters []webhooks.DeadLetterResource
+	status, err := wr.dispatcher.DeadLetters().GetByOwnerId(r.Context(), webhooks.WEBHOOK_OWNER_ID, &deadLetters)
+	if err != nil {
+		wr.Logger.Info("webhook router - call to resource store GetByOwnerId() in GetDeadLetters failed with: ", err)
+		wr.WriteHttpError(w, status, err)
+		return
+	}
+
+	wr.writeJson(w, deadLetters, "GetDeadLetters")
+}
+
+// DeleteDeadLetter discards a dead letter without delivering it
+func (wr *WebhookRouter) DeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var deadLetter webhooks.DeadLetterResource
+	status, err := wr.dispatcher.DeadLetters().GetById(r.Context(), webhooks.WEBHOOK_OWNER_ID, id, &deadLetter)
+	if err == nil {
+		_, status, err = wr.dispatcher.DeadLetters().DeleteResource(r.Context(), webhooks.WEBHOOK_OWNER_ID, id, deadLetter.Version, security.GetAuthHeader(r))
+	}
+	if err != nil {
+		wr.Logger.Info("webhook router - call to resource store in DeleteDeadLetter failed with: ", err)
+		wr.WriteHttpError(w, status, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RedeliverDeadLetter makes one more attempt to deliver a dead letter, responding 204 when it succeeds (and the
+// dead letter is gone) or 502 when the subscriber failed again
+func (wr *WebhookRouter) RedeliverDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if status, err := wr.dispatcher.Redeliver(r.Context(), mux.Vars(r)["id"]); err != nil {
+		wr.Logger.Info("webhook router - call to dispatcher Redeliver() in RedeliverDeadLetter failed with: ", err)
+		wr.WriteHttpError(w, status, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (wr *WebhookRouter) writeJson(w http.ResponseWriter, v any, methodName string) {
+	jsonResults, errmsg := json.Marshal(v)
+	if errmsg != nil {
+		wr.Logger.Infof("webhook router - call to json marshal in %s failed with: %v", methodName, errmsg)
+		wr.WriteHttpError(w, constants.RESOURCE_INTERNAL_ERROR_CODE, errmsg)
+		return
+	}
+
+	// make empty array if no results found - it's friendlier to the client
+	if string(jsonResults) == "null" {
+		jsonResults = []byte("[]")
+	}
+
+	wr.WriteHttpOK(w, jsonResults)
+}
@@ -0,0 +1,74 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+)
+
+const (
+	ETAG_HEADER          = "ETag"
+	IF_MATCH_HEADER      = "If-Match"
+	IF_NONE_MATCH_HEADER = "If-None-Match"
+)
+
+// setETag sets the ETag header of a response to the version of the resource it carries
+func setETag(w http.ResponseWriter, resource any) {
+	if iResource, ok := resource.(resourceStore.IResource); ok {
+		w.Header().Set(ETAG_HEADER, serviceBase.VersionETag(iResource.GetResourceBase().Version))
+	}
+}
+
+// expectedVersion resolves the version a PUT, PATCH or DELETE must find stored. With an If-Match header it is
+// the version the header names, reading the stored resource when the header is * or lists several tags. Without
+// one it is fallback (the version in the body or the ?version= parameter) unless If-Match is required or there
+// is no fallback, which is a 428. The store reports a version that no longer matches as a 412.
+func (n *NounResourceRouter[R]) expectedVersion(r *http.Request, identityId string, id string, fallback uint, hasFallback bool) (uint, int, error) {
+	header := r.Header.Get(IF_MATCH_HEADER)
+	if header == "" {
+		if n.requireIfMatch || !hasFallback {
+			return 0, constants.RESOURCE_PRECONDITION_REQUIRED_CODE, errors.New("an If-Match header with the version of the resource is required")
+		}
+		return fallback, constants.RESOURCE_OK_CODE, nil
+	}
+
+	match, err := serviceBase.ParseETagMatch(header)
+	if err != nil {
+		return 0, constants.RESOURCE_BAD_REQUEST_CODE, err
+	}
+	if versions := match.Versions(true); !match.Any && len(versions) == 1 {
+		return versions[0], constants.RESOURCE_OK_CODE, nil
+	}
+
+	var current R
+	if status, err := n.store.GetById(r.Context(), identityId, id, &current); err != nil {
+		if status == constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+			return 0, constants.RESOURCE_PRECONDITION_FAILED_CODE, fmt.Errorf("If-Match %s does not match - the resource does not exist", header)
+		}
+		return 0, status, err
+	}
+	currentVersion := any(&current).(resourceStore.IResource).GetResourceBase().Version
+	if !match.MatchesVersion(currentVersion, true) {
+		return 0, constants.RESOURCE_PRECONDITION_FAILED_CODE, fmt.Errorf("If-Match %s does not match the current version %s", header, serviceBase.VersionETag(currentVersion))
+	}
+	return currentVersion, constants.RESOURCE_OK_CODE, nil
+}
+
+// notModified reports whether a GET's If-None-Match header matches the resource, in which case the response is a 304
+func notModified(r *http.Request, resource any) (bool, error) {
+	header := r.Header.Get(IF_NONE_MATCH_HEADER)
+	iResource, ok := resource.(resourceStore.IResource)
+	if header == "" || !ok {
+		return false, nil
+	}
+	match, err := serviceBase.ParseETagMatch(header)
+	if err != nil {
+		return false, err
+	}
+	// If-None-Match uses the weak comparison
+	return match.MatchesVersion(iResource.GetResourceBase().Version, false), nil
+}
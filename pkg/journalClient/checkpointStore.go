@@ -0,0 +1,198 @@
+package journalClient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// CheckpointStore persists the clock of the last journal entry a Follower has fully processed. Load returns 0
+// when nothing has been saved yet, in which case the Follower starts from the beginning of the journal.
+type CheckpointStore interface {
+	Load(ctx context.Context) (uint64, error)
+	Save(ctx context.Context, clock uint64) error
+}
+
+// FileCheckpointStore keeps the checkpoint in a local file. Each save writes a temporary file and renames it
+// over the previous one so that a crash never leaves a partially written checkpoint.
+type FileCheckpointStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewFileCheckpointStore(path string) (*FileCheckpointStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("journal client - a checkpoint file path is required")
+	}
+	return &FileCheckpointStore{path: path}, nil
+}
+
+func (f *FileCheckpointStore) Load(ctx context.Context) (uint64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("journal client - unable to read checkpoint file %s: %w", f.path, err)
+	}
+
+	clock, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("journal client - invalid checkpoint in file %s: %w", f.path, err)
+	}
+	return clock, nil
+}
+
+func (f *FileCheckpointStore) Save(ctx context.Context, clock uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	tempFile, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("journal client - unable to create temporary checkpoint file: %w", err)
+	}
+	defer os.Remove(tempFile.Name()) // no-op once renamed
+
+	if _, err := tempFile.WriteString(strconv.FormatUint(clock, 10)); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("journal client - unable to write checkpoint file: %w", err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("journal client - unable to sync checkpoint file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("journal client - unable to close checkpoint file: %w", err)
+	}
+	if err := os.Rename(tempFile.Name(), f.path); err != nil {
+		return fmt.Errorf("journal client - unable to replace checkpoint file %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// PostgresCheckpointStore keeps the checkpoint in the "JournalCheckpoints" table of a schema, one row per follower
+// name. This lets a projection save its checkpoint in the same database as its read model. The table is created by
+// the migrations of any resource store in the schema (see PostgresSQL/README).
+type PostgresCheckpointStore struct {
+	name     string
+	table    string // schema-qualified and quoted
+	logger   *logrus.Logger
+	dbPool   *pgxpool.Pool
+	ownsPool bool
+}
+
+// NewPostgresCheckpointStore opens a pool of its own and uses the checkpoints table of the configured schema
+// (DB_SCHEMA, public unless set)
+func NewPostgresCheckpointStore(configuration *viper.Viper, logger *logrus.Logger, name string) (*PostgresCheckpointStore, error) {
+	if configuration == nil {
+		return nil, fmt.Errorf("journal client - invalid nil configuration detected")
+	}
+	if logger == nil {
+		return nil, fmt.Errorf("journal client - invalid nil logger detected")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("journal client - a checkpoint name is required")
+	}
+
+	dbConnectString := configuration.GetString(constants.DB_CONNECTION_STRING)
+	if dbConnectString == "" {
+		return nil, fmt.Errorf("journal client - unable to retrieve database connection string")
+	}
+
+	dbPool, err := pgxpool.New(context.Background(), dbConnectString)
+	if err != nil {
+		return nil, fmt.Errorf("journal client - unable to connect to database: %v", err)
+	}
+	if err := dbPool.Ping(context.Background()); err != nil {
+		dbPool.Close()
+		return nil, fmt.Errorf("journal client - unable to ping database to verify successful connection: %w", err)
+	}
+
+	schema := configuration.GetString(constants.DB_SCHEMA_NAME)
+	return &PostgresCheckpointStore{name: name, table: checkpointsTable(schema), logger: logger, dbPool: dbPool, ownsPool: true}, nil
+}
+
+// NewPostgresCheckpointStoreWithPool is NewPostgresCheckpointStore over a pool owned by the caller (e.g. one from
+// a resourceStore.StoreRegistry), so that many checkpoints can share it, and the checkpoints table of schema
+// (public if empty). Close leaves the pool open.
+func NewPostgresCheckpointStoreWithPool(dbPool *pgxpool.Pool, logger *logrus.Logger, schema string, name string) (*PostgresCheckpointStore, error) {
+	if dbPool == nil {
+		return nil, fmt.Errorf("journal client - invalid nil database pool detected")
+	}
+	if logger == nil {
+		return nil, fmt.Errorf("journal client - invalid nil logger detected")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("journal client - a checkpoint name is required")
+	}
+	return &PostgresCheckpointStore{name: name, table: checkpointsTable(schema), logger: logger, dbPool: dbPool}, nil
+}
+
+func checkpointsTable(schema string) string {
+	if schema == "" {
+		schema = resourceStore.DEFAULT_SCHEMA_NAME
+	}
+	return pgx.Identifier{schema, resourceStore.JOURNAL_CHECKPOINTS_TABLE}.Sanitize()
+}
+
+func (p *PostgresCheckpointStore) Load(ctx context.Context) (uint64, error) {
+	query := fmt.Sprintf(`
+		SELECT "Clock"
+		FROM %s
+		WHERE "Name" = @name;
+	`, p.table)
+	var clock int64
+	err := p.dbPool.QueryRow(ctx, query, pgx.NamedArgs{"name": p.name}).Scan(&clock)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		p.logger.Error("journal client - error detected loading checkpoint: ", err)
+		return 0, fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
+	}
+	return uint64(clock), nil
+}
+
+func (p *PostgresCheckpointStore) Save(ctx context.Context, clock uint64) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+			("Name", "Clock", "UpdatedAt")
+		VALUES
+			(@name, @clock, @updatedAt)
+		ON CONFLICT ("Name") DO UPDATE
+			SET "Clock" = EXCLUDED."Clock",
+				"UpdatedAt" = EXCLUDED."UpdatedAt";
+	`, p.table)
+	args := pgx.NamedArgs{
+		"name":      p.name,
+		"clock":     int64(clock),
+		"updatedAt": time.Now().UTC(),
+	}
+	if _, err := p.dbPool.Exec(ctx, query, args); err != nil {
+		p.logger.Error("journal client - error detected saving checkpoint: ", err)
+		return fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
+	}
+	return nil
+}
+
+func (p *PostgresCheckpointStore) Close() {
+	if p.ownsPool {
+		p.dbPool.Close()
+	}
+}
@@ -0,0 +1,253 @@
+package journalClient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_PAGE_SIZE     = 100
+	DEFAULT_POLL_INTERVAL = 5 * time.Second
+	DEFAULT_MIN_BACKOFF   = time.Second
+	DEFAULT_MAX_BACKOFF   = time.Minute
+)
+
+// ErrResyncRequired is returned by Run when journal retention has deleted entries after the follower's checkpoint.
+// The follower can't catch up from the journal - rebuild its state from the resources, save a checkpoint at the
+// journal's max clock as of the start of the rebuild and run it again.
+var ErrResyncRequired = errors.New("journal client - the checkpoint is older than the journal's retained entries - resync required")
+
+// JournalEntry is a journal entry with its resource decoded into the caller's type
+type JournalEntry[R any] struct {
+	Clock         uint64
+	Resource      R
+	UpdatedAt     time.Time
+	PartitionName string
+}
+
+// Handler processes one journal entry. Returning an error stops the Follower from moving past the entry - it is
+// retried (with backoff) until the handler succeeds, so handlers must be idempotent.
+type Handler[R any] func(ctx context.Context, entry JournalEntry[R]) error
+
+type FollowerConfig struct {
+	BaseURL      string        // scheme://host:port of the service that registered the NounJournalRouter
+	Partition    string        // follow only this journal partition (default all partitions - see JOURNAL_PARTITION_NAME)
+	PageSize     int64         // entries requested per call to /v1/journal (default 100)
+	PollInterval time.Duration // how long to wait before polling again once caught up (default 5s)
+	MinBackoff   time.Duration // wait after the first consecutive error (default 1s)
+	MaxBackoff   time.Duration // the wait doubles on each consecutive error up to this (default 1m)
+	HTTPClient   *http.Client  // defaults to http.DefaultClient - supply one that trusts your certificates if needed
+}
+
+// Follower reads the journal of a noun service from its last checkpoint and hands each entry to a Handler,
+// which is the building block for projections and other query services. Delivery is at-least-once: the
+// checkpoint is saved after entries are handled, so entries handled just before a crash are delivered again.
+//
+// Example usage from a service:
+//
+//	checkpoints, _ := journalClient.NewFileCheckpointStore("/var/lib/employee-projection/checkpoint")
+//	follower, err := journalClient.NewFollower[EmployeeResource](
+//		journalClient.FollowerConfig{BaseURL: "https://employees.internal:8443"},
+//		tokenSource, checkpoints, projection.Apply, service.Logger)
+//	go follower.Run(ctx)
+type Follower[R any] struct {
+	config      FollowerConfig
+	tokens      TokenSource
+	checkpoints CheckpointStore
+	handler     Handler[R]
+	logger      *logrus.Logger
+}
+
+func NewFollower[R any](config FollowerConfig, tokens TokenSource, checkpoints CheckpointStore, handler Handler[R], logger *logrus.Logger) (*Follower[R], error) {
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("journal client - the base URL of the journal service is required")
+	}
+	if tokens == nil {
+		return nil, fmt.Errorf("journal client - invalid nil token source detected")
+	}
+	if checkpoints == nil {
+		return nil, fmt.Errorf("journal client - invalid nil checkpoint store detected")
+	}
+	if handler == nil {
+		return nil, fmt.Errorf("journal client - invalid nil handler detected")
+	}
+	if logger == nil {
+		return nil, fmt.Errorf("journal client - invalid nil logger detected")
+	}
+	if config.PageSize < 0 || config.PollInterval < 0 || config.MinBackoff < 0 || config.MaxBackoff < 0 {
+		return nil, fmt.Errorf("journal client - the page size, poll interval and backoffs can't be negative")
+	}
+
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	if config.PageSize == 0 {
+		config.PageSize = DEFAULT_PAGE_SIZE
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = DEFAULT_POLL_INTERVAL
+	}
+	if config.MinBackoff == 0 {
+		config.MinBackoff = DEFAULT_MIN_BACKOFF
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = DEFAULT_MAX_BACKOFF
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = config.MinBackoff
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	return &Follower[R]{config: config, tokens: tokens, checkpoints: checkpoints, handler: handler, logger: logger}, nil
+}
+
+// Run follows the journal until ctx is cancelled (returning ctx.Err()) or the journal service reports that the
+// checkpoint is too old (returning an error that wraps ErrResyncRequired). Other errors reading the journal,
+// running the handler or saving the checkpoint are logged and retried with backoff.
+func (f *Follower[R]) Run(ctx context.Context) error {
+	backoff := f.config.MinBackoff
+	checkpointLoaded := false
+	var lastClock uint64
+
+	for {
+		var caughtUp bool
+		var err error
+		if !checkpointLoaded {
+			lastClock, err = f.checkpoints.Load(ctx)
+			checkpointLoaded = err == nil
+			if checkpointLoaded {
+				f.logger.Infof("journal follower - following %s from clock %d", f.config.BaseURL, lastClock+1)
+			}
+		} else {
+			caughtUp, err = f.followPage(ctx, &lastClock)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrResyncRequired) {
+			f.logger.Errorf("journal follower - unable to follow %s after clock %d: %v", f.config.BaseURL, lastClock, err)
+			return err
+		}
+
+		wait := time.Duration(0)
+		if err != nil {
+			f.logger.Infof("journal follower - error following %s after clock %d, retrying in %v: %v", f.config.BaseURL, lastClock, backoff, err)
+			wait = backoff
+			backoff = min(backoff*2, f.config.MaxBackoff)
+		} else {
+			backoff = f.config.MinBackoff
+			if caughtUp {
+				wait = f.config.PollInterval
+			}
+		}
+
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// followPage handles one page of entries after lastClock and saves the checkpoint for those that succeeded. It
+// reports whether the page was the last one currently available.
+func (f *Follower[R]) followPage(ctx context.Context, lastClock *uint64) (bool, error) {
+	var page []resourceStore.ResourceJournalEntry
+	url := fmt.Sprintf("%s/v1/journal?clock=%d&limit=%d%s", f.config.BaseURL, *lastClock+1, f.config.PageSize, f.partitionParam("&"))
+	if err := f.get(ctx, url, &page); err != nil {
+		return false, err
+	}
+
+	processedClock := *lastClock
+	var handlerErr error
+	for _, rawEntry := range page {
+		entry := JournalEntry[R]{Clock: rawEntry.Clock, UpdatedAt: rawEntry.UpdatedAt, PartitionName: rawEntry.PartitionName}
+		if err := json.Unmarshal(rawEntry.Resource, &entry.Resource); err != nil {
+			handlerErr = fmt.Errorf("journal client - unable to decode the resource in journal entry %d: %w", rawEntry.Clock, err)
+			break
+		}
+		if err := f.handler(ctx, entry); err != nil {
+			handlerErr = fmt.Errorf("journal client - handler failed for journal entry %d: %w", rawEntry.Clock, err)
+			break
+		}
+		processedClock = rawEntry.Clock
+	}
+
+	if processedClock != *lastClock {
+		if err := f.checkpoints.Save(ctx, processedClock); err != nil {
+			// the entries since the last saved checkpoint will be handled again
+			return false, err
+		}
+		*lastClock = processedClock
+	}
+	if handlerErr != nil {
+		return false, handlerErr
+	}
+
+	return int64(len(page)) < f.config.PageSize, nil
+}
+
+// MaxClock returns the latest clock in the journal (or the followed partition), which can be compared with the
+// checkpoint to measure lag
+func (f *Follower[R]) MaxClock(ctx context.Context) (uint64, error) {
+	var maxClock resourceStore.JournalMaxClock
+	if err := f.get(ctx, f.config.BaseURL+"/v1/journalMaxClock"+f.partitionParam("?"), &maxClock); err != nil {
+		return 0, err
+	}
+	return maxClock.MaxClock, nil
+}
+
+// partitionParam returns the 'partition' query parameter (after separator) when the follower follows one partition
+func (f *Follower[R]) partitionParam(separator string) string {
+	if f.config.Partition == "" {
+		return ""
+	}
+	return separator + "partition=" + url.QueryEscape(f.config.Partition)
+}
+
+func (f *Follower[R]) get(ctx context.Context, url string, v any) error {
+	token, err := f.tokens.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("journal client - unable to get a machine token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("journal client - failed to build journal request: %w", err)
+	}
+	req.Header.Set("Authorization", token)
+
+	res, err := f.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("journal client - call to journal service failed with: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("journal client - unable to read journal service reply: %w", err)
+	}
+	if res.StatusCode == http.StatusGone {
+		return fmt.Errorf("%w: %s", ErrResyncRequired, string(body))
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("journal client - journal service returned %d: %s", res.StatusCode, string(body))
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("journal client - unable to decode journal service reply: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package journalClient
+
+import (
+	"context"
+	"fmt"
+)
+
+// TokenSource supplies the machine token sent in the Authorization header of every journal request. It is
+// called before each request, so an implementation that fetches tokens should cache them until they expire.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticTokenSource always returns the same token (e.g. one injected through the environment)
+type StaticTokenSource string
+
+func (s StaticTokenSource) Token(ctx context.Context) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("journal client - the static machine token is empty")
+	}
+	return string(s), nil
+}
@@ -0,0 +1,153 @@
+package problemDetails
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const (
+	CONTENT_TYPE      = "application/problem+json"
+	REQUEST_ID_HEADER = "X-Request-Id"
+	DEFAULT_TYPE      = "about:blank" // RFC 7807 - the problem has no meaning beyond its HTTP status
+
+	MAX_REQUEST_ID_LENGTH = 128
+)
+
+// ProblemDetails is an RFC 7807 error response body. RequestId and Errors are extension members.
+type ProblemDetails struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestId string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a validation failure of one field of a request, named by its JSON path (e.g. "employee.age")
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrorer is implemented by errors that carry field-level validation failures. Write copies them into the
+// errors member of the problem.
+type FieldErrorer interface {
+	FieldErrors() []FieldError
+}
+
+// New returns a problem of the default type for status, with the request's path as the instance and its request
+// id. r may be nil.
+func New(r *http.Request, status int, detail string) *ProblemDetails {
+	problem := &ProblemDetails{
+		Type:   DEFAULT_TYPE,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+	if r != nil {
+		problem.Instance = r.URL.Path
+		problem.RequestId = RequestId(r)
+	}
+	return problem
+}
+
+// Write writes problem as an application/problem+json response. A problem made without the request (see New)
+// takes its instance and request id from the response when it came through the request id middleware.
+func Write(w http.ResponseWriter, problem *ProblemDetails) error {
+	if r := RequestOf(w); r != nil && problem.Instance == "" {
+		problem.Instance = r.URL.Path
+	}
+	if problem.RequestId == "" {
+		problem.RequestId = w.Header().Get(REQUEST_ID_HEADER)
+	}
+	body, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.WriteHeader(problem.Status)
+	_, err = w.Write(body)
+	return err
+}
+
+type requestIdKey struct{}
+
+// RequestId returns the id of the request - the one given to it by the request id middleware, or else its
+// X-Request-Id header if that is a valid request id
+func RequestId(r *http.Request) string {
+	if requestId, ok := r.Context().Value(requestIdKey{}).(string); ok {
+		return requestId
+	}
+	if requestId := r.Header.Get(REQUEST_ID_HEADER); validRequestId(requestId) {
+		return requestId
+	}
+	return ""
+}
+
+// validRequestId reports whether a caller's X-Request-Id is safe to echo in headers, problems and logs - at most
+// MAX_REQUEST_ID_LENGTH characters of [A-Za-z0-9._-]
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for i := 0; i < len(requestId); i++ {
+		c := requestId[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestId gives the request an id (the caller's X-Request-Id if it is valid), echoes it in the response
+// and returns the request and response to pass on. The response remembers the request so that code which is
+// only handed the response (e.g. ServiceBase.WriteHttpError) can still fill in the instance of a problem.
+func WithRequestId(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	if _, ok := r.Context().Value(requestIdKey{}).(string); ok {
+		return w, r
+	}
+	requestId := r.Header.Get(REQUEST_ID_HEADER)
+	if !validRequestId(requestId) {
+		requestId = uuid.New().String()
+	}
+	r = r.WithContext(context.WithValue(r.Context(), requestIdKey{}, requestId))
+	w.Header().Set(REQUEST_ID_HEADER, requestId)
+	return &requestResponseWriter{ResponseWriter: w, request: r}, r
+}
+
+// RequestIdMiddleware is WithRequestId as a mux middleware
+func RequestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r = WithRequestId(w, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestOf returns the request a response was wrapped with by WithRequestId, or nil
+func RequestOf(w http.ResponseWriter) *http.Request {
+	if rw, ok := w.(*requestResponseWriter); ok {
+		return rw.request
+	}
+	return nil
+}
+
+type requestResponseWriter struct {
+	http.ResponseWriter
+	request *http.Request
+}
+
+// Flush keeps streaming responses (e.g. the journal stream) working through the wrapper
+func (rw *requestResponseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap is used by http.ResponseController
+func (rw *requestResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
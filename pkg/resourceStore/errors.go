@@ -0,0 +1,181 @@
+package resourceStore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/problemDetails"
+)
+
+type ErrorKind int
+
+const (
+	ERROR_KIND_INTERNAL ErrorKind = iota
+	ERROR_KIND_NOT_FOUND
+	ERROR_KIND_CONFLICT         // e.g. the id is taken, or the resource is already in the requested state
+	ERROR_KIND_VERSION_MISMATCH // the stored version is not the expected version
+	ERROR_KIND_VALIDATION       // the request can't be applied as it stands (e.g. the owner id in the body is wrong)
+	ERROR_KIND_UNAUTHORIZED
+)
+
+// sentinels for errors.Is - errors.Is(err, resourceStore.ErrNotFound) is true for any ResourceError of that kind
+var (
+	ErrInternal        = errors.New("internal error")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrVersionMismatch = errors.New("version mismatch")
+	ErrValidation      = errors.New("validation failed")
+	ErrUnauthorized    = errors.New("unauthorized")
+)
+
+var errorKindSentinels = map[ErrorKind]error{
+	ERROR_KIND_INTERNAL:         ErrInternal,
+	ERROR_KIND_NOT_FOUND:        ErrNotFound,
+	ERROR_KIND_CONFLICT:         ErrConflict,
+	ERROR_KIND_VERSION_MISMATCH: ErrVersionMismatch,
+	ERROR_KIND_VALIDATION:       ErrValidation,
+	ERROR_KIND_UNAUTHORIZED:     ErrUnauthorized,
+}
+
+func (k ErrorKind) String() string {
+	return errorKindSentinels[k].Error()
+}
+
+// ResourceError is the error returned by the store methods. Message is safe to return to a client, while Cause
+// (e.g. the database error behind an internal error) is only meant for the logs.
+//
+// The store methods still return a constants.RESOURCE_*_CODE alongside the error for compatibility - Status
+// gives the code the error has always been returned with, so a version mismatch is still a
+// RESOURCE_BAD_REQUEST_CODE. Use errors.Is with the sentinels to tell the kinds apart, e.g.
+//
+//	if _, _, err := store.UpdateResource(ctx, resource, ownerId, id, auth); errors.Is(err, resourceStore.ErrVersionMismatch) {
+type ResourceError struct {
+	Kind    ErrorKind
+	Message string
+	Cause   error
+	Fields  []problemDetails.FieldError // the fields that failed a validation, if known
+	status  int                         // the code returned with the error when it isn't the code of its kind
+}
+
+// NewResourceError returns a ResourceError of kind. An internal error always has the generic public message.
+func NewResourceError(kind ErrorKind, message string, cause error) *ResourceError {
+	if kind == ERROR_KIND_INTERNAL {
+		message = constants.INTERNAL_SERVER_ERROR
+	}
+	return &ResourceError{Kind: kind, Message: message, Cause: cause}
+}
+
+// NewValidationError returns a validation error for the fields that failed, e.g. from a resource's Validate method
+//
+//	return resourceStore.NewValidationError("the employee is invalid", problemDetails.FieldError{Field: "age", Message: "must be at least 16"})
+func NewValidationError(message string, fields ...problemDetails.FieldError) *ResourceError {
+	return &ResourceError{Kind: ERROR_KIND_VALIDATION, Message: message, Fields: fields}
+}
+
+func (e *ResourceError) Error() string {
+	message := e.Message
+	for i, field := range e.Fields {
+		separator := ", "
+		if i == 0 {
+			separator = " - "
+		}
+		message += separator + field.Field + " " + field.Message
+	}
+	if e.Cause == nil {
+		return message
+	}
+	return message + ": " + e.Cause.Error()
+}
+
+func (e *ResourceError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *ResourceError) Is(target error) bool {
+	return target == errorKindSentinels[e.Kind]
+}
+
+// PublicMessage is the message to return to a client (used by serviceBase.WriteHttpError)
+func (e *ResourceError) PublicMessage() string {
+	return e.Message
+}
+
+// FieldErrors are the fields that failed a validation (used by serviceBase.WriteHttpError)
+func (e *ResourceError) FieldErrors() []problemDetails.FieldError {
+	return e.Fields
+}
+
+// HttpStatus is the HTTP status of the kind (used by serviceBase.WriteHttpError)
+func (e *ResourceError) HttpStatus() int {
+	switch e.Kind {
+	case ERROR_KIND_NOT_FOUND:
+		return http.StatusNotFound
+	case ERROR_KIND_CONFLICT:
+		return http.StatusConflict
+	case ERROR_KIND_VERSION_MISMATCH:
+		return http.StatusPreconditionFailed
+	case ERROR_KIND_VALIDATION:
+		return http.StatusUnprocessableEntity
+	case ERROR_KIND_UNAUTHORIZED:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Status is the constants.RESOURCE_*_CODE returned with the error. Validation errors and version mismatches keep
+// the RESOURCE_BAD_REQUEST_CODE they have always been returned with.
+func (e *ResourceError) Status() int {
+	if e.status != 0 {
+		return e.status
+	}
+	switch e.Kind {
+	case ERROR_KIND_NOT_FOUND:
+		return constants.RESOURCE_NOT_FOUND_ERROR_CODE
+	case ERROR_KIND_CONFLICT:
+		return constants.RESOURCE_ALREADY_EXISTS_CODE
+	case ERROR_KIND_VERSION_MISMATCH, ERROR_KIND_VALIDATION:
+		return constants.RESOURCE_BAD_REQUEST_CODE
+	case ERROR_KIND_UNAUTHORIZED:
+		return constants.RESOURCE_UNAUTHORIZED_CODE
+	default:
+		return constants.RESOURCE_INTERNAL_ERROR_CODE
+	}
+}
+
+func notFoundError(format string, args ...any) error {
+	return NewResourceError(ERROR_KIND_NOT_FOUND, fmt.Sprintf(format, args...), nil)
+}
+
+func conflictError(format string, args ...any) error {
+	return NewResourceError(ERROR_KIND_CONFLICT, fmt.Sprintf(format, args...), nil)
+}
+
+func versionMismatchError(format string, args ...any) error {
+	return NewResourceError(ERROR_KIND_VERSION_MISMATCH, fmt.Sprintf(format, args...), nil)
+}
+
+func validationError(format string, args ...any) error {
+	return NewResourceError(ERROR_KIND_VALIDATION, fmt.Sprintf(format, args...), nil)
+}
+
+// internalError hides cause (which has been, or will be, logged) behind constants.INTERNAL_SERVER_ERROR
+func internalError(cause error) error {
+	return NewResourceError(ERROR_KIND_INTERNAL, "", cause)
+}
+
+// asValidationError makes a validation error of an error from an input check (e.g. ResourceQuery.Validate)
+func asValidationError(err error) error {
+	return NewResourceError(ERROR_KIND_VALIDATION, err.Error(), nil)
+}
+
+// statusOf returns the constants.RESOURCE_*_CODE of a ResourceError, or fallback for any other error
+func statusOf(err error, fallback int) int {
+	var resourceErr *ResourceError
+	if errors.As(err, &resourceErr) {
+		return resourceErr.Status()
+	}
+	return fallback
+}
@@ -0,0 +1,280 @@
+package resourceStore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// IMPORT_BATCH_SIZE is the number of journal entries sent to postgres per round trip by ImportJournal
+const IMPORT_BATCH_SIZE = 1000
+
+// JournalRange selects the entries ExportJournal writes. Both clocks are inclusive and 0 leaves that end open.
+type JournalRange struct {
+	FromClock uint64
+	ToClock   uint64
+}
+
+type InconsistencyKind string
+
+const (
+	INCONSISTENCY_VERSION_MISMATCH  InconsistencyKind = "versionMismatch"  // the stored version isn't the latest journaled version
+	INCONSISTENCY_RESOURCE_MISMATCH InconsistencyKind = "resourceMismatch" // the versions match but the JSON doesn't
+	INCONSISTENCY_MISSING           InconsistencyKind = "missing"          // the resource is journaled but has no row in the resources table
+	INCONSISTENCY_NOT_JOURNALED     InconsistencyKind = "notJournaled"     // the resource has no journal entry (expected once delete retention has removed them all)
+)
+
+// ResourceInconsistency is a resource whose row doesn't match the latest journal entry of its id (see
+// CheckConsistency). JournalVersion and JournalClock are 0 for INCONSISTENCY_NOT_JOURNALED, and ResourceVersion is
+// 0 for INCONSISTENCY_MISSING.
+type ResourceInconsistency struct {
+	Id              string            `json:"id"`
+	OwnerId         string            `json:"ownerId"`
+	Kind            InconsistencyKind `json:"kind"`
+	ResourceVersion uint              `json:"resourceVersion"`
+	JournalVersion  uint              `json:"journalVersion"`
+	JournalClock    uint64            `json:"journalClock"`
+}
+
+// The journal holds every version of every resource, so it is all that is needed to back up a store. ExportJournal
+// writes it as NDJSON (one ResourceJournalEntry per line, in clock order), ImportJournal loads such an export into
+// empty tables and RebuildResources replays the journal into the resources table. Exports of a journal that delete
+// retention has trimmed are missing the resources whose entries were deleted - rebuilding from one loses them.
+//
+// Example usage from a service:
+//
+//	file, _ := os.Create("employees.ndjson")
+//	count, err := store.ExportJournal(ctx, resourceStore.JournalRange{}, file)
+
+// ExportJournal writes the journal entries in journalRange (of every partition) to w as NDJSON and returns the
+// number written. The entries are read in one statement, so the export is a consistent snapshot.
+func (store *PostgresResourceStoreWithJournal[R]) ExportJournal(ctx context.Context, journalRange JournalRange, w io.Writer) (int64, error) {
+	query, params := store.Cmds.GetExportJournalCommand(journalRange.FromClock, journalRange.ToClock)
+
+	rows, err := store.dbPool.Query(ctx, query, params)
+	if err != nil {
+		store.logger.Error("resource store - error detected on ExportJournal query: ", err)
+		return 0, internalError(err)
+	}
+	defer rows.Close()
+
+	encoder := json.NewEncoder(w)
+	var count int64
+	for rows.Next() {
+		var entry ResourceJournalEntry
+		var clock int64
+		var resource string
+		if err := rows.Scan(&clock, &resource, &entry.UpdatedAt, &entry.PartitionName); err != nil {
+			store.logger.Error("resource store - error scanning journal entry in ExportJournal: ", err)
+			return count, internalError(err)
+		}
+		entry.Clock = uint64(clock)
+		entry.Resource = json.RawMessage(resource)
+		if err := encoder.Encode(entry); err != nil {
+			return count, fmt.Errorf("resource store - error writing journal entry %d in ExportJournal: %w", entry.Clock, err)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		store.logger.Error("resource store - error detected reading the journal in ExportJournal: ", err)
+		return count, internalError(err)
+	}
+
+	return count, nil
+}
+
+// ImportJournal loads an export into the store's tables, which must both be empty, and rebuilds the resources from
+// it in the same transaction. The entries keep their clocks and partitions, and the next write gets a clock after
+// the last one imported. It returns the number of entries imported.
+func (store *PostgresResourceStoreWithJournal[R]) ImportJournal(ctx context.Context, r io.Reader) (int64, error) {
+	tx, err := store.dbPool.Begin(ctx)
+	if err != nil {
+		store.logger.Error("resource store - error starting transaction in ImportJournal: ", err)
+		return 0, internalError(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, store.Cmds.GetLockJournalCommand()); err != nil {
+		store.logger.Error("resource store - error locking the journal in ImportJournal: ", err)
+		return 0, internalError(err)
+	}
+	var hasRows bool
+	if err := tx.QueryRow(ctx, store.Cmds.GetTablesHaveRowsCommand()).Scan(&hasRows); err != nil {
+		store.logger.Error("resource store - error checking the tables are empty in ImportJournal: ", err)
+		return 0, internalError(err)
+	}
+	if hasRows {
+		return 0, conflictError("resource store - ImportJournal needs empty journal and resources tables")
+	}
+
+	var count int64
+	batch := &pgx.Batch{}
+	sendBatch := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		err := tx.SendBatch(ctx, batch).Close()
+		batch = &pgx.Batch{}
+		if err != nil {
+			store.logger.Error("resource store - error writing journal entries in ImportJournal: ", err)
+			return internalError(err)
+		}
+		return nil
+	}
+	err = decodeJournalExport(r, func(entry ResourceJournalEntry, resourceBase *ResourceBase) error {
+		query, params := store.Cmds.GetImportJournalEntryCommand(entry, resourceBase)
+		batch.Queue(query, params)
+		count++
+		if batch.Len() >= IMPORT_BATCH_SIZE {
+			return sendBatch()
+		}
+		return nil
+	})
+	if err == nil {
+		err = sendBatch()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	query, params := store.Cmds.GetResetJournalClockCommand()
+	if _, err := tx.Exec(ctx, query, params); err != nil {
+		store.logger.Error("resource store - error moving the journal clock in ImportJournal: ", err)
+		return 0, internalError(err)
+	}
+	if _, err := store.rebuildResources(ctx, tx, "ImportJournal"); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		store.logger.Error("resource store - error committing transaction in ImportJournal: ", err)
+		return 0, internalError(err)
+	}
+	store.logger.Infof("resource store - imported %d journal entries", count)
+	return count, nil
+}
+
+// RebuildResources replaces the resources table with the latest journal entry of every resource and returns the
+// number of resources written. Writes are blocked while it runs. It refuses to run once delete retention has
+// trimmed the journal, since the resources whose entries were deleted would be lost.
+func (store *PostgresResourceStoreWithJournal[R]) RebuildResources(ctx context.Context) (int64, error) {
+	tx, err := store.dbPool.Begin(ctx)
+	if err != nil {
+		store.logger.Error("resource store - error starting transaction in RebuildResources: ", err)
+		return 0, internalError(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, store.Cmds.GetLockJournalCommand()); err != nil {
+		store.logger.Error("resource store - error locking the journal in RebuildResources: ", err)
+		return 0, internalError(err)
+	}
+	var trimmed bool
+	query, params := store.Cmds.GetJournalTrimmedCommand()
+	if err := tx.QueryRow(ctx, query, params).Scan(&trimmed); err != nil {
+		store.logger.Error("resource store - error reading the journal watermarks in RebuildResources: ", err)
+		return 0, internalError(err)
+	}
+	if trimmed {
+		return 0, conflictError("resource store - RebuildResources can't replay a journal that delete retention has trimmed")
+	}
+
+	count, err := store.rebuildResources(ctx, tx, "RebuildResources")
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		store.logger.Error("resource store - error committing transaction in RebuildResources: ", err)
+		return 0, internalError(err)
+	}
+	store.logger.Infof("resource store - rebuilt %d resources from the journal", count)
+	return count, nil
+}
+
+// rebuildResources replaces the resources with the latest journal entry of each in tx, which holds the journal lock
+func (store *PostgresResourceStoreWithJournal[R]) rebuildResources(ctx context.Context, tx pgx.Tx, methodName string) (int64, error) {
+	if _, err := tx.Exec(ctx, store.Cmds.GetClearResourcesCommand()); err != nil {
+		store.logger.Errorf("resource store - error clearing the resources in %s: %v", methodName, err)
+		return 0, internalError(err)
+	}
+	command, err := tx.Exec(ctx, store.Cmds.GetRebuildResourcesCommand())
+	if err != nil {
+		store.logger.Errorf("resource store - error rebuilding the resources in %s: %v", methodName, err)
+		return 0, internalError(err)
+	}
+	return command.RowsAffected(), nil
+}
+
+// CheckConsistency compares every resource with the latest journal entry of its id and returns those that don't
+// match, ordered by id (none when the tables are consistent). RebuildResources repairs them.
+func (store *PostgresResourceStoreWithJournal[R]) CheckConsistency(ctx context.Context, inconsistencies *[]ResourceInconsistency) error {
+	query, params := store.Cmds.GetCheckConsistencyCommand()
+
+	rows, err := store.dbPool.Query(ctx, query, params)
+	if err != nil {
+		store.logger.Error("resource store - error detected on CheckConsistency query: ", err)
+		return internalError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var inconsistency ResourceInconsistency
+		var resourceVersion, journalVersion int32
+		var clock int64
+		var kind string
+		if err := rows.Scan(&inconsistency.Id, &inconsistency.OwnerId, &resourceVersion, &journalVersion, &clock, &kind); err != nil {
+			store.logger.Error("resource store - error scanning row in CheckConsistency: ", err)
+			return internalError(err)
+		}
+		inconsistency.ResourceVersion = uint(resourceVersion)
+		inconsistency.JournalVersion = uint(journalVersion)
+		inconsistency.JournalClock = uint64(clock)
+		inconsistency.Kind = InconsistencyKind(kind)
+		*inconsistencies = append(*inconsistencies, inconsistency)
+	}
+	if err := rows.Err(); err != nil {
+		store.logger.Error("resource store - error detected reading rows in CheckConsistency: ", err)
+		return internalError(err)
+	}
+
+	return nil
+}
+
+// decodeJournalExport reads an export, checking each entry before handing it to fn with its ResourceBase decoded.
+// The entries must be in (clock, partition) order, as ExportJournal writes them - partitions can share a clock.
+func decodeJournalExport(r io.Reader, fn func(entry ResourceJournalEntry, resourceBase *ResourceBase) error) error {
+	decoder := json.NewDecoder(r)
+	var lastClock uint64
+	var lastPartition string
+	for line := 1; ; line++ {
+		var entry ResourceJournalEntry
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return validationError("resource store - invalid journal entry %d in the export: %v", line, err)
+		}
+
+		var resourceBase ResourceBase
+		if err := json.Unmarshal(entry.Resource, &resourceBase); err != nil || resourceBase.Id == "" || resourceBase.OwnerId == "" {
+			return validationError("resource store - journal entry %d in the export doesn't have a resource with an id and owner id", line)
+		}
+		if entry.PartitionName == "" || entry.UpdatedAt.IsZero() {
+			return validationError("resource store - journal entry %d in the export is missing its partition or time", line)
+		}
+		if entry.Clock < lastClock || (entry.Clock == lastClock && entry.PartitionName <= lastPartition) {
+			return validationError("resource store - journal entry %d in the export is not after the previous one in (clock, partition) order", line)
+		}
+		lastClock, lastPartition = entry.Clock, entry.PartitionName
+
+		if err := fn(entry, &resourceBase); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package resourceStore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// JournalClockVector holds the last clock a consumer has processed in each journal partition. A sharded deployment
+// writes every partition (JOURNAL_PARTITION_NAME) to the same journal, so a single clock can't record progress
+// through all of them - a consumer keeps one clock per partition instead and reads the merged journal after it.
+type JournalClockVector map[string]uint64
+
+// Advance moves the clock of each entry's partition forward to the entry's clock
+func (v JournalClockVector) Advance(entries []ResourceJournalEntry) {
+	for _, entry := range entries {
+		if entry.Clock > v[entry.PartitionName] {
+			v[entry.PartitionName] = entry.Clock
+		}
+	}
+}
+
+// String formats the vector as partition:clock pairs separated by commas (e.g. US-EAST:12,US-WEST:9), sorted by
+// partition name, which is the format of the 'since' parameter of /v1/journal/merged
+func (v JournalClockVector) String() string {
+	partitions := make([]string, 0, len(v))
+	for partition := range v {
+		partitions = append(partitions, partition)
+	}
+	slices.Sort(partitions)
+
+	pairs := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		pairs = append(pairs, fmt.Sprintf("%s:%d", partition, v[partition]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ParseJournalClockVector parses the format written by JournalClockVector.String. An empty string is an empty vector.
+func ParseJournalClockVector(s string) (JournalClockVector, error) {
+	vector := JournalClockVector{}
+	if s == "" {
+		return vector, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		separator := strings.LastIndex(pair, ":")
+		if separator < 1 {
+			return nil, fmt.Errorf("resource store - invalid journal clock '%s' - expected partition:clock", pair)
+		}
+		clock, err := strconv.ParseUint(pair[separator+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("resource store - invalid clock in journal clock '%s': %w", pair, err)
+		}
+		vector[pair[:separator]] = clock
+	}
+	return vector, nil
+}
+
+// JournalClocks is the reply of /v1/journalClocks - the max clock of each partition
+type JournalClocks struct {
+	Clocks JournalClockVector `json:"clocks"`
+}
+
+// MergedJournalChanges is the reply of /v1/journal/merged - the next entries of all partitions in clock order and
+// the vector to pass as 'since' to read the entries after them
+type MergedJournalChanges struct {
+	Entries []ResourceJournalEntry `json:"entries"`
+	Clocks  JournalClockVector     `json:"clocks"`
+}
+
+// JournalPartitionName returns the partition the store writes its journal entries to
+func (store *PostgresResourceStoreWithJournal[R]) JournalPartitionName() string {
+	return store.journalPartitionName
+}
+
+// GetPartitionJournalChanges retrieves the changes of one partition >= clock up to limit entries
+func (store *PostgresResourceStoreWithJournal[R]) GetPartitionJournalChanges(ctx context.Context, partitionName string, clock int64, limit int64, journalEntries *[]ResourceJournalEntry) error {
+	query, params := store.Cmds.GetPartitionJournalChangesCommand(partitionName, clock, limit)
+	return store.queryJournal(ctx, query, params, "GetPartitionJournalChanges", journalEntries)
+}
+
+// GetPartitionJournalMaxClock returns the latest clock of one partition, or 0 if it has no entries
+func (store *PostgresResourceStoreWithJournal[R]) GetPartitionJournalMaxClock(ctx context.Context, partitionName string, maxClock *uint64) error {
+	query, params := store.Cmds.GetPartitionJournalMaxClockCommand(partitionName)
+
+	var clock int64
+	err := store.withRetry(ctx, "GetPartitionJournalMaxClock", true, func(ctx context.Context) error {
+		return store.readPool(ctx).QueryRow(ctx, query, params).Scan(&clock)
+	})
+	if err != nil {
+		store.logger.Error("resource store - error detected on GetPartitionJournalMaxClock query: ", err)
+		return internalError(err)
+	}
+
+	*maxClock = uint64(clock)
+	return nil
+}
+
+// GetJournalClocks returns the max clock of every partition that has journal entries
+func (store *PostgresResourceStoreWithJournal[R]) GetJournalClocks(ctx context.Context, clocks *JournalClockVector) error {
+	return store.withRetry(ctx, "GetJournalClocks", true, func(ctx context.Context) error {
+		rows, err := store.readPool(ctx).Query(ctx, store.Cmds.GetJournalClocksCommand())
+		if err != nil {
+			store.logger.Error("resource store - error detected on GetJournalClocks query: ", err)
+			return internalError(err)
+		}
+		defer rows.Close()
+
+		*clocks = JournalClockVector{}
+		for rows.Next() {
+			var partitionName string
+			var clock int64
+			if err := rows.Scan(&partitionName, &clock); err != nil {
+				return fmt.Errorf("resource store - error scanning result in GetJournalClocks: %w", err)
+			}
+			(*clocks)[partitionName] = uint64(clock)
+		}
+		if err := rows.Err(); err != nil {
+			store.logger.Error("resource store - error reading results of GetJournalClocks query: ", err)
+			return internalError(err)
+		}
+		return nil
+	})
+}
+
+// GetMergedJournalChanges retrieves up to limit entries of all partitions, in clock order, after each partition's
+// clock in since. It returns since advanced past the entries (since itself is not changed).
+func (store *PostgresResourceStoreWithJournal[R]) GetMergedJournalChanges(ctx context.Context, since JournalClockVector, limit int64, journalEntries *[]ResourceJournalEntry) (JournalClockVector, error) {
+	sinceJson, err := json.Marshal(since)
+	if err != nil {
+		return nil, fmt.Errorf("resource store - error marshaling the journal clocks in GetMergedJournalChanges: %w", err)
+	}
+
+	query, params := store.Cmds.GetMergedJournalChangesCommand(sinceJson, limit)
+	var page []ResourceJournalEntry
+	if err := store.queryJournal(ctx, query, params, "GetMergedJournalChanges", &page); err != nil {
+		return nil, err
+	}
+
+	*journalEntries = append(*journalEntries, page...)
+	next := maps.Clone(since)
+	if next == nil {
+		next = JournalClockVector{}
+	}
+	next.Advance(page)
+	return next, nil
+}
+
+// queryJournal appends the journal entries selected by query
+func (store *PostgresResourceStoreWithJournal[R]) queryJournal(ctx context.Context, query string, params pgx.NamedArgs, methodName string, journalEntries *[]ResourceJournalEntry) error {
+	var found []ResourceJournalEntry
+	err := store.withRetry(ctx, methodName, true, func(ctx context.Context) error {
+		found = nil
+		rows, err := store.readPool(ctx).Query(ctx, query, params)
+		if err != nil {
+			store.logger.Errorf("resource store - error detected on %s query: %v", methodName, err)
+			return internalError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var journalEntry ResourceJournalEntry
+			if err := rows.Scan(&journalEntry.Clock, &journalEntry.Resource, &journalEntry.UpdatedAt, &journalEntry.PartitionName); err != nil {
+				return fmt.Errorf("resource store - error scanning result in %s: %w", methodName, err)
+			}
+			found = append(found, journalEntry)
+		}
+		if err := rows.Err(); err != nil {
+			store.logger.Errorf("resource store - error reading results of %s query: %v", methodName, err)
+			return internalError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	*journalEntries = append(*journalEntries, found...)
+	return nil
+}
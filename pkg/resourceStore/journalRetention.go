@@ -0,0 +1,129 @@
+package resourceStore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/viper"
+)
+
+type RetentionMode string
+
+const (
+	RETENTION_MODE_NONE    RetentionMode = ""        // the journal is kept forever
+	RETENTION_MODE_DELETE  RetentionMode = "delete"  // entries older than the window are deleted
+	RETENTION_MODE_COMPACT RetentionMode = "compact" // entries older than the window are deleted if a newer entry for the same resource is too
+)
+
+const RETENTION_DEFAULT_INTERVAL = time.Hour
+
+// RetentionPolicy bounds the growth of the journal. With RETENTION_MODE_DELETE, readers that fall behind the
+// window lose entries, so the store records the lowest clock that can still be read (see GetJournalMinClock)
+// and the journal routes answer older clocks with 410 Gone. RETENTION_MODE_COMPACT keeps the latest entry of
+// every resource, so replaying the journal from any clock still ends in the current state - only the
+// intermediate versions (and so the older GetHistory results) are lost.
+type RetentionPolicy struct {
+	Mode     RetentionMode
+	Window   time.Duration // entries older than this are deleted or compacted
+	Interval time.Duration // how often the background job applies the policy (defaults to 1h)
+}
+
+// RetentionPolicyFromConfig reads JOURNAL_RETENTION_MODE, JOURNAL_RETENTION_WINDOW and JOURNAL_RETENTION_INTERVAL
+func RetentionPolicyFromConfig(configuration *viper.Viper) (RetentionPolicy, error) {
+	policy := RetentionPolicy{Mode: RetentionMode(configuration.GetString(constants.JOURNAL_RETENTION_MODE))}
+	if policy.Mode == RETENTION_MODE_NONE {
+		return policy, nil
+	}
+
+	window, err := time.ParseDuration(configuration.GetString(constants.JOURNAL_RETENTION_WINDOW))
+	if err != nil {
+		return policy, fmt.Errorf("resource store - invalid %s: %w", constants.JOURNAL_RETENTION_WINDOW, err)
+	}
+	policy.Window = window
+
+	if interval := configuration.GetString(constants.JOURNAL_RETENTION_INTERVAL); interval != "" {
+		policy.Interval, err = time.ParseDuration(interval)
+		if err != nil {
+			return policy, fmt.Errorf("resource store - invalid %s: %w", constants.JOURNAL_RETENTION_INTERVAL, err)
+		}
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate checks the policy and fills in the default interval
+func (p *RetentionPolicy) Validate() error {
+	switch p.Mode {
+	case RETENTION_MODE_NONE:
+		return nil
+	case RETENTION_MODE_DELETE, RETENTION_MODE_COMPACT:
+	default:
+		return fmt.Errorf("resource store - unknown journal retention mode '%s'", p.Mode)
+	}
+	if p.Window <= 0 {
+		return errors.New("resource store - the journal retention window must be positive")
+	}
+	if p.Interval == 0 {
+		p.Interval = RETENTION_DEFAULT_INTERVAL
+	}
+	if p.Interval < 0 {
+		return errors.New("resource store - the journal retention interval can't be negative")
+	}
+	return nil
+}
+
+// ApplyJournalRetention applies the policy to the store's journal partition once and returns the number of
+// entries removed. Services normally let the NounJournalRouter run it as a background job.
+func (store *PostgresResourceStoreWithJournal[R]) ApplyJournalRetention(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	if err := policy.Validate(); err != nil {
+		return 0, err
+	}
+
+	var query string
+	var params pgx.NamedArgs
+	cutoff := time.Now().Add(-policy.Window)
+	switch policy.Mode {
+	case RETENTION_MODE_NONE:
+		return 0, nil
+	case RETENTION_MODE_DELETE:
+		query, params = store.Cmds.GetDeleteJournalCommand(store.journalPartitionName, cutoff)
+	case RETENTION_MODE_COMPACT:
+		query, params = store.Cmds.GetCompactJournalCommand(store.journalPartitionName, cutoff)
+	}
+
+	var removed int64
+	if err := store.dbPool.QueryRow(ctx, query, params).Scan(&removed); err != nil {
+		store.logger.Error("resource store - error detected applying journal retention: ", err)
+		return 0, internalError(err)
+	}
+	if removed > 0 {
+		store.logger.Infof("resource store - journal retention (%s) removed %d entries older than %v", policy.Mode, removed, cutoff.UTC())
+	}
+	return removed, nil
+}
+
+// GetJournalMinClock returns the lowest clock that can still be read from the journal, or 0 if retention has
+// never deleted any entries (compaction doesn't change it).
+func (store *PostgresResourceStoreWithJournal[R]) GetJournalMinClock(ctx context.Context, minClock *uint64) error {
+	query, params := store.Cmds.GetJournalMinClockCommand(store.journalPartitionName)
+
+	var clock int64
+	err := store.withRetry(ctx, "GetJournalMinClock", true, func(ctx context.Context) error {
+		return store.readPool(ctx).QueryRow(ctx, query, params).Scan(&clock)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		*minClock = 0
+		return nil
+	}
+	if err != nil {
+		store.logger.Error("resource store - error detected on GetJournalMinClock query: ", err)
+		return internalError(err)
+	}
+
+	*minClock = uint64(clock)
+	return nil
+}
@@ -0,0 +1,202 @@
+package resourceStore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	journalSubscriptionPageSize     = 500
+	journalSubscriptionPollInterval = 30 * time.Second // re-reads the journal even without a wakeup, e.g. while the listener is reconnecting
+	journalSubscriptionMinBackoff   = time.Second
+	journalSubscriptionMaxBackoff   = 30 * time.Second
+)
+
+// SubscribeJournal streams the journal entries of the store's partition, starting at fromClock. It first catches
+// up from the Journal table and then delivers new entries as they are written (the insert/update commands NOTIFY
+// the partition's channel). The subscriptions of a store share one listener connection, which wakes each of them
+// to read the new entries through the pool - so subscriptions don't hold a connection each. If the listener
+// connection drops, it reconnects with backoff and the subscriptions resume after their last delivered clock. Every
+// writer holds the journal lock until it commits (see GetLockJournalCommand), so entries become visible in clock
+// order and moving past a clock can't skip one that commits later. Entries therefore arrive in clock order without
+// gaps or duplicates, as long as every writer to the Journal table goes through a resource store. The channel is
+// closed when ctx is cancelled or the store is closed.
+//
+// Example usage from a service:
+//
+//	for entry := range store.SubscribeJournal(ctx, lastProcessedClock+1) {
+//		process(entry)
+//		lastProcessedClock = entry.Clock
+//	}
+func (store *PostgresResourceStoreWithJournal[R]) SubscribeJournal(ctx context.Context, fromClock uint64) <-chan ResourceJournalEntry {
+	entries := make(chan ResourceJournalEntry)
+
+	subscriptionCtx, cancel := context.WithCancel(ctx)
+	stopOnClose := context.AfterFunc(store.rootCtx, cancel)
+
+	go func() {
+		defer close(entries)
+		defer cancel()
+		defer stopOnClose()
+
+		// registered before the first read, so a write made during the read wakes the subscription again
+		wakeup := store.listener.subscribe(store.rootCtx, store.runJournalListener)
+		defer store.listener.unsubscribe(wakeup)
+
+		nextClock := fromClock
+		backoff := journalSubscriptionMinBackoff
+		for {
+			err := store.deliverJournal(subscriptionCtx, &nextClock, entries)
+			if subscriptionCtx.Err() != nil {
+				return
+			}
+			if err != nil {
+				store.logger.Errorf("resource store - journal subscription interrupted at clock %d, retrying in %v: %v", nextClock, backoff, err)
+				select {
+				case <-time.After(backoff):
+				case <-subscriptionCtx.Done():
+					return
+				}
+				backoff = min(backoff*2, journalSubscriptionMaxBackoff)
+				continue
+			}
+			backoff = journalSubscriptionMinBackoff
+
+			select {
+			case <-wakeup:
+			case <-time.After(journalSubscriptionPollInterval):
+			case <-subscriptionCtx.Done():
+				return
+			}
+		}
+	}()
+
+	return entries
+}
+
+// journalListener holds the one LISTEN connection of a store while it has journal subscriptions, and wakes them
+// when the store's partition is written to
+type journalListener struct {
+	mutex       sync.Mutex
+	subscribers map[chan struct{}]struct{}
+	stop        context.CancelFunc // stops the running listener - nil when there are no subscriptions
+}
+
+// subscribe returns the wakeup channel of a new subscription, starting the listener (run, until the last
+// subscription ends or parent does) for the first one. The channel holds at most one wakeup, so a burst of writes
+// wakes a busy subscription once.
+func (l *journalListener) subscribe(parent context.Context, run func(ctx context.Context)) chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	wakeup := make(chan struct{}, 1)
+	if l.subscribers == nil {
+		l.subscribers = map[chan struct{}]struct{}{}
+	}
+	l.subscribers[wakeup] = struct{}{}
+	if l.stop == nil {
+		var ctx context.Context
+		ctx, l.stop = context.WithCancel(parent)
+		go run(ctx)
+	}
+	return wakeup
+}
+
+// unsubscribe removes a subscription, stopping the listener after the last one
+func (l *journalListener) unsubscribe(wakeup chan struct{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.subscribers, wakeup)
+	if len(l.subscribers) == 0 && l.stop != nil {
+		l.stop()
+		l.stop = nil
+	}
+}
+
+func (l *journalListener) wakeAll() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for wakeup := range l.subscribers {
+		select {
+		case wakeup <- struct{}{}:
+		default: // already due to wake
+		}
+	}
+}
+
+// runJournalListener keeps the listener connection up until ctx ends, reconnecting with backoff
+func (store *PostgresResourceStoreWithJournal[R]) runJournalListener(ctx context.Context) {
+	backoff := journalSubscriptionMinBackoff
+	for {
+		connected, err := store.listenJournal(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = journalSubscriptionMinBackoff
+		}
+		store.logger.Errorf("resource store - journal listener interrupted, retrying in %v: %v", backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, journalSubscriptionMaxBackoff)
+	}
+}
+
+// listenJournal runs one listener connection until it fails. It reports whether the connection was established
+// so that the caller can reset its backoff.
+func (store *PostgresResourceStoreWithJournal[R]) listenJournal(ctx context.Context) (bool, error) {
+	// a dedicated connection - LISTEN is session state that must not leak back into the pool
+	conn, err := pgx.ConnectConfig(ctx, store.dbPool.Config().ConnConfig.Copy())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, store.Cmds.GetListenJournalCommand(store.journalPartitionName)); err != nil {
+		return false, err
+	}
+
+	// a subscription may have read before the listener was listening - reading again once it is means nothing
+	// written since can be missed
+	store.listener.wakeAll()
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return true, err
+		}
+		store.listener.wakeAll()
+	}
+}
+
+// deliverJournal sends every entry of the store's partition from nextClock onwards, advancing nextClock as it goes
+func (store *PostgresResourceStoreWithJournal[R]) deliverJournal(ctx context.Context, nextClock *uint64, entries chan<- ResourceJournalEntry) error {
+	// the notification came from the primary, and a replica may not have the entries yet
+	ctx = ReadFromPrimary(ctx)
+	for {
+		var page []ResourceJournalEntry
+		if err := store.GetPartitionJournalChanges(ctx, store.journalPartitionName, int64(*nextClock), journalSubscriptionPageSize, &page); err != nil {
+			return err
+		}
+
+		for _, entry := range page {
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			*nextClock = entry.Clock + 1
+		}
+
+		if len(page) < journalSubscriptionPageSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package resourceStore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/geraldhinson/siftd-base/pkg/problemDetails"
+)
+
+// JsonSchema is the subset of JSON Schema that a store can check resources against before they are saved (see
+// SetSchema): type (a single type name), properties, required, additionalProperties (true or false only), items,
+// enum, const, minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern, minItems and
+// maxItems. Other keywords are ignored. The schema applies to the resource as it is stored, ResourceBase fields
+// included, so a schema with additionalProperties false has to list them.
+type JsonSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*JsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JsonSchema            `json:"items,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Const                any                    `json:"const,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+var jsonSchemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// ParseJsonSchema parses and checks a schema, e.g.
+//
+//	schema, err := resourceStore.ParseJsonSchema([]byte(`{"type": "object", "required": ["name"],
+//		"properties": {"name": {"type": "string", "minLength": 1}, "age": {"type": "integer", "minimum": 16}}}`))
+func ParseJsonSchema(data []byte) (*JsonSchema, error) {
+	// numbers in enum and const are decoded the same way as the documents validated
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	schema := &JsonSchema{}
+	if err := decoder.Decode(schema); err != nil {
+		return nil, fmt.Errorf("resource store - invalid JSON schema: %w", err)
+	}
+	if err := schema.compile(""); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// LoadJsonSchema parses the schema in a file
+func LoadJsonSchema(path string) (*JsonSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("resource store - unable to read the JSON schema %s: %w", path, err)
+	}
+	return ParseJsonSchema(data)
+}
+
+// compile checks the keywords and compiles the patterns of the schema and its subschemas
+func (s *JsonSchema) compile(path string) error {
+	if s.Type != "" && !slices.Contains(jsonSchemaTypes, s.Type) {
+		return fmt.Errorf("resource store - invalid JSON schema type '%s' at '%s'", s.Type, path)
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("resource store - invalid JSON schema pattern at '%s': %w", path, err)
+		}
+		s.pattern = pattern
+	}
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("resource store - invalid null JSON schema at '%s'", schemaFieldPath(path, name))
+		}
+		if err := property.compile(schemaFieldPath(path, name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+	return nil
+}
+
+// Validate checks a JSON document against the schema, returning a field error for each failure (none when the
+// document is valid). Fields are named by their path in the document, e.g. "employee.age" or "tags[2]".
+func (s *JsonSchema) Validate(document []byte) []problemDetails.FieldError {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []problemDetails.FieldError{{Field: "", Message: "the document is not valid JSON"}}
+	}
+
+	var fieldErrors []problemDetails.FieldError
+	s.validate("", value, &fieldErrors)
+	return fieldErrors
+}
+
+func (s *JsonSchema) validate(path string, value any, fieldErrors *[]problemDetails.FieldError) {
+	fail := func(format string, args ...any) {
+		*fieldErrors = append(*fieldErrors, problemDetails.FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !jsonTypeMatches(s.Type, value) {
+		fail("must be of type %s", s.Type)
+		return
+	}
+	if s.Const != nil && !jsonValuesEqual(s.Const, value) {
+		fail("must be %v", s.Const)
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(allowed any) bool { return jsonValuesEqual(allowed, value) }) {
+		fail("must be one of %v", s.Enum)
+	}
+
+	switch typed := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := typed[name]; !ok {
+				*fieldErrors = append(*fieldErrors, problemDetails.FieldError{Field: schemaFieldPath(path, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(typed))
+		for name := range typed {
+			names = append(names, name)
+		}
+		slices.Sort(names) // so the errors come out in a stable order
+		for _, name := range names {
+			if property, ok := s.Properties[name]; ok {
+				property.validate(schemaFieldPath(path, name), typed[name], fieldErrors)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*fieldErrors = append(*fieldErrors, problemDetails.FieldError{Field: schemaFieldPath(path, name), Message: "is not allowed"})
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(typed) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(typed) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range typed {
+				s.Items.validate(path+"["+strconv.Itoa(i)+"]", item, fieldErrors)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(typed)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(typed) {
+			fail("must match the pattern %s", s.Pattern)
+		}
+	case json.Number:
+		number, _ := typed.Float64()
+		if s.Minimum != nil && number < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && number <= *s.ExclusiveMinimum {
+			fail("must be greater than %v", *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && number >= *s.ExclusiveMaximum {
+			fail("must be less than %v", *s.ExclusiveMaximum)
+		}
+	}
+}
+
+func jsonTypeMatches(schemaType string, value any) bool {
+	switch typed := value.(type) {
+	case map[string]any:
+		return schemaType == "object"
+	case []any:
+		return schemaType == "array"
+	case string:
+		return schemaType == "string"
+	case bool:
+		return schemaType == "boolean"
+	case nil:
+		return schemaType == "null"
+	case json.Number:
+		if schemaType == "number" {
+			return true
+		}
+		number, err := typed.Float64()
+		return schemaType == "integer" && err == nil && number == math.Trunc(number)
+	}
+	return false
+}
+
+func schemaFieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,297 @@
+package resourceStore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The MemoryResourceStore evaluates a ResourceQuery in Go. The helpers below follow the postgres jsonb rules
+// used by GetQueryResourcesByOwnerIdCommand so both stores return the same pages: containment (@>) for eq,
+// jsonb equality for in, jsonb ordering for range and sort, and the text form (#>>) of the field for prefix.
+// The one known difference is that strings are compared bytewise rather than with the database collation.
+
+type memoryQueryRow struct {
+	id         string
+	sortValues []any
+}
+
+// newMemoryQueryRow decodes a stored resource, applies the query filters and extracts the sort values
+func newMemoryQueryRow(id string, data []byte, query *ResourceQuery) (memoryQueryRow, bool, error) {
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return memoryQueryRow{}, false, err
+	}
+
+	for _, filter := range query.Filters {
+		path, err := fieldPath(filter.Field)
+		if err != nil {
+			return memoryQueryRow{}, false, err
+		}
+		if !matchesFilter(document, path, &filter) {
+			return memoryQueryRow{}, false, nil
+		}
+	}
+
+	row := memoryQueryRow{id: id, sortValues: make([]any, len(query.Sort))}
+	for i, sortKey := range query.Sort {
+		path, err := fieldPath(sortKey.Field)
+		if err != nil {
+			return memoryQueryRow{}, false, err
+		}
+		// a missing field sorts as JSON null, as with the COALESCE in the postgres query
+		row.sortValues[i], _ = valueAtPath(document, path)
+	}
+
+	return row, true, nil
+}
+
+func matchesFilter(document any, path []string, filter *QueryFilter) bool {
+	switch filter.Op {
+	case QUERY_OP_EQ:
+		return jsonContains(document, nestValue(path, normalizeJSON(filter.Values[0])))
+	case QUERY_OP_IN:
+		value, ok := valueAtPath(document, path)
+		if !ok {
+			return false
+		}
+		for _, candidate := range filter.Values {
+			if compareJSON(value, normalizeJSON(candidate)) == 0 {
+				return true
+			}
+		}
+		return false
+	case QUERY_OP_RANGE:
+		value, ok := valueAtPath(document, path)
+		if !ok {
+			return false
+		}
+		if filter.Values[0] != nil && compareJSON(value, normalizeJSON(filter.Values[0])) < 0 {
+			return false
+		}
+		if filter.Values[1] != nil && compareJSON(value, normalizeJSON(filter.Values[1])) > 0 {
+			return false
+		}
+		return true
+	case QUERY_OP_PREFIX:
+		value, ok := valueAtPath(document, path)
+		if !ok || value == nil {
+			return false
+		}
+		return strings.HasPrefix(jsonText(value), filter.Values[0].(string))
+	}
+	return false
+}
+
+// compareMemoryQueryRows orders rows by the sort keys and then by id, as the postgres ORDER BY does
+func compareMemoryQueryRows(query *ResourceQuery, leftValues []any, leftId string, rightValues []any, rightId string) int {
+	for i, sortKey := range query.Sort {
+		result := compareJSON(leftValues[i], rightValues[i])
+		if sortKey.Descending {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return strings.Compare(leftId, rightId)
+}
+
+func encodeCursorValues(values []any) []string {
+	encoded := make([]string, len(values))
+	for i, value := range values {
+		jsonValue, _ := json.Marshal(value)
+		encoded[i] = string(jsonValue)
+	}
+	return encoded
+}
+
+func decodeCursorValues(cursor *queryCursor) ([]any, error) {
+	values := make([]any, len(cursor.Values))
+	for i, value := range cursor.Values {
+		if err := json.Unmarshal([]byte(value), &values[i]); err != nil {
+			return nil, fmt.Errorf("resource query - invalid cursor")
+		}
+	}
+	return values, nil
+}
+
+// valueAtPath is the equivalent of the jsonb #> operator - object keys, or array indexes for arrays
+func valueAtPath(document any, path []string) (any, bool) {
+	current := document
+	for _, segment := range path {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, false
+			}
+			if index < 0 {
+				index += len(node)
+			}
+			if index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// jsonText is the equivalent of the jsonb #>> operator - strings unquoted, everything else as JSON
+func jsonText(value any) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	jsonValue, _ := json.Marshal(value)
+	return string(jsonValue)
+}
+
+// jsonContains is the equivalent of the jsonb @> operator
+func jsonContains(document any, pattern any) bool {
+	switch p := pattern.(type) {
+	case map[string]any:
+		d, ok := document.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range p {
+			documentValue, ok := d[key]
+			if !ok || !jsonContains(documentValue, value) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		d, ok := document.([]any)
+		if !ok {
+			return false
+		}
+		for _, value := range p {
+			found := false
+			for _, documentValue := range d {
+				if jsonContains(documentValue, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return jsonTypeRank(document) == jsonTypeRank(pattern) && compareJSON(document, pattern) == 0
+	}
+}
+
+// jsonTypeRank gives the jsonb ordering between types: null < string < number < boolean < array < object
+func jsonTypeRank(value any) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case string:
+		return 1
+	case float64:
+		return 2
+	case bool:
+		return 3
+	case []any:
+		return 4
+	case map[string]any:
+		return 5
+	}
+	return 6
+}
+
+// compareJSON is the jsonb btree ordering of two decoded JSON values
+func compareJSON(left any, right any) int {
+	leftRank, rightRank := jsonTypeRank(left), jsonTypeRank(right)
+	if leftRank != rightRank {
+		return leftRank - rightRank
+	}
+
+	switch l := left.(type) {
+	case string:
+		return strings.Compare(l, right.(string))
+	case float64:
+		r := right.(float64)
+		if l < r {
+			return -1
+		}
+		if l > r {
+			return 1
+		}
+		return 0
+	case bool:
+		r := right.(bool)
+		if l == r {
+			return 0
+		}
+		if !l {
+			return -1
+		}
+		return 1
+	case []any:
+		r := right.([]any)
+		if len(l) != len(r) {
+			return len(l) - len(r)
+		}
+		for i := range l {
+			if result := compareJSON(l[i], r[i]); result != 0 {
+				return result
+			}
+		}
+		return 0
+	case map[string]any:
+		r := right.(map[string]any)
+		if len(l) != len(r) {
+			return len(l) - len(r)
+		}
+		leftKeys, rightKeys := sortedKeys(l), sortedKeys(r)
+		for i := range leftKeys {
+			if result := strings.Compare(leftKeys[i], rightKeys[i]); result != 0 {
+				return result
+			}
+		}
+		for _, key := range leftKeys {
+			if result := compareJSON(l[key], r[key]); result != 0 {
+				return result
+			}
+		}
+		return 0
+	}
+	return 0
+}
+
+func sortedKeys(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// normalizeJSON converts a filter value to the form produced by json.Unmarshal (e.g. an int to a float64)
+func normalizeJSON(value any) any {
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized any
+	if err := json.Unmarshal(jsonValue, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
@@ -0,0 +1,1153 @@
+package resourceStore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// memoryResource is the in-memory equivalent of a row in the Resources table
+type memoryResource struct {
+	ownerId string
+	version uint
+	deleted bool
+	data    []byte
+}
+
+// MemoryResourceStore is an in-memory IResourceStore intended for unit testing routers and services without
+// a database. It keeps the same semantics as PostgresResourceStoreWithJournal (owner scoping, version checks,
+// soft-delete, monotonic journal clocks and the same status codes) but nothing survives the process.
+type MemoryResourceStore[R any] struct {
+	journalPartitionName string
+	logger               *logrus.Logger
+	mutex                sync.RWMutex
+	resources            map[string]memoryResource
+	journal              []ResourceJournalEntry
+	clock                uint64
+	minClock             uint64                     // the lowest clock retention has left readable (0 until it deletes)
+	subscribers          map[chan struct{}]struct{} // woken whenever the journal grows
+	done                 chan struct{}              // closed by Close to end the subscriptions
+	closed               bool
+	schema               *JsonSchema // nil unless set with SetSchema
+}
+
+func NewMemoryResourceStore[R any](configuration *viper.Viper, logger *logrus.Logger) (*MemoryResourceStore[R], error) {
+	// validate that R is a struct that included an embedded ResourceBase struct
+	testR := new(R)
+	if _, ok := any(testR).(IResource); !ok {
+		return nil, fmt.Errorf("resource store - the type R is not a valid resource type. It is missing an embedded ResourceBase struct")
+	}
+
+	// validate inputs
+	if configuration == nil {
+		return nil, fmt.Errorf("resource store - invalid nil configuration detected")
+	}
+	if logger == nil {
+		return nil, fmt.Errorf("resource store - invalid nil logger detected")
+	}
+
+	store := &MemoryResourceStore[R]{
+		logger:      logger,
+		resources:   make(map[string]memoryResource),
+		subscribers: make(map[chan struct{}]struct{}),
+		done:        make(chan struct{}),
+	}
+
+	store.journalPartitionName = configuration.GetString(constants.JOURNAL_PARTITION_NAME)
+	if store.journalPartitionName == "" {
+		return nil, fmt.Errorf("resource store - unable to retrieve journal partition name")
+	}
+
+	return store, nil
+}
+
+// checkAvailable mirrors the failures the postgres store reports for a cancelled request or a closed pool.
+// The caller must hold the mutex.
+func (store *MemoryResourceStore[R]) checkAvailable(ctx context.Context, methodName string) error {
+	if err := ctx.Err(); err != nil {
+		store.logger.Errorf("resource store - error detected in %s: %v", methodName, err)
+		return internalError(err)
+	}
+	if store.closed {
+		store.logger.Errorf("resource store - %s called on a closed store", methodName)
+		return internalError(fmt.Errorf("resource store - %s called on a closed store", methodName))
+	}
+	return nil
+}
+
+// GetById retrieves a resource by its ID. Resources that have been soft-deleted are reported as not found.
+func (store *MemoryResourceStore[R]) GetById(ctx context.Context, ownerId string, id string, resource *R) (int, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetById"); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+	return store.getByIdLocked(ownerId, id, false, resource)
+}
+
+// GetByIdIncludingDeleted retrieves a resource by its ID even if it has been soft-deleted
+func (store *MemoryResourceStore[R]) GetByIdIncludingDeleted(ctx context.Context, ownerId string, id string, resource *R) (int, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetById"); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+	return store.getByIdLocked(ownerId, id, true, resource)
+}
+
+func (store *MemoryResourceStore[R]) getByIdLocked(ownerId string, id string, includeDeleted bool, resource *R) (int, error) {
+	stored, ok := store.resources[id]
+	if !ok || stored.ownerId != ownerId || (stored.deleted && !includeDeleted) {
+		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("resource store - resource not found: %v", id)
+	}
+
+	if err := json.Unmarshal(stored.data, resource); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetById: %w", err))
+	}
+
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// GetByOwnerId retrieves the resources of an owner (ordered by id, which the postgres store doesn't guarantee)
+func (store *MemoryResourceStore[R]) GetByOwnerId(ctx context.Context, ownerId string, resources *[]R) (int, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetByOwnerId"); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+
+	for _, id := range store.sortedIdsLocked() {
+		stored := store.resources[id]
+		if stored.ownerId != ownerId || stored.deleted {
+			continue
+		}
+		var resource R
+		if err := json.Unmarshal(stored.data, &resource); err != nil {
+			return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetByOwnerId: %w", err))
+		}
+		*resources = append(*resources, resource)
+	}
+
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// QueryByOwnerId retrieves one page of an owner's resources that match the filters in query, in the order
+// given by its sort keys. Filters and ordering follow the postgres jsonb rules (see memoryQuery.go).
+func (store *MemoryResourceStore[R]) QueryByOwnerId(ctx context.Context, ownerId string, query ResourceQuery, resources *[]R) (string, int, error) {
+	if err := query.Validate(); err != nil {
+		return "", constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+	cursor, err := query.decodeCursor()
+	if err != nil {
+		return "", constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "QueryByOwnerId"); err != nil {
+		return "", constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+
+	var rows []memoryQueryRow
+	for id, stored := range store.resources {
+		if stored.ownerId != ownerId || stored.deleted {
+			continue
+		}
+		row, matched, err := newMemoryQueryRow(id, stored.data, &query)
+		if err != nil {
+			return "", constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in QueryByOwnerId: %w", err))
+		}
+		if matched {
+			rows = append(rows, row)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return compareMemoryQueryRows(&query, rows[i].sortValues, rows[i].id, rows[j].sortValues, rows[j].id) < 0
+	})
+
+	if cursor != nil {
+		cursorValues, err := decodeCursorValues(cursor)
+		if err != nil {
+			return "", constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+		}
+		start := sort.Search(len(rows), func(i int) bool {
+			return compareMemoryQueryRows(&query, rows[i].sortValues, rows[i].id, cursorValues, cursor.Id) > 0
+		})
+		rows = rows[start:]
+	}
+
+	var nextCursor string
+	if len(rows) > query.Limit {
+		rows = rows[:query.Limit]
+		last := rows[len(rows)-1]
+		nextCursor = query.encodeCursor(encodeCursorValues(last.sortValues), last.id)
+	}
+
+	for _, row := range rows {
+		var resource R
+		if err := json.Unmarshal(store.resources[row.id].data, &resource); err != nil {
+			return "", constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in QueryByOwnerId: %w", err))
+		}
+		*resources = append(*resources, resource)
+	}
+
+	return nextCursor, constants.RESOURCE_OK_CODE, nil
+}
+
+// GetJournalChanges retrieves changes >= clock up to limit entries
+func (store *MemoryResourceStore[R]) GetJournalChanges(ctx context.Context, clock int64, limit int64, journalEntries *[]ResourceJournalEntry) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetJournalChanges"); err != nil {
+		return err
+	}
+
+	start := sort.Search(len(store.journal), func(i int) bool {
+		return int64(store.journal[i].Clock) >= clock
+	})
+	for i := start; i < len(store.journal) && int64(i-start) < limit; i++ {
+		*journalEntries = append(*journalEntries, store.journal[i])
+	}
+
+	return nil
+}
+
+func (store *MemoryResourceStore[R]) GetJournalMaxClock(ctx context.Context, maxClock *uint64) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetJournalMaxClock"); err != nil {
+		return err
+	}
+
+	*maxClock = 0
+	if len(store.journal) > 0 {
+		*maxClock = store.journal[len(store.journal)-1].Clock
+	}
+
+	return nil
+}
+
+// ApplyJournalRetention applies the policy to the journal once and returns the number of entries removed
+func (store *MemoryResourceStore[R]) ApplyJournalRetention(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	if err := policy.Validate(); err != nil {
+		return 0, err
+	}
+	if policy.Mode == RETENTION_MODE_NONE {
+		return 0, nil
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.checkAvailable(ctx, "ApplyJournalRetention"); err != nil {
+		return 0, err
+	}
+
+	// like the postgres store, delete mode always keeps the newest entry so the max clock never goes back
+	candidates := store.journal
+	if policy.Mode == RETENTION_MODE_DELETE && len(candidates) > 0 {
+		candidates = candidates[:len(candidates)-1]
+	}
+	cutoff := time.Now().Add(-policy.Window)
+	var watermark uint64
+	for _, entry := range candidates {
+		if entry.UpdatedAt.Before(cutoff) {
+			watermark = entry.Clock
+		}
+	}
+	if watermark == 0 {
+		return 0, nil
+	}
+
+	snapshots := store.journalSnapshotsLocked()
+	var latest map[string]uint64
+	if policy.Mode == RETENTION_MODE_COMPACT {
+		latest = map[string]uint64{}
+		for _, snapshot := range snapshots {
+			if snapshot.entry.Clock <= watermark {
+				latest[snapshot.base.OwnerId+"/"+snapshot.base.Id] = snapshot.entry.Clock
+			}
+		}
+	}
+
+	retained := make([]ResourceJournalEntry, 0, len(store.journal))
+	for _, snapshot := range snapshots {
+		entry := snapshot.entry
+		if entry.Clock <= watermark {
+			if policy.Mode == RETENTION_MODE_DELETE || latest[snapshot.base.OwnerId+"/"+snapshot.base.Id] != entry.Clock {
+				continue
+			}
+		}
+		retained = append(retained, entry)
+	}
+	removed := int64(len(store.journal) - len(retained))
+	store.journal = retained
+	if policy.Mode == RETENTION_MODE_DELETE {
+		store.minClock = max(store.minClock, watermark+1)
+	}
+
+	if removed > 0 {
+		store.logger.Infof("resource store - journal retention (%s) removed %d entries older than %v", policy.Mode, removed, cutoff.UTC())
+	}
+	return removed, nil
+}
+
+// GetJournalMinClock returns the lowest clock that can still be read from the journal, or 0 if retention has
+// never deleted any entries
+func (store *MemoryResourceStore[R]) GetJournalMinClock(ctx context.Context, minClock *uint64) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetJournalMinClock"); err != nil {
+		return err
+	}
+
+	*minClock = store.minClock
+	return nil
+}
+
+// JournalPartitionName returns the partition the store writes its journal entries to
+func (store *MemoryResourceStore[R]) JournalPartitionName() string {
+	return store.journalPartitionName
+}
+
+// GetPartitionJournalChanges retrieves the changes of one partition >= clock up to limit entries. The memory store
+// only writes to its own partition.
+func (store *MemoryResourceStore[R]) GetPartitionJournalChanges(ctx context.Context, partitionName string, clock int64, limit int64, journalEntries *[]ResourceJournalEntry) error {
+	if partitionName != store.journalPartitionName {
+		store.mutex.RLock()
+		defer store.mutex.RUnlock()
+		return store.checkAvailable(ctx, "GetPartitionJournalChanges")
+	}
+	return store.GetJournalChanges(ctx, clock, limit, journalEntries)
+}
+
+// GetPartitionJournalMaxClock returns the latest clock of one partition, or 0 if it has no entries
+func (store *MemoryResourceStore[R]) GetPartitionJournalMaxClock(ctx context.Context, partitionName string, maxClock *uint64) error {
+	if partitionName != store.journalPartitionName {
+		store.mutex.RLock()
+		defer store.mutex.RUnlock()
+		*maxClock = 0
+		return store.checkAvailable(ctx, "GetPartitionJournalMaxClock")
+	}
+	return store.GetJournalMaxClock(ctx, maxClock)
+}
+
+// GetJournalClocks returns the max clock of every partition that has journal entries
+func (store *MemoryResourceStore[R]) GetJournalClocks(ctx context.Context, clocks *JournalClockVector) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetJournalClocks"); err != nil {
+		return err
+	}
+
+	*clocks = JournalClockVector{}
+	clocks.Advance(store.journal)
+	return nil
+}
+
+// GetMergedJournalChanges retrieves up to limit entries of all partitions, in clock order, after each partition's
+// clock in since. It returns since advanced past the entries (since itself is not changed).
+func (store *MemoryResourceStore[R]) GetMergedJournalChanges(ctx context.Context, since JournalClockVector, limit int64, journalEntries *[]ResourceJournalEntry) (JournalClockVector, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetMergedJournalChanges"); err != nil {
+		return nil, err
+	}
+
+	next := maps.Clone(since)
+	if next == nil {
+		next = JournalClockVector{}
+	}
+	var page []ResourceJournalEntry
+	for _, entry := range store.journal {
+		if int64(len(page)) >= limit {
+			break
+		}
+		if entry.Clock > since[entry.PartitionName] {
+			page = append(page, entry)
+		}
+	}
+
+	*journalEntries = append(*journalEntries, page...)
+	next.Advance(page)
+	return next, nil
+}
+
+// GetHistory retrieves a page of the versions of a resource from the journal, oldest first
+func (store *MemoryResourceStore[R]) GetHistory(ctx context.Context, ownerId string, id string, page HistoryPage, history *[]R) (int, error) {
+	if err := page.Validate(); err != nil {
+		return constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetHistory"); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+
+	count := 0
+	for _, snapshot := range store.snapshotsLocked(ownerId, id) {
+		if count == page.Limit {
+			break
+		}
+		if snapshot.base.Version <= page.AfterVersion {
+			continue
+		}
+		var resource R
+		if err := json.Unmarshal(snapshot.entry.Resource, &resource); err != nil {
+			return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetHistory: %w", err))
+		}
+		*history = append(*history, resource)
+		count++
+	}
+
+	if count == 0 && page.AfterVersion == 0 {
+		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("resource store - no history found for resource: %v", id)
+	}
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// GetVersion retrieves one version of a resource from the journal, even if that version is a delete
+func (store *MemoryResourceStore[R]) GetVersion(ctx context.Context, ownerId string, id string, version uint, resource *R) (int, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetVersion"); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+
+	snapshots := store.snapshotsLocked(ownerId, id)
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].base.Version == version {
+			if err := json.Unmarshal(snapshots[i].entry.Resource, resource); err != nil {
+				return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetVersion: %w", err))
+			}
+			return constants.RESOURCE_OK_CODE, nil
+		}
+	}
+	return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("resource store - version %d of resource %v not found", version, id)
+}
+
+// GetByIdAsOf retrieves a resource as it was at a clock or a time. A resource that was deleted at that point
+// is reported as not found.
+func (store *MemoryResourceStore[R]) GetByIdAsOf(ctx context.Context, ownerId string, id string, asOf AsOf, resource *R) (int, error) {
+	if err := asOf.Validate(); err != nil {
+		return constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "GetByIdAsOf"); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+
+	snapshots := store.snapshotsLocked(ownerId, id)
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := snapshots[i]
+		afterAsOf := snapshot.entry.UpdatedAt.After(asOf.Time)
+		if asOf.Clock != 0 {
+			afterAsOf = snapshot.entry.Clock > asOf.Clock
+		}
+		if afterAsOf {
+			continue
+		}
+		if snapshot.base.Deleted {
+			break
+		}
+		if err := json.Unmarshal(snapshot.entry.Resource, resource); err != nil {
+			return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetByIdAsOf: %w", err))
+		}
+		return constants.RESOURCE_OK_CODE, nil
+	}
+	return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("resource store - resource not found: %v", id)
+}
+
+// memorySnapshot is a journal entry with the ResourceBase of its resource decoded
+type memorySnapshot struct {
+	entry ResourceJournalEntry
+	base  ResourceBase
+}
+
+// snapshotsLocked returns the journal entries of one resource in clock order (the equivalent of the journal's
+// Id, OwnerId and Version columns)
+func (store *MemoryResourceStore[R]) snapshotsLocked(ownerId string, id string) []memorySnapshot {
+	var snapshots []memorySnapshot
+	for _, snapshot := range store.journalSnapshotsLocked() {
+		if snapshot.base.Id == id && snapshot.base.OwnerId == ownerId {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots
+}
+
+// journalSnapshotsLocked returns every journal entry in clock order with its ResourceBase decoded
+func (store *MemoryResourceStore[R]) journalSnapshotsLocked() []memorySnapshot {
+	snapshots := make([]memorySnapshot, 0, len(store.journal))
+	for _, entry := range store.journal {
+		var base ResourceBase
+		_ = json.Unmarshal(entry.Resource, &base) // the store only journals resources it marshaled itself
+		snapshots = append(snapshots, memorySnapshot{entry: entry, base: base})
+	}
+	return snapshots
+}
+
+// CreateResource creates a new resource
+func (store *MemoryResourceStore[R]) CreateResource(ctx context.Context, resource IResource, extractedAuth string) (IResource, int, error) {
+	return store.write(ctx, "CreateResource", func() (IResource, int, error) {
+		return store.createLocked(ctx, resource, extractedAuth)
+	})
+}
+
+// write runs a single resource write with the store locked, and then the AfterCommit hook of the resource written
+// once the store is unlocked (so that the hook can use the store)
+func (store *MemoryResourceStore[R]) write(ctx context.Context, methodName string, writeLocked func() (IResource, int, error)) (IResource, int, error) {
+	resource, status, err := func() (IResource, int, error) {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+
+		if err := store.checkAvailable(ctx, methodName); err != nil {
+			return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, err
+		}
+		return writeLocked()
+	}()
+	if err != nil {
+		return resource, status, err
+	}
+
+	afterCommit(ctx, resource)
+	return resource, status, nil
+}
+
+func (store *MemoryResourceStore[R]) createLocked(ctx context.Context, resource IResource, extractedAuth string) (IResource, int, error) {
+	jsonResource, status, err := prepareCreate(ctx, resource, extractedAuth, store.schema, "CreateResource")
+	if err != nil {
+		return nil, status, err
+	}
+
+	resourceBase := resource.GetResourceBase()
+	if _, exists := store.resources[resourceBase.Id]; exists {
+		return nil, constants.RESOURCE_ALREADY_EXISTS_CODE, conflictError("resource store - resource save failed for %v in CreateResource due to duplicate key", resourceBase.Id)
+	}
+
+	store.resources[resourceBase.Id] = memoryResource{
+		ownerId: resourceBase.OwnerId,
+		version: resourceBase.Version,
+		deleted: resourceBase.Deleted,
+		data:    jsonResource,
+	}
+	store.appendJournalLocked(jsonResource, resourceBase.UpdatedAt)
+
+	return resource, constants.RESOURCE_OK_CODE, nil
+}
+
+// CreateResources creates many resources, reporting the result of each one like the postgres store
+func (store *MemoryResourceStore[R]) CreateResources(ctx context.Context, resources []IResource, extractedAuth string) ([]BulkResult, int, error) {
+	return store.writeBulk(ctx, resources, extractedAuth, false)
+}
+
+// UpsertResources creates or replaces many resources, reporting the result of each one like the postgres store
+func (store *MemoryResourceStore[R]) UpsertResources(ctx context.Context, resources []IResource, extractedAuth string) ([]BulkResult, int, error) {
+	return store.writeBulk(ctx, resources, extractedAuth, true)
+}
+
+func (store *MemoryResourceStore[R]) writeBulk(ctx context.Context, resources []IResource, extractedAuth string, upsert bool) ([]BulkResult, int, error) {
+	methodName := bulkMethodName(upsert)
+
+	results, status, err := func() ([]BulkResult, int, error) {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+
+		if err := store.checkAvailable(ctx, methodName); err != nil {
+			return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, err
+		}
+
+		results := make([]BulkResult, len(resources))
+		for i, resource := range resources {
+			if resource == nil {
+				results[i] = invalidBulkResult(resource, constants.RESOURCE_BAD_REQUEST_CODE, validationError("resource store - invalid nil resource detected in %s", methodName))
+				continue
+			}
+			results[i] = store.writeBulkLocked(ctx, resource, extractedAuth, upsert)
+		}
+		return results, constants.RESOURCE_OK_CODE, nil
+	}()
+	if err != nil {
+		return nil, status, err
+	}
+
+	afterBulkCommit(ctx, results)
+	return results, status, nil
+}
+
+func (store *MemoryResourceStore[R]) writeBulkLocked(ctx context.Context, resource IResource, extractedAuth string, upsert bool) BulkResult {
+	resourceBase := resource.GetResourceBase()
+	if upsert && resourceBase.Version > 0 {
+		_, status, err := store.updateLocked(ctx, resource, resourceBase.OwnerId, resourceBase.Id, extractedAuth, false)
+		return memoryBulkResult(resource, bulkUpdate, status, err)
+	}
+
+	stored, exists := store.resources[resourceBase.Id]
+	if !upsert || !exists {
+		_, status, err := store.createLocked(ctx, resource, extractedAuth)
+		return memoryBulkResult(resource, bulkCreate, status, err)
+	}
+	if stored.ownerId != resourceBase.OwnerId {
+		return memoryBulkResult(resource, bulkCreate, constants.RESOURCE_ALREADY_EXISTS_CODE,
+			conflictError("resource store - resource save failed for %v in UpsertResources due to duplicate key", resourceBase.Id))
+	}
+
+	// replace the stored resource as its next version
+	var storedBase ResourceBase
+	_ = json.Unmarshal(stored.data, &storedBase) // the store only keeps resources it marshaled itself
+	resourceBase.Version = stored.version
+	resourceBase.CreatedAt = storedBase.CreatedAt
+	_, status, err := store.updateLocked(ctx, resource, resourceBase.OwnerId, resourceBase.Id, extractedAuth, true)
+	return memoryBulkResult(resource, bulkUpsert, status, err)
+}
+
+// memoryBulkResult maps the status of the single resource method used for one resource of a bulk call to its BulkResult
+func memoryBulkResult(resource IResource, kind bulkKind, status int, err error) BulkResult {
+	result := BulkResult{Id: resource.GetResourceBase().Id, Status: status, Err: err}
+	switch {
+	case status == constants.RESOURCE_OK_CODE && kind == bulkCreate:
+		result.Outcome = BULK_OUTCOME_CREATED
+		result.Resource = resource
+	case status == constants.RESOURCE_OK_CODE:
+		result.Outcome = BULK_OUTCOME_UPDATED
+		result.Resource = resource
+	case status == constants.RESOURCE_ALREADY_EXISTS_CODE:
+		result.Outcome = BULK_OUTCOME_DUPLICATE
+	case kind == bulkUpdate && (errors.Is(err, ErrVersionMismatch) || errors.Is(err, ErrNotFound)):
+		result.Outcome = BULK_OUTCOME_VERSION_CONFLICT
+	default:
+		result.Outcome = BULK_OUTCOME_INVALID
+	}
+	return result
+}
+
+// UpdateResource replaces an existing resource, provided the version in the body matches the stored version
+func (store *MemoryResourceStore[R]) UpdateResource(ctx context.Context, resource IResource, ownerId string, resourceId string, extractedAuth string) (IResource, int, error) {
+	return store.write(ctx, "UpdateResource", func() (IResource, int, error) {
+		return store.updateLocked(ctx, resource, ownerId, resourceId, extractedAuth, false)
+	})
+}
+
+// updateLocked replaces a stored resource that isn't deleted - unless restoreDeleted is set, which only the upsert
+// of a resource with no version does (like the postgres upsert)
+func (store *MemoryResourceStore[R]) updateLocked(ctx context.Context, resource IResource, ownerId string, resourceId string, extractedAuth string, restoreDeleted bool) (IResource, int, error) {
+	loadOld := func(expectedVersion uint) (IResource, int, error) {
+		stored := new(R)
+		status, err := store.getByIdLocked(ownerId, resourceId, restoreDeleted, stored)
+		return storedForUpdate(status, err, stored, expectedVersion, resourceId, "UpdateResource")
+	}
+	versionToUpdate, jsonResource, status, err := prepareUpdate(ctx, resource, ownerId, resourceId, extractedAuth, store.schema, loadOld, "UpdateResource")
+	if err != nil {
+		return nil, status, err
+	}
+
+	resourceBase := resource.GetResourceBase()
+	stored, ok := store.resources[resourceBase.Id]
+	if !ok || stored.ownerId != resourceBase.OwnerId || (stored.deleted && !restoreDeleted) {
+		status, err := noRowsError(false, 0, versionToUpdate, resourceId, "UpdateResource")
+		return nil, status, err
+	}
+	if stored.version != versionToUpdate {
+		status, err := noRowsError(true, stored.version, versionToUpdate, resourceId, "UpdateResource")
+		return nil, status, err
+	}
+
+	store.resources[resourceBase.Id] = memoryResource{
+		ownerId: resourceBase.OwnerId,
+		version: resourceBase.Version,
+		deleted: resourceBase.Deleted,
+		data:    jsonResource,
+	}
+	store.appendJournalLocked(jsonResource, resourceBase.UpdatedAt)
+
+	return resource, constants.RESOURCE_OK_CODE, nil
+}
+
+// DeleteResource soft-deletes a resource and writes a tombstone copy of it to the journal
+func (store *MemoryResourceStore[R]) DeleteResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error) {
+	return store.setDeleted(ctx, ownerId, resourceId, expectedVersion, true, extractedAuth)
+}
+
+// UndeleteResource restores a resource previously removed with DeleteResource
+func (store *MemoryResourceStore[R]) UndeleteResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error) {
+	return store.setDeleted(ctx, ownerId, resourceId, expectedVersion, false, extractedAuth)
+}
+
+// PatchResource applies a merge patch or a JSON patch to the stored version of a resource (see the postgres store)
+func (store *MemoryResourceStore[R]) PatchResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, patch []byte, patchType PatchType, extractedAuth string) (IResource, int, error) {
+	return patchResource(ctx, store, ownerId, resourceId, expectedVersion, patch, patchType, extractedAuth)
+}
+
+func (store *MemoryResourceStore[R]) setDeleted(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, deleted bool, extractedAuth string) (IResource, int, error) {
+	return store.write(ctx, setDeletedMethodName(deleted), func() (IResource, int, error) {
+		return store.setDeletedLocked(ownerId, resourceId, expectedVersion, deleted, extractedAuth)
+	})
+}
+
+// SetSchema makes the store check resources against schema before they are created or updated (nil turns the
+// check off)
+func (store *MemoryResourceStore[R]) SetSchema(schema *JsonSchema) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.schema = schema
+}
+
+func (store *MemoryResourceStore[R]) setDeletedLocked(ownerId string, resourceId string, expectedVersion uint, deleted bool, extractedAuth string) (IResource, int, error) {
+	methodName := setDeletedMethodName(deleted)
+
+	patch, jsonPatch, status, err := prepareSetDeleted(expectedVersion, deleted, extractedAuth, methodName)
+	if err != nil {
+		return nil, status, err
+	}
+
+	stored, ok := store.resources[resourceId]
+	if !ok || stored.ownerId != ownerId {
+		status, err := noRowsError(false, 0, expectedVersion, resourceId, methodName)
+		return nil, status, err
+	}
+	if stored.version != expectedVersion || stored.deleted == deleted {
+		status, err := noRowsError(true, stored.version, expectedVersion, resourceId, methodName)
+		return nil, status, err
+	}
+
+	// the equivalent of the jsonb || merge done by the postgres store
+	var merged map[string]json.RawMessage
+	var patchFields map[string]json.RawMessage
+	if err := json.Unmarshal(stored.data, &merged); err != nil {
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in %s: %w", methodName, err))
+	}
+	if err := json.Unmarshal(jsonPatch, &patchFields); err != nil {
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in %s: %w", methodName, err))
+	}
+	maps.Copy(merged, patchFields)
+	resourceData, err := json.Marshal(merged)
+	if err != nil {
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error serializing resource in %s: %w", methodName, err))
+	}
+
+	resource := new(R)
+	if err := json.Unmarshal(resourceData, resource); err != nil {
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in %s: %w", methodName, err))
+	}
+
+	store.resources[resourceId] = memoryResource{
+		ownerId: stored.ownerId,
+		version: patch.Version,
+		deleted: deleted,
+		data:    resourceData,
+	}
+	store.appendJournalLocked(resourceData, patch.UpdatedAt)
+
+	return any(resource).(IResource), constants.RESOURCE_OK_CODE, nil
+}
+
+func (store *MemoryResourceStore[R]) appendJournalLocked(resourceData []byte, updatedAt time.Time) {
+	store.clock++
+	store.journal = append(store.journal, ResourceJournalEntry{
+		Clock:         store.clock,
+		Resource:      json.RawMessage(resourceData),
+		UpdatedAt:     updatedAt,
+		PartitionName: store.journalPartitionName,
+	})
+
+	// subscribers can't read the new entry until the lock is released, so a rolled back InTx is never seen
+	for wake := range store.subscribers {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SubscribeJournal streams the journal entries of the store's partition from fromClock onwards - first those
+// already written (an imported journal may hold other partitions too) and then new ones as they are added. The
+// channel is closed when ctx is cancelled or the store is closed.
+func (store *MemoryResourceStore[R]) SubscribeJournal(ctx context.Context, fromClock uint64) <-chan ResourceJournalEntry {
+	entries := make(chan ResourceJournalEntry)
+	wake := make(chan struct{}, 1)
+
+	store.mutex.Lock()
+	if store.closed {
+		store.mutex.Unlock()
+		close(entries)
+		return entries
+	}
+	store.subscribers[wake] = struct{}{}
+	store.mutex.Unlock()
+
+	go func() {
+		defer close(entries)
+		defer func() {
+			store.mutex.Lock()
+			delete(store.subscribers, wake)
+			store.mutex.Unlock()
+		}()
+
+		nextClock := fromClock
+		for {
+			store.mutex.RLock()
+			start := sort.Search(len(store.journal), func(i int) bool {
+				return store.journal[i].Clock >= nextClock
+			})
+			pending := slices.Clone(store.journal[start:])
+			store.mutex.RUnlock()
+
+			for _, entry := range pending {
+				if entry.PartitionName != store.journalPartitionName {
+					nextClock = entry.Clock + 1
+					continue
+				}
+				select {
+				case entries <- entry:
+					nextClock = entry.Clock + 1
+				case <-ctx.Done():
+					return
+				case <-store.done:
+					return
+				}
+			}
+
+			select {
+			case <-wake:
+			case <-ctx.Done():
+				return
+			case <-store.done:
+				return
+			}
+		}
+	}()
+
+	return entries
+}
+
+func (store *MemoryResourceStore[R]) sortedIdsLocked() []string {
+	ids := make([]string, 0, len(store.resources))
+	for id := range store.resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ExportJournal writes the journal entries in journalRange to w as NDJSON and returns the number written
+func (store *MemoryResourceStore[R]) ExportJournal(ctx context.Context, journalRange JournalRange, w io.Writer) (int64, error) {
+	store.mutex.RLock()
+	if err := store.checkAvailable(ctx, "ExportJournal"); err != nil {
+		store.mutex.RUnlock()
+		return 0, err
+	}
+	journal := slices.Clone(store.journal) // so that a slow writer doesn't hold the lock
+	store.mutex.RUnlock()
+
+	encoder := json.NewEncoder(w)
+	var count int64
+	for _, entry := range journal {
+		if entry.Clock < journalRange.FromClock || (journalRange.ToClock != 0 && entry.Clock > journalRange.ToClock) {
+			continue
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return count, fmt.Errorf("resource store - error writing journal entry %d in ExportJournal: %w", entry.Clock, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ImportJournal loads an export into the store, which must be empty, and rebuilds the resources from it. The
+// entries keep their clocks and partitions.
+func (store *MemoryResourceStore[R]) ImportJournal(ctx context.Context, r io.Reader) (int64, error) {
+	var journal []ResourceJournalEntry
+	err := decodeJournalExport(r, func(entry ResourceJournalEntry, resourceBase *ResourceBase) error {
+		journal = append(journal, entry)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.checkAvailable(ctx, "ImportJournal"); err != nil {
+		return 0, err
+	}
+	if len(store.journal) > 0 || len(store.resources) > 0 {
+		return 0, conflictError("resource store - ImportJournal needs an empty store")
+	}
+
+	store.journal = journal
+	if len(journal) > 0 {
+		store.clock = journal[len(journal)-1].Clock
+	}
+	store.rebuildResourcesLocked()
+	return int64(len(journal)), nil
+}
+
+// RebuildResources replaces the resources with the latest journal entry of each and returns the number written.
+// It refuses to run once delete retention has trimmed the journal.
+func (store *MemoryResourceStore[R]) RebuildResources(ctx context.Context) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.checkAvailable(ctx, "RebuildResources"); err != nil {
+		return 0, err
+	}
+	if store.minClock > 0 {
+		return 0, conflictError("resource store - RebuildResources can't replay a journal that delete retention has trimmed")
+	}
+
+	return store.rebuildResourcesLocked(), nil
+}
+
+func (store *MemoryResourceStore[R]) rebuildResourcesLocked() int64 {
+	store.resources = make(map[string]memoryResource)
+	for _, snapshot := range store.journalSnapshotsLocked() {
+		store.resources[snapshot.base.Id] = memoryResource{
+			ownerId: snapshot.base.OwnerId,
+			version: snapshot.base.Version,
+			deleted: snapshot.base.Deleted,
+			data:    snapshot.entry.Resource,
+		}
+	}
+	return int64(len(store.resources))
+}
+
+// CheckConsistency compares every resource with the latest journal entry of its id and returns those that don't
+// match, ordered by id
+func (store *MemoryResourceStore[R]) CheckConsistency(ctx context.Context, inconsistencies *[]ResourceInconsistency) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if err := store.checkAvailable(ctx, "CheckConsistency"); err != nil {
+		return err
+	}
+
+	latest := map[string]memorySnapshot{}
+	for _, snapshot := range store.journalSnapshotsLocked() {
+		latest[snapshot.base.Id] = snapshot
+	}
+	ids := slices.Collect(maps.Keys(latest))
+	for id := range store.resources {
+		if _, ok := latest[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		stored, isStored := store.resources[id]
+		snapshot, isJournaled := latest[id]
+		inconsistency := ResourceInconsistency{Id: id, OwnerId: stored.ownerId, ResourceVersion: stored.version}
+		switch {
+		case !isJournaled:
+			inconsistency.Kind = INCONSISTENCY_NOT_JOURNALED
+		case !isStored:
+			inconsistency.Kind = INCONSISTENCY_MISSING
+			inconsistency.OwnerId = snapshot.base.OwnerId
+		case stored.version != snapshot.base.Version:
+			inconsistency.Kind = INCONSISTENCY_VERSION_MISMATCH
+		case !jsonDocumentsEqual(stored.data, snapshot.entry.Resource):
+			inconsistency.Kind = INCONSISTENCY_RESOURCE_MISMATCH
+		default:
+			continue
+		}
+		if isJournaled {
+			inconsistency.JournalVersion = snapshot.base.Version
+			inconsistency.JournalClock = snapshot.entry.Clock
+		}
+		*inconsistencies = append(*inconsistencies, inconsistency)
+	}
+	return nil
+}
+
+// jsonDocumentsEqual compares two JSON documents the way postgres compares jsonb
+func jsonDocumentsEqual(a []byte, b []byte) bool {
+	var valueA, valueB any
+	decoderA := json.NewDecoder(bytes.NewReader(a))
+	decoderA.UseNumber()
+	decoderB := json.NewDecoder(bytes.NewReader(b))
+	decoderB.UseNumber()
+	if decoderA.Decode(&valueA) != nil || decoderB.Decode(&valueB) != nil {
+		return false
+	}
+	return jsonValuesEqual(valueA, valueB)
+}
+
+// HealthCheck reports whether the store is still open
+func (store *MemoryResourceStore[R]) HealthCheck(ctx context.Context) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.checkAvailable(ctx, "HealthCheck")
+}
+
+// Close marks the store as closed - every call after this fails as it would against a closed pool
+func (store *MemoryResourceStore[R]) Close(ctx context.Context) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if !store.closed {
+		store.closed = true
+		close(store.done)
+	}
+	store.logger.Info("resource store - memory store closed")
+	return nil
+}
+
+type memoryResourceTx[R any] struct {
+	store      *MemoryResourceStore[R]
+	ctx        context.Context
+	written    []IResource // for their AfterCommit hooks
+	failStatus int
+	failErr    error
+}
+
+// InTx runs fn as a single unit of work. The store is locked for the duration of fn so the journal entries
+// of the unit of work get contiguous clocks, and every change is undone if fn or any operation fails.
+func (store *MemoryResourceStore[R]) InTx(ctx context.Context, fn func(tx ResourceTx[R]) error) (int, error) {
+	resourceTx := &memoryResourceTx[R]{store: store, ctx: ctx}
+	status, err := store.inTx(ctx, resourceTx, fn)
+	if err != nil {
+		return status, err
+	}
+
+	// the store is unlocked by now, so the hooks can use it
+	afterCommit(ctx, resourceTx.written...)
+	return status, nil
+}
+
+func (store *MemoryResourceStore[R]) inTx(ctx context.Context, resourceTx *memoryResourceTx[R], fn func(tx ResourceTx[R]) error) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.checkAvailable(ctx, "InTx"); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+
+	savedResources := maps.Clone(store.resources)
+	savedJournalLength := len(store.journal)
+	savedClock := store.clock
+	rollback := func() {
+		store.resources = savedResources
+		store.journal = store.journal[:savedJournalLength]
+		store.clock = savedClock
+	}
+
+	if err := fn(resourceTx); err != nil {
+		rollback()
+		if resourceTx.failErr != nil {
+			return resourceTx.failStatus, resourceTx.failErr
+		}
+		// an error of fn's own keeps its status when it is a ResourceError (e.g. from tx.GetById)
+		return statusOf(err, constants.RESOURCE_BAD_REQUEST_CODE), err
+	}
+	if resourceTx.failErr != nil {
+		rollback()
+		return resourceTx.failStatus, resourceTx.failErr
+	}
+	if err := ctx.Err(); err != nil {
+		rollback()
+		store.logger.Error("resource store - error committing transaction in InTx: ", err)
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// fail records the first failure in the unit of work so that InTx rolls back regardless of what fn returns
+func (t *memoryResourceTx[R]) fail(status int, err error) (IResource, int, error) {
+	if t.failErr == nil {
+		t.failStatus = status
+		t.failErr = err
+	}
+	return nil, status, err
+}
+
+func (t *memoryResourceTx[R]) failedAlready() (IResource, int, error) {
+	return nil, t.failStatus, fmt.Errorf("resource store - transaction already failed: %w", t.failErr)
+}
+
+func (t *memoryResourceTx[R]) GetById(ownerId string, id string, resource *R) (int, error) {
+	if t.failErr != nil {
+		_, status, err := t.failedAlready()
+		return status, err
+	}
+	// not found is an answer, not a failure of the unit of work
+	status, err := t.store.getByIdLocked(ownerId, id, false, resource)
+	if status == constants.RESOURCE_INTERNAL_ERROR_CODE {
+		_, status, err = t.fail(status, err)
+	}
+	return status, err
+}
+
+func (t *memoryResourceTx[R]) CreateResource(resource IResource, extractedAuth string) (IResource, int, error) {
+	if t.failErr != nil {
+		return t.failedAlready()
+	}
+	createdResource, status, err := t.store.createLocked(t.ctx, resource, extractedAuth)
+	if err != nil {
+		return t.fail(status, err)
+	}
+	t.written = append(t.written, createdResource)
+	return createdResource, status, nil
+}
+
+func (t *memoryResourceTx[R]) UpdateResource(resource IResource, ownerId string, resourceId string, extractedAuth string) (IResource, int, error) {
+	if t.failErr != nil {
+		return t.failedAlready()
+	}
+	updatedResource, status, err := t.store.updateLocked(t.ctx, resource, ownerId, resourceId, extractedAuth, false)
+	if err != nil {
+		return t.fail(status, err)
+	}
+	t.written = append(t.written, updatedResource)
+	return updatedResource, status, nil
+}
+
+func (t *memoryResourceTx[R]) DeleteResource(ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error) {
+	return t.setDeleted(ownerId, resourceId, expectedVersion, true, extractedAuth)
+}
+
+func (t *memoryResourceTx[R]) UndeleteResource(ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error) {
+	return t.setDeleted(ownerId, resourceId, expectedVersion, false, extractedAuth)
+}
+
+func (t *memoryResourceTx[R]) setDeleted(ownerId string, resourceId string, expectedVersion uint, deleted bool, extractedAuth string) (IResource, int, error) {
+	if t.failErr != nil {
+		return t.failedAlready()
+	}
+	resource, status, err := t.store.setDeletedLocked(ownerId, resourceId, expectedVersion, deleted, extractedAuth)
+	if err != nil {
+		return t.fail(status, err)
+	}
+	t.written = append(t.written, resource)
+	return resource, status, nil
+}
@@ -0,0 +1,215 @@
+package resourceStore
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+// The schema of the resource store is kept as ordered migrations in migrations/NNNN_description.sql. Each file is
+// a text/template rendered with the store's TableNames (see migrationTables) so that the same migrations can build
+// the tables of every noun. Applied versions are recorded per resources table in the "SchemaMigrations" table of the
+// store's schema. Never edit a migration once it has been released - add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockName identifies the advisory lock that serializes migrations across all the service instances (and
+// all the stores) that share a database
+const migrationLockName = "siftd-base resource store migrations"
+
+type migration struct {
+	version int
+	name    string
+	sql     *template.Template
+}
+
+// migrationTables is the data the migration templates are rendered with
+type migrationTables struct {
+	Schema        string // quoted, ready to be used in SQL text
+	Resources     string // schema-qualified and quoted
+	Journal       string // schema-qualified and quoted
+	SchemaName    string // unquoted, e.g. for use in constraint and index names or with the literal function
+	ResourcesName string
+	JournalName   string
+}
+
+var migrationFuncs = template.FuncMap{
+	"ident":   func(name string) string { return pgx.Identifier{name}.Sanitize() },
+	"literal": func(value string) string { return "'" + strings.ReplaceAll(value, "'", "''") + "'" },
+}
+
+// loadMigrations parses the embedded migrations in version order. The versions must start at 1 and have no gaps.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("resource store - unable to read the embedded migrations: %w", err)
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("resource store - the migration %s does not start with a version number", entry.Name())
+		}
+		text, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("resource store - unable to read the migration %s: %w", entry.Name(), err)
+		}
+		sql, err := template.New(entry.Name()).Funcs(migrationFuncs).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("resource store - unable to parse the migration %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: sql})
+	}
+
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("resource store - no migrations are embedded")
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("resource store - expected migration version %d but found %s", i+1, m.name)
+		}
+	}
+	return migrations, nil
+}
+
+// LatestSchemaVersion is the version of the newest migration embedded in this binary
+func LatestSchemaVersion() int {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// Migrate applies the migrations the store's tables are missing. NewPostgresResourceStoreWithJournal calls it at
+// startup unless DB_SKIP_MIGRATIONS is set, in which case it can be called explicitly (e.g. from a deployment job).
+// It fails without changing anything if the database has a newer schema than this binary.
+func (store *PostgresResourceStoreWithJournal[R]) Migrate(ctx context.Context) error {
+	_, err := migrateSchema(ctx, store.dbPool, store.tables, store.logger, true)
+	return err
+}
+
+// SchemaVersion returns the latest migration applied to the store's tables (0 if none have been)
+func (store *PostgresResourceStoreWithJournal[R]) SchemaVersion(ctx context.Context) (int, error) {
+	return migrateSchema(ctx, store.dbPool, store.tables, store.logger, false)
+}
+
+// migrateSchema applies the pending migrations (if apply is set) in a single transaction, so a failure leaves the
+// schema as it was. It returns the schema version of the database afterwards.
+func migrateSchema(ctx context.Context, dbPool *pgxpool.Pool, tables TableNames, logger *logrus.Logger, apply bool) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	latest := migrations[len(migrations)-1].version
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		logger.Error("resource store - error detected starting the migration transaction: ", err)
+		return 0, fmt.Errorf("resource store - unable to start the migration transaction: %w", err)
+	}
+	defer tx.Rollback(context.Background()) // no-op once committed
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext(@lockName));`, pgx.NamedArgs{"lockName": migrationLockName}); err != nil {
+		return 0, fmt.Errorf("resource store - unable to take the migration lock: %w", err)
+	}
+
+	migrationsTable := pgx.Identifier{tables.Schema, "SchemaMigrations"}.Sanitize()
+	var migrationsTableExists bool
+	if err := tx.QueryRow(ctx, `SELECT to_regclass(@table) IS NOT NULL;`, pgx.NamedArgs{"table": migrationsTable}).Scan(&migrationsTableExists); err != nil {
+		return 0, fmt.Errorf("resource store - unable to look up the migrations table: %w", err)
+	}
+	if !migrationsTableExists {
+		if !apply {
+			return 0, nil
+		}
+		if err := createMigrationsTable(ctx, tx, tables.Schema, migrationsTable); err != nil {
+			return 0, err
+		}
+	}
+
+	var current int
+	currentQuery := fmt.Sprintf(`SELECT COALESCE(MAX("Version"), 0) FROM %s WHERE "Scope" = @scope;`, migrationsTable)
+	if err := tx.QueryRow(ctx, currentQuery, pgx.NamedArgs{"scope": tables.Resources}).Scan(&current); err != nil {
+		return 0, fmt.Errorf("resource store - unable to read the applied migrations: %w", err)
+	}
+	if current > latest {
+		return current, fmt.Errorf("resource store - the schema of table %s is at version %d but this binary only knows up to version %d - refusing to start",
+			tables.Resources, current, latest)
+	}
+	if !apply || current == latest {
+		return current, tx.Commit(ctx)
+	}
+
+	data := migrationTables{
+		Schema:        pgx.Identifier{tables.Schema}.Sanitize(),
+		Resources:     pgx.Identifier{tables.Schema, tables.Resources}.Sanitize(),
+		Journal:       pgx.Identifier{tables.Schema, tables.Journal}.Sanitize(),
+		SchemaName:    tables.Schema,
+		ResourcesName: tables.Resources,
+		JournalName:   tables.Journal,
+	}
+	recordQuery := fmt.Sprintf(`INSERT INTO %s ("Scope", "Version", "Name", "AppliedAt") VALUES (@scope, @version, @name, @appliedAt);`, migrationsTable)
+	for _, m := range migrations[current:] {
+		var sql strings.Builder
+		if err := m.sql.Execute(&sql, data); err != nil {
+			return current, fmt.Errorf("resource store - unable to render migration %s: %w", m.name, err)
+		}
+		if _, err := tx.Exec(ctx, sql.String()); err != nil {
+			return current, fmt.Errorf("resource store - migration %s failed: %w", m.name, err)
+		}
+		args := pgx.NamedArgs{"scope": tables.Resources, "version": m.version, "name": m.name, "appliedAt": time.Now().UTC()}
+		if _, err := tx.Exec(ctx, recordQuery, args); err != nil {
+			return current, fmt.Errorf("resource store - unable to record migration %s: %w", m.name, err)
+		}
+		logger.Infof("resource store - applied migration %s to table %s", m.name, tables.Resources)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return current, fmt.Errorf("resource store - unable to commit the migrations: %w", err)
+	}
+	return latest, nil
+}
+
+// createMigrationsTable creates the table that records the applied migrations (and its schema if need be)
+func createMigrationsTable(ctx context.Context, tx pgx.Tx, schema string, migrationsTable string) error {
+	var schemaExists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = @schema);`, pgx.NamedArgs{"schema": schema}).Scan(&schemaExists); err != nil {
+		return fmt.Errorf("resource store - unable to look up schema %s: %w", schema, err)
+	}
+	if !schemaExists {
+		if _, err := tx.Exec(ctx, "CREATE SCHEMA "+pgx.Identifier{schema}.Sanitize()+";"); err != nil {
+			return fmt.Errorf("resource store - unable to create schema %s: %w", schema, err)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE %s (
+			"Scope" varchar(63) not null,
+			"Version" integer not null,
+			"Name" varchar(200) not null,
+			"AppliedAt" timestamp without time zone not null,
+			constraint "PK_SchemaMigrations" primary key ("Scope", "Version")
+		);
+	`, migrationsTable)
+	if _, err := tx.Exec(ctx, query); err != nil {
+		return fmt.Errorf("resource store - unable to create the migrations table: %w", err)
+	}
+	return nil
+}
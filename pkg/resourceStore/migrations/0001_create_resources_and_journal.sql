@@ -0,0 +1,20 @@
+-- the resources and the journal of their changes (a no-op for databases created by the original psql script)
+create table if not exists {{.Journal}} (
+	"Clock" bigint not null generated by default as identity,
+	"Resource" text null,
+	"UpdatedAt" timestamp without time zone not null,
+	"PartitionName" varchar(20) null,
+	constraint {{ident (print "PK_" .JournalName)}} primary key ("Clock", "PartitionName")
+);
+
+create table if not exists {{.Resources}} (
+	"Id" varchar(50) not null,
+	"OwnerId" varchar(50) not null,
+	"Version" integer not null,
+	"UpdatedAt" timestamp without time zone not null,
+	"Deleted" boolean not null,
+	"Resource" jsonb null,
+	constraint {{ident (print "PK_" .ResourcesName)}} primary key ("Id")
+);
+
+create index if not exists {{ident (print "IX_" .ResourcesName "_OwnerId")}} on {{.Resources}} ("OwnerId");
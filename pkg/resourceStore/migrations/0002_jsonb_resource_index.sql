@@ -0,0 +1,18 @@
+-- the field filters of QueryByOwnerId need "Resource" to be jsonb (databases created before it was are converted)
+do $migration$
+begin
+	if exists (
+		select 1
+		from information_schema.columns
+		where table_schema = {{literal .SchemaName}}
+			and table_name = {{literal .ResourcesName}}
+			and column_name = 'Resource'
+			and data_type <> 'jsonb'
+	) then
+		alter table {{.Resources}} alter column "Resource" type jsonb using "Resource"::jsonb;
+	end if;
+end
+$migration$;
+
+-- supports the field filters of QueryByOwnerId (jsonb containment)
+create index if not exists {{ident (print "IX_" .ResourcesName "_Resource")}} on {{.Resources}} using gin ("Resource" jsonb_path_ops);
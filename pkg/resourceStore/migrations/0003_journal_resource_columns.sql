@@ -0,0 +1,15 @@
+-- the id, owner and version of each journaled snapshot, so the history of a resource can be read from the journal
+alter table {{.Journal}}
+	add column if not exists "Id" varchar(50) null,
+	add column if not exists "OwnerId" varchar(50) null,
+	add column if not exists "Version" integer null;
+
+update {{.Journal}}
+set
+	"Id" = "Resource"::jsonb ->> 'id',
+	"OwnerId" = "Resource"::jsonb ->> 'ownerId',
+	"Version" = ("Resource"::jsonb ->> 'version')::integer
+where "Id" is null;
+
+-- serves GetHistory, GetVersion and GetByIdAsOf (the versions of a resource are in clock order)
+create index if not exists {{ident (print "IX_" .JournalName "_Resource")}} on {{.Journal}} ("OwnerId", "Id", "Clock");
@@ -0,0 +1,8 @@
+-- the lowest clock that each journal partition can still be read from, once retention has deleted older entries
+create table if not exists {{.Schema}}."JournalWatermarks" (
+	"Journal" varchar(200) not null,
+	"PartitionName" varchar(20) not null,
+	"MinClock" bigint not null,
+	"UpdatedAt" timestamp without time zone not null,
+	constraint "PK_JournalWatermarks" primary key ("Journal", "PartitionName")
+);
@@ -0,0 +1,2 @@
+-- serves the partition-filtered journal reads and the per-partition max clocks
+create index if not exists {{ident (print "IX_" .JournalName "_Partition")}} on {{.Journal}} ("PartitionName", "Clock");
@@ -0,0 +1,8 @@
+-- the checkpoints of the journal followers (journalClient.PostgresCheckpointStore) and webhook subscriptions that
+-- use this schema (a no-op for databases whose checkpoints table was created by the original psql script)
+create table if not exists {{.Schema}}."JournalCheckpoints" (
+	"Name" varchar(100) not null,
+	"Clock" bigint not null,
+	"UpdatedAt" timestamp without time zone not null,
+	constraint "PK_JournalCheckpoints" primary key ("Name")
+);
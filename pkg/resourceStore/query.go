@@ -0,0 +1,241 @@
+package resourceStore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type QueryOperator string
+
+const (
+	QUERY_OP_EQ     QueryOperator = "eq"     // field equals the single value
+	QUERY_OP_IN     QueryOperator = "in"     // field equals any of the values
+	QUERY_OP_RANGE  QueryOperator = "range"  // field is between the two values (inclusive) - a nil value leaves that side open
+	QUERY_OP_PREFIX QueryOperator = "prefix" // string field starts with the single value
+)
+
+const (
+	QUERY_DEFAULT_LIMIT = 100
+	QUERY_MAX_LIMIT     = 1000
+)
+
+// QueryFilter is a predicate on a field of the resource JSON. Field is a dotted path using the JSON
+// names of the fields (e.g. "employee.age"), and Values are JSON-compatible values (string, float64, bool, nil).
+type QueryFilter struct {
+	Field  string
+	Op     QueryOperator
+	Values []any
+}
+
+type QuerySortKey struct {
+	Field      string
+	Descending bool
+}
+
+// ResourceQuery describes a filtered, sorted page of resources. Results are always ordered by the sort keys
+// followed by the resource id, which makes the ordering total so that Cursor (as returned by the previous
+// page) can resume exactly where that page stopped.
+type ResourceQuery struct {
+	Filters []QueryFilter
+	Sort    []QuerySortKey
+	Limit   int
+	Cursor  string
+}
+
+// queryCursor is the decoded form of ResourceQuery.Cursor - the sort values (as JSON text) and id of the
+// last resource returned, plus the sort fields so a cursor can't be replayed against a different ordering
+type queryCursor struct {
+	SortFields []string `json:"s"`
+	Values     []string `json:"v"`
+	Id         string   `json:"id"`
+}
+
+// Validate checks the query for errors a caller can fix and fills in the default limit
+func (q *ResourceQuery) Validate() error {
+	if q.Limit == 0 {
+		q.Limit = QUERY_DEFAULT_LIMIT
+	}
+	if q.Limit < 0 || q.Limit > QUERY_MAX_LIMIT {
+		return fmt.Errorf("resource query - limit must be between 1 and %d", QUERY_MAX_LIMIT)
+	}
+
+	for _, filter := range q.Filters {
+		if _, err := fieldPath(filter.Field); err != nil {
+			return err
+		}
+		switch filter.Op {
+		case QUERY_OP_EQ:
+			if len(filter.Values) != 1 {
+				return fmt.Errorf("resource query - the '%s' filter on '%s' requires exactly one value", filter.Op, filter.Field)
+			}
+		case QUERY_OP_IN:
+			if len(filter.Values) == 0 {
+				return fmt.Errorf("resource query - the '%s' filter on '%s' requires at least one value", filter.Op, filter.Field)
+			}
+		case QUERY_OP_RANGE:
+			if len(filter.Values) != 2 || (filter.Values[0] == nil && filter.Values[1] == nil) {
+				return fmt.Errorf("resource query - the '%s' filter on '%s' requires a lower and/or upper bound", filter.Op, filter.Field)
+			}
+		case QUERY_OP_PREFIX:
+			if len(filter.Values) != 1 {
+				return fmt.Errorf("resource query - the '%s' filter on '%s' requires exactly one value", filter.Op, filter.Field)
+			}
+			if _, ok := filter.Values[0].(string); !ok {
+				return fmt.Errorf("resource query - the '%s' filter on '%s' requires a string value", filter.Op, filter.Field)
+			}
+		default:
+			return fmt.Errorf("resource query - unsupported filter operator '%s'", filter.Op)
+		}
+	}
+
+	for _, sortKey := range q.Sort {
+		if _, err := fieldPath(sortKey.Field); err != nil {
+			return err
+		}
+	}
+
+	if q.Cursor != "" {
+		if _, err := q.decodeCursor(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (q *ResourceQuery) sortFields() []string {
+	fields := make([]string, 0, len(q.Sort))
+	for _, sortKey := range q.Sort {
+		if sortKey.Descending {
+			fields = append(fields, "-"+sortKey.Field)
+		} else {
+			fields = append(fields, sortKey.Field)
+		}
+	}
+	return fields
+}
+
+func (q *ResourceQuery) encodeCursor(sortValues []string, id string) string {
+	jsonCursor, _ := json.Marshal(queryCursor{SortFields: q.sortFields(), Values: sortValues, Id: id})
+	return base64.RawURLEncoding.EncodeToString(jsonCursor)
+}
+
+func (q *ResourceQuery) decodeCursor() (*queryCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	jsonCursor, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("resource query - invalid cursor")
+	}
+	var cursor queryCursor
+	if err := json.Unmarshal(jsonCursor, &cursor); err != nil {
+		return nil, fmt.Errorf("resource query - invalid cursor")
+	}
+	if !slices.Equal(cursor.SortFields, q.sortFields()) || len(cursor.Values) != len(q.Sort) {
+		return nil, fmt.Errorf("resource query - the cursor was created for a different sort order")
+	}
+
+	return &cursor, nil
+}
+
+// fieldPath splits a dotted field name into the path used to address it in the resource JSON
+func fieldPath(field string) ([]string, error) {
+	if field == "" {
+		return nil, fmt.Errorf("resource query - a field name is required")
+	}
+	path := strings.Split(field, ".")
+	for _, segment := range path {
+		if segment == "" {
+			return nil, fmt.Errorf("resource query - invalid field name '%s'", field)
+		}
+	}
+	return path, nil
+}
+
+// ParseResourceQuery builds a ResourceQuery from the standard query parameters used by the noun routers:
+//
+//	filter=<field>:<op>:<value>[,<value>]   (repeatable) e.g. filter=employee.age:range:30,40
+//	sort=<field>[,-<field>]                 a leading '-' sorts descending e.g. sort=-employee.age,employee.name
+//	limit=<n>                               page size (default 100, max 1000)
+//	cursor=<cursor>                         the X-Next-Cursor returned with the previous page
+//
+// Filter values are read as JSON when they parse as JSON (numbers, true, false, null, "quoted strings")
+// and as plain strings otherwise. An empty range bound leaves that side open (e.g. employee.age:range:30,).
+func ParseResourceQuery(params url.Values) (ResourceQuery, error) {
+	var query ResourceQuery
+
+	for _, filterParam := range params["filter"] {
+		parts := strings.SplitN(filterParam, ":", 3)
+		if len(parts) != 3 {
+			return query, fmt.Errorf("resource query - invalid filter '%s', expected <field>:<op>:<value>", filterParam)
+		}
+
+		filter := QueryFilter{Field: parts[0], Op: QueryOperator(parts[1])}
+		switch filter.Op {
+		case QUERY_OP_EQ, QUERY_OP_PREFIX:
+			filter.Values = []any{parseQueryValue(parts[2])}
+		case QUERY_OP_IN:
+			for _, value := range strings.Split(parts[2], ",") {
+				filter.Values = append(filter.Values, parseQueryValue(value))
+			}
+		case QUERY_OP_RANGE:
+			bounds := strings.Split(parts[2], ",")
+			if len(bounds) != 2 {
+				return query, fmt.Errorf("resource query - invalid range filter '%s', expected <field>:range:<from>,<to>", filterParam)
+			}
+			filter.Values = make([]any, 2)
+			for i, bound := range bounds {
+				if bound != "" {
+					filter.Values[i] = parseQueryValue(bound)
+				}
+			}
+		}
+		if filter.Op == QUERY_OP_PREFIX {
+			// a prefix is always a string, even if it looks like a number
+			filter.Values = []any{parts[2]}
+		}
+		query.Filters = append(query.Filters, filter)
+	}
+
+	if sortParam := params.Get("sort"); sortParam != "" {
+		for _, field := range strings.Split(sortParam, ",") {
+			if strings.HasPrefix(field, "-") {
+				query.Sort = append(query.Sort, QuerySortKey{Field: field[1:], Descending: true})
+			} else {
+				query.Sort = append(query.Sort, QuerySortKey{Field: field})
+			}
+		}
+	}
+
+	if limitParam := params.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("resource query - invalid limit '%s'", limitParam)
+		}
+		query.Limit = limit
+	}
+
+	query.Cursor = params.Get("cursor")
+
+	return query, query.Validate()
+}
+
+// HasResourceQueryParams reports whether any of the parameters read by ParseResourceQuery are present
+func HasResourceQueryParams(params url.Values) bool {
+	return params.Has("filter") || params.Has("sort") || params.Has("limit") || params.Has("cursor")
+}
+
+func parseQueryValue(value string) any {
+	var parsed any
+	if err := json.Unmarshal([]byte(value), &parsed); err == nil {
+		return parsed
+	}
+	return value
+}
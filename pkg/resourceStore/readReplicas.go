@@ -0,0 +1,223 @@
+package resourceStore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	REPLICA_DEFAULT_MAX_LAG        = 5 * time.Second
+	REPLICA_DEFAULT_CHECK_INTERVAL = 5 * time.Second
+)
+
+// ReplicaPolicy describes the read replicas of a PostgresResourceStoreWithJournal. When there are replicas,
+// GetById, GetByIdIncludingDeleted, GetByOwnerId, QueryByOwnerId and the journal reads go to a healthy replica
+// (round robin) and everything else goes to the primary. A replica is skipped while its last check failed or
+// showed it lagging the primary by more than MaxLag, and reads go to the primary when no replica is usable.
+// Use ReadFromPrimary for a read that must see the caller's own writes.
+type ReplicaPolicy struct {
+	ConnectStrings []string
+	MaxLag         time.Duration // defaults to 5s
+	CheckInterval  time.Duration // how often the replicas' health and lag are checked (defaults to 5s)
+}
+
+// ReplicaPolicyFromConfig reads DB_REPLICA_CONNECTSTRINGS (a comma separated list), DB_REPLICA_MAX_LAG and
+// DB_REPLICA_CHECK_INTERVAL
+func ReplicaPolicyFromConfig(configuration *viper.Viper) (ReplicaPolicy, error) {
+	var policy ReplicaPolicy
+	for _, connectString := range strings.Split(configuration.GetString(constants.DB_REPLICA_CONNECTION_STRINGS), ",") {
+		if connectString = strings.TrimSpace(connectString); connectString != "" {
+			policy.ConnectStrings = append(policy.ConnectStrings, connectString)
+		}
+	}
+
+	var err error
+	if maxLag := configuration.GetString(constants.DB_REPLICA_MAX_LAG); maxLag != "" {
+		if policy.MaxLag, err = time.ParseDuration(maxLag); err != nil {
+			return policy, fmt.Errorf("resource store - invalid %s: %w", constants.DB_REPLICA_MAX_LAG, err)
+		}
+	}
+	if interval := configuration.GetString(constants.DB_REPLICA_CHECK_INTERVAL); interval != "" {
+		if policy.CheckInterval, err = time.ParseDuration(interval); err != nil {
+			return policy, fmt.Errorf("resource store - invalid %s: %w", constants.DB_REPLICA_CHECK_INTERVAL, err)
+		}
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate defaults MaxLag and CheckInterval when they are 0 and rejects negative ones
+func (p *ReplicaPolicy) Validate() error {
+	if p.MaxLag == 0 {
+		p.MaxLag = REPLICA_DEFAULT_MAX_LAG
+	}
+	if p.CheckInterval == 0 {
+		p.CheckInterval = REPLICA_DEFAULT_CHECK_INTERVAL
+	}
+	if p.MaxLag < 0 || p.CheckInterval < 0 {
+		return errors.New("resource store - the replica max lag and check interval can't be negative")
+	}
+	return nil
+}
+
+// ReplicaStatus is the state of a read replica as of its last check
+type ReplicaStatus struct {
+	Host      string        `json:"host"`
+	Healthy   bool          `json:"healthy"` // reachable and within the max lag
+	Lag       time.Duration `json:"lag"`
+	CheckedAt time.Time     `json:"checkedAt"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type primaryReadKey struct{}
+
+// ReadFromPrimary returns a ctx whose reads skip the replicas, e.g. to read back a resource just written
+//
+//	store.GetById(resourceStore.ReadFromPrimary(ctx), ownerId, id, &resource)
+func ReadFromPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadKey{}, true)
+}
+
+// IsReadFromPrimary reports whether the reads made with ctx skip the replicas
+func IsReadFromPrimary(ctx context.Context) bool {
+	return ctx.Value(primaryReadKey{}) != nil
+}
+
+type replica struct {
+	dbPool *pgxpool.Pool
+	mu     sync.Mutex
+	status ReplicaStatus
+}
+
+type replicaSet struct {
+	policy   ReplicaPolicy
+	replicas []*replica
+	next     atomic.Uint64
+	logger   *logrus.Logger
+}
+
+// newReplicaSet wraps pools that open lazily, so a replica that is down when the service starts only counts as
+// unhealthy. Every replica starts unhealthy until its first check.
+func newReplicaSet(policy ReplicaPolicy, dbPools []*pgxpool.Pool, logger *logrus.Logger) *replicaSet {
+	set := &replicaSet{policy: policy, logger: logger}
+	for _, dbPool := range dbPools {
+		set.replicas = append(set.replicas, &replica{dbPool: dbPool, status: ReplicaStatus{Host: dbPool.Config().ConnConfig.Host}})
+	}
+	return set
+}
+
+// pick returns the pool of the next usable replica, or nil when the read should go to the primary
+func (set *replicaSet) pick(ctx context.Context) *pgxpool.Pool {
+	if set == nil || IsReadFromPrimary(ctx) {
+		return nil
+	}
+	start := set.next.Add(1)
+	for i := range set.replicas {
+		replica := set.replicas[(start+uint64(i))%uint64(len(set.replicas))]
+		replica.mu.Lock()
+		healthy := replica.status.Healthy
+		replica.mu.Unlock()
+		if healthy {
+			return replica.dbPool
+		}
+	}
+	return nil
+}
+
+// check measures the lag of every replica. A replica that has replayed everything it has received isn't
+// lagging, however long ago its last replayed transaction was.
+func (set *replicaSet) check(ctx context.Context, lagQuery string) {
+	for _, replica := range set.replicas {
+		// an unreachable replica can take a while to fail, so it mustn't hold up the next check
+		checkCtx, cancel := context.WithTimeout(ctx, set.policy.CheckInterval)
+		var lagSeconds float64
+		err := replica.dbPool.QueryRow(checkCtx, lagQuery).Scan(&lagSeconds)
+		cancel()
+
+		replica.mu.Lock()
+		wasHealthy := replica.status.Healthy
+		replica.status.CheckedAt = time.Now()
+		replica.status.Error = ""
+		if err != nil {
+			replica.status.Healthy = false
+			replica.status.Error = err.Error()
+		} else {
+			replica.status.Lag = time.Duration(lagSeconds * float64(time.Second))
+			replica.status.Healthy = replica.status.Lag <= set.policy.MaxLag
+		}
+		status := replica.status
+		replica.mu.Unlock()
+
+		if wasHealthy && !status.Healthy {
+			set.logger.Infof("resource store - read replica %s is unusable (lag %v, error '%s') - its reads go to the primary", status.Host, status.Lag, status.Error)
+		} else if !wasHealthy && status.Healthy {
+			set.logger.Infof("resource store - read replica %s is usable (lag %v)", status.Host, status.Lag)
+		}
+	}
+}
+
+// monitor checks the replicas every CheckInterval until ctx is done
+func (set *replicaSet) monitor(ctx context.Context, lagQuery string) {
+	ticker := time.NewTicker(set.policy.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			set.check(ctx, lagQuery)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (set *replicaSet) statuses() []ReplicaStatus {
+	statuses := []ReplicaStatus{}
+	if set == nil {
+		return statuses
+	}
+	for _, replica := range set.replicas {
+		replica.mu.Lock()
+		statuses = append(statuses, replica.status)
+		replica.mu.Unlock()
+	}
+	return statuses
+}
+
+func (set *replicaSet) close() {
+	if set == nil {
+		return
+	}
+	for _, replica := range set.replicas {
+		replica.dbPool.Close()
+	}
+}
+
+// readPool is the pool a replica-eligible read runs on
+func (store *PostgresResourceStoreWithJournal[R]) readPool(ctx context.Context) *pgxpool.Pool {
+	if dbPool := store.replicas.pick(ctx); dbPool != nil {
+		return dbPool
+	}
+	return store.dbPool
+}
+
+// ReplicaStatuses reports the read replicas as of their last check (empty when the store has none)
+func (store *PostgresResourceStoreWithJournal[R]) ReplicaStatuses() []ReplicaStatus {
+	return store.replicas.statuses()
+}
+
+// CheckReplicas checks the read replicas now rather than waiting for the next scheduled check
+func (store *PostgresResourceStoreWithJournal[R]) CheckReplicas(ctx context.Context) {
+	if store.replicas != nil {
+		store.replicas.check(ctx, store.Cmds.GetReplicaLagCommand())
+	}
+}
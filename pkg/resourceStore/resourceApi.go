@@ -3,12 +3,15 @@ package resourceStore
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/geraldhinson/siftd-base/pkg/constants"
 	"github.com/geraldhinson/siftd-base/pkg/security"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sirupsen/logrus"
@@ -17,24 +20,41 @@ import (
 
 // PostgresResourceStoreWithJournal is the Go equivalent of the C# PostgresResourceStoreWithJournal class
 type PostgresResourceStoreWithJournal[R any] struct {
-	dbConnectString      string
 	journalPartitionName string
+	tables               TableNames
 	logger               *logrus.Logger
 	dbPool               *pgxpool.Pool
-	rootCtx              *context.Context
-	cancel               *context.CancelFunc //TODO: not using this currently
+	ownsPool             bool        // false when the pool is shared (see NewPostgresResourceStoreWithPool)
+	replicas             *replicaSet // nil without read replicas
+	retrier              *Retrier
+	schema               *JsonSchema        // nil unless set with SetSchema
+	rootCtx              context.Context    // lives for the life of the store - ends journal subscriptions when it is closed
+	cancel               context.CancelFunc // cancels rootCtx when the store is closed
+	listener             journalListener    // shared by the journal subscriptions (see SubscribeJournal)
 	Cmds                 *PostgresCommandHelper
 	// resource        R
 }
 
 // private methods below here
+
+// NewPostgresResourceStoreWithJournal creates a store over the tables named in the configuration (public."Resources"
+// and public."Journal" unless DB_SCHEMA, DB_RESOURCES_TABLE or DB_JOURNAL_TABLE are set).
 func NewPostgresResourceStoreWithJournal[R any](configuration *viper.Viper, logger *logrus.Logger) (*PostgresResourceStoreWithJournal[R], error) {
-	// validate that R is a struct that included an embedded ResourceBase struct
-	testR := new(R)
-	if _, ok := any(testR).(IResource); !ok {
-		return nil, fmt.Errorf("resource store - the type R is not a valid resource type. It is missing an embedded ResourceBase struct")
+	if configuration == nil {
+		return nil, fmt.Errorf("resource store - invalid nil configuration detected")
 	}
+	return NewPostgresResourceStoreWithTables[R](configuration, logger, TableNamesFromConfig(configuration))
+}
 
+// NewPostgresResourceStoreWithTables creates a store over the given tables, so that several resource types can
+// live side by side in one database, e.g.
+//
+//	orders, err := resourceStore.NewPostgresResourceStoreWithTables[Order](configuration, logger, resourceStore.NounTableNames("public", "orders"))
+//	invoices, err := resourceStore.NewPostgresResourceStoreWithTables[Invoice](configuration, logger, resourceStore.NounTableNames("public", "invoices"))
+//
+// Each store opens its own pool (and one for each read replica in DB_REPLICA_CONNECTSTRINGS - see ReplicaPolicy).
+// Use a StoreRegistry (or NewPostgresResourceStoreWithPool) to share them.
+func NewPostgresResourceStoreWithTables[R any](configuration *viper.Viper, logger *logrus.Logger, tables TableNames) (*PostgresResourceStoreWithJournal[R], error) {
 	// validate inputs
 	if configuration == nil {
 		return nil, fmt.Errorf("resource store - invalid nil configuration detected")
@@ -42,175 +62,513 @@ func NewPostgresResourceStoreWithJournal[R any](configuration *viper.Viper, logg
 	if logger == nil {
 		return nil, fmt.Errorf("resource store - invalid nil logger detected")
 	}
+	replicaPolicy, err := ReplicaPolicyFromConfig(configuration)
+	if err != nil {
+		return nil, err
+	}
 
-	store := &PostgresResourceStoreWithJournal[R]{logger: logger, Cmds: &PostgresCommandHelper{}}
+	dbPool, err := newPostgresPool(configuration.GetString(constants.DB_CONNECTION_STRING))
+	if err != nil {
+		return nil, err
+	}
+	replicaPools := []*pgxpool.Pool{}
+	closePools := func() {
+		dbPool.Close()
+		for _, replicaPool := range replicaPools {
+			replicaPool.Close()
+		}
+	}
+	for _, connectString := range replicaPolicy.ConnectStrings {
+		replicaPool, err := openPostgresPool(connectString)
+		if err != nil {
+			closePools()
+			return nil, err
+		}
+		replicaPools = append(replicaPools, replicaPool)
+	}
 
-	store.dbConnectString = configuration.GetString(constants.DB_CONNECTION_STRING)
-	if store.dbConnectString == "" {
-		return nil, fmt.Errorf("resource store - unable to retrieve database connection string")
+	store, err := newPostgresResourceStore[R](configuration, logger, tables, dbPool, replicaPools, replicaPolicy, true)
+	if err != nil {
+		closePools()
+		return nil, err
 	}
+	return store, nil
+}
 
-	store.journalPartitionName = configuration.GetString(constants.JOURNAL_PARTITION_NAME)
-	if store.journalPartitionName == "" {
-		return nil, fmt.Errorf("resource store - unable to retrieve journal partition name")
+// NewPostgresResourceStoreWithPool creates a store over the given tables that runs its queries on an existing
+// pool, and its replica-eligible reads on the replica pools (see ReplicaPolicy). Closing the store leaves the
+// pools open - they belong to the caller.
+func NewPostgresResourceStoreWithPool[R any](configuration *viper.Viper, logger *logrus.Logger, tables TableNames, dbPool *pgxpool.Pool, replicaPools ...*pgxpool.Pool) (*PostgresResourceStoreWithJournal[R], error) {
+	if configuration == nil {
+		return nil, fmt.Errorf("resource store - invalid nil configuration detected")
+	}
+	if logger == nil {
+		return nil, fmt.Errorf("resource store - invalid nil logger detected")
+	}
+	if dbPool == nil || slices.Contains(replicaPools, nil) {
+		return nil, fmt.Errorf("resource store - invalid nil database pool detected")
+	}
+	replicaPolicy, err := ReplicaPolicyFromConfig(configuration)
+	if err != nil {
+		return nil, err
+	}
+	return newPostgresResourceStore[R](configuration, logger, tables, dbPool, replicaPools, replicaPolicy, false)
+}
+
+// newPostgresPool opens and pings a pool on the database
+func newPostgresPool(dbConnectString string) (*pgxpool.Pool, error) {
+	dbPool, err := openPostgresPool(dbConnectString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the connection
+	if err := dbPool.Ping(context.Background()); err != nil {
+		dbPool.Close()
+		return nil, fmt.Errorf("resource store - unable to ping database to verify successful connection: %w", err)
+	}
+	return dbPool, nil
+}
+
+// openPostgresPool opens a pool without connecting - the first connection is made when the pool is first used
+func openPostgresPool(dbConnectString string) (*pgxpool.Pool, error) {
+	if dbConnectString == "" {
+		return nil, fmt.Errorf("resource store - unable to retrieve database connection string")
 	}
 
-	// Initialize the database pool (example with pgx)
-	connConfig, err := pgxpool.ParseConfig(store.dbConnectString)
+	connConfig, err := pgxpool.ParseConfig(dbConnectString)
 	if err != nil {
 		return nil, fmt.Errorf("resource store - unable to parse connection config: %v", err)
 	}
-	rootCtx, cancel := context.WithCancel(context.Background())
-	store.rootCtx = &rootCtx
-	store.cancel = &cancel
 
 	connConfig.MaxConnIdleTime = 60 * time.Second
 	connConfig.MaxConnLifetime = 60 * time.Second
 	connConfig.MaxConns = 15
-	//	defer cancel()
 
-	store.dbPool, err = pgxpool.NewWithConfig(*store.rootCtx, connConfig)
+	// the pool outlives any one request, so it isn't given a ctx that could be cancelled
+	dbPool, err := pgxpool.NewWithConfig(context.Background(), connConfig)
 	if err != nil {
 		return nil, fmt.Errorf("resource store - unable to connect to database: %v", err)
 	}
-	//	defer store.dbPool.Close()
+	return dbPool, nil
+}
 
-	// Verify the connection
-	err = store.dbPool.Ping(*store.rootCtx)
+func newPostgresResourceStore[R any](configuration *viper.Viper, logger *logrus.Logger, tables TableNames, dbPool *pgxpool.Pool, replicaPools []*pgxpool.Pool, replicaPolicy ReplicaPolicy, ownsPool bool) (*PostgresResourceStoreWithJournal[R], error) {
+	// validate that R is a struct that included an embedded ResourceBase struct
+	testR := new(R)
+	if _, ok := any(testR).(IResource); !ok {
+		return nil, fmt.Errorf("resource store - the type R is not a valid resource type. It is missing an embedded ResourceBase struct")
+	}
+
+	cmds, err := NewPostgresCommandHelper(tables)
 	if err != nil {
-		return nil, fmt.Errorf("resource store - unable to ping database to verify successful connection: %w", err)
+		return nil, err
+	}
+	store := &PostgresResourceStoreWithJournal[R]{logger: logger, tables: tables.withDefaults(), Cmds: cmds, dbPool: dbPool, ownsPool: ownsPool}
+
+	store.journalPartitionName = configuration.GetString(constants.JOURNAL_PARTITION_NAME)
+	if store.journalPartitionName == "" {
+		return nil, fmt.Errorf("resource store - unable to retrieve journal partition name")
+	}
+
+	retryPolicy, err := RetryPolicyFromConfig(configuration)
+	if err != nil {
+		return nil, err
+	}
+	if store.retrier, err = NewRetrier(retryPolicy, logger); err != nil {
+		return nil, err
+	}
+
+	store.rootCtx, store.cancel = context.WithCancel(context.Background())
+	logger.Infof("resource store - successfully connected to database (tables %s and %s)", cmds.resourcesTable(), cmds.journalTable())
+
+	// bring the schema up to date, or at least make sure it isn't newer than this binary
+	if configuration.GetBool(constants.DB_SKIP_MIGRATIONS) {
+		version, err := store.SchemaVersion(store.rootCtx)
+		if err != nil {
+			store.cancel()
+			return nil, err
+		}
+		if version < LatestSchemaVersion() {
+			logger.Infof("resource store - the schema is at version %d and %d is available - skipping migrations as configured", version, LatestSchemaVersion())
+		}
+	} else if err := store.Migrate(store.rootCtx); err != nil {
+		store.cancel()
+		return nil, err
+	}
+
+	if len(replicaPools) > 0 {
+		store.replicas = newReplicaSet(replicaPolicy, replicaPools, logger)
+		store.CheckReplicas(store.rootCtx)
+		go store.replicas.monitor(store.rootCtx, cmds.GetReplicaLagCommand())
 	}
-	logger.Info("resource store - successfully connected to database")
 
 	return store, nil
 }
 
-// GetById retrieves a resource by its ID
-func (store *PostgresResourceStoreWithJournal[R]) GetById(ownerId string, id string, resource *R) (int, error) {
-	// validate that R is a struct that includes the ResourceBase struct
+// GetById retrieves a resource by its ID. Resources that have been soft-deleted are reported as not found.
+func (store *PostgresResourceStoreWithJournal[R]) GetById(ctx context.Context, ownerId string, id string, resource *R) (int, error) {
+	return store.getById(ctx, ownerId, id, false, resource)
+}
+
+// GetByIdIncludingDeleted retrieves a resource by its ID even if it has been soft-deleted (e.g. so that
+// it can be inspected before an UndeleteResource call).
+func (store *PostgresResourceStoreWithJournal[R]) GetByIdIncludingDeleted(ctx context.Context, ownerId string, id string, resource *R) (int, error) {
+	return store.getById(ctx, ownerId, id, true, resource)
+}
 
-	query, params := store.Cmds.GetResourceByIdCommand(id, ownerId)
+func (store *PostgresResourceStoreWithJournal[R]) getById(ctx context.Context, ownerId string, id string, includeDeleted bool, resource *R) (int, error) {
+	query, params := store.Cmds.GetResourceByIdCommand(id, ownerId, includeDeleted)
 
-	rows, err := store.dbPool.Query(*store.rootCtx, query, params)
-	if err != nil {
-		store.logger.Error("resource store - error detected on GetById query: ", err)
-		// We don't pass the database error back to the caller. We log it and return a generic error message.
-		// This is to prevent leaking sensitive information to the caller.
-		return constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
-	}
-	defer rows.Close()
+	err := store.withRetry(ctx, "GetById", true, func(ctx context.Context) error {
+		rows, err := store.readPool(ctx).Query(ctx, query, params)
+		if err != nil {
+			store.logger.Error("resource store - error detected on GetById query: ", err)
+			// We don't pass the database error back to the caller. We log it and return a generic error message.
+			// This is to prevent leaking sensitive information to the caller.
+			return internalError(err)
+		}
+		defer rows.Close()
+
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				store.logger.Error("resource store - error reading result of GetById query: ", err)
+				return internalError(err)
+			}
+			return notFoundError("resource store - resource not found: %v", id)
+		}
 
-	if rows.Next() {
 		var resourceData []byte
 		if err := rows.Scan(&resourceData); err != nil {
-			return constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("resource store - db error scanning result in GetById: %w", err)
+			return internalError(fmt.Errorf("resource store - db error scanning result in GetById: %w", err))
 		}
-
-		err := json.Unmarshal(resourceData, resource)
-		if err != nil {
-			return constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("resource store - error unmarshaling JSON in GetById: %w", err)
+		if err := json.Unmarshal(resourceData, resource); err != nil {
+			return internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetById: %w", err))
 		}
-
-		//		if resource.Deleted && throwExceptions {
-		//			return nil, fmt.Errorf("Resource not found (deleted): %v", id)
-		//		}
-	} else {
-		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, fmt.Errorf("resource store - resource not found: %v", id)
+		return nil
+	})
+	if err != nil {
+		return statusOf(err, constants.RESOURCE_INTERNAL_ERROR_CODE), err
 	}
 
 	return constants.RESOURCE_OK_CODE, nil // resource found - no error
 }
 
 // GetByOwner retrieves resources by owner ID
-func (store *PostgresResourceStoreWithJournal[R]) GetByOwnerId(ownerId string, resources *[]R) (int, error) {
+func (store *PostgresResourceStoreWithJournal[R]) GetByOwnerId(ctx context.Context, ownerId string, resources *[]R) (int, error) {
 	query, params := store.Cmds.GetResourcesByOwnerIdCommand(ownerId)
 
-	rows, err := store.dbPool.Query(*store.rootCtx, query, params)
-	if err != nil {
-		store.logger.Error("resource store - error detected on GetByOwnerId query: ", err)
-		// We don't pass the database error back to the caller. We log it and return a generic error message.
-		// This is to prevent leaking sensitive information to the caller.
-		return constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var resourceData []byte
-		var resource R
-		if err := rows.Scan(&resourceData); err != nil {
-			return constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("resource store - error scanning result in GetByOwnerId: %w", err)
-		}
-		err := json.Unmarshal(resourceData, &resource)
+	var found []R
+	err := store.withRetry(ctx, "GetByOwnerId", true, func(ctx context.Context) error {
+		found = nil
+		rows, err := store.readPool(ctx).Query(ctx, query, params)
 		if err != nil {
-			return constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("resource store - error unmarshaling JSON in GetByOwnerId: %w", err)
+			store.logger.Error("resource store - error detected on GetByOwnerId query: ", err)
+			// We don't pass the database error back to the caller. We log it and return a generic error message.
+			// This is to prevent leaking sensitive information to the caller.
+			return internalError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var resourceData []byte
+			var resource R
+			if err := rows.Scan(&resourceData); err != nil {
+				return internalError(fmt.Errorf("resource store - error scanning result in GetByOwnerId: %w", err))
+			}
+			err := json.Unmarshal(resourceData, &resource)
+			if err != nil {
+				return internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetByOwnerId: %w", err))
+			}
+			found = append(found, resource)
+		}
+		if err := rows.Err(); err != nil {
+			store.logger.Error("resource store - error reading results of GetByOwnerId query: ", err)
+			return internalError(err)
 		}
-		*resources = append(*resources, resource)
+		return nil
+	})
+	if err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
 	}
+	*resources = append(*resources, found...)
 
 	//TODO: should I do this or just allow it to return below and let the caller respond
 	// with an empty array and http200
 	//	if len(*resources) == 0 {
-	//		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, fmt.Errorf("no resources found for owner: %v", ownerId)
+	//		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("no resources found for owner: %v", ownerId)
 	//	}
 
 	return constants.RESOURCE_OK_CODE, nil
 }
 
+// QueryByOwnerId retrieves one page of an owner's resources that match the filters in query, in the order
+// given by its sort keys. The cursor returned is passed in the next query to get the following page and is
+// empty when there are no more results.
+func (store *PostgresResourceStoreWithJournal[R]) QueryByOwnerId(ctx context.Context, ownerId string, query ResourceQuery, resources *[]R) (string, int, error) {
+	if err := query.Validate(); err != nil {
+		return "", constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+	cursor, err := query.decodeCursor()
+	if err != nil {
+		return "", constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+
+	sql, params, err := store.Cmds.GetQueryResourcesByOwnerIdCommand(ownerId, &query, cursor)
+	if err != nil {
+		return "", constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+
+	var found []R
+	var nextCursor string
+	err = store.withRetry(ctx, "QueryByOwnerId", true, func(ctx context.Context) error {
+		found, nextCursor = nil, ""
+		rows, err := store.readPool(ctx).Query(ctx, sql, params)
+		if err != nil {
+			store.logger.Error("resource store - error detected on QueryByOwnerId query: ", err)
+			// We don't pass the database error back to the caller. We log it and return a generic error message.
+			// This is to prevent leaking sensitive information to the caller.
+			return internalError(err)
+		}
+		defer rows.Close()
+
+		var lastSortValues []string
+		var lastId string
+		count := 0
+		for rows.Next() {
+			count++
+			if count > query.Limit {
+				// the extra row tells us there is another page - it starts after the last row returned
+				nextCursor = query.encodeCursor(lastSortValues, lastId)
+				break
+			}
+
+			var resourceData []byte
+			var resource R
+			sortValues := make([]string, len(query.Sort))
+			scanTargets := []any{&resourceData, &lastId}
+			for i := range sortValues {
+				scanTargets = append(scanTargets, &sortValues[i])
+			}
+			if err := rows.Scan(scanTargets...); err != nil {
+				return internalError(fmt.Errorf("resource store - error scanning result in QueryByOwnerId: %w", err))
+			}
+			lastSortValues = sortValues
+
+			if err := json.Unmarshal(resourceData, &resource); err != nil {
+				return internalError(fmt.Errorf("resource store - error unmarshaling JSON in QueryByOwnerId: %w", err))
+			}
+			found = append(found, resource)
+		}
+		if err := rows.Err(); err != nil {
+			store.logger.Error("resource store - error reading results of QueryByOwnerId query: ", err)
+			return internalError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+	*resources = append(*resources, found...)
+
+	return nextCursor, constants.RESOURCE_OK_CODE, nil
+}
+
 // GetJournalChanges retrieves changes >= clock up to limit entries
 // We support >= clock to allow for fetching a specific clock entry (e.g. clock = 25, limit = 1) when the client
 // has the clock value for that one and needs to fetch it again for some reason.
-func (store *PostgresResourceStoreWithJournal[R]) GetJournalChanges(clock int64, limit int64, journalEntries *[]ResourceJournalEntry) error {
+func (store *PostgresResourceStoreWithJournal[R]) GetJournalChanges(ctx context.Context, clock int64, limit int64, journalEntries *[]ResourceJournalEntry) error {
 	query, params := store.Cmds.GetJournalChangesCommand(clock, limit)
+	return store.queryJournal(ctx, query, params, "GetJournalChanges", journalEntries)
+}
+
+func (store *PostgresResourceStoreWithJournal[R]) GetJournalMaxClock(ctx context.Context, maxClock *uint64) error {
+	query := store.Cmds.GetJournalMaxClockCommand()
+
+	return store.withRetry(ctx, "GetJournalMaxClock", true, func(ctx context.Context) error {
+		rows, err := store.readPool(ctx).Query(ctx, query)
+		if err != nil {
+			store.logger.Error("resource store - error detected on GetJournalMaxClock query: ", err)
+			// We don't pass the database error back to the caller. We log it and return a generic error message.
+			// This is to prevent leaking sensitive information to the caller.
+			return internalError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			err := rows.Scan(maxClock)
+			if err != nil {
+				store.logger.Info("resource store - null result detected on scan of journal clock. This is expected if no journal entries exist.")
+				*maxClock = 0
+				return nil
+			}
+		}
+		if err := rows.Err(); err != nil {
+			store.logger.Error("resource store - error reading result of GetJournalMaxClock query: ", err)
+			return internalError(err)
+		}
+		return nil
+	})
+}
 
-	rows, err := store.dbPool.Query(*store.rootCtx, query, params)
+// CreateResource creates a new resource
+func (store *PostgresResourceStoreWithJournal[R]) CreateResource(ctx context.Context, resource IResource, extractedAuth string) (IResource, int, error) {
+	jsonResource, status, err := prepareCreate(ctx, resource, extractedAuth, store.schema, "CreateResource")
 	if err != nil {
-		store.logger.Error("resource store - error detected on GetJournalChanges query: ", err)
-		// We don't pass the database error back to the caller. We log it and return a generic error message.
+		return nil, status, err
+	}
+
+	query, params := store.Cmds.GetInsertResourceWithJournalCommand(resource, jsonResource, store.journalPartitionName)
+
+	err = store.withRetry(ctx, "CreateResource", false, func(ctx context.Context) error {
+		return store.writeJournaled(ctx, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, query, params)
+			return err
+		})
+	})
+	if err != nil {
+		store.logger.Error("resource store - error detected on db insert in CreateResource: ", err)
+
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == constants.PRIMARY_KEY_VIOLATION_SQL_CODE {
+			return nil, constants.RESOURCE_ALREADY_EXISTS_CODE, conflictError("resource store - resource save failed for %v in CreateResource due to duplicate key", resource.GetResourceBase().Id)
+		}
+		// We don't pass unantcipated database errors back to the caller. We log it and return a generic error message.
 		// This is to prevent leaking sensitive information to the caller.
-		return fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+
+	afterCommit(ctx, resource)
+	return resource, constants.RESOURCE_OK_CODE, nil
+}
+
+// UpdateResource replaces an existing resource, provided the version in the body matches the stored version
+func (store *PostgresResourceStoreWithJournal[R]) UpdateResource(ctx context.Context, resource IResource, ownerId string, resourceId string, extractedAuth string) (IResource, int, error) {
+	versionToUpdate, jsonResource, status, err := prepareUpdate(ctx, resource, ownerId, resourceId, extractedAuth, store.schema, store.loadForUpdate(ctx, ownerId, resourceId, "UpdateResource"), "UpdateResource")
+	if err != nil {
+		return nil, status, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var journalEntry ResourceJournalEntry
-		if err := rows.Scan(&journalEntry.Clock, &journalEntry.Resource, &journalEntry.UpdatedAt, &journalEntry.PartitionName); err != nil {
-			return fmt.Errorf("resource store - error scanning result in GetJournalChanges: %w", err)
+	query, params := store.Cmds.GetUpdateResourceWithJournalCommand(resource, versionToUpdate, jsonResource, store.journalPartitionName)
+
+	var command pgconn.CommandTag
+	err = store.withRetry(ctx, "UpdateResource", false, func(ctx context.Context) error {
+		return store.writeJournaled(ctx, func(tx pgx.Tx) error {
+			command, err = tx.Exec(ctx, query, params)
+			return err
+		})
+	})
+	if err != nil {
+		store.logger.Error("resource store - error detected on db update in UpdateResource: ", err)
+
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == constants.PRIMARY_KEY_VIOLATION_SQL_CODE {
+			return nil, constants.RESOURCE_ALREADY_EXISTS_CODE, conflictError("resource store - resource update failed for %v in UpdateResource", resourceId)
 		}
-		*journalEntries = append(*journalEntries, journalEntry)
+
+		// We don't pass the database error back to the caller. We log it and return a generic error message.
+		// This is to prevent leaking sensitive information to the caller.
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+	if command.RowsAffected() == 0 {
+		status, err := store.explainNoRows(ctx, ownerId, resourceId, versionToUpdate, false, "UpdateResource")
+		return nil, status, err
 	}
 
-	return nil
+	afterCommit(ctx, resource)
+	return resource, constants.RESOURCE_OK_CODE, nil
 }
 
-func (store *PostgresResourceStoreWithJournal[R]) GetJournalMaxClock(maxClock *uint64) error {
-	query := store.Cmds.GetJournalMaxClockCommand()
+// loadForUpdate returns the loadOld of prepareUpdate for an update made outside of InTx
+func (store *PostgresResourceStoreWithJournal[R]) loadForUpdate(ctx context.Context, ownerId string, resourceId string, methodName string) func(expectedVersion uint) (IResource, int, error) {
+	return func(expectedVersion uint) (IResource, int, error) {
+		var stored R
+		status, err := store.getById(ReadFromPrimary(ctx), ownerId, resourceId, false, &stored)
+		return storedForUpdate(status, err, &stored, expectedVersion, resourceId, methodName)
+	}
+}
+
+// DeleteResource soft-deletes a resource. The row is kept (with Deleted = true) so that it can be restored
+// with UndeleteResource, and a tombstone copy of the resource is written to the journal in the same statement.
+func (store *PostgresResourceStoreWithJournal[R]) DeleteResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error) {
+	return store.setDeleted(ctx, ownerId, resourceId, expectedVersion, true, extractedAuth)
+}
+
+// UndeleteResource restores a resource previously removed with DeleteResource
+func (store *PostgresResourceStoreWithJournal[R]) UndeleteResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error) {
+	return store.setDeleted(ctx, ownerId, resourceId, expectedVersion, false, extractedAuth)
+}
+
+func (store *PostgresResourceStoreWithJournal[R]) setDeleted(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, deleted bool, extractedAuth string) (IResource, int, error) {
+	methodName := setDeletedMethodName(deleted)
 
-	rows, err := store.dbPool.Query(*store.rootCtx, query)
+	patch, jsonPatch, status, err := prepareSetDeleted(expectedVersion, deleted, extractedAuth, methodName)
 	if err != nil {
-		store.logger.Error("resource store - error detected on GetJournalMaxClock query: ", err)
+		return nil, status, err
+	}
+
+	query, params := store.Cmds.GetSetDeletedWithJournalCommand(ownerId, resourceId, expectedVersion, deleted, jsonPatch, patch.UpdatedAt, store.journalPartitionName)
+
+	var resourceData []byte
+	err = store.withRetry(ctx, methodName, false, func(ctx context.Context) error {
+		return store.writeJournaled(ctx, func(tx pgx.Tx) error {
+			return tx.QueryRow(ctx, query, params).Scan(&resourceData)
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// figure out why nothing was changed so the caller gets a meaningful status
+		status, err := store.explainNoRows(ctx, ownerId, resourceId, expectedVersion, true, methodName)
+		return nil, status, err
+	}
+	if err != nil {
+		store.logger.Errorf("resource store - error detected on db update in %s: %v", methodName, err)
 		// We don't pass the database error back to the caller. We log it and return a generic error message.
 		// This is to prevent leaking sensitive information to the caller.
-		return fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		err := rows.Scan(maxClock)
-		if err != nil {
-			store.logger.Info("resource store - null result detected on scan of journal clock. This is expected if no journal entries exist.")
-			*maxClock = 0
-			return nil
+	resource, status, err := store.unmarshalResource(resourceData, methodName)
+	if err == nil {
+		afterCommit(ctx, resource)
+	}
+	return resource, status, err
+}
+
+// writeJournaled runs a single-statement write in a transaction that holds the journal lock. The statement draws
+// its clock from the journal's sequence before it commits, so without the lock a write could commit after one with
+// a later clock and a reader that had already moved past that clock would never see it.
+func (store *PostgresResourceStoreWithJournal[R]) writeJournaled(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, store.dbPool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, store.Cmds.GetLockJournalCommand()); err != nil {
+			return err
 		}
+		return fn(tx)
+	})
+}
+
+func (store *PostgresResourceStoreWithJournal[R]) unmarshalResource(resourceData []byte, methodName string) (IResource, int, error) {
+	resource := new(R)
+	if err := json.Unmarshal(resourceData, resource); err != nil {
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in %s: %w", methodName, err))
 	}
 
-	return nil
+	return any(resource).(IResource), constants.RESOURCE_OK_CODE, nil
 }
 
-// CreateResource creates a new resource
-func (store *PostgresResourceStoreWithJournal[R]) CreateResource(resource IResource, extractedAuth string) (IResource, int, error) {
+// resourceBasePatch is merged into the stored resource JSON by the delete/undelete command
+type resourceBasePatch struct {
+	Version        uint      `json:"version"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	UpdatedBy      string    `json:"updatedBy"`
+	ImpersonatedBy string    `json:"impersonatedBy"`
+	LastAction     string    `json:"lastAction"`
+	Deleted        bool      `json:"deleted"`
+}
+
+// prepareCreate stamps the ResourceBase fields owned by the store on a resource about to be created
+// and returns its JSON. It is shared by the single statement and the transactional (InTx) paths.
+func prepareCreate(ctx context.Context, resource IResource, extractedAuth string, schema *JsonSchema, methodName string) ([]byte, int, error) {
 	identities := security.ValidateAuthToken(extractedAuth)
 	if len(identities) == 0 {
-		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("resource store - no identities found in auth token in CreateResource")
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - no identities found in auth token in %s", methodName))
 	}
 
 	now := time.Now().UTC()
@@ -219,6 +577,7 @@ func (store *PostgresResourceStoreWithJournal[R]) CreateResource(resource IResou
 	resourceBase.UpdatedAt = resourceBase.CreatedAt
 	resourceBase.Version = 1
 	resourceBase.Deleted = false
+	resourceBase.LastAction = constants.RESOURCE_ACTION_CREATE
 
 	// generate unique ID if not provided (but allow for it to be provided)
 	if resourceBase.Id == "" {
@@ -228,43 +587,38 @@ func (store *PostgresResourceStoreWithJournal[R]) CreateResource(resource IResou
 	resourceBase.UpdatedBy = identities["sub"]
 	resourceBase.ImpersonatedBy = identities["impersonatedBy"]
 
-	jsonResource, err := json.Marshal(resource)
-	if err != nil {
-		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("resource store - error serializing resource in CreateResource: %w", err)
+	if status, err := beforeCreate(ctx, resource, methodName); err != nil {
+		return nil, status, err
 	}
 
-	query, params := store.Cmds.GetInsertResourceWithJournalCommand(resource, jsonResource, store.journalPartitionName)
-
-	_, err = store.dbPool.Exec(*store.rootCtx, query, params)
+	jsonResource, err := json.Marshal(resource)
 	if err != nil {
-		store.logger.Error("resource store - error detected on db insert in CreateResource: ", err)
-
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == constants.PRIMARY_KEY_VIOLATION_SQL_CODE {
-			return nil, constants.RESOURCE_ALREADY_EXISTS_CODE, fmt.Errorf("resource store - resource save failed for %v in CreateResource due to duplicate key", resourceBase.Id)
-		}
-		// We don't pass unantcipated database errors back to the caller. We log it and return a generic error message.
-		// This is to prevent leaking sensitive information to the caller.
-		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error serializing resource in %s: %w", methodName, err))
+	}
+	if status, err := validateSchema(schema, jsonResource, methodName); err != nil {
+		return nil, status, err
 	}
 
-	return resource, constants.RESOURCE_OK_CODE, nil
+	return jsonResource, constants.RESOURCE_OK_CODE, nil
 }
 
-// CreateResource creates a new resource
-func (store *PostgresResourceStoreWithJournal[R]) UpdateResource(resource IResource, ownerId string, resourceId string, extractedAuth string) (IResource, int, error) {
+// prepareUpdate validates and stamps a resource about to replace the stored copy (which must not be deleted). It returns the version
+// that must currently be stored for the update to succeed along with the JSON of the new version. loadOld looks
+// up the stored copy for a BeforeUpdate hook (see storedForUpdate).
+func prepareUpdate(ctx context.Context, resource IResource, ownerId string, resourceId string, extractedAuth string, schema *JsonSchema, loadOld func(expectedVersion uint) (IResource, int, error), methodName string) (uint, []byte, int, error) {
 	identities := security.ValidateAuthToken(extractedAuth)
 	if len(identities) == 0 {
-		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("resource store - no identities found in auth token in UpdateResource")
+		return 0, nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - no identities found in auth token in %s", methodName))
 	}
 
 	// validate that the resource id in the URL matches the resource id in the body and
 	// that the owner id in the URL matches the owner id in the body
 	resourceBase := resource.GetResourceBase()
 	if resourceBase.OwnerId != ownerId {
-		return nil, constants.RESOURCE_BAD_REQUEST_CODE, fmt.Errorf("resource store - owner id passed in the request does not match owner id in body in UpdateResource")
+		return 0, nil, constants.RESOURCE_BAD_REQUEST_CODE, validationError("resource store - owner id passed in the request does not match owner id in body in %s", methodName)
 	}
 	if resourceBase.Id != resourceId {
-		return nil, constants.RESOURCE_BAD_REQUEST_CODE, fmt.Errorf("resource store - resource id passed in the request does not match resource id in body in UpdateResource")
+		return 0, nil, constants.RESOURCE_BAD_REQUEST_CODE, validationError("resource store - resource id passed in the request does not match resource id in body in %s", methodName)
 	}
 
 	resourceBase.UpdatedBy = identities["sub"]
@@ -272,53 +626,147 @@ func (store *PostgresResourceStoreWithJournal[R]) UpdateResource(resource IResou
 
 	now := time.Now().UTC()
 	resourceBase.UpdatedAt = now
+	resourceBase.LastAction = constants.RESOURCE_ACTION_UPDATE
+	// an update never deletes or restores a resource - that is what DeleteResource and UndeleteResource are for
+	resourceBase.Deleted = false
 	versionToUpdate := resourceBase.Version
 	resourceBase.Version++
 
+	if status, err := beforeUpdate(ctx, resource, func() (IResource, int, error) { return loadOld(versionToUpdate) }, methodName); err != nil {
+		return 0, nil, status, err
+	}
+
 	jsonResource, err := json.Marshal(resource)
 	if err != nil {
-		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("resource store - error serializing resource in UpdateResource: %w", err)
+		return 0, nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error serializing resource in %s: %w", methodName, err))
+	}
+	if status, err := validateSchema(schema, jsonResource, methodName); err != nil {
+		return 0, nil, status, err
 	}
 
-	query, params := store.Cmds.GetUpdateResourceWithJournalCommand(resource, versionToUpdate, jsonResource, store.journalPartitionName)
+	return versionToUpdate, jsonResource, constants.RESOURCE_OK_CODE, nil
+}
 
-	command, err := store.dbPool.Exec(*store.rootCtx, query, params)
+// prepareSetDeleted builds the ResourceBase changes merged into the stored JSON by a delete or undelete
+func prepareSetDeleted(expectedVersion uint, deleted bool, extractedAuth string, methodName string) (resourceBasePatch, []byte, int, error) {
+	identities := security.ValidateAuthToken(extractedAuth)
+	if len(identities) == 0 {
+		return resourceBasePatch{}, nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - no identities found in auth token in %s", methodName))
+	}
+
+	lastAction := constants.RESOURCE_ACTION_DELETE
+	if !deleted {
+		lastAction = constants.RESOURCE_ACTION_UNDELETE
+	}
+
+	patch := resourceBasePatch{
+		Version:        expectedVersion + 1,
+		UpdatedAt:      time.Now().UTC(),
+		UpdatedBy:      identities["sub"],
+		ImpersonatedBy: identities["impersonatedBy"],
+		LastAction:     lastAction,
+		Deleted:        deleted,
+	}
+	jsonPatch, err := json.Marshal(patch)
 	if err != nil {
-		store.logger.Error("resource store - error detected on db update in UpdateResource: ", err)
+		return resourceBasePatch{}, nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error serializing resource base in %s: %w", methodName, err))
+	}
 
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == constants.PRIMARY_KEY_VIOLATION_SQL_CODE {
-			return nil, constants.RESOURCE_ALREADY_EXISTS_CODE, fmt.Errorf("resource store - resource update failed for %v in UpdateResource", resourceBase.Id)
+	return patch, jsonPatch, constants.RESOURCE_OK_CODE, nil
+}
+
+func setDeletedMethodName(deleted bool) string {
+	if deleted {
+		return "DeleteResource"
+	}
+	return "UndeleteResource"
+}
+
+// noRowsError explains why a conditional update, delete or undelete changed nothing, given a follow-up lookup of
+// the resource (found is false when the owner has no such resource, deleted or not): either the resource doesn't
+// exist, its stored version is not the expected one, or it is already in the requested state. The returned code
+// is the one these cases have always had - RESOURCE_BAD_REQUEST_CODE, except for a delete or undelete of a
+// resource that doesn't exist.
+func noRowsError(found bool, storedVersion uint, expectedVersion uint, resourceId string, methodName string) (int, error) {
+	var err *ResourceError
+	switch {
+	case !found:
+		err = NewResourceError(ERROR_KIND_NOT_FOUND, fmt.Sprintf("resource store - resource not found: %v", resourceId), nil)
+		if methodName != setDeletedMethodName(true) && methodName != setDeletedMethodName(false) {
+			err.status = constants.RESOURCE_BAD_REQUEST_CODE
 		}
+	case storedVersion != expectedVersion:
+		err = NewResourceError(ERROR_KIND_VERSION_MISMATCH, fmt.Sprintf("resource store - no rows were updated because the If-Match was not correct in %s (the current version is %d)", methodName, storedVersion), nil)
+	default:
+		err = NewResourceError(ERROR_KIND_CONFLICT, fmt.Sprintf("resource store - no rows were updated because the resource is already in the requested state in %s", methodName), nil)
+		err.status = constants.RESOURCE_BAD_REQUEST_CODE
+	}
+	return err.Status(), err
+}
 
-		// We don't pass the database error back to the caller. We log it and return a generic error message.
-		// This is to prevent leaking sensitive information to the caller.
-		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
+// explainNoRows looks up a resource that a conditional write didn't change to tell the caller why (see noRowsError).
+// A deleted resource is only looked at by a delete or undelete - an update reports it as not found.
+func (store *PostgresResourceStoreWithJournal[R]) explainNoRows(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, includeDeleted bool, methodName string) (int, error) {
+	var current R
+	status, err := store.getById(ReadFromPrimary(ctx), ownerId, resourceId, includeDeleted, &current)
+	if status == constants.RESOURCE_INTERNAL_ERROR_CODE {
+		return status, err
 	}
-	if command.RowsAffected() == 0 {
-		return nil, constants.RESOURCE_BAD_REQUEST_CODE, fmt.Errorf("resource store - no rows were updated because the resource id does not exist or the If-Match was not correct in UpdateResource")
+
+	var storedVersion uint
+	if status == constants.RESOURCE_OK_CODE {
+		storedVersion = any(&current).(IResource).GetResourceBase().Version
 	}
+	return noRowsError(status == constants.RESOURCE_OK_CODE, storedVersion, expectedVersion, resourceId, methodName)
+}
 
-	return resource, constants.RESOURCE_OK_CODE, nil
+// SetSchema makes the store check resources against schema before they are created or updated (nil turns the
+// check off). Call it before the store is used.
+func (store *PostgresResourceStoreWithJournal[R]) SetSchema(schema *JsonSchema) {
+	store.schema = schema
 }
 
 // HealthCheck performs a health check on the database
-func (store *PostgresResourceStoreWithJournal[R]) HealthCheck() error {
+func (store *PostgresResourceStoreWithJournal[R]) HealthCheck(ctx context.Context) error {
 	store.MonitorPoolStats()
 
 	query := store.Cmds.GetHealthCheckCommand()
 
-	rows, err := store.dbPool.Query(*store.rootCtx, query)
-	// rows, err := store.dbPool.Query(*store.rootCtx, query, ids)
+	rows, err := store.dbPool.Query(ctx, query)
 	if err != nil {
 		store.logger.Error("resource store - error detected on HealthCheck query: ", err)
 		// We don't pass the database error back to the caller. We log it and return a generic error message.
 		// This is to prevent leaking sensitive information to the caller.
-		return fmt.Errorf(constants.INTERNAL_SERVER_ERROR)
+		return internalError(err)
 	}
 	defer rows.Close()
 	return nil
 }
 
+// Close cancels the store's root context and closes the connection pool, unless the pool is shared. Closing the
+// pool waits for connections that are in use to be released, so the wait is bounded by the ctx passed in.
+func (store *PostgresResourceStoreWithJournal[R]) Close(ctx context.Context) error {
+	store.cancel()
+	if !store.ownsPool {
+		return nil
+	}
+	store.replicas.close()
+
+	closed := make(chan struct{})
+	go func() {
+		store.dbPool.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		store.logger.Info("resource store - database pool closed")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("resource store - timed out waiting for the database pool to close: %w", ctx.Err())
+	}
+}
+
 func (store *PostgresResourceStoreWithJournal[R]) MonitorPoolStats() {
 	stats := store.dbPool.Stat()
 	statsMap := make(map[string]int)
@@ -330,5 +778,10 @@ func (store *PostgresResourceStoreWithJournal[R]) MonitorPoolStats() {
 	statsMap["max_connection_lifetime"] = int(store.dbPool.Config().MaxConnLifetime.Seconds())
 	statsMap["max_connection_idle_time"] = int(store.dbPool.Config().MaxConnIdleTime.Seconds())
 
+	retryStats := store.RetryStats()
+	statsMap["retries"] = int(retryStats.Retries)
+	statsMap["retries_recovered"] = int(retryStats.Recovered)
+	statsMap["retries_exhausted"] = int(retryStats.Exhausted)
+
 	store.logger.Info("resource store - Pool stats", statsMap)
 }
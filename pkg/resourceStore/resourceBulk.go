@@ -0,0 +1,239 @@
+package resourceStore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/jackc/pgx/v5"
+)
+
+// BULK_BATCH_SIZE is the number of statements sent to postgres per round trip by CreateResources and UpsertResources
+const BULK_BATCH_SIZE = 1000
+
+type BulkOutcome string
+
+const (
+	BULK_OUTCOME_CREATED          BulkOutcome = "created"
+	BULK_OUTCOME_UPDATED          BulkOutcome = "updated"
+	BULK_OUTCOME_DUPLICATE        BulkOutcome = "duplicate"       // the id is already taken (by any owner for a create, by another owner for an upsert)
+	BULK_OUTCOME_VERSION_CONFLICT BulkOutcome = "versionConflict" // the resource doesn't exist at the version in the body
+	BULK_OUTCOME_INVALID          BulkOutcome = "invalid"         // the resource was rejected before it was written (see Status and Err)
+)
+
+// BulkResult is the result of one resource of a CreateResources or UpsertResources call, at the same index as the
+// resource. Status is the constants.RESOURCE_*_CODE that CreateResource or UpdateResource would have returned.
+type BulkResult struct {
+	Id       string
+	Outcome  BulkOutcome
+	Status   int
+	Err      error
+	Resource IResource // the stored resource when it was written (the resource passed in, with the store's fields set)
+}
+
+// Written reports whether the resource was created or updated
+func (r BulkResult) Written() bool {
+	return r.Outcome == BULK_OUTCOME_CREATED || r.Outcome == BULK_OUTCOME_UPDATED
+}
+
+type bulkKind int
+
+const (
+	bulkCreate bulkKind = iota
+	bulkUpsert
+	bulkUpdate
+)
+
+// bulkWrite is one statement of a bulk call, for the resource at index
+type bulkWrite struct {
+	index  int
+	kind   bulkKind
+	query  string
+	params pgx.NamedArgs
+}
+
+// CreateResources creates many resources in one transaction, sending the statements in batches rather than making
+// a round trip per resource. A resource that can't be created (e.g. a duplicate id) is reported in its BulkResult
+// and doesn't stop the others. The returned status is only an error when the whole call failed, in which case
+// nothing was written. The journal entries of the created resources have contiguous clocks in slice order.
+func (store *PostgresResourceStoreWithJournal[R]) CreateResources(ctx context.Context, resources []IResource, extractedAuth string) ([]BulkResult, int, error) {
+	return store.writeBulk(ctx, resources, extractedAuth, false)
+}
+
+// UpsertResources is CreateResources for resources that may already exist. A resource with a version of 0 is
+// created, or replaces the owner's resource with the same id as its next version (restoring it if it was deleted).
+// A resource with a version is an update, which must match the stored version like UpdateResource.
+func (store *PostgresResourceStoreWithJournal[R]) UpsertResources(ctx context.Context, resources []IResource, extractedAuth string) ([]BulkResult, int, error) {
+	return store.writeBulk(ctx, resources, extractedAuth, true)
+}
+
+func (store *PostgresResourceStoreWithJournal[R]) writeBulk(ctx context.Context, resources []IResource, extractedAuth string, upsert bool) ([]BulkResult, int, error) {
+	methodName := bulkMethodName(upsert)
+	results := make([]BulkResult, len(resources))
+
+	var writes []bulkWrite
+	for i, resource := range resources {
+		write, status, err := store.prepareBulkWrite(ctx, resource, extractedAuth, upsert, methodName)
+		if err != nil {
+			results[i] = invalidBulkResult(resource, status, err)
+			continue
+		}
+		write.index = i
+		writes = append(writes, write)
+	}
+	if len(writes) == 0 {
+		return results, constants.RESOURCE_OK_CODE, nil
+	}
+
+	tx, err := store.dbPool.Begin(ctx)
+	if err != nil {
+		store.logger.Errorf("resource store - error starting transaction in %s: %v", methodName, err)
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+	// no-op once the transaction has been committed
+	defer tx.Rollback(context.Background())
+
+	// lock the journal before the rows, like the single writes (see writeJournaled), so that the two can't deadlock
+	if _, err := tx.Exec(ctx, store.Cmds.GetLockJournalCommand()); err != nil {
+		store.logger.Errorf("resource store - error locking the journal in %s: %v", methodName, err)
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+
+	var journal []pendingJournalEntry
+	for start := 0; start < len(writes); start += BULK_BATCH_SIZE {
+		chunk := writes[start:min(start+BULK_BATCH_SIZE, len(writes))]
+		batch := &pgx.Batch{}
+		for _, write := range chunk {
+			batch.Queue(write.query, write.params)
+		}
+
+		batchResults := tx.SendBatch(ctx, batch)
+		for _, write := range chunk {
+			resource := resources[write.index]
+			var resourceData []byte
+			inserted := write.kind == bulkCreate
+			if write.kind == bulkUpsert {
+				err = batchResults.QueryRow().Scan(&resourceData, &inserted)
+			} else {
+				err = batchResults.QueryRow().Scan(&resourceData)
+			}
+			if errors.Is(err, pgx.ErrNoRows) {
+				results[write.index] = unwrittenBulkResult(resource, write.kind, methodName)
+				continue
+			}
+			if err != nil {
+				batchResults.Close()
+				store.logger.Errorf("resource store - error detected on db write in %s: %v", methodName, err)
+				return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+			}
+
+			// an upsert that replaced a resource changed fields the caller didn't set (e.g. the version)
+			if err := json.Unmarshal(resourceData, resource); err != nil {
+				batchResults.Close()
+				return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in %s: %w", methodName, err))
+			}
+			outcome := BULK_OUTCOME_UPDATED
+			if inserted {
+				outcome = BULK_OUTCOME_CREATED
+			}
+			results[write.index] = BulkResult{Id: resource.GetResourceBase().Id, Outcome: outcome, Status: constants.RESOURCE_OK_CODE, Resource: resource}
+			journal = append(journal, pendingJournalEntry{resource: resourceData, resourceBase: *resource.GetResourceBase()})
+		}
+		if err := batchResults.Close(); err != nil {
+			store.logger.Errorf("resource store - error detected on db write in %s: %v", methodName, err)
+			return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+		}
+	}
+
+	if len(journal) > 0 {
+		for start := 0; start < len(journal); start += BULK_BATCH_SIZE {
+			batch := &pgx.Batch{}
+			for _, entry := range journal[start:min(start+BULK_BATCH_SIZE, len(journal))] {
+				query, params := store.Cmds.GetInsertJournalCommand(&entry.resourceBase, entry.resource, store.journalPartitionName)
+				batch.Queue(query, params)
+			}
+			if start+BULK_BATCH_SIZE >= len(journal) {
+				notifyQuery, notifyParams := store.Cmds.GetNotifyJournalCommand(store.journalPartitionName)
+				batch.Queue(notifyQuery, notifyParams)
+			}
+			if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+				store.logger.Errorf("resource store - error writing journal entries in %s: %v", methodName, err)
+				return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		store.logger.Errorf("resource store - error committing transaction in %s: %v", methodName, err)
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+
+	afterBulkCommit(ctx, results)
+	return results, constants.RESOURCE_OK_CODE, nil
+}
+
+// prepareBulkWrite stamps one resource of a bulk call and builds its statement
+func (store *PostgresResourceStoreWithJournal[R]) prepareBulkWrite(ctx context.Context, resource IResource, extractedAuth string, upsert bool, methodName string) (bulkWrite, int, error) {
+	if resource == nil {
+		return bulkWrite{}, constants.RESOURCE_BAD_REQUEST_CODE, validationError("resource store - invalid nil resource detected in %s", methodName)
+	}
+
+	if upsert && resource.GetResourceBase().Version > 0 {
+		resourceBase := resource.GetResourceBase()
+		loadOld := store.loadForUpdate(ctx, resourceBase.OwnerId, resourceBase.Id, methodName)
+		versionToUpdate, jsonResource, status, err := prepareUpdate(ctx, resource, resourceBase.OwnerId, resourceBase.Id, extractedAuth, store.schema, loadOld, methodName)
+		if err != nil {
+			return bulkWrite{}, status, err
+		}
+		query, params := store.Cmds.GetUpdateResourceCommand(resource, versionToUpdate, jsonResource)
+		return bulkWrite{kind: bulkUpdate, query: query, params: params}, constants.RESOURCE_OK_CODE, nil
+	}
+
+	jsonResource, status, err := prepareCreate(ctx, resource, extractedAuth, store.schema, methodName)
+	if err != nil {
+		return bulkWrite{}, status, err
+	}
+	if upsert {
+		query, params := store.Cmds.GetUpsertResourceCommand(resource, jsonResource)
+		return bulkWrite{kind: bulkUpsert, query: query, params: params}, constants.RESOURCE_OK_CODE, nil
+	}
+	query, params := store.Cmds.GetInsertResourceIfAbsentCommand(resource, jsonResource)
+	return bulkWrite{kind: bulkCreate, query: query, params: params}, constants.RESOURCE_OK_CODE, nil
+}
+
+func bulkMethodName(upsert bool) string {
+	if upsert {
+		return "UpsertResources"
+	}
+	return "CreateResources"
+}
+
+// afterBulkCommit runs the AfterCommit hooks of the resources a bulk call wrote
+func afterBulkCommit(ctx context.Context, results []BulkResult) {
+	for _, result := range results {
+		if result.Written() {
+			afterCommit(ctx, result.Resource)
+		}
+	}
+}
+
+func invalidBulkResult(resource IResource, status int, err error) BulkResult {
+	result := BulkResult{Outcome: BULK_OUTCOME_INVALID, Status: status, Err: err}
+	if resource != nil {
+		result.Id = resource.GetResourceBase().Id
+	}
+	return result
+}
+
+// unwrittenBulkResult explains why the statement of a resource didn't write a row
+func unwrittenBulkResult(resource IResource, kind bulkKind, methodName string) BulkResult {
+	id := resource.GetResourceBase().Id
+	if kind == bulkUpdate {
+		return BulkResult{Id: id, Outcome: BULK_OUTCOME_VERSION_CONFLICT, Status: constants.RESOURCE_BAD_REQUEST_CODE,
+			Err: versionMismatchError("resource store - no rows were updated because the resource id does not exist or the version was not correct for %v in %s", id, methodName)}
+	}
+	return BulkResult{Id: id, Outcome: BULK_OUTCOME_DUPLICATE, Status: constants.RESOURCE_ALREADY_EXISTS_CODE,
+		Err: conflictError("resource store - resource save failed for %v in %s due to duplicate key", id, methodName)}
+}
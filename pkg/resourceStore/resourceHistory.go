@@ -0,0 +1,140 @@
+package resourceStore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	HISTORY_DEFAULT_LIMIT = 100
+	HISTORY_MAX_LIMIT     = 1000
+)
+
+// HistoryPage selects the versions of a resource after AfterVersion (0 for the first page), oldest first.
+// The next page starts after the version of the last resource returned.
+type HistoryPage struct {
+	AfterVersion uint
+	Limit        int
+}
+
+// Validate checks the page for errors a caller can fix and fills in the default limit
+func (p *HistoryPage) Validate() error {
+	if p.Limit == 0 {
+		p.Limit = HISTORY_DEFAULT_LIMIT
+	}
+	if p.Limit < 0 || p.Limit > HISTORY_MAX_LIMIT {
+		return fmt.Errorf("resource store - the history limit must be between 1 and %d", HISTORY_MAX_LIMIT)
+	}
+	return nil
+}
+
+// AsOf is a point in the journal for GetByIdAsOf - either a clock or a time. Exactly one must be set.
+type AsOf struct {
+	Clock uint64
+	Time  time.Time
+}
+
+func (a AsOf) Validate() error {
+	if (a.Clock == 0) == a.Time.IsZero() {
+		return errors.New("resource store - exactly one of a clock or a time is required for a point-in-time read")
+	}
+	return nil
+}
+
+// GetHistory retrieves a page of the versions of a resource from the journal, oldest first, including the
+// versions written by deletes and undeletes. A resource without any history is reported as not found.
+func (store *PostgresResourceStoreWithJournal[R]) GetHistory(ctx context.Context, ownerId string, id string, page HistoryPage, history *[]R) (int, error) {
+	if err := page.Validate(); err != nil {
+		return constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+
+	query, params := store.Cmds.GetHistoryCommand(ownerId, id, page.AfterVersion, page.Limit)
+	var found []R
+	err := store.withRetry(ctx, "GetHistory", true, func(ctx context.Context) error {
+		found = nil
+		rows, err := store.dbPool.Query(ctx, query, params)
+		if err != nil {
+			store.logger.Error("resource store - error detected on GetHistory query: ", err)
+			return internalError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var resourceData []byte
+			var resource R
+			if err := rows.Scan(&resourceData); err != nil {
+				return internalError(fmt.Errorf("resource store - error scanning result in GetHistory: %w", err))
+			}
+			if err := json.Unmarshal(resourceData, &resource); err != nil {
+				return internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetHistory: %w", err))
+			}
+			found = append(found, resource)
+		}
+		if err := rows.Err(); err != nil {
+			store.logger.Error("resource store - error reading results of GetHistory query: ", err)
+			return internalError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+	*history = append(*history, found...)
+
+	if len(found) == 0 && page.AfterVersion == 0 {
+		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("resource store - no history found for resource: %v", id)
+	}
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// GetVersion retrieves one version of a resource from the journal, even if that version is a delete
+func (store *PostgresResourceStoreWithJournal[R]) GetVersion(ctx context.Context, ownerId string, id string, version uint, resource *R) (int, error) {
+	query, params := store.Cmds.GetVersionCommand(ownerId, id, version)
+	return store.getSnapshot(ctx, query, params, "GetVersion", fmt.Sprintf("version %d of resource %v", version, id), resource)
+}
+
+// GetByIdAsOf retrieves a resource as it was at a clock or a time. Like GetById, a resource that was deleted
+// at that point is reported as not found.
+func (store *PostgresResourceStoreWithJournal[R]) GetByIdAsOf(ctx context.Context, ownerId string, id string, asOf AsOf, resource *R) (int, error) {
+	if err := asOf.Validate(); err != nil {
+		return constants.RESOURCE_BAD_REQUEST_CODE, asValidationError(err)
+	}
+
+	query, params := store.Cmds.GetAsOfCommand(ownerId, id, asOf.Clock, asOf.Time)
+	var snapshot R
+	if status, err := store.getSnapshot(ctx, query, params, "GetByIdAsOf", fmt.Sprintf("resource %v", id), &snapshot); err != nil {
+		return status, err
+	}
+	if any(&snapshot).(IResource).GetResourceBase().Deleted {
+		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("resource store - resource not found: %v", id)
+	}
+
+	*resource = snapshot
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// getSnapshot reads the single journal snapshot selected by query
+func (store *PostgresResourceStoreWithJournal[R]) getSnapshot(ctx context.Context, query string, params pgx.NamedArgs, methodName string, description string, resource *R) (int, error) {
+	var resourceData []byte
+	err := store.withRetry(ctx, methodName, true, func(ctx context.Context) error {
+		return store.dbPool.QueryRow(ctx, query, params).Scan(&resourceData)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("resource store - %s not found", description)
+	}
+	if err != nil {
+		store.logger.Errorf("resource store - error detected on %s query: %v", methodName, err)
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+
+	if err := json.Unmarshal(resourceData, resource); err != nil {
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in %s: %w", methodName, err))
+	}
+	return constants.RESOURCE_OK_CODE, nil
+}
@@ -0,0 +1,140 @@
+package resourceStore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/problemDetails"
+)
+
+// The optional interfaces below are implemented by a resource type (on its pointer, like GetResourceBase) to take
+// part in the store's writes. Both stores call them for CreateResource, UpdateResource, PatchResource, the writes
+// of InTx and the bulk writes. The ResourceBase fields have already been set by the store when the before hooks
+// run and must not be changed by them. Validate and the before hooks must not call the store, which may be
+// locked (MemoryResourceStore) or in the middle of a transaction while they run.
+//
+// An error from Validate, BeforeCreate or BeforeUpdate stops the write. A ResourceError is returned as it is,
+// other errors become validation errors (so a router responds 422) and keep their field errors when they have
+// them (see problemDetails.FieldErrorer and NewValidationError).
+//
+// Example usage from a service:
+//
+//	func (e *EmployeeResource) Validate() error {
+//		if e.Age < 16 {
+//			return resourceStore.NewValidationError("the employee is invalid", problemDetails.FieldError{Field: "age", Message: "must be at least 16"})
+//		}
+//		return nil
+//	}
+
+// Validator checks a resource before it is created or updated, after the Before hooks
+type Validator interface {
+	Validate() error
+}
+
+// BeforeCreateHook is called before a resource is created, e.g. to fill in defaults
+type BeforeCreateHook interface {
+	BeforeCreate(ctx context.Context) error
+}
+
+// BeforeUpdateHook is called before a resource replaces old, the version currently stored (e.g. to reject a change
+// of a field that can't change)
+type BeforeUpdateHook interface {
+	BeforeUpdate(ctx context.Context, old IResource) error
+}
+
+// AfterCommitHook is called once a create, update, delete or undelete of the resource has been committed
+// (ResourceBase.LastAction says which). For a write made in InTx or a bulk call it is called after the whole
+// unit of work commits. It can't fail the write, which has already happened.
+type AfterCommitHook interface {
+	AfterCommit(ctx context.Context)
+}
+
+// beforeCreate runs the hooks of a resource about to be created
+func beforeCreate(ctx context.Context, resource IResource, methodName string) (int, error) {
+	if hook, ok := resource.(BeforeCreateHook); ok {
+		if err := hook.BeforeCreate(ctx); err != nil {
+			return hookError(err, "BeforeCreate", methodName)
+		}
+	}
+	return validateResource(resource, methodName)
+}
+
+// beforeUpdate runs the hooks of a resource about to replace the stored version. loadOld is only called when the
+// resource has a BeforeUpdate hook, and returns the stored resource or the error the update would fail with.
+func beforeUpdate(ctx context.Context, resource IResource, loadOld func() (IResource, int, error), methodName string) (int, error) {
+	if hook, ok := resource.(BeforeUpdateHook); ok {
+		old, status, err := loadOld()
+		if err != nil {
+			return status, err
+		}
+		if err := hook.BeforeUpdate(ctx, old); err != nil {
+			return hookError(err, "BeforeUpdate", methodName)
+		}
+	}
+	return validateResource(resource, methodName)
+}
+
+func validateResource(resource IResource, methodName string) (int, error) {
+	if validator, ok := resource.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return hookError(err, "Validate", methodName)
+		}
+	}
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// validateSchema checks the JSON of a resource about to be saved against the store's schema (if it has one)
+func validateSchema(schema *JsonSchema, jsonResource []byte, methodName string) (int, error) {
+	if schema == nil {
+		return constants.RESOURCE_OK_CODE, nil
+	}
+	if fieldErrors := schema.Validate(jsonResource); len(fieldErrors) > 0 {
+		return constants.RESOURCE_BAD_REQUEST_CODE, NewValidationError(fmt.Sprintf("resource store - the resource does not match the schema in %s", methodName), fieldErrors...)
+	}
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// hookError turns an error from a hook into the error the write fails with
+func hookError(err error, hookName string, methodName string) (int, error) {
+	var resourceErr *ResourceError
+	if errors.As(err, &resourceErr) {
+		return resourceErr.Status(), err
+	}
+
+	validationErr := NewValidationError(fmt.Sprintf("resource store - %s failed in %s: %s", hookName, methodName, err.Error()))
+	var fieldErrorer problemDetails.FieldErrorer
+	if errors.As(err, &fieldErrorer) {
+		validationErr.Fields = fieldErrorer.FieldErrors()
+	}
+	return constants.RESOURCE_BAD_REQUEST_CODE, validationErr
+}
+
+// storedForUpdate is the result of loadOld for a lookup of the stored resource (deleted or not) that an update
+// expecting expectedVersion replaces
+func storedForUpdate[R any](status int, err error, stored *R, expectedVersion uint, resourceId string, methodName string) (IResource, int, error) {
+	if status == constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		status, err := noRowsError(false, 0, expectedVersion, resourceId, methodName)
+		return nil, status, err
+	}
+	if err != nil {
+		return nil, status, err
+	}
+
+	old := any(stored).(IResource)
+	if old.GetResourceBase().Version != expectedVersion {
+		status, err := noRowsError(true, old.GetResourceBase().Version, expectedVersion, resourceId, methodName)
+		return nil, status, err
+	}
+	return old, constants.RESOURCE_OK_CODE, nil
+}
+
+// afterCommit runs the AfterCommit hooks of the resources written by a committed write
+func afterCommit(ctx context.Context, resources ...IResource) {
+	for _, resource := range resources {
+		if hook, ok := resource.(AfterCommitHook); ok {
+			hook.AfterCommit(ctx)
+		}
+	}
+}
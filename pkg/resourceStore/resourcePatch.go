@@ -0,0 +1,434 @@
+package resourceStore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+)
+
+// PatchType is the format of a patch passed to PatchResource - the media type a client sends it as
+type PatchType string
+
+const (
+	PATCH_TYPE_MERGE_PATCH PatchType = "application/merge-patch+json" // RFC 7396
+	PATCH_TYPE_JSON_PATCH  PatchType = "application/json-patch+json"  // RFC 6902
+)
+
+// resourceBaseFields are the JSON names of the ResourceBase fields, which only the store may change
+var resourceBaseFields = []string{"id", "ownerId", "version", "createdAt", "updatedAt", "updatedBy", "impersonatedBy", "lastAction", "deleted"}
+
+// PatchResource applies a patch to the stored version of a resource, provided it is still expectedVersion, and
+// saves the result as the next version (journaled like UpdateResource). The patch is applied to the current row
+// inside a transaction, so a client with a partial view of the resource can't clobber fields it didn't load.
+// A patch that changes a ResourceBase field, or that leaves a document R can't be decoded from, is a bad request.
+func (store *PostgresResourceStoreWithJournal[R]) PatchResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, patch []byte, patchType PatchType, extractedAuth string) (IResource, int, error) {
+	return patchResource(ctx, store, ownerId, resourceId, expectedVersion, patch, patchType, extractedAuth)
+}
+
+// patchResource implements PatchResource on top of a store's InTx
+func patchResource[R any](ctx context.Context, store IResourceStore[R], ownerId string, resourceId string, expectedVersion uint, patch []byte, patchType PatchType, extractedAuth string) (IResource, int, error) {
+	if patchType != PATCH_TYPE_MERGE_PATCH && patchType != PATCH_TYPE_JSON_PATCH {
+		err := NewResourceError(ERROR_KIND_VALIDATION, fmt.Sprintf("resource store - unsupported patch type '%s' in PatchResource", patchType), nil)
+		err.status = constants.RESOURCE_UNSUPPORTED_TYPE_CODE
+		return nil, err.Status(), err
+	}
+
+	// the errors returned by fn are ResourceErrors, so InTx returns the status of their kind
+	var patchedResource IResource
+	status, err := store.InTx(ctx, func(tx ResourceTx[R]) error {
+		var current R
+		if _, err := tx.GetById(ownerId, resourceId, &current); err != nil {
+			return err
+		}
+		currentResource, ok := any(&current).(IResource)
+		if !ok {
+			return internalError(errors.New("resource store - the resource type is missing an embedded ResourceBase struct in PatchResource"))
+		}
+		if storedVersion := currentResource.GetResourceBase().Version; storedVersion != expectedVersion {
+			_, err := noRowsError(true, storedVersion, expectedVersion, resourceId, "PatchResource")
+			return err
+		}
+
+		currentJson, err := json.Marshal(&current)
+		if err != nil {
+			return internalError(fmt.Errorf("resource store - error serializing resource in PatchResource: %w", err))
+		}
+		patchedJson, err := ApplyPatch(currentJson, patch, patchType)
+		if err != nil {
+			return validationError("resource store - invalid patch in PatchResource: %v", err)
+		}
+		if err := checkResourceBaseUnchanged(currentJson, patchedJson); err != nil {
+			return validationError("resource store - invalid patch in PatchResource: %v", err)
+		}
+
+		patched := new(R)
+		if err := json.Unmarshal(patchedJson, patched); err != nil {
+			return validationError("resource store - the patched resource is not valid in PatchResource: %v", err)
+		}
+
+		updatedResource, _, err := tx.UpdateResource(any(patched).(IResource), ownerId, resourceId, extractedAuth)
+		patchedResource = updatedResource
+		return err
+	})
+	if err != nil {
+		return nil, status, err
+	}
+
+	return patchedResource, constants.RESOURCE_OK_CODE, nil
+}
+
+// ApplyPatch applies a merge patch (RFC 7396) or a JSON patch (RFC 6902) to a JSON document and returns the result
+func ApplyPatch(document []byte, patch []byte, patchType PatchType) ([]byte, error) {
+	doc, err := decodeJsonValue(document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+
+	switch patchType {
+	case PATCH_TYPE_MERGE_PATCH:
+		mergePatch, err := decodeJsonValue(patch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON in merge patch: %w", err)
+		}
+		doc = applyMergePatch(doc, mergePatch)
+	case PATCH_TYPE_JSON_PATCH:
+		var operations []jsonPatchOperation
+		decoder := json.NewDecoder(bytes.NewReader(patch))
+		decoder.UseNumber()
+		if err := decoder.Decode(&operations); err != nil {
+			return nil, fmt.Errorf("invalid JSON patch - expected an array of operations: %w", err)
+		}
+		for i, operation := range operations {
+			if doc, err = operation.apply(doc); err != nil {
+				return nil, fmt.Errorf("JSON patch operation %d (%s %s) failed: %w", i, operation.Op, operation.Path, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported patch type '%s' - expected %s or %s", patchType, PATCH_TYPE_MERGE_PATCH, PATCH_TYPE_JSON_PATCH)
+	}
+
+	return json.Marshal(doc)
+}
+
+// checkResourceBaseUnchanged rejects a patched document whose ResourceBase fields differ from the original's
+func checkResourceBaseUnchanged(original []byte, patched []byte) error {
+	var originalFields, patchedFields map[string]any
+	if err := json.Unmarshal(original, &originalFields); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(patched, &patchedFields); err != nil {
+		return errors.New("the patched resource must be a JSON object")
+	}
+	for _, field := range resourceBaseFields {
+		if !reflect.DeepEqual(originalFields[field], patchedFields[field]) {
+			return fmt.Errorf("the '%s' field can't be changed by a patch", field)
+		}
+	}
+	return nil
+}
+
+func decodeJsonValue(data []byte) (any, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// applyMergePatch merges patch into target as described by RFC 7396 - null removes a member, objects are
+// merged recursively and any other value replaces the target
+func applyMergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = applyMergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// jsonPatchOperation is one operation of an RFC 6902 JSON patch
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"` // empty when missing, "null" for a null value
+}
+
+func (o jsonPatchOperation) apply(doc any) (any, error) {
+	path, err := parseJsonPointer(o.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.Op {
+	case "add", "replace", "test":
+		if len(o.Value) == 0 {
+			return nil, errors.New("missing 'value'")
+		}
+		value, err := decodeJsonValue(o.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'value': %w", err)
+		}
+		switch o.Op {
+		case "add":
+			return addJsonValue(doc, path, value)
+		case "replace":
+			if _, err := getJsonValue(doc, path); err != nil {
+				return nil, err
+			}
+			if len(path) == 0 {
+				return value, nil
+			}
+			if doc, err = removeJsonValue(doc, path); err != nil {
+				return nil, err
+			}
+			return addJsonValue(doc, path, value)
+		default:
+			current, err := getJsonValue(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !jsonValuesEqual(current, value) {
+				return nil, errors.New("the value does not match")
+			}
+			return doc, nil
+		}
+	case "remove":
+		return removeJsonValue(doc, path)
+	case "move", "copy":
+		from, err := parseJsonPointer(o.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'from': %w", err)
+		}
+		value, err := getJsonValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if o.Op == "move" {
+			if len(path) > len(from) && slices.Equal(path[:len(from)], from) {
+				return nil, errors.New("a value can't be moved into itself")
+			}
+			if doc, err = removeJsonValue(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			// copy the value so later operations on one location don't change the other
+			if value, err = copyJsonValue(value); err != nil {
+				return nil, err
+			}
+		}
+		return addJsonValue(doc, path, value)
+	default:
+		return nil, fmt.Errorf("unknown operation '%s'", o.Op)
+	}
+}
+
+// parseJsonPointer splits an RFC 6901 JSON pointer into its unescaped reference tokens
+func parseJsonPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer '%s' - expected it to start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func getJsonValue(doc any, path []string) (any, error) {
+	current := doc
+	for _, token := range path {
+		switch container := current.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path member '%s' does not exist", token)
+			}
+			current = value
+		case []any:
+			index, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			current = container[index]
+		default:
+			return nil, fmt.Errorf("path member '%s' does not exist", token)
+		}
+	}
+	return current, nil
+}
+
+// addJsonValue adds value at path, replacing an existing object member or inserting into an array
+func addJsonValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := getJsonValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]any:
+		container[token] = value
+		return doc, nil
+	case []any:
+		index, err := arrayIndex(token, len(container), true)
+		if err != nil {
+			return nil, err
+		}
+		container = append(container, nil)
+		copy(container[index+1:], container[index:])
+		container[index] = value
+		return setJsonValue(doc, path[:len(path)-1], container)
+	default:
+		return nil, fmt.Errorf("the parent of path member '%s' is not an object or an array", token)
+	}
+}
+
+func removeJsonValue(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("the whole document can't be removed")
+	}
+	parent, err := getJsonValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]any:
+		if _, ok := container[token]; !ok {
+			return nil, fmt.Errorf("path member '%s' does not exist", token)
+		}
+		delete(container, token)
+		return doc, nil
+	case []any:
+		index, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		return setJsonValue(doc, path[:len(path)-1], append(container[:index:index], container[index+1:]...))
+	default:
+		return nil, fmt.Errorf("path member '%s' does not exist", token)
+	}
+}
+
+// setJsonValue replaces the value at an existing path - used to store an array whose length changed
+func setJsonValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := getJsonValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]any:
+		container[token] = value
+	case []any:
+		index, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = value
+	}
+	return doc, nil
+}
+
+// arrayIndex parses an array reference token. "-" (the end of the array) and length are only valid for an add.
+func arrayIndex(token string, length int, forAdd bool) (int, error) {
+	if token == "-" && forAdd {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	if index > length || (index == length && !forAdd) {
+		return 0, fmt.Errorf("array index %d is out of range", index)
+	}
+	return index, nil
+}
+
+func copyJsonValue(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJsonValue(data)
+}
+
+// jsonValuesEqual compares decoded JSON values, treating numbers as equal when their values are
+func jsonValuesEqual(a any, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == b {
+			return true
+		}
+		af, errA := a.Float64()
+		bf, errB := b.Float64()
+		return errA == nil && errB == nil && af == bf
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for name, value := range a {
+			other, ok := b[name]
+			if !ok || !jsonValuesEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonValuesEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
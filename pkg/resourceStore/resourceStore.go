@@ -0,0 +1,53 @@
+package resourceStore
+
+import (
+	"context"
+	"io"
+)
+
+// IResourceStore is the storage-agnostic API of a resource store. PostgresResourceStoreWithJournal is the
+// production implementation and MemoryResourceStore is a drop-in replacement for unit tests. Both return the
+// same constants.RESOURCE_*_CODE values for the same situations.
+type IResourceStore[R any] interface {
+	GetById(ctx context.Context, ownerId string, id string, resource *R) (int, error)
+	GetByIdIncludingDeleted(ctx context.Context, ownerId string, id string, resource *R) (int, error)
+	GetByOwnerId(ctx context.Context, ownerId string, resources *[]R) (int, error)
+	QueryByOwnerId(ctx context.Context, ownerId string, query ResourceQuery, resources *[]R) (string, int, error)
+
+	GetHistory(ctx context.Context, ownerId string, id string, page HistoryPage, history *[]R) (int, error)
+	GetVersion(ctx context.Context, ownerId string, id string, version uint, resource *R) (int, error)
+	GetByIdAsOf(ctx context.Context, ownerId string, id string, asOf AsOf, resource *R) (int, error)
+
+	CreateResource(ctx context.Context, resource IResource, extractedAuth string) (IResource, int, error)
+	UpdateResource(ctx context.Context, resource IResource, ownerId string, resourceId string, extractedAuth string) (IResource, int, error)
+	DeleteResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error)
+	UndeleteResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error)
+	PatchResource(ctx context.Context, ownerId string, resourceId string, expectedVersion uint, patch []byte, patchType PatchType, extractedAuth string) (IResource, int, error)
+	InTx(ctx context.Context, fn func(tx ResourceTx[R]) error) (int, error)
+	CreateResources(ctx context.Context, resources []IResource, extractedAuth string) ([]BulkResult, int, error)
+	UpsertResources(ctx context.Context, resources []IResource, extractedAuth string) ([]BulkResult, int, error)
+
+	GetJournalChanges(ctx context.Context, clock int64, limit int64, journalEntries *[]ResourceJournalEntry) error
+	GetJournalMaxClock(ctx context.Context, maxClock *uint64) error
+	GetJournalMinClock(ctx context.Context, minClock *uint64) error
+	GetPartitionJournalChanges(ctx context.Context, partitionName string, clock int64, limit int64, journalEntries *[]ResourceJournalEntry) error
+	GetPartitionJournalMaxClock(ctx context.Context, partitionName string, maxClock *uint64) error
+	GetJournalClocks(ctx context.Context, clocks *JournalClockVector) error
+	GetMergedJournalChanges(ctx context.Context, since JournalClockVector, limit int64, journalEntries *[]ResourceJournalEntry) (JournalClockVector, error)
+	JournalPartitionName() string
+	SubscribeJournal(ctx context.Context, fromClock uint64) <-chan ResourceJournalEntry
+	ApplyJournalRetention(ctx context.Context, policy RetentionPolicy) (int64, error)
+
+	ExportJournal(ctx context.Context, journalRange JournalRange, w io.Writer) (int64, error)
+	ImportJournal(ctx context.Context, r io.Reader) (int64, error)
+	RebuildResources(ctx context.Context) (int64, error)
+	CheckConsistency(ctx context.Context, inconsistencies *[]ResourceInconsistency) error
+
+	SetSchema(schema *JsonSchema)
+	HealthCheck(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// compile-time checks that both stores implement the full interface
+var _ IResourceStore[ResourceBase] = (*PostgresResourceStoreWithJournal[ResourceBase])(nil)
+var _ IResourceStore[ResourceBase] = (*MemoryResourceStore[ResourceBase])(nil)
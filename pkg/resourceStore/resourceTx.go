@@ -0,0 +1,290 @@
+package resourceStore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ResourceTx is the set of store operations that can be grouped into one unit of work with InTx. The methods
+// mirror the store methods of the same name (minus the ctx, which comes from InTx) and return the same codes.
+type ResourceTx[R any] interface {
+	GetById(ownerId string, id string, resource *R) (int, error)
+	CreateResource(resource IResource, extractedAuth string) (IResource, int, error)
+	UpdateResource(resource IResource, ownerId string, resourceId string, extractedAuth string) (IResource, int, error)
+	DeleteResource(ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error)
+	UndeleteResource(ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error)
+}
+
+// pendingJournalEntry is a journal row buffered by a postgresResourceTx until commit
+type pendingJournalEntry struct {
+	resource     []byte
+	resourceBase ResourceBase
+}
+
+type postgresResourceTx[R any] struct {
+	store      *PostgresResourceStoreWithJournal[R]
+	ctx        context.Context
+	tx         pgx.Tx
+	journal    []pendingJournalEntry
+	written    []IResource // for their AfterCommit hooks
+	failStatus int
+	failErr    error
+}
+
+// InTx runs fn as a single unit of work. Every create, update and delete made through the ResourceTx commits
+// together, and their journal rows are written with contiguous clocks in the order the operations were made.
+// The transaction is rolled back if fn returns an error or if any operation fails (e.g. on a version conflict),
+// even when fn ignores that failure. The journal is locked for the whole of fn, so other writes to the store wait
+// until the unit of work ends - keep fn short.
+//
+// Example usage from a service (moving an item between two owners):
+//
+//	status, err := store.InTx(ctx, func(tx resourceStore.ResourceTx[ItemResource]) error {
+//		if _, _, err := tx.DeleteResource(fromOwner, itemId, version, auth); err != nil {
+//			return err
+//		}
+//		item.OwnerId = toOwner
+//		_, _, err := tx.CreateResource(item, auth)
+//		return err
+//	})
+func (store *PostgresResourceStoreWithJournal[R]) InTx(ctx context.Context, fn func(tx ResourceTx[R]) error) (int, error) {
+	tx, err := store.dbPool.Begin(ctx)
+	if err != nil {
+		store.logger.Error("resource store - error starting transaction in InTx: ", err)
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+	// no-op once the transaction has been committed
+	defer tx.Rollback(context.Background())
+
+	// the journal lock comes before any row lock taken by fn, in the same order as the single writes
+	// (see writeJournaled), so that the two can't deadlock
+	if _, err := tx.Exec(ctx, store.Cmds.GetLockJournalCommand()); err != nil {
+		store.logger.Error("resource store - error locking the journal in InTx: ", err)
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+
+	resourceTx := &postgresResourceTx[R]{store: store, ctx: ctx, tx: tx}
+
+	if err := fn(resourceTx); err != nil {
+		if resourceTx.failErr != nil {
+			return resourceTx.failStatus, resourceTx.failErr
+		}
+		// an error of fn's own keeps its status when it is a ResourceError (e.g. from tx.GetById)
+		return statusOf(err, constants.RESOURCE_BAD_REQUEST_CODE), err
+	}
+	if resourceTx.failErr != nil {
+		return resourceTx.failStatus, resourceTx.failErr
+	}
+
+	if len(resourceTx.journal) > 0 {
+		batch := &pgx.Batch{}
+		for _, entry := range resourceTx.journal {
+			query, params := store.Cmds.GetInsertJournalCommand(&entry.resourceBase, entry.resource, store.journalPartitionName)
+			batch.Queue(query, params)
+		}
+		notifyQuery, notifyParams := store.Cmds.GetNotifyJournalCommand(store.journalPartitionName)
+		batch.Queue(notifyQuery, notifyParams)
+		if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+			store.logger.Error("resource store - error writing journal entries in InTx: ", err)
+			return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		store.logger.Error("resource store - error committing transaction in InTx: ", err)
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+	}
+
+	afterCommit(ctx, resourceTx.written...)
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+// fail records the first failure in the unit of work so that InTx rolls back regardless of what fn returns
+func (t *postgresResourceTx[R]) fail(status int, err error) (IResource, int, error) {
+	if t.failErr == nil {
+		t.failStatus = status
+		t.failErr = err
+	}
+	return nil, status, err
+}
+
+// failedAlready stops further work once an operation has failed - postgres rejects statements in an aborted transaction
+func (t *postgresResourceTx[R]) failedAlready() (IResource, int, error) {
+	return nil, t.failStatus, fmt.Errorf("resource store - transaction already failed: %w", t.failErr)
+}
+
+func (t *postgresResourceTx[R]) GetById(ownerId string, id string, resource *R) (int, error) {
+	if t.failErr != nil {
+		_, status, err := t.failedAlready()
+		return status, err
+	}
+
+	query, params := t.store.Cmds.GetResourceByIdForUpdateCommand(id, ownerId, false)
+
+	var resourceData []byte
+	err := t.tx.QueryRow(t.ctx, query, params).Scan(&resourceData)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// not found is an answer, not a failure of the unit of work
+		return constants.RESOURCE_NOT_FOUND_ERROR_CODE, notFoundError("resource store - resource not found: %v", id)
+	}
+	if err != nil {
+		t.store.logger.Error("resource store - error detected on GetById query in InTx: ", err)
+		_, status, err := t.fail(constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err))
+		return status, err
+	}
+
+	if err := json.Unmarshal(resourceData, resource); err != nil {
+		_, status, err := t.fail(constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in GetById: %w", err)))
+		return status, err
+	}
+
+	return constants.RESOURCE_OK_CODE, nil
+}
+
+func (t *postgresResourceTx[R]) CreateResource(resource IResource, extractedAuth string) (IResource, int, error) {
+	if t.failErr != nil {
+		return t.failedAlready()
+	}
+
+	jsonResource, status, err := prepareCreate(t.ctx, resource, extractedAuth, t.store.schema, "CreateResource")
+	if err != nil {
+		return t.fail(status, err)
+	}
+
+	query, params := t.store.Cmds.GetInsertResourceCommand(resource, jsonResource)
+
+	var resourceData []byte
+	err = t.tx.QueryRow(t.ctx, query, params).Scan(&resourceData)
+	if err != nil {
+		t.store.logger.Error("resource store - error detected on db insert in CreateResource in InTx: ", err)
+
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == constants.PRIMARY_KEY_VIOLATION_SQL_CODE {
+			return t.fail(constants.RESOURCE_ALREADY_EXISTS_CODE, conflictError("resource store - resource save failed for %v in CreateResource due to duplicate key", resource.GetResourceBase().Id))
+		}
+		return t.fail(constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err))
+	}
+
+	t.journal = append(t.journal, pendingJournalEntry{resource: resourceData, resourceBase: *resource.GetResourceBase()})
+	t.written = append(t.written, resource)
+	return resource, constants.RESOURCE_OK_CODE, nil
+}
+
+func (t *postgresResourceTx[R]) UpdateResource(resource IResource, ownerId string, resourceId string, extractedAuth string) (IResource, int, error) {
+	if t.failErr != nil {
+		return t.failedAlready()
+	}
+
+	versionToUpdate, jsonResource, status, err := prepareUpdate(t.ctx, resource, ownerId, resourceId, extractedAuth, t.store.schema, t.loadForUpdate(ownerId, resourceId, "UpdateResource"), "UpdateResource")
+	if err != nil {
+		return t.fail(status, err)
+	}
+
+	query, params := t.store.Cmds.GetUpdateResourceCommand(resource, versionToUpdate, jsonResource)
+
+	var resourceData []byte
+	err = t.tx.QueryRow(t.ctx, query, params).Scan(&resourceData)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return t.explainNoRows(ownerId, resourceId, versionToUpdate, false, "UpdateResource")
+	}
+	if err != nil {
+		t.store.logger.Error("resource store - error detected on db update in UpdateResource in InTx: ", err)
+		return t.fail(constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err))
+	}
+
+	t.journal = append(t.journal, pendingJournalEntry{resource: resourceData, resourceBase: *resource.GetResourceBase()})
+	t.written = append(t.written, resource)
+	return resource, constants.RESOURCE_OK_CODE, nil
+}
+
+// loadForUpdate returns the loadOld of prepareUpdate, locking the stored row like GetById
+func (t *postgresResourceTx[R]) loadForUpdate(ownerId string, resourceId string, methodName string) func(expectedVersion uint) (IResource, int, error) {
+	return func(expectedVersion uint) (IResource, int, error) {
+		query, params := t.store.Cmds.GetResourceByIdForUpdateCommand(resourceId, ownerId, false)
+
+		var resourceData []byte
+		err := t.tx.QueryRow(t.ctx, query, params).Scan(&resourceData)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storedForUpdate[R](constants.RESOURCE_NOT_FOUND_ERROR_CODE, nil, nil, expectedVersion, resourceId, methodName)
+		}
+		if err != nil {
+			t.store.logger.Errorf("resource store - error detected on lookup in %s in InTx: %v", methodName, err)
+			return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err)
+		}
+
+		stored := new(R)
+		if err := json.Unmarshal(resourceData, stored); err != nil {
+			return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in %s: %w", methodName, err))
+		}
+		return storedForUpdate(constants.RESOURCE_OK_CODE, nil, stored, expectedVersion, resourceId, methodName)
+	}
+}
+
+func (t *postgresResourceTx[R]) DeleteResource(ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error) {
+	return t.setDeleted(ownerId, resourceId, expectedVersion, true, extractedAuth)
+}
+
+func (t *postgresResourceTx[R]) UndeleteResource(ownerId string, resourceId string, expectedVersion uint, extractedAuth string) (IResource, int, error) {
+	return t.setDeleted(ownerId, resourceId, expectedVersion, false, extractedAuth)
+}
+
+func (t *postgresResourceTx[R]) setDeleted(ownerId string, resourceId string, expectedVersion uint, deleted bool, extractedAuth string) (IResource, int, error) {
+	if t.failErr != nil {
+		return t.failedAlready()
+	}
+	methodName := setDeletedMethodName(deleted)
+
+	patch, jsonPatch, status, err := prepareSetDeleted(expectedVersion, deleted, extractedAuth, methodName)
+	if err != nil {
+		return t.fail(status, err)
+	}
+
+	query, params := t.store.Cmds.GetSetDeletedCommand(ownerId, resourceId, expectedVersion, deleted, jsonPatch, patch.UpdatedAt)
+
+	var resourceData []byte
+	err = t.tx.QueryRow(t.ctx, query, params).Scan(&resourceData)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// figure out why nothing was changed so the caller gets a meaningful status
+		return t.explainNoRows(ownerId, resourceId, expectedVersion, true, methodName)
+	}
+	if err != nil {
+		t.store.logger.Errorf("resource store - error detected on db update in %s in InTx: %v", methodName, err)
+		return t.fail(constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err))
+	}
+
+	resource, status, err := t.store.unmarshalResource(resourceData, methodName)
+	if err != nil {
+		return t.fail(status, err)
+	}
+
+	t.journal = append(t.journal, pendingJournalEntry{resource: resourceData, resourceBase: *resource.GetResourceBase()})
+	t.written = append(t.written, resource)
+	return resource, constants.RESOURCE_OK_CODE, nil
+}
+
+// explainNoRows looks up a resource that a conditional write didn't change and fails the unit of work with the
+// reason (see noRowsError and PostgresResourceStoreWithJournal.explainNoRows)
+func (t *postgresResourceTx[R]) explainNoRows(ownerId string, resourceId string, expectedVersion uint, includeDeleted bool, methodName string) (IResource, int, error) {
+	query, params := t.store.Cmds.GetResourceByIdForUpdateCommand(resourceId, ownerId, includeDeleted)
+
+	var resourceData []byte
+	err := t.tx.QueryRow(t.ctx, query, params).Scan(&resourceData)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return t.fail(noRowsError(false, 0, expectedVersion, resourceId, methodName))
+	}
+	if err != nil {
+		t.store.logger.Errorf("resource store - error detected on lookup in %s in InTx: %v", methodName, err)
+		return t.fail(constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(err))
+	}
+
+	var stored ResourceBase
+	if err := json.Unmarshal(resourceData, &stored); err != nil {
+		return t.fail(constants.RESOURCE_INTERNAL_ERROR_CODE, internalError(fmt.Errorf("resource store - error unmarshaling JSON in %s: %w", methodName, err)))
+	}
+	return t.fail(noRowsError(true, stored.Version, expectedVersion, resourceId, methodName))
+}
@@ -0,0 +1,247 @@
+package resourceStore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/backoff"
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	RETRY_DEFAULT_MAX_ATTEMPTS    = 3
+	RETRY_DEFAULT_INITIAL_BACKOFF = 50 * time.Millisecond
+	RETRY_DEFAULT_MAX_BACKOFF     = time.Second
+)
+
+type FailureClass int
+
+const (
+	FAILURE_PERMANENT  FailureClass = iota // retrying won't help (e.g. a constraint violation or a bad query)
+	FAILURE_TRANSIENT                      // the statement didn't take effect and may succeed if run again
+	FAILURE_CONNECTION                     // the connection failed after the statement was sent - it may or may not have taken effect
+)
+
+// transientSqlCodes are the postgres errors that leave nothing behind and are worth another attempt
+var transientSqlCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown - e.g. the server is restarting for a failover
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now - the server is starting up
+	"25006": true, // read_only_sql_transaction - a write reached a server that has just been demoted to a replica
+}
+
+// ClassifyError says whether a failed database operation can be run again. An error from the server is
+// transient when its code is one of the transient codes or is in class 08 (connection exception). An error
+// that happened before anything was sent is always transient, while a connection lost after the statement was
+// sent is FAILURE_CONNECTION.
+func ClassifyError(err error) FailureClass {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return FAILURE_PERMANENT
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if transientSqlCodes[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08") {
+			return FAILURE_TRANSIENT
+		}
+		return FAILURE_PERMANENT
+	}
+
+	if pgconn.SafeToRetry(err) {
+		return FAILURE_TRANSIENT
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) {
+		return FAILURE_TRANSIENT
+	}
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return FAILURE_CONNECTION
+	}
+	return FAILURE_PERMANENT
+}
+
+// RetryPolicy bounds the retries of a PostgresResourceStoreWithJournal. Reads are retried on transient and
+// connection failures. The single statement writes (CreateResource, UpdateResource, DeleteResource and
+// UndeleteResource, whose journal row is written by the same statement) are only retried on transient failures,
+// since a write whose connection was lost may already have been applied. InTx and the bulk writes are never
+// retried - they are multi-statement transactions that the caller should run again as a whole.
+type RetryPolicy struct {
+	MaxAttempts    int           // including the first - 1 turns retries off (defaults to 3)
+	InitialBackoff time.Duration // the wait before the first retry, doubled for each retry after it (defaults to 50ms)
+	MaxBackoff     time.Duration // the longest wait between attempts (defaults to 1s)
+}
+
+// RetryPolicyFromConfig reads DB_RETRY_MAX_ATTEMPTS, DB_RETRY_INITIAL_BACKOFF and DB_RETRY_MAX_BACKOFF
+func RetryPolicyFromConfig(configuration *viper.Viper) (RetryPolicy, error) {
+	var policy RetryPolicy
+	var err error
+	if maxAttempts := configuration.GetString(constants.DB_RETRY_MAX_ATTEMPTS); maxAttempts != "" {
+		if policy.MaxAttempts, err = strconv.Atoi(maxAttempts); err != nil {
+			return policy, fmt.Errorf("resource store - invalid %s: %w", constants.DB_RETRY_MAX_ATTEMPTS, err)
+		}
+		if policy.MaxAttempts < 1 {
+			return policy, fmt.Errorf("resource store - %s must be at least 1", constants.DB_RETRY_MAX_ATTEMPTS)
+		}
+	}
+	if initialBackoff := configuration.GetString(constants.DB_RETRY_INITIAL_BACKOFF); initialBackoff != "" {
+		if policy.InitialBackoff, err = time.ParseDuration(initialBackoff); err != nil {
+			return policy, fmt.Errorf("resource store - invalid %s: %w", constants.DB_RETRY_INITIAL_BACKOFF, err)
+		}
+	}
+	if maxBackoff := configuration.GetString(constants.DB_RETRY_MAX_BACKOFF); maxBackoff != "" {
+		if policy.MaxBackoff, err = time.ParseDuration(maxBackoff); err != nil {
+			return policy, fmt.Errorf("resource store - invalid %s: %w", constants.DB_RETRY_MAX_BACKOFF, err)
+		}
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate fills in the RETRY_DEFAULT_ attempts and backoffs that aren't set and rejects a policy that can't retry
+func (p *RetryPolicy) Validate() error {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = RETRY_DEFAULT_MAX_ATTEMPTS
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = RETRY_DEFAULT_INITIAL_BACKOFF
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = RETRY_DEFAULT_MAX_BACKOFF
+	}
+	if p.MaxAttempts < 1 || p.InitialBackoff < 0 || p.MaxBackoff < p.InitialBackoff {
+		return errors.New("resource store - the retry attempts must be at least 1 and the max backoff can't be less than the initial backoff")
+	}
+	return nil
+}
+
+// Backoff is the wait before retry number retry (1 for the first), with jitter (see backoff.Exponential)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	return backoff.Exponential(p.InitialBackoff, p.MaxBackoff, retry)
+}
+
+// RetryStats counts the store's retries since it was created
+type RetryStats struct {
+	Retries   uint64            `json:"retries"`   // attempts after the first
+	Recovered uint64            `json:"recovered"` // operations that succeeded after a retry
+	Exhausted uint64            `json:"exhausted"` // operations still failing transiently after the last attempt
+	ByMethod  map[string]uint64 `json:"byMethod"`  // retries by store method
+}
+
+type retryMetrics struct {
+	mu    sync.Mutex
+	stats RetryStats
+}
+
+func (m *retryMetrics) record(methodName string, retries int, recovered bool, exhausted bool) {
+	if retries == 0 && !exhausted {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stats.ByMethod == nil {
+		m.stats.ByMethod = map[string]uint64{}
+	}
+	m.stats.Retries += uint64(retries)
+	m.stats.ByMethod[methodName] += uint64(retries)
+	if recovered {
+		m.stats.Recovered++
+	}
+	if exhausted {
+		m.stats.Exhausted++
+	}
+}
+
+func (m *retryMetrics) snapshot() RetryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.ByMethod = map[string]uint64{}
+	for methodName, retries := range m.stats.ByMethod {
+		stats.ByMethod[methodName] = retries
+	}
+	return stats
+}
+
+// Retrier runs operations under a RetryPolicy and counts the retries it makes. Every
+// PostgresResourceStoreWithJournal has one, and one can be used on its own around other calls to Postgres.
+type Retrier struct {
+	policy  RetryPolicy
+	logger  *logrus.Logger
+	metrics retryMetrics
+}
+
+func NewRetrier(policy RetryPolicy, logger *logrus.Logger) (*Retrier, error) {
+	if logger == nil {
+		return nil, fmt.Errorf("resource store - invalid nil logger detected")
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &Retrier{policy: policy, logger: logger}, nil
+}
+
+// Stats reports the retries made so far
+func (r *Retrier) Stats() RetryStats {
+	return r.metrics.snapshot()
+}
+
+// Do runs fn until it succeeds, fails for good or the policy's attempts run out. idempotent says fn can safely
+// run again after a FAILURE_CONNECTION. The attempts after the first are given a ctx that reads from the primary,
+// in case it was a read replica that failed. methodName is what the retries are counted under in RetryStats. When
+// ctx ends during a backoff the error wraps both ctx.Err() and the last error of fn.
+func (r *Retrier) Do(ctx context.Context, methodName string, idempotent bool, fn func(ctx context.Context) error) error {
+	attemptCtx := ctx
+	for attempt := 1; ; attempt++ {
+		err := fn(attemptCtx)
+		if err == nil {
+			r.metrics.record(methodName, attempt-1, attempt > 1, false)
+			return nil
+		}
+
+		class := ClassifyError(err)
+		if class == FAILURE_PERMANENT || (class == FAILURE_CONNECTION && !idempotent) {
+			r.metrics.record(methodName, attempt-1, false, false)
+			return err
+		}
+		if attempt >= r.policy.MaxAttempts {
+			r.logger.Errorf("resource store - giving up on %s after %d attempts: %v", methodName, attempt, err)
+			r.metrics.record(methodName, attempt-1, false, true)
+			return err
+		}
+
+		backoff := r.policy.Backoff(attempt)
+		r.logger.Infof("resource store - retrying %s in %v after a transient failure: %v", methodName, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			r.metrics.record(methodName, attempt-1, false, false)
+			return fmt.Errorf("resource store - %s stopped waiting to retry: %w (after %w)", methodName, ctx.Err(), err)
+		}
+		attemptCtx = ReadFromPrimary(ctx)
+	}
+}
+
+// RetryStats reports the retries made so far
+func (store *PostgresResourceStoreWithJournal[R]) RetryStats() RetryStats {
+	return store.retrier.Stats()
+}
+
+// withRetry runs fn with the store's Retrier (see Retrier.Do)
+func (store *PostgresResourceStoreWithJournal[R]) withRetry(ctx context.Context, methodName string, idempotent bool, fn func(ctx context.Context) error) error {
+	return store.retrier.Do(ctx, methodName, idempotent, fn)
+}
@@ -1,34 +1,148 @@
 package resourceStore
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
 	"github.com/jackc/pgx/v5"
+	"github.com/spf13/viper"
+)
+
+const (
+	DEFAULT_SCHEMA_NAME     = "public"
+	DEFAULT_RESOURCES_TABLE = "Resources"
+	DEFAULT_JOURNAL_TABLE   = "Journal"
+
+	JOURNAL_WATERMARKS_TABLE  = "JournalWatermarks"  // one per schema, shared by all the journals in it
+	JOURNAL_CHECKPOINTS_TABLE = "JournalCheckpoints" // one per schema, shared by the journal followers that use it
+
+	maxIdentifierLength = 63 // Postgres silently truncates longer identifiers (NAMEDATALEN - 1)
 )
 
-// PostgresCommandHelper handles query building using pgx and named parameters.
+// TableNames locates the tables used by one resource store. The names are quoted, so they are case sensitive,
+// and empty fields take the defaults (public."Resources" and public."Journal").
+type TableNames struct {
+	Schema    string
+	Resources string
+	Journal   string
+}
+
+// TableNamesFromConfig reads the optional DB_SCHEMA, DB_RESOURCES_TABLE and DB_JOURNAL_TABLE configuration keys
+func TableNamesFromConfig(configuration *viper.Viper) TableNames {
+	return TableNames{
+		Schema:    configuration.GetString(constants.DB_SCHEMA_NAME),
+		Resources: configuration.GetString(constants.DB_RESOURCES_TABLE),
+		Journal:   configuration.GetString(constants.DB_JOURNAL_TABLE),
+	}
+}
+
+// NounTableNames derives the tables of a noun so that several resource types can share one database,
+// e.g. the noun "orders" uses "OrdersResources" and "OrdersJournal" in the given schema.
+func NounTableNames(schema string, noun string) TableNames {
+	prefix := noun
+	if r, size := utf8.DecodeRuneInString(noun); r != utf8.RuneError {
+		prefix = string(unicode.ToUpper(r)) + noun[size:]
+	}
+	return TableNames{Schema: schema, Resources: prefix + DEFAULT_RESOURCES_TABLE, Journal: prefix + DEFAULT_JOURNAL_TABLE}
+}
+
+func (t TableNames) withDefaults() TableNames {
+	if t.Schema == "" {
+		t.Schema = DEFAULT_SCHEMA_NAME
+	}
+	if t.Resources == "" {
+		t.Resources = DEFAULT_RESOURCES_TABLE
+	}
+	if t.Journal == "" {
+		t.Journal = DEFAULT_JOURNAL_TABLE
+	}
+	return t
+}
+
+func (t TableNames) validate() error {
+	for _, name := range []string{t.Schema, t.Resources, t.Journal} {
+		if len(name) > maxIdentifierLength {
+			return fmt.Errorf("resource store - the name '%s' is longer than %d bytes", name, maxIdentifierLength)
+		}
+		if !utf8.ValidString(name) || strings.ContainsRune(name, 0) {
+			return fmt.Errorf("resource store - the name '%s' is not a valid identifier", name)
+		}
+	}
+	if t.Resources == t.Journal {
+		return fmt.Errorf("resource store - the resources and journal tables must be different")
+	}
+	return nil
+}
+
+// PostgresCommandHelper handles query building using pgx and named parameters. The zero value uses the
+// default tables - use NewPostgresCommandHelper for any others.
 type PostgresCommandHelper struct {
+	resources  string // schema-qualified and quoted, ready to be used in SQL text
+	journal    string
+	watermarks string
 }
 
-func (p *PostgresCommandHelper) GetResourceByIdCommand(id string, ownerId string) (string, pgx.NamedArgs) {
-	query := `
+func NewPostgresCommandHelper(tables TableNames) (*PostgresCommandHelper, error) {
+	tables = tables.withDefaults()
+	if err := tables.validate(); err != nil {
+		return nil, err
+	}
+	return &PostgresCommandHelper{
+		resources:  pgx.Identifier{tables.Schema, tables.Resources}.Sanitize(),
+		journal:    pgx.Identifier{tables.Schema, tables.Journal}.Sanitize(),
+		watermarks: pgx.Identifier{tables.Schema, JOURNAL_WATERMARKS_TABLE}.Sanitize(),
+	}, nil
+}
+
+func (p *PostgresCommandHelper) resourcesTable() string {
+	if p.resources == "" {
+		return pgx.Identifier{DEFAULT_SCHEMA_NAME, DEFAULT_RESOURCES_TABLE}.Sanitize()
+	}
+	return p.resources
+}
+
+func (p *PostgresCommandHelper) journalTable() string {
+	if p.journal == "" {
+		return pgx.Identifier{DEFAULT_SCHEMA_NAME, DEFAULT_JOURNAL_TABLE}.Sanitize()
+	}
+	return p.journal
+}
+
+func (p *PostgresCommandHelper) watermarksTable() string {
+	if p.watermarks == "" {
+		return pgx.Identifier{DEFAULT_SCHEMA_NAME, JOURNAL_WATERMARKS_TABLE}.Sanitize()
+	}
+	return p.watermarks
+}
+
+func (p *PostgresCommandHelper) GetResourceByIdCommand(id string, ownerId string, includeDeleted bool) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
 		SELECT "Resource"
-		FROM public."Resources"
+		FROM %s
 		WHERE "Id" = @id
-			AND "OwnerId" = @ownerId;
-	`
+			AND "OwnerId" = @ownerId
+			AND ("Deleted" = false OR @includeDeleted);
+	`, p.resourcesTable())
 	args := pgx.NamedArgs{
-		"id":      id,
-		"ownerId": ownerId,
+		"id":             id,
+		"ownerId":        ownerId,
+		"includeDeleted": includeDeleted,
 	}
 	return query, args
 }
 
 func (p *PostgresCommandHelper) GetResourcesByOwnerIdCommand(ownerId string) (string, pgx.NamedArgs) {
-	query := `
+	query := fmt.Sprintf(`
 		SELECT "Resource"
-		FROM public."Resources"
+		FROM %s
 		WHERE "OwnerId" = @ownerId
 			AND "Deleted" = false;
-	`
+	`, p.resourcesTable())
 	args := pgx.NamedArgs{
 		"ownerId": ownerId,
 	}
@@ -36,13 +150,13 @@ func (p *PostgresCommandHelper) GetResourcesByOwnerIdCommand(ownerId string) (st
 }
 
 func (p *PostgresCommandHelper) GetJournalChangesCommand(clock, limit int64) (string, pgx.NamedArgs) {
-	query := `
+	query := fmt.Sprintf(`
 		SELECT "Clock", "Resource", "UpdatedAt", "PartitionName"
-		FROM public."Journal"
+		FROM %s
 		WHERE "Clock" >= @clock
 		ORDER BY "Clock"
 		LIMIT @limit;
-	`
+	`, p.journalTable())
 	args := pgx.NamedArgs{
 		"clock": clock,
 		"limit": limit,
@@ -51,30 +165,93 @@ func (p *PostgresCommandHelper) GetJournalChangesCommand(clock, limit int64) (st
 }
 
 func (p *PostgresCommandHelper) GetJournalMaxClockCommand() string {
-	query := `
+	query := fmt.Sprintf(`
 		SELECT MAX("Clock") AS "Clock"
-		FROM public."Journal";
-	`
+		FROM %s;
+	`, p.journalTable())
 	return query
 }
 
+func (p *PostgresCommandHelper) GetPartitionJournalChangesCommand(partitionName string, clock, limit int64) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT "Clock", "Resource", "UpdatedAt", "PartitionName"
+		FROM %s
+		WHERE "PartitionName" = @partitionName
+			AND "Clock" >= @clock
+		ORDER BY "Clock"
+		LIMIT @limit;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"partitionName": partitionName,
+		"clock":         clock,
+		"limit":         limit,
+	}
+	return query, args
+}
+
+func (p *PostgresCommandHelper) GetPartitionJournalMaxClockCommand(partitionName string) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT COALESCE(MAX("Clock"), 0) AS "Clock"
+		FROM %s
+		WHERE "PartitionName" = @partitionName;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"partitionName": partitionName,
+	}
+	return query, args
+}
+
+func (p *PostgresCommandHelper) GetJournalClocksCommand() string {
+	query := fmt.Sprintf(`
+		SELECT "PartitionName", MAX("Clock") AS "Clock"
+		FROM %s
+		WHERE "PartitionName" IS NOT NULL
+		GROUP BY "PartitionName";
+	`, p.journalTable())
+	return query
+}
+
+// GetMergedJournalChangesCommand reads the entries of every partition after the partition's clock in the since
+// vector (a JSON object of partition name to clock). Partitions that aren't in the vector are read from the start.
+func (p *PostgresCommandHelper) GetMergedJournalChangesCommand(since []byte, limit int64) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT journal."Clock", journal."Resource", journal."UpdatedAt", journal."PartitionName"
+		FROM %s AS journal
+		LEFT JOIN jsonb_each_text(@since::jsonb) AS since ("PartitionName", "Clock")
+			ON since."PartitionName" = journal."PartitionName"
+		WHERE journal."PartitionName" IS NOT NULL
+			AND journal."Clock" > COALESCE(since."Clock"::bigint, 0)
+		ORDER BY journal."Clock"
+		LIMIT @limit;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"since": string(since),
+		"limit": limit,
+	}
+	return query, args
+}
+
 func (p *PostgresCommandHelper) GetInsertResourceWithJournalCommand(resource IResource, resourceJson []byte, partitionName string) (string, pgx.NamedArgs) {
-	query := `
+	query := fmt.Sprintf(`
 		WITH cte AS (
-			INSERT INTO public."Resources"
+			INSERT INTO %s
 				("Id", "OwnerId", "Version", "UpdatedAt", "Deleted", "Resource")
 			VALUES
 				(@id, @ownerId, @version, @updatedAt, @deleted, @resource)
-			RETURNING "Resource"
+			RETURNING "Resource", "Id", "OwnerId", "Version"
+		), journal AS (
+			INSERT INTO %s
+				("Resource", "UpdatedAt", "PartitionName", "Id", "OwnerId", "Version")
+			SELECT
+				"Resource"::text, @updatedAt, @partitionName, "Id", "OwnerId", "Version"
+			FROM cte
+			RETURNING "Clock", "Resource"
 		)
-		INSERT INTO public."Journal"
-			("Resource", "UpdatedAt", "PartitionName")
-		SELECT
-			"Resource", @updatedAt, @partitionName
-		FROM cte
-		RETURNING "Resource";
-	`
+		SELECT "Resource"
+		FROM journal, pg_notify(@channel, journal."Clock"::text);
+	`, p.resourcesTable(), p.journalTable())
 	args := pgx.NamedArgs{
+		"channel":       journalChannel(partitionName),
 		"id":            resource.GetResourceBase().Id,
 		"ownerId":       resource.GetResourceBase().OwnerId,
 		"version":       resource.GetResourceBase().Version,
@@ -87,32 +264,35 @@ func (p *PostgresCommandHelper) GetInsertResourceWithJournalCommand(resource IRe
 }
 
 func (p *PostgresCommandHelper) GetUpdateResourceWithJournalCommand(resource IResource, versionToUpdate uint, resourceJson []byte, partitionName string) (string, pgx.NamedArgs) {
-	query := `
+	query := fmt.Sprintf(`
 		WITH cte AS (
-			UPDATE public."Resources"
+			UPDATE %s
 			SET
 				"Version" = @nextVersion,
 				"UpdatedAt" = @updatedAt,
-				"Deleted" = @deleted,
 				"OwnerId" = @ownerId,
 				"Resource" = @resource
 			WHERE "Id" = @id
 				AND "Version" = @version
 				AND "OwnerId" = @ownerId
-			RETURNING "Resource"
+				AND "Deleted" = false
+			RETURNING "Resource", "Id", "OwnerId", "Version"
+		), journal AS (
+			INSERT INTO %s
+				("Resource", "UpdatedAt", "PartitionName", "Id", "OwnerId", "Version")
+			SELECT
+				"Resource"::text, @updatedAt, @partitionName, "Id", "OwnerId", "Version"
+			FROM cte
+			WHERE "Resource" IS NOT NULL
+			RETURNING "Clock", "Resource"
 		)
-		INSERT INTO public."Journal"
-			("Resource", "UpdatedAt", "PartitionName")
-		SELECT
-			"Resource", @updatedAt, @partitionName
-		FROM cte
-		WHERE "Resource" IS NOT NULL
-		RETURNING "Resource";
-	`
+		SELECT "Resource"
+		FROM journal, pg_notify(@channel, journal."Clock"::text);
+	`, p.resourcesTable(), p.journalTable())
 	args := pgx.NamedArgs{
+		"channel":       journalChannel(partitionName),
 		"nextVersion":   resource.GetResourceBase().Version,
 		"updatedAt":     resource.GetResourceBase().UpdatedAt,
-		"deleted":       resource.GetResourceBase().Deleted,
 		"ownerId":       resource.GetResourceBase().OwnerId,
 		"resource":      resourceJson,
 		"id":            resource.GetResourceBase().Id,
@@ -122,9 +302,680 @@ func (p *PostgresCommandHelper) GetUpdateResourceWithJournalCommand(resource IRe
 	return query, args
 }
 
+// GetSetDeletedWithJournalCommand flips the Deleted flag on a resource (delete or undelete) and writes
+// the resulting resource to the journal in the same statement. The ResourceBase fields that change are
+// merged into the stored JSON so the caller does not need to load and re-send the full resource.
+func (p *PostgresCommandHelper) GetSetDeletedWithJournalCommand(ownerId string, id string, versionToUpdate uint, deleted bool, resourceBasePatch []byte, updatedAt time.Time, partitionName string) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		WITH cte AS (
+			UPDATE %s
+			SET
+				"Version" = @nextVersion,
+				"UpdatedAt" = @updatedAt,
+				"Deleted" = @deleted,
+				"Resource" = "Resource" || @resourceBasePatch::jsonb
+			WHERE "Id" = @id
+				AND "Version" = @version
+				AND "OwnerId" = @ownerId
+				AND "Deleted" = NOT @deleted
+			RETURNING "Resource", "Id", "OwnerId", "Version"
+		), journal AS (
+			INSERT INTO %s
+				("Resource", "UpdatedAt", "PartitionName", "Id", "OwnerId", "Version")
+			SELECT
+				"Resource"::text, @updatedAt, @partitionName, "Id", "OwnerId", "Version"
+			FROM cte
+			RETURNING "Clock", "Resource"
+		)
+		SELECT "Resource"
+		FROM journal, pg_notify(@channel, journal."Clock"::text);
+	`, p.resourcesTable(), p.journalTable())
+	args := pgx.NamedArgs{
+		"channel":           journalChannel(partitionName),
+		"nextVersion":       versionToUpdate + 1,
+		"updatedAt":         updatedAt,
+		"deleted":           deleted,
+		"resourceBasePatch": string(resourceBasePatch),
+		"id":                id,
+		"version":           versionToUpdate,
+		"ownerId":           ownerId,
+		"partitionName":     partitionName,
+	}
+	return query, args
+}
+
+// GetQueryResourcesByOwnerIdCommand builds the (dynamic) SQL for a ResourceQuery. Every value, including the
+// JSON paths of the fields, is passed as a parameter. Equality uses jsonb containment so that it can be served
+// by the GIN index on "Resource". The query fetches one extra row so the caller can tell if another page exists.
+func (p *PostgresCommandHelper) GetQueryResourcesByOwnerIdCommand(ownerId string, query *ResourceQuery, cursor *queryCursor) (string, pgx.NamedArgs, error) {
+	args := pgx.NamedArgs{
+		"ownerId": ownerId,
+		"limit":   query.Limit + 1,
+	}
+	conditions := []string{`"OwnerId" = @ownerId`, `"Deleted" = false`}
+
+	for i, filter := range query.Filters {
+		path, err := fieldPath(filter.Field)
+		if err != nil {
+			return "", nil, err
+		}
+		name := fmt.Sprintf("f%d", i)
+		args[name+"path"] = path
+		field := fmt.Sprintf(`"Resource" #> @%spath::text[]`, name)
+
+		switch filter.Op {
+		case QUERY_OP_EQ:
+			containment, err := json.Marshal(nestValue(path, filter.Values[0]))
+			if err != nil {
+				return "", nil, fmt.Errorf("resource query - invalid value for field '%s': %w", filter.Field, err)
+			}
+			args[name] = string(containment)
+			conditions = append(conditions, fmt.Sprintf(`"Resource" @> @%s::text::jsonb`, name))
+		case QUERY_OP_IN:
+			values := make([]string, 0, len(filter.Values))
+			for _, value := range filter.Values {
+				jsonValue, err := json.Marshal(value)
+				if err != nil {
+					return "", nil, fmt.Errorf("resource query - invalid value for field '%s': %w", filter.Field, err)
+				}
+				values = append(values, string(jsonValue))
+			}
+			args[name] = values
+			conditions = append(conditions, fmt.Sprintf(`%s = ANY(@%s::text[]::jsonb[])`, field, name))
+		case QUERY_OP_RANGE:
+			for j, bound := range filter.Values {
+				if bound == nil {
+					continue
+				}
+				jsonValue, err := json.Marshal(bound)
+				if err != nil {
+					return "", nil, fmt.Errorf("resource query - invalid value for field '%s': %w", filter.Field, err)
+				}
+				comparison := ">="
+				if j == 1 {
+					comparison = "<="
+				}
+				boundName := fmt.Sprintf("%sb%d", name, j)
+				args[boundName] = string(jsonValue)
+				conditions = append(conditions, fmt.Sprintf(`%s %s @%s::text::jsonb`, field, comparison, boundName))
+			}
+		case QUERY_OP_PREFIX:
+			args[name] = likeEscaper.Replace(filter.Values[0].(string)) + "%"
+			conditions = append(conditions, fmt.Sprintf(`"Resource" #>> @%spath::text[] LIKE @%s`, name, name))
+		default:
+			return "", nil, fmt.Errorf("resource query - unsupported filter operator '%s'", filter.Op)
+		}
+	}
+
+	// a missing field sorts as JSON null so that the ordering (and therefore the cursor) is total
+	sortExpressions := make([]string, 0, len(query.Sort))
+	selectColumns := []string{`"Resource"`, `"Id"`}
+	orderBy := make([]string, 0, len(query.Sort)+1)
+	for i, sortKey := range query.Sort {
+		path, err := fieldPath(sortKey.Field)
+		if err != nil {
+			return "", nil, err
+		}
+		name := fmt.Sprintf("s%d", i)
+		args[name+"path"] = path
+		expression := fmt.Sprintf(`COALESCE("Resource" #> @%spath::text[], 'null'::jsonb)`, name)
+		sortExpressions = append(sortExpressions, expression)
+		selectColumns = append(selectColumns, expression+"::text")
+		if sortKey.Descending {
+			orderBy = append(orderBy, expression+" DESC")
+		} else {
+			orderBy = append(orderBy, expression+" ASC")
+		}
+	}
+	orderBy = append(orderBy, `"Id" ASC`)
+
+	// keyset pagination - rows strictly after the cursor in (sort keys..., id) order
+	if cursor != nil {
+		args["cursorId"] = cursor.Id
+		var alternatives []string
+		for i := 0; i <= len(query.Sort); i++ {
+			var terms []string
+			for j := 0; j < i; j++ {
+				args[fmt.Sprintf("c%d", j)] = cursor.Values[j]
+				terms = append(terms, fmt.Sprintf(`%s = @c%d::text::jsonb`, sortExpressions[j], j))
+			}
+			if i < len(query.Sort) {
+				args[fmt.Sprintf("c%d", i)] = cursor.Values[i]
+				comparison := ">"
+				if query.Sort[i].Descending {
+					comparison = "<"
+				}
+				terms = append(terms, fmt.Sprintf(`%s %s @c%d::text::jsonb`, sortExpressions[i], comparison, i))
+			} else {
+				terms = append(terms, `"Id" > @cursorId`)
+			}
+			alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	sql := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE %s
+		ORDER BY %s
+		LIMIT @limit;
+	`, strings.Join(selectColumns, ", "), p.resourcesTable(), strings.Join(conditions, "\n\t\t\tAND "), strings.Join(orderBy, ", "))
+
+	return sql, args, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// nestValue turns a path and value into the nested object used for a containment (@>) match,
+// e.g. ["employee", "age"] and 30 become {"employee": {"age": 30}}
+func nestValue(path []string, value any) any {
+	for i := len(path) - 1; i >= 0; i-- {
+		value = map[string]any{path[i]: value}
+	}
+	return value
+}
+
+// The commands below are used by InTx. They change the Resources table only - the journal rows for the
+// whole unit of work are written in one batch just before commit so that their clocks are contiguous.
+
+func (p *PostgresCommandHelper) GetResourceByIdForUpdateCommand(id string, ownerId string, includeDeleted bool) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT "Resource"
+		FROM %s
+		WHERE "Id" = @id
+			AND "OwnerId" = @ownerId
+			AND ("Deleted" = false OR @includeDeleted)
+		FOR UPDATE;
+	`, p.resourcesTable())
+	args := pgx.NamedArgs{
+		"id":             id,
+		"ownerId":        ownerId,
+		"includeDeleted": includeDeleted,
+	}
+	return query, args
+}
+
+func (p *PostgresCommandHelper) GetInsertResourceCommand(resource IResource, resourceJson []byte) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+			("Id", "OwnerId", "Version", "UpdatedAt", "Deleted", "Resource")
+		VALUES
+			(@id, @ownerId, @version, @updatedAt, @deleted, @resource)
+		RETURNING "Resource";
+	`, p.resourcesTable())
+	args := pgx.NamedArgs{
+		"id":        resource.GetResourceBase().Id,
+		"ownerId":   resource.GetResourceBase().OwnerId,
+		"version":   resource.GetResourceBase().Version,
+		"updatedAt": resource.GetResourceBase().UpdatedAt,
+		"deleted":   resource.GetResourceBase().Deleted,
+		"resource":  resourceJson,
+	}
+	return query, args
+}
+
+// GetInsertResourceIfAbsentCommand inserts a resource unless its id is already taken, in which case no row is
+// returned. Unlike a failed INSERT, this doesn't abort the transaction, so a bulk create can carry on.
+func (p *PostgresCommandHelper) GetInsertResourceIfAbsentCommand(resource IResource, resourceJson []byte) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+			("Id", "OwnerId", "Version", "UpdatedAt", "Deleted", "Resource")
+		VALUES
+			(@id, @ownerId, @version, @updatedAt, @deleted, @resource)
+		ON CONFLICT ("Id") DO NOTHING
+		RETURNING "Resource";
+	`, p.resourcesTable())
+	args := pgx.NamedArgs{
+		"id":        resource.GetResourceBase().Id,
+		"ownerId":   resource.GetResourceBase().OwnerId,
+		"version":   resource.GetResourceBase().Version,
+		"updatedAt": resource.GetResourceBase().UpdatedAt,
+		"deleted":   resource.GetResourceBase().Deleted,
+		"resource":  resourceJson,
+	}
+	return query, args
+}
+
+// GetUpsertResourceCommand inserts a resource stamped for creation or, if the owner already has a resource with
+// its id, replaces it as the next version (keeping its createdAt and restoring it if it was deleted). No row is
+// returned when the id belongs to another owner. The second column reports whether the row was inserted.
+func (p *PostgresCommandHelper) GetUpsertResourceCommand(resource IResource, resourceJson []byte) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		INSERT INTO %s AS existing
+			("Id", "OwnerId", "Version", "UpdatedAt", "Deleted", "Resource")
+		VALUES
+			(@id, @ownerId, @version, @updatedAt, @deleted, @resource)
+		ON CONFLICT ("Id") DO UPDATE
+			SET
+				"Version" = existing."Version" + 1,
+				"UpdatedAt" = EXCLUDED."UpdatedAt",
+				"Deleted" = EXCLUDED."Deleted",
+				"Resource" = EXCLUDED."Resource" || jsonb_build_object(
+					'version', existing."Version" + 1,
+					'createdAt', existing."Resource" -> 'createdAt',
+					'lastAction', @updateAction::text)
+			WHERE existing."OwnerId" = EXCLUDED."OwnerId"
+		RETURNING "Resource", (xmax = 0) AS "Inserted";
+	`, p.resourcesTable())
+	args := pgx.NamedArgs{
+		"id":           resource.GetResourceBase().Id,
+		"ownerId":      resource.GetResourceBase().OwnerId,
+		"version":      resource.GetResourceBase().Version,
+		"updatedAt":    resource.GetResourceBase().UpdatedAt,
+		"deleted":      resource.GetResourceBase().Deleted,
+		"resource":     resourceJson,
+		"updateAction": constants.RESOURCE_ACTION_UPDATE,
+	}
+	return query, args
+}
+
+func (p *PostgresCommandHelper) GetUpdateResourceCommand(resource IResource, versionToUpdate uint, resourceJson []byte) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET
+			"Version" = @nextVersion,
+			"UpdatedAt" = @updatedAt,
+			"OwnerId" = @ownerId,
+			"Resource" = @resource
+		WHERE "Id" = @id
+			AND "Version" = @version
+			AND "OwnerId" = @ownerId
+			AND "Deleted" = false
+		RETURNING "Resource";
+	`, p.resourcesTable())
+	args := pgx.NamedArgs{
+		"nextVersion": resource.GetResourceBase().Version,
+		"updatedAt":   resource.GetResourceBase().UpdatedAt,
+		"ownerId":     resource.GetResourceBase().OwnerId,
+		"resource":    resourceJson,
+		"id":          resource.GetResourceBase().Id,
+		"version":     versionToUpdate,
+	}
+	return query, args
+}
+
+func (p *PostgresCommandHelper) GetSetDeletedCommand(ownerId string, id string, versionToUpdate uint, deleted bool, resourceBasePatch []byte, updatedAt time.Time) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET
+			"Version" = @nextVersion,
+			"UpdatedAt" = @updatedAt,
+			"Deleted" = @deleted,
+			"Resource" = "Resource" || @resourceBasePatch::jsonb
+		WHERE "Id" = @id
+			AND "Version" = @version
+			AND "OwnerId" = @ownerId
+			AND "Deleted" = NOT @deleted
+		RETURNING "Resource";
+	`, p.resourcesTable())
+	args := pgx.NamedArgs{
+		"nextVersion":       versionToUpdate + 1,
+		"updatedAt":         updatedAt,
+		"deleted":           deleted,
+		"resourceBasePatch": string(resourceBasePatch),
+		"id":                id,
+		"version":           versionToUpdate,
+		"ownerId":           ownerId,
+	}
+	return query, args
+}
+
+// GetLockJournalCommand blocks other writers to the journal until the current transaction ends. SHARE ROW
+// EXCLUSIVE conflicts with the ROW EXCLUSIVE lock taken by every INSERT (and with itself), but not with readers.
+// Every write to the journal takes it first, so entries commit in clock order and a reader that has seen a clock
+// has seen every clock before it.
+func (p *PostgresCommandHelper) GetLockJournalCommand() string {
+	query := fmt.Sprintf(`
+		LOCK TABLE %s IN SHARE ROW EXCLUSIVE MODE;
+	`, p.journalTable())
+	return query
+}
+
+func (p *PostgresCommandHelper) GetInsertJournalCommand(resourceBase *ResourceBase, resourceJson []byte, partitionName string) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+			("Resource", "UpdatedAt", "PartitionName", "Id", "OwnerId", "Version")
+		VALUES
+			(@resource, @updatedAt, @partitionName, @id, @ownerId, @version)
+		RETURNING "Clock";
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"resource":      string(resourceJson),
+		"updatedAt":     resourceBase.UpdatedAt,
+		"partitionName": partitionName,
+		"id":            resourceBase.Id,
+		"ownerId":       resourceBase.OwnerId,
+		"version":       resourceBase.Version,
+	}
+	return query, args
+}
+
+// The commands below read the versions of one resource from the journal. Versions only ever increase, so
+// clock order is version order.
+
+func (p *PostgresCommandHelper) GetHistoryCommand(ownerId string, id string, afterVersion uint, limit int) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT "Resource"
+		FROM %s
+		WHERE "OwnerId" = @ownerId
+			AND "Id" = @id
+			AND "Version" > @afterVersion
+		ORDER BY "Clock"
+		LIMIT @limit;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"ownerId":      ownerId,
+		"id":           id,
+		"afterVersion": afterVersion,
+		"limit":        limit,
+	}
+	return query, args
+}
+
+func (p *PostgresCommandHelper) GetVersionCommand(ownerId string, id string, version uint) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT "Resource"
+		FROM %s
+		WHERE "OwnerId" = @ownerId
+			AND "Id" = @id
+			AND "Version" = @version
+		ORDER BY "Clock" DESC
+		LIMIT 1;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"ownerId": ownerId,
+		"id":      id,
+		"version": version,
+	}
+	return query, args
+}
+
+// GetAsOfCommand finds the latest snapshot of a resource at a clock, or at a time when clock is 0
+func (p *PostgresCommandHelper) GetAsOfCommand(ownerId string, id string, clock uint64, asOfTime time.Time) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT "Resource"
+		FROM %s
+		WHERE "OwnerId" = @ownerId
+			AND "Id" = @id
+			AND ("Clock" <= @clock OR @clock = 0)
+			AND ("UpdatedAt" <= @asOfTime OR @clock <> 0)
+		ORDER BY "Clock" DESC
+		LIMIT 1;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"ownerId":  ownerId,
+		"id":       id,
+		"clock":    int64(clock),
+		"asOfTime": asOfTime.UTC(),
+	}
+	return query, args
+}
+
+// The commands below implement the journal retention policies. Both work up to a watermark - the latest clock
+// whose entry is older than the cutoff - so that they act on a clean prefix of the journal even though UpdatedAt
+// is not strictly in clock order.
+
+func (p *PostgresCommandHelper) GetJournalMinClockCommand(partitionName string) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT "MinClock"
+		FROM %s
+		WHERE "Journal" = @journal
+			AND "PartitionName" = @partitionName;
+	`, p.watermarksTable())
+	args := pgx.NamedArgs{
+		"journal":       p.journalTable(),
+		"partitionName": partitionName,
+	}
+	return query, args
+}
+
+// GetDeleteJournalCommand deletes the entries up to the watermark and records the clock after it as the lowest
+// one that can still be read. The partition's newest entry is always kept so that its max clock never goes back.
+// It returns the number of entries deleted.
+func (p *PostgresCommandHelper) GetDeleteJournalCommand(partitionName string, cutoff time.Time) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		WITH watermark AS (
+			SELECT MAX("Clock") AS "Clock"
+			FROM %[1]s
+			WHERE "PartitionName" = @partitionName
+				AND "UpdatedAt" < @cutoff
+				AND "Clock" < (SELECT MAX("Clock") FROM %[1]s WHERE "PartitionName" = @partitionName)
+		), deleted AS (
+			DELETE FROM %[1]s
+			WHERE "PartitionName" = @partitionName
+				AND "Clock" <= (SELECT "Clock" FROM watermark)
+			RETURNING "Clock"
+		), recorded AS (
+			INSERT INTO %[2]s AS existing
+				("Journal", "PartitionName", "MinClock", "UpdatedAt")
+			SELECT
+				@journal, @partitionName, "Clock" + 1, @now
+			FROM watermark
+			WHERE "Clock" IS NOT NULL
+			ON CONFLICT ("Journal", "PartitionName") DO UPDATE
+				SET "MinClock" = GREATEST(existing."MinClock", EXCLUDED."MinClock"),
+					"UpdatedAt" = EXCLUDED."UpdatedAt"
+		)
+		SELECT COUNT(*)
+		FROM deleted;
+	`, p.journalTable(), p.watermarksTable())
+	args := pgx.NamedArgs{
+		"journal":       p.journalTable(),
+		"partitionName": partitionName,
+		"cutoff":        cutoff.UTC(),
+		"now":           time.Now().UTC(),
+	}
+	return query, args
+}
+
+// GetCompactJournalCommand deletes the entries up to the watermark that have a newer entry for the same resource
+// that is also up to the watermark. Replaying the journal still ends with the latest version of every resource,
+// so no clock becomes too old to read from. It returns the number of entries deleted.
+func (p *PostgresCommandHelper) GetCompactJournalCommand(partitionName string, cutoff time.Time) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		WITH watermark AS (
+			SELECT MAX("Clock") AS "Clock"
+			FROM %[1]s
+			WHERE "PartitionName" = @partitionName
+				AND "UpdatedAt" < @cutoff
+		), deleted AS (
+			DELETE FROM %[1]s AS entry
+			WHERE entry."PartitionName" = @partitionName
+				AND entry."Clock" <= (SELECT "Clock" FROM watermark)
+				AND EXISTS (
+					SELECT 1
+					FROM %[1]s AS newer
+					WHERE newer."OwnerId" = entry."OwnerId"
+						AND newer."Id" = entry."Id"
+						AND newer."PartitionName" = entry."PartitionName"
+						AND newer."Clock" > entry."Clock"
+						AND newer."Clock" <= (SELECT "Clock" FROM watermark)
+				)
+			RETURNING entry."Clock"
+		)
+		SELECT COUNT(*)
+		FROM deleted;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"partitionName": partitionName,
+		"cutoff":        cutoff.UTC(),
+	}
+	return query, args
+}
+
+// The commands below back up, restore and repair the store's tables (see journalExport.go)
+
+// GetExportJournalCommand reads the journal entries from fromClock to toClock (0 for no upper bound) in clock order.
+// Partitions that share a clock are ordered bytewise, the order the import checks.
+func (p *PostgresCommandHelper) GetExportJournalCommand(fromClock uint64, toClock uint64) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT "Clock", "Resource", "UpdatedAt", "PartitionName"
+		FROM %s
+		WHERE "Clock" >= @fromClock
+			AND ("Clock" <= @toClock OR @toClock = 0)
+		ORDER BY "Clock", "PartitionName" COLLATE "C";
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"fromClock": int64(fromClock),
+		"toClock":   int64(toClock),
+	}
+	return query, args
+}
+
+func (p *PostgresCommandHelper) GetTablesHaveRowsCommand() string {
+	query := fmt.Sprintf(`
+		SELECT EXISTS (SELECT 1 FROM %s) OR EXISTS (SELECT 1 FROM %s);
+	`, p.journalTable(), p.resourcesTable())
+	return query
+}
+
+// GetImportJournalEntryCommand inserts a journal entry with the clock and partition it was exported with
+func (p *PostgresCommandHelper) GetImportJournalEntryCommand(entry ResourceJournalEntry, resourceBase *ResourceBase) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+			("Clock", "Resource", "UpdatedAt", "PartitionName", "Id", "OwnerId", "Version")
+		VALUES
+			(@clock, @resource, @updatedAt, @partitionName, @id, @ownerId, @version);
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"clock":         int64(entry.Clock),
+		"resource":      string(entry.Resource),
+		"updatedAt":     entry.UpdatedAt,
+		"partitionName": entry.PartitionName,
+		"id":            resourceBase.Id,
+		"ownerId":       resourceBase.OwnerId,
+		"version":       resourceBase.Version,
+	}
+	return query, args
+}
+
+// GetResetJournalClockCommand moves the journal's clock sequence past the imported entries, so that the next
+// write doesn't reuse a clock
+func (p *PostgresCommandHelper) GetResetJournalClockCommand() (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT setval(pg_get_serial_sequence(@journal, 'Clock'), MAX("Clock"))
+		FROM %s
+		HAVING MAX("Clock") IS NOT NULL;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"journal": p.journalTable(),
+	}
+	return query, args
+}
+
+// GetJournalTrimmedCommand reports whether delete retention has removed entries from any partition of the journal
+func (p *PostgresCommandHelper) GetJournalTrimmedCommand() (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT EXISTS (SELECT 1 FROM %s WHERE "Journal" = @journal);
+	`, p.watermarksTable())
+	args := pgx.NamedArgs{
+		"journal": p.journalTable(),
+	}
+	return query, args
+}
+
+func (p *PostgresCommandHelper) GetClearResourcesCommand() string {
+	query := fmt.Sprintf(`
+		DELETE FROM %s;
+	`, p.resourcesTable())
+	return query
+}
+
+// GetRebuildResourcesCommand writes the latest journal entry of every resource to the (empty) resources table
+func (p *PostgresCommandHelper) GetRebuildResourcesCommand() string {
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+			("Id", "OwnerId", "Version", "UpdatedAt", "Deleted", "Resource")
+		SELECT DISTINCT ON ("Id")
+			"Id", "OwnerId", "Version", "UpdatedAt", COALESCE(("Resource"::jsonb ->> 'deleted')::boolean, false), "Resource"::jsonb
+		FROM %s
+		ORDER BY "Id", "Clock" DESC;
+	`, p.resourcesTable(), p.journalTable())
+	return query
+}
+
+// GetCheckConsistencyCommand compares every resource with the latest journal entry of its id, returning the id,
+// owner, stored version, journaled version, journal clock and problem of those that don't match
+func (p *PostgresCommandHelper) GetCheckConsistencyCommand() (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		WITH latest AS (
+			SELECT DISTINCT ON ("Id")
+				"Id", "OwnerId", "Version", "Clock", "Resource"::jsonb AS "Resource"
+			FROM %[2]s
+			ORDER BY "Id", "Clock" DESC
+		)
+		SELECT
+			COALESCE(resource."Id", latest."Id"),
+			COALESCE(resource."OwnerId", latest."OwnerId"),
+			COALESCE(resource."Version", 0),
+			COALESCE(latest."Version", 0),
+			COALESCE(latest."Clock", 0),
+			CASE
+				WHEN latest."Id" IS NULL THEN @notJournaled
+				WHEN resource."Id" IS NULL THEN @missing
+				WHEN resource."Version" <> latest."Version" THEN @versionMismatch
+				ELSE @resourceMismatch
+			END
+		FROM %[1]s AS resource
+		FULL OUTER JOIN latest ON latest."Id" = resource."Id"
+		WHERE latest."Id" IS NULL
+			OR resource."Id" IS NULL
+			OR resource."Version" <> latest."Version"
+			OR resource."Resource" IS DISTINCT FROM latest."Resource"
+		ORDER BY 1;
+	`, p.resourcesTable(), p.journalTable())
+	args := pgx.NamedArgs{
+		"notJournaled":     string(INCONSISTENCY_NOT_JOURNALED),
+		"missing":          string(INCONSISTENCY_MISSING),
+		"versionMismatch":  string(INCONSISTENCY_VERSION_MISMATCH),
+		"resourceMismatch": string(INCONSISTENCY_RESOURCE_MISMATCH),
+	}
+	return query, args
+}
+
+// GetNotifyJournalCommand wakes the journal subscribers of a partition. Notifications sent inside a transaction
+// are only delivered when it commits, so InTx queues this after its journal rows.
+func (p *PostgresCommandHelper) GetNotifyJournalCommand(partitionName string) (string, pgx.NamedArgs) {
+	query := fmt.Sprintf(`
+		SELECT pg_notify(@channel, MAX("Clock")::text)
+		FROM %s
+		WHERE "PartitionName" = @partitionName;
+	`, p.journalTable())
+	args := pgx.NamedArgs{
+		"channel":       journalChannel(partitionName),
+		"partitionName": partitionName,
+	}
+	return query, args
+}
+
+// GetListenJournalCommand subscribes the connection it runs on to the journal notifications of a partition
+func (p *PostgresCommandHelper) GetListenJournalCommand(partitionName string) string {
+	return "LISTEN " + pgx.Identifier{journalChannel(partitionName)}.Sanitize() + ";"
+}
+
+// journalChannel is the NOTIFY channel used for the journal entries of a partition. The payload is the clock
+// of the new entry, but subscribers only treat it as a signal to read the journal from their last clock, so
+// stores with different journal tables in the same partition can share the channel (at the cost of a read).
+func journalChannel(partitionName string) string {
+	return "journal_" + partitionName
+}
+
 func (p *PostgresCommandHelper) GetHealthCheckCommand() string {
 	query := `
 		SELECT 1;
 	`
 	return query
 }
+
+// GetReplicaLagCommand returns how far behind the primary a replica is in seconds. A replica that has replayed
+// all the WAL it has received is up to date, and a server that isn't a replica reports 0.
+func (p *PostgresCommandHelper) GetReplicaLagCommand() string {
+	query := `
+		SELECT CASE
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END::float8;
+	`
+	return query
+}
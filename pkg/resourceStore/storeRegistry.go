@@ -0,0 +1,165 @@
+package resourceStore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// StoreRegistry hands out one store per resource type and one pool per database (read replicas included), so
+// that the routers of a service (resource, journal and health check) all run on the same pools.
+// serviceBase.ServiceBase has one.
+//
+// Example usage from a service with several resource types, each over tables of its own:
+//
+//	orders, err := resourceStore.SharedStore[Order](service.Stores, resourceStore.NounTableNames("public", "orders"))
+//	invoices, err := resourceStore.SharedStore[Invoice](service.Stores, resourceStore.NounTableNames("public", "invoices"))
+type StoreRegistry struct {
+	configuration *viper.Viper
+	logger        *logrus.Logger
+	mu            sync.Mutex
+	pools         map[string]*pgxpool.Pool     // keyed by connection string
+	stores        map[reflect.Type]sharedStore // keyed by resource type
+	ownedStores   []func(ctx context.Context) error
+}
+
+type sharedStore struct {
+	store      any
+	tables     TableNames // with defaults applied
+	registered bool       // passed to RegisterStore rather than created over tables
+}
+
+func NewStoreRegistry(configuration *viper.Viper, logger *logrus.Logger) *StoreRegistry {
+	return &StoreRegistry{
+		configuration: configuration,
+		logger:        logger,
+		pools:         map[string]*pgxpool.Pool{},
+		stores:        map[reflect.Type]sharedStore{},
+	}
+}
+
+// RegisterStore makes store the one SharedStore returns for R, whatever the tables asked for (e.g. a
+// MemoryResourceStore in unit tests). The caller remains responsible for closing it.
+func RegisterStore[R any](registry *StoreRegistry, store IResourceStore[R]) error {
+	if store == nil {
+		return errors.New("resource store - invalid nil store detected in RegisterStore")
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	key := reflect.TypeFor[R]()
+	if _, ok := registry.stores[key]; ok {
+		return fmt.Errorf("resource store - a store for %v is already registered", key)
+	}
+	registry.stores[key] = sharedStore{store: store, registered: true}
+	return nil
+}
+
+// SharedStore returns the store for R, creating a PostgresResourceStoreWithJournal over tables (on the shared pool
+// of the configured database) the first time it is asked for. A resource type has one shared store, and two types
+// can't share tables, so asking for R over other tables than the first time, or over the tables of another type,
+// is an error.
+func SharedStore[R any](registry *StoreRegistry, tables TableNames) (IResourceStore[R], error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	key := reflect.TypeFor[R]()
+	tables = tables.withDefaults()
+	if shared, ok := registry.stores[key]; ok {
+		if !shared.registered && shared.tables != tables {
+			return nil, fmt.Errorf("resource store - the shared store for %v is over %s.%s, not %s.%s",
+				key, shared.tables.Schema, shared.tables.Resources, tables.Schema, tables.Resources)
+		}
+		return shared.store.(IResourceStore[R]), nil
+	}
+	for otherKey, shared := range registry.stores {
+		if !shared.registered && shared.tables.Schema == tables.Schema &&
+			(shared.tables.Resources == tables.Resources || shared.tables.Journal == tables.Journal) {
+			return nil, fmt.Errorf("resource store - the tables %s.%s and %s.%s are already used by the shared store for %v",
+				tables.Schema, tables.Resources, tables.Schema, tables.Journal, otherKey)
+		}
+	}
+
+	dbPool, err := registry.poolLocked(registry.configuration.GetString(constants.DB_CONNECTION_STRING), true)
+	if err != nil {
+		return nil, err
+	}
+	replicaPolicy, err := ReplicaPolicyFromConfig(registry.configuration)
+	if err != nil {
+		return nil, err
+	}
+	replicaPools := []*pgxpool.Pool{}
+	for _, connectString := range replicaPolicy.ConnectStrings {
+		// a replica that is down only counts as unhealthy, so it isn't pinged
+		replicaPool, err := registry.poolLocked(connectString, false)
+		if err != nil {
+			return nil, err
+		}
+		replicaPools = append(replicaPools, replicaPool)
+	}
+	store, err := NewPostgresResourceStoreWithPool[R](registry.configuration, registry.logger, tables, dbPool, replicaPools...)
+	if err != nil {
+		return nil, err
+	}
+	registry.stores[key] = sharedStore{store: store, tables: tables}
+	registry.ownedStores = append(registry.ownedStores, store.Close)
+	return store, nil
+}
+
+// Pool returns the shared pool of the database, opening it the first time it is asked for
+func (registry *StoreRegistry) Pool(dbConnectString string) (*pgxpool.Pool, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return registry.poolLocked(dbConnectString, true)
+}
+
+func (registry *StoreRegistry) poolLocked(dbConnectString string, ping bool) (*pgxpool.Pool, error) {
+	if dbPool, ok := registry.pools[dbConnectString]; ok {
+		return dbPool, nil
+	}
+	open := openPostgresPool
+	if ping {
+		open = newPostgresPool
+	}
+	dbPool, err := open(dbConnectString)
+	if err != nil {
+		return nil, err
+	}
+	registry.pools[dbConnectString] = dbPool
+	return dbPool, nil
+}
+
+// Close closes the stores SharedStore created and then the pools. Stores passed to RegisterStore are left open.
+func (registry *StoreRegistry) Close(ctx context.Context) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, closeStore := range registry.ownedStores {
+		closeStore(ctx)
+	}
+	registry.ownedStores = nil
+	registry.stores = map[reflect.Type]sharedStore{}
+
+	for dbConnectString, dbPool := range registry.pools {
+		closed := make(chan struct{})
+		go func() {
+			dbPool.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-ctx.Done():
+			return fmt.Errorf("resource store - timed out waiting for the database pools to close: %w", ctx.Err())
+		}
+		delete(registry.pools, dbConnectString)
+	}
+	registry.logger.Info("resource store - shared database pools closed")
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/problemDetails"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -316,7 +317,7 @@ func (a *AuthModel) ValidateSecurity(w http.ResponseWriter, r *http.Request) boo
 		if a.debugLevel > 0 {
 			a.Logger.Infof("validate security - Error authenticating token: %v", err)
 		}
-		a.writeHttpResponse(w, http.StatusUnauthorized, []byte(""))
+		a.writeProblem(w, r, http.StatusUnauthorized, "the request does not carry a valid token")
 		return false
 	}
 
@@ -335,7 +336,7 @@ func (a *AuthModel) ValidateSecurity(w http.ResponseWriter, r *http.Request) boo
 		if a.debugLevel > 0 {
 			a.Logger.Infof("validate security - Error authorizing token: %v", err)
 		}
-		a.writeHttpResponse(w, http.StatusForbidden, []byte(""))
+		a.writeProblem(w, r, http.StatusForbidden, "the identity in the token is not authorized for this request")
 		return false
 	}
 
@@ -343,7 +344,7 @@ func (a *AuthModel) ValidateSecurity(w http.ResponseWriter, r *http.Request) boo
 	claims, ok := parsedToken.Claims.(jwt.MapClaims)
 	if !ok {
 		a.Logger.Info("auth header creation - Error getting claims from token")
-		a.writeHttpResponse(w, http.StatusUnauthorized, []byte(""))
+		a.writeProblem(w, r, http.StatusUnauthorized, "the token does not carry the expected claims")
 		return false
 	}
 	sub := claims["sub"]                       // this exists - used earlier
@@ -408,17 +409,8 @@ func (a *AuthModel) Secure(nakedFunc http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func (a *AuthModel) writeHttpResponse(w http.ResponseWriter, status int, v []byte) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if len(v) > 0 {
-		bytes, error := w.Write(v)
-		if error != nil {
-			a.Logger.Errorf("writeHttpResponse - Error writing response: %v", error)
-		}
-
-		if a.debugLevel > 0 {
-			a.Logger.Infof("writeHttpResponse - Wrote %d bytes to response", bytes)
-		}
+func (a *AuthModel) writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	if err := problemDetails.Write(w, problemDetails.New(r, status, detail)); err != nil {
+		a.Logger.Errorf("writeProblem - Error writing response: %v", err)
 	}
 }
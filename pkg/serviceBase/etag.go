@@ -0,0 +1,78 @@
+package serviceBase
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETagMatch is a parsed If-Match or If-None-Match header: either * (any current representation) or a list of
+// entity tags
+type ETagMatch struct {
+	Any  bool
+	Tags []EntityTag
+}
+
+// EntityTag is one entity tag of an If-Match or If-None-Match header, without its quotes
+type EntityTag struct {
+	Opaque string
+	Weak   bool
+}
+
+// VersionETag formats a resource version as the strong entity tag sent in the ETag header
+func VersionETag(version uint) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// ParseETagMatch parses the value of an If-Match or If-None-Match header (RFC 9110 section 13.1)
+func ParseETagMatch(header string) (ETagMatch, error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return ETagMatch{Any: true}, nil
+	}
+
+	var match ETagMatch
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		weak := strings.HasPrefix(tag, "W/")
+		tag = strings.TrimPrefix(tag, "W/")
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' || strings.Contains(tag[1:len(tag)-1], "\"") {
+			return ETagMatch{}, fmt.Errorf("invalid entity tag '%s' - expected a quoted value such as \"3\" or *", tag)
+		}
+		match.Tags = append(match.Tags, EntityTag{Opaque: tag[1 : len(tag)-1], Weak: weak})
+	}
+	return match, nil
+}
+
+// Versions returns the resource versions named by the tags. Tags that aren't versions can never match, so they
+// are left out. Weak tags are left out when strong is true (If-Match uses the strong comparison).
+func (m ETagMatch) Versions(strong bool) []uint {
+	var versions []uint
+	for _, tag := range m.Tags {
+		if strong && tag.Weak {
+			continue
+		}
+		if version, err := strconv.ParseUint(tag.Opaque, 10, 64); err == nil {
+			versions = append(versions, uint(version))
+		}
+	}
+	return versions
+}
+
+// MatchesVersion reports whether the header matches a resource at version
+func (m ETagMatch) MatchesVersion(version uint, strong bool) bool {
+	if m.Any {
+		return true
+	}
+	for _, v := range m.Versions(strong) {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (sb *ServiceBase) WriteHttpNotModified(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -10,10 +10,13 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/problemDetails"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
 	"github.com/geraldhinson/siftd-base/pkg/security"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -32,8 +35,18 @@ type ServiceBase struct {
 	Router         *mux.Router
 	KeyCache       *security.KeyCache
 	HealthStatus   *HealthStatus
-	CommandChannel chan string // can be used to communicate to backend processes when needed
+	CommandChannel chan string                  // can be used to communicate to backend processes when needed
+	Stores         *resourceStore.StoreRegistry // the resource stores shared by the helper routers (see resourceStore.SharedStore)
 	debugLevel     int
+	shutdownHooks  []shutdownHook
+	shutdown       chan struct{} // closed when a shutdown signal is received
+	backgroundJobs sync.WaitGroup
+}
+
+// shutdownHook is run after the HTTP server has stopped accepting requests (e.g. to close a resource store)
+type shutdownHook struct {
+	name string
+	hook func(ctx context.Context) error
 }
 
 // ValidateConfigAndListen configures the services for the Queries Service and listens for incoming requests
@@ -72,7 +85,12 @@ func NewServiceBase() *ServiceBase {
 
 	commandChannel := make(chan string, 1)
 
-	return &ServiceBase{
+	// every response carries a request id, and requests that match no route get a problem rather than mux's text
+	router.Use(problemDetails.RequestIdMiddleware)
+	router.NotFoundHandler = http.HandlerFunc(writeRouteProblem(http.StatusNotFound, "no route matches the request path"))
+	router.MethodNotAllowedHandler = http.HandlerFunc(writeRouteProblem(http.StatusMethodNotAllowed, "the request method is not supported by this route"))
+
+	sb := &ServiceBase{
 		Configuration:  configuration,
 		Logger:         logger,
 		Router:         router,
@@ -80,7 +98,13 @@ func NewServiceBase() *ServiceBase {
 		HealthStatus:   health,
 		debugLevel:     debugLevel,
 		CommandChannel: commandChannel,
+		Stores:         resourceStore.NewStoreRegistry(configuration, logger),
+		shutdown:       make(chan struct{}),
 	}
+	// registered first so that it runs last, after anything using the stores has been shut down
+	sb.RegisterShutdownHook("shared resource stores", sb.Stores.Close)
+
+	return sb
 }
 
 func setup() (*logrus.Logger, *viper.Viper) {
@@ -191,6 +215,8 @@ func (sb *ServiceBase) ListenAndServe() {
 	<-sigChannel
 
 	sb.CommandChannel <- "SIGTERM"
+	// lets long-lived requests (e.g. event streams) finish so that the server can drain
+	close(sb.shutdown)
 
 	if sb.debugLevel > 0 {
 		sb.Logger.Printf("service base - received shutdown signal")
@@ -204,11 +230,85 @@ func (sb *ServiceBase) ListenAndServe() {
 		sb.Logger.Fatalf("service base - HTTP shutdown error: %v", err)
 	}
 
+	// background jobs were cancelled when shutdown started - wait for them before their dependencies are closed
+	jobsDone := make(chan struct{})
+	go func() {
+		sb.backgroundJobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		sb.Logger.Errorf("service base - background jobs did not stop before the shutdown deadline")
+	}
+
+	// run in reverse order of registration so later dependencies are released before earlier ones
+	for i := len(sb.shutdownHooks) - 1; i >= 0; i-- {
+		hook := sb.shutdownHooks[i]
+		if err := hook.hook(shutdownCtx); err != nil {
+			sb.Logger.Errorf("service base - shutdown hook '%s' failed: %v", hook.name, err)
+		} else if sb.debugLevel > 0 {
+			sb.Logger.Printf("service base - shutdown hook '%s' complete", hook.name)
+		}
+	}
+
 	if sb.debugLevel > 0 {
 		sb.Logger.Printf("service base - HTTP server shutdown complete")
 	}
 }
 
+// RegisterShutdownHook adds a function to be called during ListenAndServe shutdown, after the HTTP server
+// has drained. The ctx passed to the hook carries the shutdown deadline.
+//
+// Example usage from a service:
+//
+//	service.RegisterShutdownHook("orders store", store.Close)
+func (sb *ServiceBase) RegisterShutdownHook(name string, hook func(ctx context.Context) error) {
+	sb.shutdownHooks = append(sb.shutdownHooks, shutdownHook{name: name, hook: hook})
+}
+
+// ShutdownStarted is closed when the service receives a shutdown signal. Handlers that hold a request open
+// (e.g. a Server-Sent Events stream) should return when it closes, otherwise the HTTP server can't drain.
+func (sb *ServiceBase) ShutdownStarted() <-chan struct{} {
+	return sb.shutdown
+}
+
+// StartBackgroundJob runs job once immediately and then every interval until the service starts shutting down,
+// when the ctx passed to the job is cancelled. Errors are logged and the job keeps its schedule. ListenAndServe
+// waits for running jobs to return before it runs the shutdown hooks.
+//
+// Example usage from a service:
+//
+//	service.StartBackgroundJob("orders cleanup", time.Hour, cleanupOrders)
+func (sb *ServiceBase) StartBackgroundJob(name string, interval time.Duration, job func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sb.shutdown
+		cancel()
+	}()
+
+	sb.backgroundJobs.Add(1)
+	go func() {
+		defer sb.backgroundJobs.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := job(ctx); err != nil && ctx.Err() == nil {
+				sb.Logger.Errorf("service base - background job '%s' failed: %v", name, err)
+			} else if sb.debugLevel > 0 {
+				sb.Logger.Printf("service base - background job '%s' complete", name)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
 func (sb *ServiceBase) IsCertASiftdSelfSignedOne(certFileName string) (bool, error) {
 	if certFileName == "" {
 		error := fmt.Errorf("service base - unset cert file name in isCertASiftdSignedOne(). Shutting down.")
@@ -281,23 +381,71 @@ func (sb *ServiceBase) RegisterRoute(httpMethod string, routeString string, auth
 	sb.Logger.Infof("service base - registered route: %s %s", httpMethod, routeString)
 }
 
+// HttpError is implemented by errors that carry their own HTTP status and a message that is safe to return to
+// the client (e.g. resourceStore.ResourceError)
+type HttpError interface {
+	error
+	HttpStatus() int
+	PublicMessage() string
+}
+
+// WriteHttpError writes an error response as an application/problem+json ProblemDetails. The status of an
+// HttpError in v's chain wins over status, which is one of the constants.RESOURCE_*_CODE values, and field
+// errors in v's chain (see problemDetails.FieldErrorer) are included.
 func (sb *ServiceBase) WriteHttpError(w http.ResponseWriter, status int, v error) {
 	var httpStatus int = http.StatusInternalServerError
+	var detail string = v.Error()
 
-	switch status {
-	case constants.RESOURCE_NOT_FOUND_ERROR_CODE:
-		httpStatus = http.StatusNotFound
-	case constants.RESOURCE_BAD_REQUEST_CODE:
-		httpStatus = http.StatusBadRequest
-	case constants.RESOURCE_ALREADY_EXISTS_CODE:
-		httpStatus = http.StatusConflict
-	case constants.RESOURCE_UNAUTHORIZED_CODE:
-		httpStatus = http.StatusForbidden
+	var httpErr HttpError
+	if errors.As(v, &httpErr) {
+		httpStatus = httpErr.HttpStatus()
+		detail = httpErr.PublicMessage()
+	} else {
+		switch status {
+		case constants.RESOURCE_NOT_FOUND_ERROR_CODE:
+			httpStatus = http.StatusNotFound
+		case constants.RESOURCE_BAD_REQUEST_CODE:
+			httpStatus = http.StatusBadRequest
+		case constants.RESOURCE_ALREADY_EXISTS_CODE:
+			httpStatus = http.StatusConflict
+		case constants.RESOURCE_GONE_CODE:
+			httpStatus = http.StatusGone
+		case constants.RESOURCE_PRECONDITION_FAILED_CODE:
+			httpStatus = http.StatusPreconditionFailed
+		case constants.RESOURCE_UNSUPPORTED_TYPE_CODE:
+			httpStatus = http.StatusUnsupportedMediaType
+		case constants.RESOURCE_PRECONDITION_REQUIRED_CODE:
+			httpStatus = http.StatusPreconditionRequired
+		case constants.RESOURCE_UNAUTHORIZED_CODE:
+			httpStatus = http.StatusForbidden
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(httpStatus)
-	w.Write([]byte(v.Error()))
+	problem := problemDetails.New(problemDetails.RequestOf(w), httpStatus, detail)
+	var fieldErrorer problemDetails.FieldErrorer
+	if errors.As(v, &fieldErrorer) {
+		problem.Errors = fieldErrorer.FieldErrors()
+	}
+	sb.WriteHttpProblem(w, problem)
+}
+
+// WriteHttpProblem writes a problem built by the service itself, e.g.
+//
+//	problem := problemDetails.New(r, http.StatusConflict, "the order has already shipped")
+//	problem.Type = "https://example.com/problems/order-shipped"
+//	sb.WriteHttpProblem(w, problem)
+func (sb *ServiceBase) WriteHttpProblem(w http.ResponseWriter, problem *problemDetails.ProblemDetails) {
+	if err := problemDetails.Write(w, problem); err != nil {
+		sb.Logger.Errorf("service base - error writing problem response: %v", err)
+	}
+}
+
+// writeRouteProblem handles requests that mux couldn't route, which bypass the router's middleware
+func writeRouteProblem(status int, detail string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, r = problemDetails.WithRequestId(w, r)
+		problemDetails.Write(w, problemDetails.New(r, status, detail))
+	}
 }
 
 func (sb *ServiceBase) WriteHttpOK(w http.ResponseWriter, v []byte) {
@@ -306,6 +454,12 @@ func (sb *ServiceBase) WriteHttpOK(w http.ResponseWriter, v []byte) {
 	w.Write(v)
 }
 
+func (sb *ServiceBase) WriteHttpCreated(w http.ResponseWriter, v []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(v)
+}
+
 func (sb *ServiceBase) GetQueryParams(r *http.Request) map[string]string {
 	queryParams := make(map[string]string)
 	for key, values := range r.URL.Query() {
@@ -1,6 +1,7 @@
 package unitTestsShared
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -111,6 +112,16 @@ func NewTestRouter(realm string, authType security.AuthTypes, authTimeout securi
 		return nil, fmt.Errorf("Failed to create journal api server (for testing only). Shutting down.")
 	}
 
+	noAuthModel, err := service.NewAuthModel(security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize AuthModel for NounResourceRouter : %v", err)
+	}
+	NounResourceRouter := helpers.NewNounResourceRouter[TestNounResource](service, "testnouns",
+		helpers.NounResourceAuthModels{Get: noAuthModel, Post: noAuthModel, Put: noAuthModel, Delete: noAuthModel})
+	if NounResourceRouter == nil {
+		return nil, fmt.Errorf("Failed to create noun resource api server (for testing only). Shutting down.")
+	}
+
 	HealthCheckRouter := helpers.NewNounHealthCheckRouter[TestNounResource](service, security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
 	if HealthCheckRouter == nil {
 		return nil, fmt.Errorf("Failed to create health check api server (for testing only). Shutting down.")
@@ -131,6 +142,10 @@ func NewTestRouter(realm string, authType security.AuthTypes, authTimeout securi
 }
 
 func CallServiceViaLoopback(configuration *viper.Viper, httpMethod string, fakeUserToken []byte, requestURLSuffix string) ([]byte, error, int) {
+	return CallServiceViaLoopbackWithBody(configuration, httpMethod, fakeUserToken, requestURLSuffix, nil)
+}
+
+func CallServiceViaLoopbackWithBody(configuration *viper.Viper, httpMethod string, fakeUserToken []byte, requestURLSuffix string, requestBody []byte) ([]byte, error, int) {
 
 	listenAddress := configuration.GetString(constants.LISTEN_ADDRESS)
 	if listenAddress == "" {
@@ -139,7 +154,11 @@ func CallServiceViaLoopback(configuration *viper.Viper, httpMethod string, fakeU
 	}
 	requestURL := fmt.Sprintf("%s/%s", listenAddress, requestURLSuffix)
 
-	req, err := http.NewRequest(httpMethod, requestURL, nil)
+	var bodyReader io.Reader
+	if requestBody != nil {
+		bodyReader = bytes.NewReader(requestBody)
+	}
+	req, err := http.NewRequest(httpMethod, requestURL, bodyReader)
 	if err != nil {
 		err = fmt.Errorf("failed to build noun service request in UnitTest: %s", err)
 		return nil, err, http.StatusBadRequest
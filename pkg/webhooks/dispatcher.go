@@ -0,0 +1,488 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/backoff"
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/journalClient"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	WEBHOOK_DEFAULT_MAX_ATTEMPTS    = 5
+	WEBHOOK_DEFAULT_INITIAL_BACKOFF = time.Second
+	WEBHOOK_DEFAULT_MAX_BACKOFF     = time.Minute
+	WEBHOOK_DEFAULT_TIMEOUT         = 10 * time.Second
+	WEBHOOK_DEFAULT_POLL_INTERVAL   = time.Second
+	WEBHOOK_PAGE_SIZE               = 100
+)
+
+// DeliveryPolicy bounds the attempts to deliver a journal entry to a subscriber
+type DeliveryPolicy struct {
+	MaxAttempts    int           // including the first - the entry is dead-lettered after the last (defaults to 5)
+	InitialBackoff time.Duration // the wait before the first retry, doubled for each retry after it and jittered (defaults to 1s)
+	MaxBackoff     time.Duration // the longest wait between attempts (defaults to 1m)
+	Timeout        time.Duration // how long a subscriber has to reply to each attempt (defaults to 10s)
+	PollInterval   time.Duration // how long to wait before reading the journal again once caught up (defaults to 1s)
+}
+
+// DeliveryPolicyFromConfig reads WEBHOOK_MAX_ATTEMPTS, WEBHOOK_INITIAL_BACKOFF, WEBHOOK_MAX_BACKOFF, WEBHOOK_TIMEOUT
+// and WEBHOOK_POLL_INTERVAL
+func DeliveryPolicyFromConfig(configuration *viper.Viper) (DeliveryPolicy, error) {
+	var policy DeliveryPolicy
+	var err error
+	if maxAttempts := configuration.GetString(constants.WEBHOOK_MAX_ATTEMPTS); maxAttempts != "" {
+		if policy.MaxAttempts, err = strconv.Atoi(maxAttempts); err != nil {
+			return policy, fmt.Errorf("webhooks - invalid %s: %w", constants.WEBHOOK_MAX_ATTEMPTS, err)
+		}
+		if policy.MaxAttempts < 1 {
+			return policy, fmt.Errorf("webhooks - %s must be at least 1", constants.WEBHOOK_MAX_ATTEMPTS)
+		}
+	}
+	durations := []struct {
+		key   string
+		value *time.Duration
+	}{
+		{constants.WEBHOOK_INITIAL_BACKOFF, &policy.InitialBackoff},
+		{constants.WEBHOOK_MAX_BACKOFF, &policy.MaxBackoff},
+		{constants.WEBHOOK_TIMEOUT, &policy.Timeout},
+		{constants.WEBHOOK_POLL_INTERVAL, &policy.PollInterval},
+	}
+	for _, duration := range durations {
+		if value := configuration.GetString(duration.key); value != "" {
+			if *duration.value, err = time.ParseDuration(value); err != nil {
+				return policy, fmt.Errorf("webhooks - invalid %s: %w", duration.key, err)
+			}
+		}
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate sets the fields left at 0 to the WEBHOOK_DEFAULT_ values and checks that the result can be used
+func (p *DeliveryPolicy) Validate() error {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = WEBHOOK_DEFAULT_MAX_ATTEMPTS
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = WEBHOOK_DEFAULT_INITIAL_BACKOFF
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = WEBHOOK_DEFAULT_MAX_BACKOFF
+	}
+	if p.Timeout == 0 {
+		p.Timeout = WEBHOOK_DEFAULT_TIMEOUT
+	}
+	if p.PollInterval == 0 {
+		p.PollInterval = WEBHOOK_DEFAULT_POLL_INTERVAL
+	}
+	if p.MaxAttempts < 1 || p.InitialBackoff < 0 || p.MaxBackoff < p.InitialBackoff || p.Timeout < 0 || p.PollInterval < 0 {
+		return errors.New("webhooks - the delivery attempts must be at least 1, the durations can't be negative and the max backoff can't be less than the initial backoff")
+	}
+	return nil
+}
+
+// Backoff is the wait before retry number retry (1 for the first). Its jitter spreads out the retries of the
+// deliveries that failed together, e.g. while a subscriber restarts (see backoff.Exponential).
+func (p DeliveryPolicy) Backoff(retry int) time.Duration {
+	return backoff.Exponential(p.InitialBackoff, p.MaxBackoff, retry)
+}
+
+// DeliveryError is a delivery that failed - either the subscriber couldn't be reached (Status is 0) or it
+// replied with a status other than 2xx
+type DeliveryError struct {
+	Url    string
+	Status int
+	Err    error
+}
+
+func (e *DeliveryError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("webhooks - delivery to %s failed with status %d", e.Url, e.Status)
+	}
+	return fmt.Sprintf("webhooks - delivery to %s failed with: %v", e.Url, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// HttpStatus and PublicMessage make a failed redelivery a 502 (see serviceBase.HttpError)
+func (e *DeliveryError) HttpStatus() int {
+	return http.StatusBadGateway
+}
+
+func (e *DeliveryError) PublicMessage() string {
+	return e.Error()
+}
+
+// JournalReader is the part of a resourceStore.IResourceStore the dispatcher reads the journal through
+type JournalReader interface {
+	JournalPartitionName() string
+	GetPartitionJournalChanges(ctx context.Context, partitionName string, clock int64, limit int64, journalEntries *[]resourceStore.ResourceJournalEntry) error
+	GetPartitionJournalMaxClock(ctx context.Context, partitionName string, maxClock *uint64) error
+}
+
+// CheckpointFactory returns the checkpoint store of a subscription (e.g. a journalClient.PostgresCheckpointStore
+// named webhooks-<partition>-<subscription id>)
+type CheckpointFactory func(subscriptionId string) (journalClient.CheckpointStore, error)
+
+// Dispatcher POSTs the journal entries of its partition to every subscription, which makes the journal a
+// transactional outbox: an entry is written in the same transaction as its resource and is delivered once it
+// commits. Every writer holds the journal lock until it commits, so entries become visible in clock order and a
+// checkpoint never moves past an entry that commits later. Each body is the entry as /v1/journal returns it,
+// signed with the subscription's secret (see SIGNATURE_HEADER and VerifySignature).
+//
+// Each subscription has a worker and a checkpoint of its own, starting at the journal's max clock when it is
+// created (see CreateSubscription), and gets the entries in clock order. A failed delivery is retried with backoff
+// and the entry is saved as a DeadLetterResource after the policy's MaxAttempts. A subscriber that is down only
+// holds up its own deliveries. Delivery is at-least-once: the entries after a subscription's checkpoint are
+// delivered again after a crash or an error saving a dead letter, so receivers should drop duplicates by
+// DELIVERY_HEADER. Run one dispatcher per partition - each instance running one delivers every entry.
+//
+// Example usage from a service:
+//
+//	checkpoints := func(subscriptionId string) (journalClient.CheckpointStore, error) {
+//		return journalClient.NewPostgresCheckpointStoreWithPool(dbPool, service.Logger, schema, "webhooks-"+partition+"-"+subscriptionId)
+//	}
+//	dispatcher, err := webhooks.NewDispatcher(store, subscriptions, deadLetters, checkpoints, policy, service.Logger)
+//	go dispatcher.Run(ctx)
+type Dispatcher struct {
+	journal       JournalReader
+	subscriptions resourceStore.IResourceStore[SubscriptionResource]
+	deadLetters   resourceStore.IResourceStore[DeadLetterResource]
+	checkpoints   CheckpointFactory
+	policy        DeliveryPolicy
+	logger        *logrus.Logger
+	// HTTPClient sends the deliveries - supply one that trusts the subscribers' certificates if needed
+	HTTPClient *http.Client
+}
+
+func NewDispatcher(
+	journal JournalReader,
+	subscriptions resourceStore.IResourceStore[SubscriptionResource],
+	deadLetters resourceStore.IResourceStore[DeadLetterResource],
+	checkpoints CheckpointFactory,
+	policy DeliveryPolicy,
+	logger *logrus.Logger) (*Dispatcher, error) {
+
+	if journal == nil || subscriptions == nil || deadLetters == nil {
+		return nil, fmt.Errorf("webhooks - the journal, subscription and dead letter stores are required")
+	}
+	if checkpoints == nil {
+		return nil, fmt.Errorf("webhooks - invalid nil checkpoint factory detected")
+	}
+	if logger == nil {
+		return nil, fmt.Errorf("webhooks - invalid nil logger detected")
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Dispatcher{
+		journal:       journal,
+		subscriptions: subscriptions,
+		deadLetters:   deadLetters,
+		checkpoints:   checkpoints,
+		policy:        policy,
+		logger:        logger,
+		HTTPClient:    http.DefaultClient,
+	}, nil
+}
+
+// Subscriptions is the store of the subscriptions
+func (d *Dispatcher) Subscriptions() resourceStore.IResourceStore[SubscriptionResource] {
+	return d.subscriptions
+}
+
+// DeadLetters is the store of the dead letters
+func (d *Dispatcher) DeadLetters() resourceStore.IResourceStore[DeadLetterResource] {
+	return d.deadLetters
+}
+
+// CreateSubscription saves a new subscription with a generated id. Its checkpoint is set to the journal's max
+// clock first, so the subscriber gets the entries written from then on rather than the whole journal.
+func (d *Dispatcher) CreateSubscription(ctx context.Context, subscription *SubscriptionResource, extractedAuth string) (*SubscriptionResource, int, error) {
+	subscription.Id = uuid.New().String()
+
+	var maxClock uint64
+	if err := d.journal.GetPartitionJournalMaxClock(ctx, d.journal.JournalPartitionName(), &maxClock); err != nil {
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+	checkpoints, err := d.checkpoints(subscription.Id)
+	if err == nil {
+		err = checkpoints.Save(ctx, maxClock)
+	}
+	if err != nil {
+		return nil, constants.RESOURCE_INTERNAL_ERROR_CODE, fmt.Errorf("webhooks - unable to save the checkpoint of a new subscription: %w", err)
+	}
+
+	if _, status, err := d.subscriptions.CreateResource(ctx, subscription, extractedAuth); err != nil {
+		return nil, status, err
+	}
+	return subscription, constants.RESOURCE_OK_CODE, nil
+}
+
+// subscriptionWorker is the goroutine delivering to one version of a subscription
+type subscriptionWorker struct {
+	version uint
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func (w *subscriptionWorker) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// Run delivers the journal until ctx is cancelled, returning ctx.Err(). It reads the subscriptions every
+// PollInterval, starting a worker for each new one, stopping the workers of those that were deleted and restarting
+// the worker of one that was updated (e.g. with a new url or secret).
+func (d *Dispatcher) Run(ctx context.Context) error {
+	workers := map[string]*subscriptionWorker{}
+	defer func() {
+		for _, worker := range workers {
+			worker.stop()
+		}
+	}()
+
+	for {
+		var subscriptions []SubscriptionResource
+		if _, err := d.subscriptions.GetByOwnerId(ctx, WEBHOOK_OWNER_ID, &subscriptions); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			d.logger.Errorf("webhook dispatcher - error reading the subscriptions, retrying in %v: %v", d.policy.PollInterval, err)
+		} else {
+			active := map[string]bool{}
+			for _, subscription := range subscriptions {
+				active[subscription.Id] = true
+				if worker, running := workers[subscription.Id]; running {
+					if worker.version == subscription.Version {
+						continue
+					}
+					// waits for the old worker to save its checkpoint before the new one loads it
+					d.logger.Infof("webhook dispatcher - restarting the worker of subscription %s for version %d", subscription.Id, subscription.Version)
+					worker.stop()
+				}
+				workerCtx, cancel := context.WithCancel(ctx)
+				worker := &subscriptionWorker{version: subscription.Version, cancel: cancel, done: make(chan struct{})}
+				workers[subscription.Id] = worker
+				go func() {
+					defer close(worker.done)
+					d.runSubscription(workerCtx, subscription)
+				}()
+			}
+			for id, worker := range workers {
+				if !active[id] {
+					worker.stop()
+					delete(workers, id)
+				}
+			}
+		}
+
+		select {
+		case <-time.After(d.policy.PollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runSubscription delivers the journal to one subscription until ctx is cancelled. Errors loading or saving its
+// checkpoint, reading the journal or saving a dead letter are logged and retried with backoff.
+func (d *Dispatcher) runSubscription(ctx context.Context, subscription SubscriptionResource) {
+	var checkpoints journalClient.CheckpointStore
+	var lastClock uint64
+	failures := 0
+
+	for {
+		var caughtUp bool
+		var err error
+		if checkpoints == nil {
+			var store journalClient.CheckpointStore
+			if store, err = d.checkpoints(subscription.Id); err == nil {
+				if lastClock, err = store.Load(ctx); err == nil {
+					checkpoints = store
+					d.logger.Infof("webhook dispatcher - delivering partition %s to subscription %s from clock %d", d.journal.JournalPartitionName(), subscription.Id, lastClock+1)
+				}
+			}
+		} else {
+			caughtUp, err = d.dispatchPage(ctx, &subscription, checkpoints, &lastClock)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := time.Duration(0)
+		if err != nil {
+			failures++
+			wait = d.policy.Backoff(failures)
+			d.logger.Errorf("webhook dispatcher - error delivering to subscription %s after clock %d, retrying in %v: %v", subscription.Id, lastClock, wait, err)
+		} else {
+			failures = 0
+			if caughtUp {
+				wait = d.policy.PollInterval
+			}
+		}
+
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// dispatchPage delivers one page of entries after lastClock to a subscription and saves its checkpoint at the last
+// one delivered (or dead-lettered). It reports whether the page was the last one currently available.
+func (d *Dispatcher) dispatchPage(ctx context.Context, subscription *SubscriptionResource, checkpoints journalClient.CheckpointStore, lastClock *uint64) (bool, error) {
+	var page []resourceStore.ResourceJournalEntry
+	if err := d.journal.GetPartitionJournalChanges(ctx, d.journal.JournalPartitionName(), int64(*lastClock+1), WEBHOOK_PAGE_SIZE, &page); err != nil {
+		return false, err
+	}
+
+	delivered := *lastClock
+	var deliveryErr error
+	for _, entry := range page {
+		if deliveryErr = d.deliverWithRetry(ctx, subscription, entry); deliveryErr != nil {
+			break
+		}
+		delivered = entry.Clock
+	}
+
+	if delivered != *lastClock {
+		// saved even when the page stopped early, so what was delivered isn't sent again. A cancelled ctx is
+		// ignored so that a shutdown keeps the progress made.
+		if err := checkpoints.Save(context.WithoutCancel(ctx), delivered); err != nil {
+			return false, err
+		}
+		*lastClock = delivered
+	}
+	if deliveryErr != nil {
+		return false, deliveryErr
+	}
+	return len(page) < WEBHOOK_PAGE_SIZE, nil
+}
+
+// deliverWithRetry delivers an entry, dead-lettering it once the attempts run out. It only fails when ctx is
+// cancelled or the dead letter can't be saved.
+func (d *Dispatcher) deliverWithRetry(ctx context.Context, subscription *SubscriptionResource, entry resourceStore.ResourceJournalEntry) error {
+	for attempt := 1; ; attempt++ {
+		err := d.deliver(ctx, subscription, entry)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= d.policy.MaxAttempts {
+			d.logger.Errorf("webhook dispatcher - dead-lettering clock %d for subscription %s after %d attempts: %v", entry.Clock, subscription.Id, attempt, err)
+			return d.saveDeadLetter(ctx, subscription, entry, attempt, err)
+		}
+
+		backoff := d.policy.Backoff(attempt)
+		d.logger.Infof("webhook dispatcher - retrying clock %d for subscription %s in %v: %v", entry.Clock, subscription.Id, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// deliver makes one attempt to POST an entry to a subscriber
+func (d *Dispatcher) deliver(ctx context.Context, subscription *SubscriptionResource, entry resourceStore.ResourceJournalEntry) error {
+	url := subscription.Subscription.Url
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return &DeliveryError{Url: url, Err: fmt.Errorf("unable to encode journal entry %d: %w", entry.Clock, err)}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.policy.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &DeliveryError{Url: url, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SIGNATURE_HEADER, Sign(subscription.Subscription.Secret, time.Now(), body))
+	req.Header.Set(DELIVERY_HEADER, fmt.Sprintf("%s:%s:%d", subscription.Id, entry.PartitionName, entry.Clock))
+
+	res, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return &DeliveryError{Url: url, Err: err}
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024)) // so the connection can be reused
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &DeliveryError{Url: url, Status: res.StatusCode}
+	}
+	return nil
+}
+
+func (d *Dispatcher) saveDeadLetter(ctx context.Context, subscription *SubscriptionResource, entry resourceStore.ResourceJournalEntry, attempts int, err error) error {
+	deadLetter := &DeadLetterResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: WEBHOOK_OWNER_ID},
+		DeadLetter: DeadLetter{
+			SubscriptionId: subscription.Id,
+			Url:            subscription.Subscription.Url,
+			Entry:          entry,
+			Attempts:       attempts,
+			LastError:      err.Error(),
+		},
+	}
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) {
+		deadLetter.DeadLetter.LastStatus = deliveryErr.Status
+	}
+
+	if _, _, err := d.deadLetters.CreateResource(ctx, deadLetter, WEBHOOK_DISPATCHER_AUTH); err != nil {
+		return fmt.Errorf("webhooks - unable to save the dead letter of clock %d for subscription %s: %w", entry.Clock, subscription.Id, err)
+	}
+	return nil
+}
+
+// Redeliver makes one more attempt to deliver a dead letter to its subscription. The dead letter is deleted when
+// the attempt succeeds, otherwise its attempts and last error are updated and the DeliveryError is returned.
+func (d *Dispatcher) Redeliver(ctx context.Context, deadLetterId string) (int, error) {
+	var deadLetter DeadLetterResource
+	if status, err := d.deadLetters.GetById(ctx, WEBHOOK_OWNER_ID, deadLetterId, &deadLetter); err != nil {
+		return status, err
+	}
+	var subscription SubscriptionResource
+	if status, err := d.subscriptions.GetById(ctx, WEBHOOK_OWNER_ID, deadLetter.DeadLetter.SubscriptionId, &subscription); err != nil {
+		return status, err
+	}
+
+	if err := d.deliver(ctx, &subscription, deadLetter.DeadLetter.Entry); err != nil {
+		deadLetter.DeadLetter.Attempts++
+		deadLetter.DeadLetter.LastError = err.Error()
+		var deliveryErr *DeliveryError
+		if errors.As(err, &deliveryErr) {
+			deadLetter.DeadLetter.LastStatus = deliveryErr.Status
+		}
+		if _, status, updateErr := d.deadLetters.UpdateResource(ctx, &deadLetter, WEBHOOK_OWNER_ID, deadLetterId, WEBHOOK_DISPATCHER_AUTH); updateErr != nil {
+			return status, updateErr
+		}
+		return constants.RESOURCE_INTERNAL_ERROR_CODE, err
+	}
+
+	_, status, err := d.deadLetters.DeleteResource(ctx, WEBHOOK_OWNER_ID, deadLetterId, deadLetter.Version, WEBHOOK_DISPATCHER_AUTH)
+	return status, err
+}
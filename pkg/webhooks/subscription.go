@@ -0,0 +1,52 @@
+package webhooks
+
+import (
+	"net/url"
+
+	"github.com/geraldhinson/siftd-base/pkg/problemDetails"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+)
+
+// WEBHOOK_OWNER_ID owns every subscription and dead letter in their stores
+const WEBHOOK_OWNER_ID = "webhooks"
+
+// WEBHOOK_DISPATCHER_AUTH is the identity the dispatcher writes dead letters as
+const WEBHOOK_DISPATCHER_AUTH = "webhook-dispatcher:"
+
+type Subscription struct {
+	Url         string `json:"url"`
+	Secret      string `json:"secret,omitempty"` // signs the deliveries - only returned when the subscription is created
+	Description string `json:"description,omitempty"`
+}
+
+// SubscriptionResource is a subscriber that every journal entry is POSTed to. Subscriptions are kept in a
+// resource store of their own (e.g. over resourceStore.NounTableNames("public", "webhookSubscriptions")).
+type SubscriptionResource struct {
+	resourceStore.ResourceBase
+	Subscription Subscription `json:"subscription"`
+}
+
+func (s *SubscriptionResource) Validate() error {
+	parsed, err := url.Parse(s.Subscription.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return resourceStore.NewValidationError("webhooks - the subscription is invalid",
+			problemDetails.FieldError{Field: "subscription.url", Message: "must be an absolute http or https URL"})
+	}
+	return nil
+}
+
+type DeadLetter struct {
+	SubscriptionId string                             `json:"subscriptionId"`
+	Url            string                             `json:"url"`
+	Entry          resourceStore.ResourceJournalEntry `json:"entry"`
+	Attempts       int                                `json:"attempts"`
+	LastStatus     int                                `json:"lastStatus,omitempty"` // the HTTP status of the last attempt (0 if it got no reply)
+	LastError      string                             `json:"lastError"`
+}
+
+// DeadLetterResource is a journal entry that could not be delivered to a subscriber within the policy's attempts.
+// It stays in the dead letter store until it is redelivered or discarded.
+type DeadLetterResource struct {
+	resourceStore.ResourceBase
+	DeadLetter DeadLetter `json:"deadLetter"`
+}
@@ -0,0 +1,85 @@
+package unittests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/problemDetails"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+)
+
+type fieldValidationError struct{}
+
+func (e fieldValidationError) Error() string { return "the employee is not valid" }
+
+func (e fieldValidationError) FieldErrors() []problemDetails.FieldError {
+	return []problemDetails.FieldError{{Field: "employee.age", Message: "must not be negative"}}
+}
+
+func TestProblemDetailsResponses(t *testing.T) {
+	service := newConditionalTestService(t, false)
+	collection := "/v1/identities/1234/employees"
+
+	memberAuthModel, err := service.NewAuthModel(security.REALM_MEMBER, security.VALID_IDENTITY, security.ONE_HOUR, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize AuthModel: %v", err)
+	}
+	service.RegisterRoute(constants.HTTP_GET, "/v1/secured", memberAuthModel, func(w http.ResponseWriter, r *http.Request) {
+		service.WriteHttpOK(w, []byte("{}"))
+	})
+	noAuthModel, _ := service.NewAuthModel(security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	service.RegisterRoute(constants.HTTP_GET, "/v1/validated", noAuthModel, func(w http.ResponseWriter, r *http.Request) {
+		service.WriteHttpError(w, constants.RESOURCE_BAD_REQUEST_CODE, fieldValidationError{})
+	})
+
+	expectProblem := func(method string, url string, status int, headers ...string) problemDetails.ProblemDetails {
+		response := conditionalCall(service, method, url, nil, headers...)
+		var problem problemDetails.ProblemDetails
+		if err := json.Unmarshal(response.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("Expected a problem from %s %s, got %d: %s", method, url, response.Code, response.Body.String())
+		}
+		if response.Code != status || problem.Status != status || response.Header().Get("Content-Type") != problemDetails.CONTENT_TYPE {
+			t.Fatalf("Expected a %d problem from %s %s, got %d (%s): %+v", status, method, url, response.Code, response.Header().Get("Content-Type"), problem)
+		}
+		if problem.Type != problemDetails.DEFAULT_TYPE || problem.Title != http.StatusText(status) || problem.Instance != url {
+			t.Fatalf("Unexpected problem from %s %s: %+v", method, url, problem)
+		}
+		if problem.RequestId == "" || problem.RequestId != response.Header().Get(problemDetails.REQUEST_ID_HEADER) {
+			t.Fatalf("Expected the request id of the response in the problem, got %s and %s", problem.RequestId, response.Header().Get(problemDetails.REQUEST_ID_HEADER))
+		}
+		return problem
+	}
+
+	// store errors keep their public message, and a request id sent by the caller is used
+	problem := expectProblem(http.MethodGet, collection+"/no-such-id", http.StatusNotFound, "X-Request-Id", "request-1")
+	if problem.RequestId != "request-1" || problem.Detail == "" {
+		t.Fatalf("Expected the caller's request id and a detail, got %+v", problem)
+	}
+	expectProblem(http.MethodPost, collection, http.StatusBadRequest, "Content-Type", "application/json")
+
+	// a request id that is too long or not made of token characters is replaced
+	for _, requestId := range []string{strings.Repeat("a", problemDetails.MAX_REQUEST_ID_LENGTH+1), "request 1", "request-1\"><script>", "réquest"} {
+		problem = expectProblem(http.MethodGet, collection+"/no-such-id", http.StatusNotFound, "X-Request-Id", requestId)
+		if problem.RequestId == requestId {
+			t.Fatalf("Expected the request id %q to be replaced", requestId)
+		}
+	}
+	longest := strings.Repeat("a", problemDetails.MAX_REQUEST_ID_LENGTH)
+	if problem = expectProblem(http.MethodGet, collection+"/no-such-id", http.StatusNotFound, "X-Request-Id", longest); problem.RequestId != longest {
+		t.Fatalf("Expected the request id %s to be kept, got %s", longest, problem.RequestId)
+	}
+
+	// routing and auth
+	expectProblem(http.MethodGet, "/v1/no-such-route", http.StatusNotFound)
+	expectProblem(http.MethodPut, collection, http.StatusMethodNotAllowed)
+	expectProblem(http.MethodGet, "/v1/secured", http.StatusUnauthorized)
+
+	// field errors
+	problem = expectProblem(http.MethodGet, "/v1/validated", http.StatusBadRequest)
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "employee.age" {
+		t.Fatalf("Expected the field errors in the problem, got %+v", problem)
+	}
+}
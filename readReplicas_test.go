@@ -0,0 +1,40 @@
+package unittests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/spf13/viper"
+)
+
+func TestReplicaPolicyFromConfig(t *testing.T) {
+	configuration := viper.New()
+	policy, err := resourceStore.ReplicaPolicyFromConfig(configuration)
+	if err != nil || len(policy.ConnectStrings) != 0 || policy.MaxLag != resourceStore.REPLICA_DEFAULT_MAX_LAG || policy.CheckInterval != resourceStore.REPLICA_DEFAULT_CHECK_INTERVAL {
+		t.Fatalf("Expected no replicas and the defaults, got %+v, %v", policy, err)
+	}
+
+	configuration.Set(constants.DB_REPLICA_CONNECTION_STRINGS, " postgres://replica-1/db , ,postgres://replica-2/db")
+	configuration.Set(constants.DB_REPLICA_MAX_LAG, "30s")
+	configuration.Set(constants.DB_REPLICA_CHECK_INTERVAL, "1s")
+	policy, err = resourceStore.ReplicaPolicyFromConfig(configuration)
+	if err != nil || len(policy.ConnectStrings) != 2 || policy.ConnectStrings[1] != "postgres://replica-2/db" || policy.MaxLag != 30*time.Second || policy.CheckInterval != time.Second {
+		t.Fatalf("Expected two replicas with a 30s max lag checked every second, got %+v, %v", policy, err)
+	}
+
+	for _, invalid := range []map[string]string{
+		{constants.DB_REPLICA_MAX_LAG: "a while"},
+		{constants.DB_REPLICA_MAX_LAG: "-1s"},
+		{constants.DB_REPLICA_CHECK_INTERVAL: "often"},
+	} {
+		configuration := viper.New()
+		for key, value := range invalid {
+			configuration.Set(key, value)
+		}
+		if _, err := resourceStore.ReplicaPolicyFromConfig(configuration); err == nil {
+			t.Fatalf("Expected an error for %v", invalid)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package unittests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/google/uuid"
+)
+
+func expectBulkOutcomes(t *testing.T, results []resourceStore.BulkResult, expected ...resourceStore.BulkOutcome) {
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if result.Outcome != expected[i] {
+			t.Fatalf("Expected %s at index %d, got %s (%d, %v)", expected[i], i, result.Outcome, result.Status, result.Err)
+		}
+		if result.Written() != (result.Status == constants.RESOURCE_OK_CODE) || result.Written() != (result.Resource != nil) {
+			t.Fatalf("Expected a written result to have an OK status and a resource at index %d, got %+v", i, result)
+		}
+	}
+}
+
+func testBulkResources(t *testing.T, store resourceStore.IResourceStore[EmployeeResource]) {
+	ctx := context.Background()
+	bobId := uuid.New().String()
+	newEmployee := func(id string, ownerId string, name string, age int) *EmployeeResource {
+		return &EmployeeResource{ResourceBase: resourceStore.ResourceBase{Id: id, OwnerId: ownerId}, Employee: Employee{Name: name, Age: age}}
+	}
+
+	var startClock uint64
+	if err := store.GetJournalMaxClock(ctx, &startClock); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	alice := newEmployee("", "1234", "Alice", 30)
+	results, status, err := store.CreateResources(ctx, []resourceStore.IResource{
+		alice,
+		newEmployee(bobId, "1234", "Bob", 40),
+		newEmployee(bobId, "1234", "Bobby", 41),
+		nil,
+	}, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error creating resources: %d, %v", status, err)
+	}
+	expectBulkOutcomes(t, results, resourceStore.BULK_OUTCOME_CREATED, resourceStore.BULK_OUTCOME_CREATED, resourceStore.BULK_OUTCOME_DUPLICATE, resourceStore.BULK_OUTCOME_INVALID)
+	if results[0].Id == "" || results[0].Id != alice.Id || alice.Version != 1 || results[2].Status != constants.RESOURCE_ALREADY_EXISTS_CODE {
+		t.Fatalf("Expected an id to be assigned to Alice and Bobby to be a duplicate, got %+v", results)
+	}
+
+	var maxClock uint64
+	if err := store.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != startClock+2 {
+		t.Fatalf("Expected 2 journal entries after clock %d, got max clock %d, %v", startClock, maxClock, err)
+	}
+
+	var bob EmployeeResource
+	if status, _ := store.GetById(ctx, "1234", bobId, &bob); status != constants.RESOURCE_OK_CODE || bob.Employee.Name != "Bob" {
+		t.Fatalf("Expected Bob to be stored, got %d, %+v", status, bob)
+	}
+
+	staleAlice := *alice
+	updatedAlice := *alice
+	updatedAlice.Employee.Age = 31
+	carol := newEmployee("", "1234", "Carol", 50)
+	results, status, err = store.UpsertResources(ctx, []resourceStore.IResource{
+		newEmployee(bobId, "1234", "Robert", 42), // replaces Bob
+		carol,                                    // created
+		&updatedAlice,                            // an update of version 1
+		&staleAlice,                              // version 1 is no longer current
+		newEmployee(bobId, "5678", "Mallory", 1), // the id belongs to another owner
+	}, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error upserting resources: %d, %v", status, err)
+	}
+	expectBulkOutcomes(t, results, resourceStore.BULK_OUTCOME_UPDATED, resourceStore.BULK_OUTCOME_CREATED, resourceStore.BULK_OUTCOME_UPDATED,
+		resourceStore.BULK_OUTCOME_VERSION_CONFLICT, resourceStore.BULK_OUTCOME_DUPLICATE)
+	if !errors.Is(results[3].Err, resourceStore.ErrVersionMismatch) || !errors.Is(results[4].Err, resourceStore.ErrConflict) {
+		t.Fatalf("Expected a version mismatch and a conflict, got %v and %v", results[3].Err, results[4].Err)
+	}
+
+	robert := results[0].Resource.(*EmployeeResource)
+	if robert.Version != 2 || robert.LastAction != constants.RESOURCE_ACTION_UPDATE || !robert.CreatedAt.Equal(bob.CreatedAt) {
+		t.Fatalf("Expected Robert to replace Bob as version 2 with Bob's createdAt, got %+v", robert)
+	}
+	var stored EmployeeResource
+	if status, _ := store.GetById(ctx, "1234", bobId, &stored); status != constants.RESOURCE_OK_CODE || stored.Employee.Name != "Robert" || stored.Version != 2 {
+		t.Fatalf("Expected Robert to be stored as version 2, got %d, %+v", status, stored)
+	}
+	if status, _ := store.GetById(ctx, "1234", alice.Id, &stored); status != constants.RESOURCE_OK_CODE || stored.Employee.Age != 31 || stored.Version != 2 {
+		t.Fatalf("Expected Alice to be updated to version 2, got %d, %+v", status, stored)
+	}
+	if err := store.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != startClock+5 {
+		t.Fatalf("Expected 3 more journal entries, got max clock %d, %v", maxClock, err)
+	}
+
+	// a deleted resource is restored by an upsert
+	if _, status, err := store.DeleteResource(ctx, "1234", carol.Id, carol.Version, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error deleting resource: %d, %v", status, err)
+	}
+	results, _, _ = store.UpsertResources(ctx, []resourceStore.IResource{newEmployee(carol.Id, "1234", "Carol", 51)}, "1234:")
+	expectBulkOutcomes(t, results, resourceStore.BULK_OUTCOME_UPDATED)
+	if status, _ := store.GetById(ctx, "1234", carol.Id, &stored); status != constants.RESOURCE_OK_CODE || stored.Version != 3 || stored.Deleted {
+		t.Fatalf("Expected Carol to be restored as version 3, got %d, %+v", status, stored)
+	}
+
+	if results, status, err = store.CreateResources(ctx, nil, "1234:"); status != constants.RESOURCE_OK_CODE || len(results) != 0 {
+		t.Fatalf("Expected no results for no resources, got %d, %v", status, err)
+	}
+}
+
+func TestMemoryResourceStoreBulk(t *testing.T) {
+	testBulkResources(t, newMemoryStore(t))
+}
@@ -0,0 +1,102 @@
+package unittests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+)
+
+func TestResourceError(t *testing.T) {
+	kinds := []struct {
+		kind       resourceStore.ErrorKind
+		sentinel   error
+		httpStatus int
+		status     int
+	}{
+		{resourceStore.ERROR_KIND_NOT_FOUND, resourceStore.ErrNotFound, http.StatusNotFound, constants.RESOURCE_NOT_FOUND_ERROR_CODE},
+		{resourceStore.ERROR_KIND_CONFLICT, resourceStore.ErrConflict, http.StatusConflict, constants.RESOURCE_ALREADY_EXISTS_CODE},
+		{resourceStore.ERROR_KIND_VERSION_MISMATCH, resourceStore.ErrVersionMismatch, http.StatusPreconditionFailed, constants.RESOURCE_BAD_REQUEST_CODE},
+		{resourceStore.ERROR_KIND_VALIDATION, resourceStore.ErrValidation, http.StatusUnprocessableEntity, constants.RESOURCE_BAD_REQUEST_CODE},
+		{resourceStore.ERROR_KIND_UNAUTHORIZED, resourceStore.ErrUnauthorized, http.StatusForbidden, constants.RESOURCE_UNAUTHORIZED_CODE},
+		{resourceStore.ERROR_KIND_INTERNAL, resourceStore.ErrInternal, http.StatusInternalServerError, constants.RESOURCE_INTERNAL_ERROR_CODE},
+	}
+	for _, k := range kinds {
+		err := fmt.Errorf("wrapped: %w", resourceStore.NewResourceError(k.kind, "message", nil))
+		if !errors.Is(err, k.sentinel) || errors.Is(err, resourceStore.ErrNotFound) != (k.kind == resourceStore.ERROR_KIND_NOT_FOUND) {
+			t.Fatalf("Expected a %s error to match only its own sentinel", k.kind)
+		}
+		var resourceErr *resourceStore.ResourceError
+		if !errors.As(err, &resourceErr) || resourceErr.HttpStatus() != k.httpStatus || resourceErr.Status() != k.status {
+			t.Fatalf("Unexpected statuses for a %s error: %+v", k.kind, resourceErr)
+		}
+	}
+
+	// an internal error keeps its cause out of the public message
+	cause := errors.New("connection refused")
+	internal := resourceStore.NewResourceError(resourceStore.ERROR_KIND_INTERNAL, "connection refused", cause)
+	if internal.PublicMessage() != constants.INTERNAL_SERVER_ERROR || !errors.Is(internal, cause) || !strings.Contains(internal.Error(), "connection refused") {
+		t.Fatalf("Expected the cause to be wrapped but not public, got %s / %s", internal.PublicMessage(), internal.Error())
+	}
+}
+
+func TestMemoryResourceStoreErrorKinds(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(t)
+	createEmployees(t, store, "Alice")
+
+	var alice EmployeeResource
+	_, err := store.GetById(ctx, "1234", "no-such-id", &alice)
+	if !errors.Is(err, resourceStore.ErrNotFound) {
+		t.Fatalf("Expected a not found error, got %v", err)
+	}
+
+	var resources []EmployeeResource
+	if _, err := store.GetByOwnerId(ctx, "1234", &resources); err != nil || len(resources) != 1 {
+		t.Fatalf("Expected one resource, got %d, %v", len(resources), err)
+	}
+	alice = resources[0]
+
+	// the codes are the ones these failures have always been returned with, and only the errors tell them apart
+	stale := alice
+	stale.Version = 7
+	if _, status, err := store.UpdateResource(ctx, &stale, "1234", alice.Id, "1234:"); !errors.Is(err, resourceStore.ErrVersionMismatch) || status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected a version mismatch, got %d, %v", status, err)
+	}
+	missing := alice
+	missing.Id = "no-such-id"
+	if _, status, err := store.UpdateResource(ctx, &missing, "1234", missing.Id, "1234:"); !errors.Is(err, resourceStore.ErrNotFound) || status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected not found updating a missing resource, got %d, %v", status, err)
+	}
+	if _, status, err := store.UpdateResource(ctx, &alice, "5678", alice.Id, "1234:"); !errors.Is(err, resourceStore.ErrValidation) || status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected a validation error for a mismatched owner, got %d, %v", status, err)
+	}
+	if _, status, err := store.UndeleteResource(ctx, "1234", alice.Id, alice.Version, "1234:"); !errors.Is(err, resourceStore.ErrConflict) || status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected a conflict undeleting a resource that isn't deleted, got %d, %v", status, err)
+	}
+
+	// WriteHttpError maps the kind rather than the code, and only writes the public message
+	service := &serviceBase.ServiceBase{}
+	for _, c := range []struct {
+		err    error
+		status int
+	}{
+		{resourceStore.NewResourceError(resourceStore.ERROR_KIND_VALIDATION, "bad field", nil), http.StatusUnprocessableEntity},
+		{resourceStore.NewResourceError(resourceStore.ERROR_KIND_VERSION_MISMATCH, "stale", nil), http.StatusPreconditionFailed},
+		{resourceStore.NewResourceError(resourceStore.ERROR_KIND_INTERNAL, "", errors.New("secret")), http.StatusInternalServerError},
+		{errors.New("plain"), http.StatusBadRequest},
+	} {
+		recorder := httptest.NewRecorder()
+		service.WriteHttpError(recorder, constants.RESOURCE_BAD_REQUEST_CODE, c.err)
+		if recorder.Code != c.status || strings.Contains(recorder.Body.String(), "secret") {
+			t.Fatalf("Expected %d for %v, got %d: %s", c.status, c.err, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package unittests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/helpers"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+)
+
+// createResourceHistory creates, updates, deletes and undeletes a resource (versions 1 to 4) and returns the
+// resource after each change along with the journal clock of each change
+func createResourceHistory(t *testing.T, store resourceStore.IResourceStore[EmployeeResource]) ([]EmployeeResource, []uint64) {
+	ctx := context.Background()
+	var versions []EmployeeResource
+	var clocks []uint64
+	record := func(resource resourceStore.IResource, status int, err error) {
+		if status != constants.RESOURCE_OK_CODE {
+			t.Fatalf("Error changing resource: %d, %v", status, err)
+		}
+		var clock uint64
+		if err := store.GetJournalMaxClock(ctx, &clock); err != nil {
+			t.Fatalf("Error getting journal max clock: %v", err)
+		}
+		versions = append(versions, *resource.(*EmployeeResource))
+		clocks = append(clocks, clock)
+		time.Sleep(2 * time.Millisecond) // keeps the UpdatedAt of each version distinct
+	}
+
+	record(store.CreateResource(ctx, &EmployeeResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Employee: Employee{Name: "Alice", Age: 30}}, "1234:"))
+	updated := versions[0]
+	updated.Employee.Age = 31
+	record(store.UpdateResource(ctx, &updated, "1234", updated.Id, "1234:"))
+	record(store.DeleteResource(ctx, "1234", updated.Id, versions[1].Version, "1234:"))
+	record(store.UndeleteResource(ctx, "1234", updated.Id, versions[2].Version, "1234:"))
+
+	return versions, clocks
+}
+
+func testResourceHistory(t *testing.T, store resourceStore.IResourceStore[EmployeeResource]) {
+	ctx := context.Background()
+	versions, clocks := createResourceHistory(t, store)
+	id := versions[0].Id
+
+	var history []EmployeeResource
+	status, err := store.GetHistory(ctx, "1234", id, resourceStore.HistoryPage{}, &history)
+	if status != constants.RESOURCE_OK_CODE || len(history) != 4 {
+		t.Fatalf("Expected 4 versions, got %d (%d, %v)", len(history), status, err)
+	}
+	for i, resource := range history {
+		if resource.Version != uint(i+1) || resource.Version != versions[i].Version {
+			t.Fatalf("Expected version %d at position %d, got %d", i+1, i, resource.Version)
+		}
+	}
+	if history[0].Employee.Age != 30 || history[1].Employee.Age != 31 || !history[2].Deleted || history[3].Deleted {
+		t.Fatalf("Expected the snapshots of each change, got %+v", history)
+	}
+
+	history = nil
+	status, _ = store.GetHistory(ctx, "1234", id, resourceStore.HistoryPage{AfterVersion: 1, Limit: 2}, &history)
+	if status != constants.RESOURCE_OK_CODE || len(history) != 2 || history[0].Version != 2 || history[1].Version != 3 {
+		t.Fatalf("Expected versions 2 and 3 in the second page, got %+v", history)
+	}
+	history = nil
+	if status, _ = store.GetHistory(ctx, "1234", id, resourceStore.HistoryPage{AfterVersion: 4}, &history); status != constants.RESOURCE_OK_CODE || len(history) != 0 {
+		t.Fatalf("Expected an empty page after the last version, got %d, %+v", status, history)
+	}
+	if status, _ = store.GetHistory(ctx, "5678", id, resourceStore.HistoryPage{}, &history); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected not found for another owner, got %d", status)
+	}
+	if status, _ = store.GetHistory(ctx, "1234", id, resourceStore.HistoryPage{Limit: -1}, &history); status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected bad request for a negative limit, got %d", status)
+	}
+
+	var resource EmployeeResource
+	if status, err = store.GetVersion(ctx, "1234", id, 1, &resource); status != constants.RESOURCE_OK_CODE || resource.Employee.Age != 30 {
+		t.Fatalf("Expected version 1 with age 30, got %d, %v, %+v", status, err, resource)
+	}
+	if status, _ = store.GetVersion(ctx, "1234", id, 3, &resource); status != constants.RESOURCE_OK_CODE || !resource.Deleted {
+		t.Fatalf("Expected the deleted version 3, got %d, %+v", status, resource)
+	}
+	if status, _ = store.GetVersion(ctx, "1234", id, 9, &resource); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected not found for a version that doesn't exist, got %d", status)
+	}
+
+	// as of each clock, and as of the time of each change
+	for i, expectFound := range []bool{true, true, false, true} {
+		for _, asOf := range []resourceStore.AsOf{{Clock: clocks[i]}, {Time: versions[i].UpdatedAt}} {
+			resource = EmployeeResource{}
+			status, err := store.GetByIdAsOf(ctx, "1234", id, asOf, &resource)
+			if !expectFound {
+				if status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+					t.Fatalf("Expected not found as of %+v (deleted), got %d", asOf, status)
+				}
+				continue
+			}
+			if status != constants.RESOURCE_OK_CODE || resource.Version != versions[i].Version {
+				t.Fatalf("Expected version %d as of %+v, got %d (%d, %v)", versions[i].Version, asOf, resource.Version, status, err)
+			}
+		}
+	}
+	if status, _ = store.GetByIdAsOf(ctx, "1234", id, resourceStore.AsOf{Time: versions[0].UpdatedAt.Add(-time.Hour)}, &resource); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected not found before the resource was created, got %d", status)
+	}
+	for _, asOf := range []resourceStore.AsOf{{}, {Clock: clocks[0], Time: time.Now()}} {
+		if status, _ = store.GetByIdAsOf(ctx, "1234", id, asOf, &resource); status != constants.RESOURCE_BAD_REQUEST_CODE {
+			t.Fatalf("Expected bad request for %+v, got %d", asOf, status)
+		}
+	}
+}
+
+func TestMemoryResourceStoreHistory(t *testing.T) {
+	testResourceHistory(t, newMemoryStore(t))
+}
+
+func TestNounResourceRouterHistory(t *testing.T) {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	noAuthModel, err := service.NewAuthModel(security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize AuthModel: %v", err)
+	}
+
+	store := newMemoryStore(t)
+	router := helpers.NewNounResourceRouterWithStore[EmployeeResource](service, store, "employees", helpers.NounResourceAuthModels{Get: noAuthModel})
+	if router == nil {
+		t.Fatal("Expected non-nil NounResourceRouter")
+	}
+	versions, clocks := createResourceHistory(t, store)
+	itemUrl := "/v1/identities/1234/employees/" + versions[0].Id
+
+	get := func(url string, expectedStatus int, v any) {
+		recorder := httptest.NewRecorder()
+		service.Router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		if recorder.Code != expectedStatus {
+			t.Fatalf("Expected %d from GET %s, got %d: %s", expectedStatus, url, recorder.Code, recorder.Body.String())
+		}
+		if v != nil {
+			if err := json.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+				t.Fatalf("Error decoding response of GET %s: %v", url, err)
+			}
+		}
+	}
+
+	var history []EmployeeResource
+	get(itemUrl+"/history?afterVersion=1&limit=2", http.StatusOK, &history)
+	if len(history) != 2 || history[0].Version != 2 || history[1].Version != 3 {
+		t.Fatalf("Expected versions 2 and 3, got %+v", history)
+	}
+	history = nil
+	get(itemUrl+"/history?afterVersion=4", http.StatusOK, &history)
+	if history == nil || len(history) != 0 {
+		t.Fatalf("Expected an empty array after the last version, got %+v", history)
+	}
+
+	var resource EmployeeResource
+	get(itemUrl+"/versions/1", http.StatusOK, &resource)
+	if resource.Version != 1 || resource.Employee.Age != 30 {
+		t.Fatalf("Expected version 1, got %+v", resource)
+	}
+
+	resource = EmployeeResource{}
+	get(fmt.Sprintf("%s?asOfClock=%d", itemUrl, clocks[1]), http.StatusOK, &resource)
+	if resource.Version != 2 {
+		t.Fatalf("Expected version 2 as of clock %d, got %+v", clocks[1], resource)
+	}
+	resource = EmployeeResource{}
+	get(itemUrl+"?asOfTime="+versions[0].UpdatedAt.Format(time.RFC3339Nano), http.StatusOK, &resource)
+	if resource.Version != 1 {
+		t.Fatalf("Expected version 1 as of its update time, got %+v", resource)
+	}
+	get(fmt.Sprintf("%s?asOfClock=%d", itemUrl, clocks[2]), http.StatusNotFound, nil)
+
+	get(itemUrl+"/versions/9", http.StatusNotFound, nil)
+	get(itemUrl+"/versions/abc", http.StatusBadRequest, nil)
+	get(itemUrl+"/history?limit=abc", http.StatusBadRequest, nil)
+	get(itemUrl+"?asOfTime=yesterday", http.StatusBadRequest, nil)
+	get(fmt.Sprintf("%s?asOfClock=%d&asOfTime=%s", itemUrl, clocks[0], versions[0].UpdatedAt.Format(time.RFC3339Nano)), http.StatusBadRequest, nil)
+}
@@ -0,0 +1,211 @@
+package unittests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/helpers"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+)
+
+func TestApplyPatch(t *testing.T) {
+	document := `{"a":{"b":1,"c":[1,2,3]},"d":"x","e~f/g":true}`
+	tests := []struct {
+		patchType resourceStore.PatchType
+		patch     string
+		expected  string // empty when the patch should fail
+	}{
+		// RFC 7396
+		{resourceStore.PATCH_TYPE_MERGE_PATCH, `{"a":{"b":2},"d":null}`, `{"a":{"b":2,"c":[1,2,3]},"e~f/g":true}`},
+		{resourceStore.PATCH_TYPE_MERGE_PATCH, `{"a":{"c":[4]},"h":{"i":null,"j":1}}`, `{"a":{"b":1,"c":[4]},"d":"x","e~f/g":true,"h":{"j":1}}`},
+		{resourceStore.PATCH_TYPE_MERGE_PATCH, `["replaced"]`, `["replaced"]`},
+		{resourceStore.PATCH_TYPE_MERGE_PATCH, `{"a":`, ""},
+
+		// RFC 6902
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"add","path":"/a/c/1","value":9}]`, `{"a":{"b":1,"c":[1,9,2,3]},"d":"x","e~f/g":true}`},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"add","path":"/a/c/-","value":null}]`, `{"a":{"b":1,"c":[1,2,3,null]},"d":"x","e~f/g":true}`},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"remove","path":"/a/c/0"},{"op":"remove","path":"/e~0f~1g"}]`, `{"a":{"b":1,"c":[2,3]},"d":"x"}`},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"replace","path":"/d","value":{"y":1}}]`, `{"a":{"b":1,"c":[1,2,3]},"d":{"y":1},"e~f/g":true}`},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"move","from":"/a/b","path":"/b"}]`, `{"a":{"c":[1,2,3]},"b":1,"d":"x","e~f/g":true}`},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"copy","from":"/a/c","path":"/c"},{"op":"add","path":"/c/0","value":0}]`, `{"a":{"b":1,"c":[1,2,3]},"c":[0,1,2,3],"d":"x","e~f/g":true}`},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"test","path":"/a","value":{"c":[1,2,3],"b":1.0}},{"op":"replace","path":"/a/b","value":2}]`, `{"a":{"b":2,"c":[1,2,3]},"d":"x","e~f/g":true}`},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"test","path":"/d","value":"y"}]`, ""},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"replace","path":"/missing","value":1}]`, ""},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"remove","path":"/a/c/3"}]`, ""},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"add","path":"/a/c/01","value":1}]`, ""},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"add","path":"/a/b"}]`, ""},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"move","from":"/a","path":"/a/x"}]`, ""},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"increment","path":"/a/b"}]`, ""},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `[{"op":"add","path":"a","value":1}]`, ""},
+		{resourceStore.PATCH_TYPE_JSON_PATCH, `{"op":"add","path":"/a","value":1}`, ""},
+		{"application/json", `{}`, ""},
+	}
+
+	for i, test := range tests {
+		patched, err := resourceStore.ApplyPatch([]byte(document), []byte(test.patch), test.patchType)
+		if test.expected == "" {
+			if err == nil {
+				t.Fatalf("Expected test %d (%s) to fail, got %s", i, test.patch, patched)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error applying test %d (%s): %v", i, test.patch, err)
+		}
+		var actual, expected any
+		json.Unmarshal(patched, &actual)
+		json.Unmarshal([]byte(test.expected), &expected)
+		if fmt.Sprint(actual) != fmt.Sprint(expected) {
+			t.Fatalf("Expected test %d (%s) to produce %s, got %s", i, test.patch, test.expected, patched)
+		}
+	}
+}
+
+func testPatchResource(t *testing.T, store resourceStore.IResourceStore[EmployeeResource]) {
+	ctx := context.Background()
+	created, status, err := store.CreateResource(ctx, &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Alice", Age: 30},
+	}, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error creating resource: %d, %v", status, err)
+	}
+	id := created.GetResourceBase().Id
+
+	var startClock uint64
+	if err := store.GetJournalMaxClock(ctx, &startClock); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	patched, status, err := store.PatchResource(ctx, "1234", id, 1, []byte(`{"employee":{"age":31}}`), resourceStore.PATCH_TYPE_MERGE_PATCH, "5678:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error merge patching resource: %d, %v", status, err)
+	}
+	alice := patched.(*EmployeeResource)
+	if alice.Employee.Name != "Alice" || alice.Employee.Age != 31 || alice.Version != 2 || alice.UpdatedBy != "5678" || alice.LastAction != constants.RESOURCE_ACTION_UPDATE {
+		t.Fatalf("Expected only the age to change in version 2, got %+v", alice)
+	}
+
+	patched, status, err = store.PatchResource(ctx, "1234", id, 2,
+		[]byte(`[{"op":"test","path":"/employee/age","value":31},{"op":"replace","path":"/employee/name","value":"Alicia"}]`), resourceStore.PATCH_TYPE_JSON_PATCH, "1234:")
+	if status != constants.RESOURCE_OK_CODE || patched.(*EmployeeResource).Employee.Name != "Alicia" || patched.GetResourceBase().Version != 3 {
+		t.Fatalf("Expected the JSON patch to rename Alice in version 3, got %d, %v", status, err)
+	}
+
+	var stored EmployeeResource
+	if status, _ := store.GetById(ctx, "1234", id, &stored); status != constants.RESOURCE_OK_CODE || stored.Employee.Name != "Alicia" || stored.Employee.Age != 31 || stored.Version != 3 {
+		t.Fatalf("Expected the patches to be stored, got %d, %+v", status, stored)
+	}
+	var maxClock uint64
+	if err := store.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != startClock+2 {
+		t.Fatalf("Expected a journal entry per patch, got max clock %d after %d, %v", maxClock, startClock, err)
+	}
+
+	failures := []struct {
+		ownerId   string
+		version   uint
+		patch     string
+		patchType resourceStore.PatchType
+		status    int
+	}{
+		{"1234", 2, `{"employee":{"age":32}}`, resourceStore.PATCH_TYPE_MERGE_PATCH, constants.RESOURCE_BAD_REQUEST_CODE},                          // stale version
+		{"5678", 3, `{"employee":{"age":32}}`, resourceStore.PATCH_TYPE_MERGE_PATCH, constants.RESOURCE_NOT_FOUND_ERROR_CODE},                      // another owner
+		{"1234", 3, `{"version":7}`, resourceStore.PATCH_TYPE_MERGE_PATCH, constants.RESOURCE_BAD_REQUEST_CODE},                                    // a ResourceBase field
+		{"1234", 3, `{"ownerId":"5678"}`, resourceStore.PATCH_TYPE_MERGE_PATCH, constants.RESOURCE_BAD_REQUEST_CODE},                               // a ResourceBase field
+		{"1234", 3, `[{"op":"remove","path":"/deleted"}]`, resourceStore.PATCH_TYPE_JSON_PATCH, constants.RESOURCE_BAD_REQUEST_CODE},               // a ResourceBase field
+		{"1234", 3, `{"employee":{"age":"old"}}`, resourceStore.PATCH_TYPE_MERGE_PATCH, constants.RESOURCE_BAD_REQUEST_CODE},                       // not an EmployeeResource
+		{"1234", 3, `[{"op":"test","path":"/employee/age","value":30}]`, resourceStore.PATCH_TYPE_JSON_PATCH, constants.RESOURCE_BAD_REQUEST_CODE}, // failed test
+		{"1234", 3, `{"employee":{"age":32}}`, "application/json", constants.RESOURCE_UNSUPPORTED_TYPE_CODE},
+	}
+	for i, failure := range failures {
+		if _, status, err := store.PatchResource(ctx, failure.ownerId, id, failure.version, []byte(failure.patch), failure.patchType, "1234:"); status != failure.status {
+			t.Fatalf("Expected status %d from failure %d (%s), got %d, %v", failure.status, i, failure.patch, status, err)
+		}
+	}
+	if _, _, err := store.PatchResource(ctx, "1234", id, 3, []byte(`{"employee":{"age":32}}`), "application/json", "1234:"); !errors.Is(err, resourceStore.ErrValidation) {
+		t.Fatalf("Expected a validation error for an unsupported patch type, got %v", err)
+	}
+	if err := store.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != startClock+2 {
+		t.Fatalf("Expected no journal entries from failed patches, got max clock %d, %v", maxClock, err)
+	}
+
+	// a deleted resource can't be patched
+	if _, status, err := store.DeleteResource(ctx, "1234", id, 3, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error deleting resource: %d, %v", status, err)
+	}
+	if _, status, _ := store.PatchResource(ctx, "1234", id, 4, []byte(`{"employee":{"age":32}}`), resourceStore.PATCH_TYPE_MERGE_PATCH, "1234:"); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected 404 patching a deleted resource, got %d", status)
+	}
+}
+
+func TestMemoryResourceStorePatch(t *testing.T) {
+	testPatchResource(t, newMemoryStore(t))
+}
+
+func TestNounResourceRouterPatch(t *testing.T) {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	noAuthModel, err := service.NewAuthModel(security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize AuthModel: %v", err)
+	}
+
+	store := newMemoryStore(t)
+	router := helpers.NewNounResourceRouterWithStore[EmployeeResource](service, store, "employees",
+		helpers.NounResourceAuthModels{Patch: noAuthModel})
+	if router == nil {
+		t.Fatal("Expected non-nil NounResourceRouter")
+	}
+	created, _, _ := store.CreateResource(context.Background(), &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Alice", Age: 30},
+	}, "1234:")
+	id := created.GetResourceBase().Id
+
+	patch := func(version int, contentType string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/v1/identities/1234/employees/%s?version=%d", id, version), bytes.NewReader([]byte(body)))
+		request.Header.Set("X-AuthToken", "1234:")
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		service.Router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := patch(1, "application/merge-patch+json; charset=utf-8", `{"employee":{"age":31}}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from a merge patch, got %d: %s", response.Code, response.Body.String())
+	}
+	var patched EmployeeResource
+	if err := json.Unmarshal(response.Body.Bytes(), &patched); err != nil || patched.Employee.Age != 31 || patched.Version != 2 {
+		t.Fatalf("Expected the patched resource in the response, got %s, %v", response.Body.String(), err)
+	}
+
+	response = patch(2, "application/json-patch+json", `[{"op":"replace","path":"/employee/name","value":"Alicia"}]`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from a JSON patch, got %d: %s", response.Code, response.Body.String())
+	}
+
+	if response = patch(3, "application/json", `{"employee":{"age":32}}`); response.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Expected 415 for an unsupported Content-Type, got %d", response.Code)
+	}
+	if response = patch(2, "application/merge-patch+json", `{"employee":{"age":32}}`); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 for a stale version, got %d", response.Code)
+	}
+	if response = patch(3, "application/merge-patch+json", `{"id":"other"}`); response.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422 for a patch of the id, got %d", response.Code)
+	}
+}
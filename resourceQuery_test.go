@@ -0,0 +1,68 @@
+package unittests
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+)
+
+func TestParseResourceQuery(t *testing.T) {
+	params, _ := url.ParseQuery("filter=employee.age:range:30,&filter=employee.name:in:Alice,Bob&filter=employee.name:prefix:12&sort=-employee.age,employee.name&limit=25")
+
+	query, err := resourceStore.ParseResourceQuery(params)
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	if len(query.Filters) != 3 {
+		t.Fatalf("Expected 3 filters, got %d", len(query.Filters))
+	}
+
+	rangeFilter := query.Filters[0]
+	if rangeFilter.Op != resourceStore.QUERY_OP_RANGE || rangeFilter.Field != "employee.age" {
+		t.Fatalf("Unexpected range filter: %+v", rangeFilter)
+	}
+	if rangeFilter.Values[0] != float64(30) || rangeFilter.Values[1] != nil {
+		t.Fatalf("Expected an open-ended range from 30, got %v", rangeFilter.Values)
+	}
+
+	inFilter := query.Filters[1]
+	if len(inFilter.Values) != 2 || inFilter.Values[0] != "Alice" || inFilter.Values[1] != "Bob" {
+		t.Fatalf("Unexpected in filter values: %v", inFilter.Values)
+	}
+
+	// a prefix stays a string even when it looks like a number
+	if query.Filters[2].Values[0] != "12" {
+		t.Fatalf("Expected string prefix '12', got %v", query.Filters[2].Values[0])
+	}
+
+	if len(query.Sort) != 2 || !query.Sort[0].Descending || query.Sort[0].Field != "employee.age" || query.Sort[1].Descending {
+		t.Fatalf("Unexpected sort keys: %+v", query.Sort)
+	}
+	if query.Limit != 25 {
+		t.Fatalf("Expected limit 25, got %d", query.Limit)
+	}
+}
+
+func TestParseResourceQueryFails(t *testing.T) {
+	invalid := []string{
+		"filter=employee.age",
+		"filter=employee.age:gt:30",
+		"filter=employee.age:range:,",
+		"filter=employee..age:eq:30",
+		"limit=0",
+		"limit=100000",
+		"cursor=not-a-cursor",
+	}
+
+	for _, rawQuery := range invalid {
+		params, _ := url.ParseQuery(rawQuery)
+		if _, err := resourceStore.ParseResourceQuery(params); err == nil {
+			t.Errorf("Expected an error parsing '%s'", rawQuery)
+		}
+	}
+
+	if resourceStore.HasResourceQueryParams(url.Values{"AgeOver": {"30"}}) {
+		t.Error("Expected unrelated query parameters to be ignored")
+	}
+}
@@ -1,14 +1,22 @@
 package unittests
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/geraldhinson/siftd-base/pkg/constants"
 	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
 	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+	"github.com/jackc/pgx/v5"
 	"github.com/spf13/viper"
 )
 
@@ -290,7 +298,7 @@ func TestHealthCheck(t *testing.T) {
 		t.Fatal("Expected non-nil store")
 	}
 
-	err := gResourceStore.HealthCheck()
+	err := gResourceStore.HealthCheck(context.Background())
 	if err != nil {
 		t.Errorf("Error checking health: %s", err)
 	}
@@ -309,7 +317,7 @@ func TestCreateResource(t *testing.T) {
 	// this simulates the additional auth token that is added to the header by the security layer
 	addedSecurityHeader := resourceA.ResourceBase.OwnerId + ":" // owner w/o impersonation
 
-	createdResource, status, errmsg := gResourceStore.CreateResource(resourceA, addedSecurityHeader)
+	createdResource, status, errmsg := gResourceStore.CreateResource(context.Background(), resourceA, addedSecurityHeader)
 	if status != constants.RESOURCE_OK_CODE {
 		t.Errorf("Error creating resource: %d, %v", status, errmsg)
 		return
@@ -350,7 +358,7 @@ func TestCreateResourceFails(t *testing.T) {
 	// this simulates the additional auth token that is added to the header by the security layer
 	addedSecurityHeader := resourceA.ResourceBase.OwnerId + ":" // owner w/o impersonation
 
-	createdResource, status, errmsg := gResourceStore.CreateResource(resourceA, addedSecurityHeader)
+	createdResource, status, errmsg := gResourceStore.CreateResource(context.Background(), resourceA, addedSecurityHeader)
 	if status != constants.RESOURCE_OK_CODE {
 		t.Errorf("Error creating resource: %d, %v", status, errmsg)
 		return
@@ -365,7 +373,7 @@ func TestCreateResourceFails(t *testing.T) {
 			OwnerId: "1234"},
 		Employee: Employee{Name: "Goober", Age: 30},
 	}
-	createdResource, status, errmsg = gResourceStore.CreateResource(resourceDuplicateId, addedSecurityHeader)
+	createdResource, status, errmsg = gResourceStore.CreateResource(context.Background(), resourceDuplicateId, addedSecurityHeader)
 	if status != constants.RESOURCE_ALREADY_EXISTS_CODE {
 		t.Errorf("Error creating resource - expected duplicate id error: %d, %v", status, errmsg)
 		return
@@ -391,13 +399,13 @@ func TestUpdateResource(t *testing.T) {
 	// this simulates the additional auth token that is added to the header by the security layer
 	addedSecurityHeader := resourceA.ResourceBase.OwnerId + ":" // owner w/o impersonation
 
-	createdResource, status, errmsg := gResourceStore.CreateResource(resourceA, addedSecurityHeader)
+	createdResource, status, errmsg := gResourceStore.CreateResource(context.Background(), resourceA, addedSecurityHeader)
 	if status != constants.RESOURCE_OK_CODE {
 		t.Errorf("Error creating resource: %d, %v", status, errmsg)
 		return
 	}
 	resourceA.Employee.Name = "Bob's Uncle"
-	updatedResource, status, errmsg := gResourceStore.UpdateResource(resourceA, resourceA.OwnerId, resourceA.Id, addedSecurityHeader)
+	updatedResource, status, errmsg := gResourceStore.UpdateResource(context.Background(), resourceA, resourceA.OwnerId, resourceA.Id, addedSecurityHeader)
 	if status != constants.RESOURCE_OK_CODE {
 		t.Errorf("Error updating resource: %d, %v", status, errmsg)
 		return
@@ -444,7 +452,7 @@ func TestUpdateResourceFails(t *testing.T) {
 	// this simulates the additional auth token that is added to the header by the security layer
 	addedSecurityHeader := resourceA.ResourceBase.OwnerId + ":" // owner w/o impersonation
 
-	createdResource, status, errmsg := gResourceStore.CreateResource(resourceA, addedSecurityHeader)
+	createdResource, status, errmsg := gResourceStore.CreateResource(context.Background(), resourceA, addedSecurityHeader)
 	if status != constants.RESOURCE_OK_CODE {
 		t.Errorf("Error creating resource: %d, %v", status, errmsg)
 		return
@@ -456,7 +464,7 @@ func TestUpdateResourceFails(t *testing.T) {
 	// Test invalid version
 	resourceA.Employee.Name = "Bob's Aunt"
 	resourceA.ResourceBase.Version = 2 // Set version to 1 to simulate a conflict
-	updatedResource, status, errmsg := gResourceStore.UpdateResource(resourceA, resourceA.OwnerId, resourceA.Id, addedSecurityHeader)
+	updatedResource, status, errmsg := gResourceStore.UpdateResource(context.Background(), resourceA, resourceA.OwnerId, resourceA.Id, addedSecurityHeader)
 	if status != constants.RESOURCE_BAD_REQUEST_CODE {
 		t.Errorf("Error updating resource - wrong status returned for invalid version test: %d, %v", status, errmsg)
 		return
@@ -472,7 +480,7 @@ func TestUpdateResourceFails(t *testing.T) {
 	resourceA.Employee.Name = "Bob's Aunt"
 	resourceA.ResourceBase.Version = 1       // Set version to 1 to simulate a conflict
 	var BadOwnerId = "NON-EXISTENT-OWNER-ID" // Set owner ID to a non-existent value
-	updatedResource, status, errmsg = gResourceStore.UpdateResource(resourceA, BadOwnerId, resourceA.Id, addedSecurityHeader)
+	updatedResource, status, errmsg = gResourceStore.UpdateResource(context.Background(), resourceA, BadOwnerId, resourceA.Id, addedSecurityHeader)
 	if status != constants.RESOURCE_BAD_REQUEST_CODE {
 		t.Errorf("Error updating resource - wrong status returned for invalid ownerId param test: %d, %v", status, errmsg)
 		return
@@ -489,7 +497,7 @@ func TestUpdateResourceFails(t *testing.T) {
 	resourceA.ResourceBase.Version = 1
 	var saveResourceId = resourceA.Id
 	resourceA.Id = "NON-EXISTENT-ID" // Set ID to a non-existent value
-	updatedResource, status, errmsg = gResourceStore.UpdateResource(resourceA, resourceA.OwnerId, resourceA.Id, addedSecurityHeader)
+	updatedResource, status, errmsg = gResourceStore.UpdateResource(context.Background(), resourceA, resourceA.OwnerId, resourceA.Id, addedSecurityHeader)
 	if status != constants.RESOURCE_BAD_REQUEST_CODE {
 		t.Errorf("Error updating resource - wrong status returned for invalid id in body test: %d, %v", status, errmsg)
 		return
@@ -506,7 +514,7 @@ func TestUpdateResourceFails(t *testing.T) {
 	resourceA.Employee.Name = "Bob's Aunt"
 	resourceA.ResourceBase.Version = 1
 	var BadIdParam = "NON-EXISTENT-ID" // Set ID to a non-existent value
-	updatedResource, status, errmsg = gResourceStore.UpdateResource(resourceA, resourceA.OwnerId, BadIdParam, addedSecurityHeader)
+	updatedResource, status, errmsg = gResourceStore.UpdateResource(context.Background(), resourceA, resourceA.OwnerId, BadIdParam, addedSecurityHeader)
 	if status != constants.RESOURCE_BAD_REQUEST_CODE {
 		t.Errorf("Error updating resource - wrong status returned for invalid id param test: %d, %v", status, errmsg)
 		return
@@ -533,14 +541,14 @@ func TestGetById(t *testing.T) {
 	// this simulates the additional auth token that is added to the header by the security layer
 	addedSecurityHeader := resourceA.ResourceBase.OwnerId + ":" // owner w/o impersonation
 
-	createdResource, status, errmsg := gResourceStore.CreateResource(resourceA, addedSecurityHeader)
+	createdResource, status, errmsg := gResourceStore.CreateResource(context.Background(), resourceA, addedSecurityHeader)
 	if status != constants.RESOURCE_OK_CODE {
 		t.Errorf("Error creating resource: %d, %v", status, errmsg)
 		return
 	}
 
 	var fetchedResource EmployeeResource
-	status, errmsg = gResourceStore.GetById(createdResource.GetResourceBase().Id, createdResource.GetResourceBase().OwnerId, &fetchedResource)
+	status, errmsg = gResourceStore.GetById(context.Background(), createdResource.GetResourceBase().OwnerId, createdResource.GetResourceBase().Id, &fetchedResource)
 	if status != constants.RESOURCE_OK_CODE {
 		t.Errorf("Error getting resource by id: %d, %v", status, errmsg)
 		return
@@ -564,7 +572,7 @@ func TestGetByIdFail(t *testing.T) {
 	}
 
 	var fetchedResource EmployeeResource
-	status, errmsg := gResourceStore.GetById(resourceA.Id, resourceA.OwnerId, &fetchedResource)
+	status, errmsg := gResourceStore.GetById(context.Background(), resourceA.OwnerId, resourceA.Id, &fetchedResource)
 	if status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
 		t.Errorf("Error found resource by bogus id: %d, %v", status, errmsg)
 		return
@@ -577,7 +585,7 @@ func TestGetByOwnerId(t *testing.T) {
 	}
 
 	var fetchedResources = []EmployeeResource{}
-	status, errmsg := gResourceStore.GetByOwnerId("1234", &fetchedResources)
+	status, errmsg := gResourceStore.GetByOwnerId(context.Background(), "1234", &fetchedResources)
 	if status != constants.RESOURCE_OK_CODE {
 		t.Errorf("Error getting resource by owner id: %d, %v", status, errmsg)
 		return
@@ -599,7 +607,7 @@ func TestGetJournalMaxClock(t *testing.T) {
 	}
 
 	var maxClock uint64
-	err := gResourceStore.GetJournalMaxClock(&maxClock)
+	err := gResourceStore.GetJournalMaxClock(context.Background(), &maxClock)
 	if err != nil {
 		t.Errorf("Error getting journal max clock: %v", err)
 		return
@@ -615,7 +623,7 @@ func TestGetJournalChanges(t *testing.T) {
 	}
 
 	var maxClock uint64
-	err := gResourceStore.GetJournalMaxClock(&maxClock)
+	err := gResourceStore.GetJournalMaxClock(context.Background(), &maxClock)
 	if err != nil {
 		t.Errorf("Error getting journal max clock: %v", err)
 		return
@@ -623,7 +631,7 @@ func TestGetJournalChanges(t *testing.T) {
 
 	if maxClock > 0 {
 		var journalEntries = []resourceStore.ResourceJournalEntry{}
-		err := gResourceStore.GetJournalChanges(1, int64(maxClock), &journalEntries) // TODO: fix the type of limit in the API
+		err := gResourceStore.GetJournalChanges(context.Background(), 1, int64(maxClock), &journalEntries) // TODO: fix the type of limit in the API
 		if err != nil {
 			t.Errorf("Error getting journal entries: %v", err)
 			return
@@ -661,7 +669,1166 @@ func TestGetJournalChanges(t *testing.T) {
 	}
 }
 
-// TODO: add a 'Delete' (aka UpdateResource with Deleted = true) test
+func TestDeleteResource(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+
+	resourceA := &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Eve", Age: 52},
+	}
+
+	// this simulates the additional auth token that is added to the header by the security layer
+	addedSecurityHeader := resourceA.ResourceBase.OwnerId + ":" // owner w/o impersonation
+
+	createdResource, status, errmsg := gResourceStore.CreateResource(context.Background(), resourceA, addedSecurityHeader)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Errorf("Error creating resource: %d, %v", status, errmsg)
+		return
+	}
+	if createdResource.GetResourceBase().LastAction != constants.RESOURCE_ACTION_CREATE {
+		t.Fatalf("Expected last action %s, got %s", constants.RESOURCE_ACTION_CREATE, createdResource.GetResourceBase().LastAction)
+	}
+
+	var maxClockBefore uint64
+	err := gResourceStore.GetJournalMaxClock(context.Background(), &maxClockBefore)
+	if err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	deletedResource, status, errmsg := gResourceStore.DeleteResource(context.Background(), resourceA.OwnerId, resourceA.Id, 1, addedSecurityHeader)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error deleting resource: %d, %v", status, errmsg)
+	}
+	if deletedResource == nil {
+		t.Fatal("Expected non-nil deleted resource")
+	}
+	if !deletedResource.GetResourceBase().Deleted {
+		t.Fatal("Expected Deleted to be true")
+	}
+	if deletedResource.GetResourceBase().Version != 2 {
+		t.Fatalf("Expected version 2, got %d", deletedResource.GetResourceBase().Version)
+	}
+	if deletedResource.GetResourceBase().LastAction != constants.RESOURCE_ACTION_DELETE {
+		t.Fatalf("Expected last action %s, got %s", constants.RESOURCE_ACTION_DELETE, deletedResource.GetResourceBase().LastAction)
+	}
+	if deletedResource.GetResourceBase().UpdatedBy != resourceA.OwnerId {
+		t.Fatalf("Expected updated by %s, got %s", resourceA.OwnerId, deletedResource.GetResourceBase().UpdatedBy)
+	}
+	if deletedResource.(*EmployeeResource).Employee.Name != "Eve" {
+		t.Fatalf("Expected employee name 'Eve', got %s", deletedResource.(*EmployeeResource).Employee.Name)
+	}
+
+	// the tombstone must be in the journal
+	var journalEntries = []resourceStore.ResourceJournalEntry{}
+	err = gResourceStore.GetJournalChanges(context.Background(), int64(maxClockBefore)+1, 10, &journalEntries)
+	if err != nil {
+		t.Fatalf("Error getting journal entries: %v", err)
+	}
+	foundTombstone := false
+	for _, entry := range journalEntries {
+		var journaled EmployeeResource
+		if err := json.Unmarshal(entry.Resource, &journaled); err != nil {
+			t.Fatalf("Error unmarshaling journal entry: %v", err)
+		}
+		if journaled.Id == resourceA.Id && journaled.Deleted && journaled.Version == 2 {
+			foundTombstone = true
+		}
+	}
+	if !foundTombstone {
+		t.Fatal("Expected a tombstone entry in the journal for the deleted resource")
+	}
+
+	// deleted resources are not found unless explicitly asked for
+	var fetchedResource EmployeeResource
+	status, _ = gResourceStore.GetById(context.Background(), resourceA.OwnerId, resourceA.Id, &fetchedResource)
+	if status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected deleted resource to be reported as not found, got %d", status)
+	}
+	status, errmsg = gResourceStore.GetByIdIncludingDeleted(context.Background(), resourceA.OwnerId, resourceA.Id, &fetchedResource)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error getting deleted resource by id: %d, %v", status, errmsg)
+	}
+	if !fetchedResource.Deleted {
+		t.Fatal("Expected fetched resource to be marked deleted")
+	}
+
+	var ownedResources = []EmployeeResource{}
+	status, errmsg = gResourceStore.GetByOwnerId(context.Background(), resourceA.OwnerId, &ownedResources)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error getting resources by owner id: %d, %v", status, errmsg)
+	}
+	for _, owned := range ownedResources {
+		if owned.Id == resourceA.Id {
+			t.Fatal("Expected deleted resource to be excluded from GetByOwnerId")
+		}
+	}
+
+	// restore it
+	restoredResource, status, errmsg := gResourceStore.UndeleteResource(context.Background(), resourceA.OwnerId, resourceA.Id, 2, addedSecurityHeader)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error undeleting resource: %d, %v", status, errmsg)
+	}
+	if restoredResource.GetResourceBase().Deleted {
+		t.Fatal("Expected Deleted to be false after undelete")
+	}
+	if restoredResource.GetResourceBase().Version != 3 {
+		t.Fatalf("Expected version 3, got %d", restoredResource.GetResourceBase().Version)
+	}
+	if restoredResource.GetResourceBase().LastAction != constants.RESOURCE_ACTION_UNDELETE {
+		t.Fatalf("Expected last action %s, got %s", constants.RESOURCE_ACTION_UNDELETE, restoredResource.GetResourceBase().LastAction)
+	}
+	status, errmsg = gResourceStore.GetById(context.Background(), resourceA.OwnerId, resourceA.Id, &fetchedResource)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error getting restored resource by id: %d, %v", status, errmsg)
+	}
+}
+
+func TestDeleteResourceFails(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+
+	resourceA := &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Frank", Age: 61},
+	}
+
+	// this simulates the additional auth token that is added to the header by the security layer
+	addedSecurityHeader := resourceA.ResourceBase.OwnerId + ":" // owner w/o impersonation
+
+	_, status, errmsg := gResourceStore.CreateResource(context.Background(), resourceA, addedSecurityHeader)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Errorf("Error creating resource: %d, %v", status, errmsg)
+		return
+	}
+
+	// wrong version
+	deletedResource, status, errmsg := gResourceStore.DeleteResource(context.Background(), resourceA.OwnerId, resourceA.Id, 5, addedSecurityHeader)
+	if status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Error deleting resource - wrong status returned for invalid version test: %d, %v", status, errmsg)
+	}
+	if deletedResource != nil {
+		t.Fatal("Expected nil deleted resource for invalid version test")
+	}
+
+	// wrong owner
+	_, status, errmsg = gResourceStore.DeleteResource(context.Background(), "NON-EXISTENT-OWNER-ID", resourceA.Id, 1, addedSecurityHeader)
+	if status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Error deleting resource - wrong status returned for invalid owner test: %d, %v", status, errmsg)
+	}
+
+	// non-existent id
+	_, status, errmsg = gResourceStore.DeleteResource(context.Background(), resourceA.OwnerId, "NON-EXISTENT-ID", 1, addedSecurityHeader)
+	if status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Error deleting resource - wrong status returned for invalid id test: %d, %v", status, errmsg)
+	}
+
+	// undelete of a resource that is not deleted
+	_, status, errmsg = gResourceStore.UndeleteResource(context.Background(), resourceA.OwnerId, resourceA.Id, 1, addedSecurityHeader)
+	if status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Error undeleting resource - wrong status returned for not-deleted test: %d, %v", status, errmsg)
+	}
+}
+
+func TestQueryByOwnerId(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+	ctx := context.Background()
+
+	// a fresh owner so that results from other tests don't interfere
+	ownerId := "query-owner-" + time.Now().Format("150405.000000")
+	addedSecurityHeader := ownerId + ":" // owner w/o impersonation
+	for i, name := range []string{"Ann", "Ben", "Cat", "Dan", "Ed"} {
+		resource := &EmployeeResource{
+			ResourceBase: resourceStore.ResourceBase{OwnerId: ownerId},
+			Employee:     Employee{Name: name, Age: 20 + i*10},
+		}
+		_, status, errmsg := gResourceStore.CreateResource(ctx, resource, addedSecurityHeader)
+		if status != constants.RESOURCE_OK_CODE {
+			t.Fatalf("Error creating resource: %d, %v", status, errmsg)
+		}
+	}
+
+	// ages 30..60 sorted oldest first, two per page
+	query := resourceStore.ResourceQuery{
+		Filters: []resourceStore.QueryFilter{{Field: "employee.age", Op: resourceStore.QUERY_OP_RANGE, Values: []any{30, 60}}},
+		Sort:    []resourceStore.QuerySortKey{{Field: "employee.age", Descending: true}},
+		Limit:   2,
+	}
+	var names []string
+	for page := 0; page < 5; page++ {
+		var resources []EmployeeResource
+		nextCursor, status, errmsg := gResourceStore.QueryByOwnerId(ctx, ownerId, query, &resources)
+		if status != constants.RESOURCE_OK_CODE {
+			t.Fatalf("Error querying resources: %d, %v", status, errmsg)
+		}
+		for _, resource := range resources {
+			names = append(names, resource.Employee.Name)
+		}
+		if nextCursor == "" {
+			break
+		}
+		query.Cursor = nextCursor
+	}
+	if strings.Join(names, ",") != "Ed,Dan,Cat,Ben" {
+		t.Fatalf("Expected Ed,Dan,Cat,Ben got %v", names)
+	}
+
+	// equality, in and prefix
+	var resources []EmployeeResource
+	_, status, errmsg := gResourceStore.QueryByOwnerId(ctx, ownerId, resourceStore.ResourceQuery{
+		Filters: []resourceStore.QueryFilter{{Field: "employee.name", Op: resourceStore.QUERY_OP_EQ, Values: []any{"Cat"}}},
+	}, &resources)
+	if status != constants.RESOURCE_OK_CODE || len(resources) != 1 || resources[0].Employee.Age != 40 {
+		t.Fatalf("Unexpected eq query result: %d, %v, %v", status, errmsg, resources)
+	}
+
+	resources = nil
+	_, status, errmsg = gResourceStore.QueryByOwnerId(ctx, ownerId, resourceStore.ResourceQuery{
+		Filters: []resourceStore.QueryFilter{{Field: "employee.name", Op: resourceStore.QUERY_OP_IN, Values: []any{"Ann", "Ed", "Zed"}}},
+		Sort:    []resourceStore.QuerySortKey{{Field: "employee.name"}},
+	}, &resources)
+	if status != constants.RESOURCE_OK_CODE || len(resources) != 2 || resources[0].Employee.Name != "Ann" {
+		t.Fatalf("Unexpected in query result: %d, %v, %v", status, errmsg, resources)
+	}
+
+	resources = nil
+	_, status, errmsg = gResourceStore.QueryByOwnerId(ctx, ownerId, resourceStore.ResourceQuery{
+		Filters: []resourceStore.QueryFilter{{Field: "employee.name", Op: resourceStore.QUERY_OP_PREFIX, Values: []any{"D"}}},
+	}, &resources)
+	if status != constants.RESOURCE_OK_CODE || len(resources) != 1 || resources[0].Employee.Name != "Dan" {
+		t.Fatalf("Unexpected prefix query result: %d, %v, %v", status, errmsg, resources)
+	}
+
+	// a cursor can't be reused with a different sort order
+	query.Sort = []resourceStore.QuerySortKey{{Field: "employee.name"}}
+	_, status, _ = gResourceStore.QueryByOwnerId(ctx, ownerId, query, &resources)
+	if status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected bad request for a mismatched cursor, got %d", status)
+	}
+}
+
+func TestInTx(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+	ctx := context.Background()
+
+	item := &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Grace", Age: 33},
+	}
+	addedSecurityHeader := item.ResourceBase.OwnerId + ":" // owner w/o impersonation
+
+	_, status, errmsg := gResourceStore.CreateResource(ctx, item, addedSecurityHeader)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error creating resource: %d, %v", status, errmsg)
+	}
+
+	var maxClockBefore uint64
+	if err := gResourceStore.GetJournalMaxClock(ctx, &maxClockBefore); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	// move the item to another owner as one unit of work
+	movedItem := &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "5678"},
+		Employee:     item.Employee,
+	}
+	status, errmsg = gResourceStore.InTx(ctx, func(tx resourceStore.ResourceTx[EmployeeResource]) error {
+		var current EmployeeResource
+		if _, err := tx.GetById(item.OwnerId, item.Id, &current); err != nil {
+			return err
+		}
+		if _, _, err := tx.DeleteResource(current.OwnerId, current.Id, current.Version, addedSecurityHeader); err != nil {
+			return err
+		}
+		_, _, err := tx.CreateResource(movedItem, addedSecurityHeader)
+		return err
+	})
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error running InTx: %d, %v", status, errmsg)
+	}
+
+	var fetched EmployeeResource
+	status, _ = gResourceStore.GetById(ctx, item.OwnerId, item.Id, &fetched)
+	if status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected moved-from resource to be deleted, got %d", status)
+	}
+	status, errmsg = gResourceStore.GetById(ctx, movedItem.OwnerId, movedItem.Id, &fetched)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Expected moved-to resource to exist: %d, %v", status, errmsg)
+	}
+
+	// both journal rows must be present with contiguous clocks
+	var journalEntries = []resourceStore.ResourceJournalEntry{}
+	if err := gResourceStore.GetJournalChanges(ctx, int64(maxClockBefore)+1, 10, &journalEntries); err != nil {
+		t.Fatalf("Error getting journal entries: %v", err)
+	}
+	if len(journalEntries) != 2 {
+		t.Fatalf("Expected 2 journal entries from the unit of work, got %d", len(journalEntries))
+	}
+	if journalEntries[1].Clock != journalEntries[0].Clock+1 {
+		t.Fatalf("Expected contiguous clocks, got %d and %d", journalEntries[0].Clock, journalEntries[1].Clock)
+	}
+}
+
+func TestInTxRollback(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+	ctx := context.Background()
+
+	existing := &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Heidi", Age: 29},
+	}
+	addedSecurityHeader := existing.ResourceBase.OwnerId + ":" // owner w/o impersonation
+
+	_, status, errmsg := gResourceStore.CreateResource(ctx, existing, addedSecurityHeader)
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error creating resource: %d, %v", status, errmsg)
+	}
+
+	var maxClockBefore uint64
+	if err := gResourceStore.GetJournalMaxClock(ctx, &maxClockBefore); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	created := &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Ivan", Age: 50},
+	}
+	// the version conflict on the update must undo the create, even though fn ignores the failure
+	status, errmsg = gResourceStore.InTx(ctx, func(tx resourceStore.ResourceTx[EmployeeResource]) error {
+		if _, _, err := tx.CreateResource(created, addedSecurityHeader); err != nil {
+			return err
+		}
+		existing.Version = 7
+		tx.UpdateResource(existing, existing.OwnerId, existing.Id, addedSecurityHeader)
+		return nil
+	})
+	if status != constants.RESOURCE_BAD_REQUEST_CODE {
+		t.Fatalf("Expected version conflict from InTx, got %d, %v", status, errmsg)
+	}
+
+	var fetched EmployeeResource
+	status, _ = gResourceStore.GetById(ctx, created.OwnerId, created.Id, &fetched)
+	if status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected the create to be rolled back, got %d", status)
+	}
+
+	var maxClockAfter uint64
+	if err := gResourceStore.GetJournalMaxClock(ctx, &maxClockAfter); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+	if maxClockAfter != maxClockBefore {
+		t.Fatalf("Expected no journal entries from a rolled back unit of work, max clock went from %d to %d", maxClockBefore, maxClockAfter)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var fetchedResources = []EmployeeResource{}
+	status, errmsg := gResourceStore.GetByOwnerId(ctx, "1234", &fetchedResources)
+	if status != constants.RESOURCE_INTERNAL_ERROR_CODE {
+		t.Fatalf("Expected internal error status for a cancelled context, got %d, %v", status, errmsg)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+	err := gResourceStore.HealthCheck(ctx)
+	if err == nil {
+		t.Fatal("Expected health check to fail for an expired deadline")
+	}
+}
+
+func TestCloseResourceStore(t *testing.T) {
+	store, err := resourceStore.NewPostgresResourceStoreWithJournal[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger)
+	if err != nil {
+		t.Fatalf("Error creating PostgresResourceStoreWithJournal: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := store.Close(ctx); err != nil {
+		t.Fatalf("Error closing resource store: %v", err)
+	}
+
+	if err := store.HealthCheck(context.Background()); err == nil {
+		t.Fatal("Expected health check to fail on a closed store")
+	}
+}
+
+func TestSubscribeJournal(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+
+	var maxClock uint64
+	if err := gResourceStore.GetJournalMaxClock(context.Background(), &maxClock); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	entries := gResourceStore.SubscribeJournal(ctx, maxClock+1)
+
+	resourceA := &EmployeeResource{
+		ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"},
+		Employee:     Employee{Name: "Alice", Age: 30},
+	}
+	createdResource, status, errmsg := gResourceStore.CreateResource(context.Background(), resourceA, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error creating resource: %d, %v", status, errmsg)
+	}
+
+	select {
+	case entry, ok := <-entries:
+		if !ok {
+			t.Fatal("Expected the subscription to stay open")
+		}
+		if entry.Clock <= maxClock {
+			t.Fatalf("Expected a journal entry after clock %d, got %d", maxClock, entry.Clock)
+		}
+		var journaledResource EmployeeResource
+		if err := json.Unmarshal(entry.Resource, &journaledResource); err != nil {
+			t.Fatalf("Error unmarshaling journal entry: %v", err)
+		}
+		if journaledResource.Id != createdResource.GetResourceBase().Id {
+			t.Fatalf("Expected journal entry for %s, got %s", createdResource.GetResourceBase().Id, journaledResource.Id)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the journal entry to be pushed")
+	}
+
+	cancel()
+	for range entries {
+		// drain until the subscription closes
+	}
+}
+
+func TestSubscribeJournalConcurrentWrites(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+
+	var maxClock uint64
+	if err := gResourceStore.GetJournalMaxClock(context.Background(), &maxClock); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	entries := gResourceStore.SubscribeJournal(ctx, maxClock+1)
+
+	// writes racing each other still commit in clock order, so the subscription can't move past one that is late
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resource := &EmployeeResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Employee: Employee{Name: fmt.Sprintf("Concurrent %d", i)}}
+			if _, status, err := gResourceStore.CreateResource(context.Background(), resource, "1234:"); status != constants.RESOURCE_OK_CODE {
+				t.Errorf("Error creating resource: %d, %v", status, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var clocks []uint64
+	for len(clocks) < writers {
+		select {
+		case entry := <-entries:
+			clocks = append(clocks, entry.Clock)
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for the journal entries, got %v", clocks)
+		}
+	}
+	for i := range clocks {
+		if clocks[i] != clocks[0]+uint64(i) {
+			t.Fatalf("Expected contiguous clocks, got %v", clocks)
+		}
+	}
+
+	cancel()
+	for range entries {
+		// drain until the subscription closes
+	}
+}
+
+func TestSubscribeJournalSharedListener(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	_, tables := newEmptyStore(t, "subscribers")
+	store := newPartitionStore(t, tables, "TEST-SUBSCRIBERS")
+	dbPool, err := gServiceBase.Stores.Pool(gServiceBase.Configuration.GetString(constants.DB_CONNECTION_STRING))
+	if err != nil {
+		t.Fatalf("Error getting the database pool: %v", err)
+	}
+	listeners := func() int {
+		var count int
+		query := `SELECT COUNT(*) FROM pg_stat_activity WHERE query = $1;`
+		if err := dbPool.QueryRow(context.Background(), query, store.Cmds.GetListenJournalCommand("TEST-SUBSCRIBERS")).Scan(&count); err != nil {
+			t.Fatalf("Error counting the listener connections: %v", err)
+		}
+		return count
+	}
+
+	// the subscriptions of a store share one listener connection
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	const subscribers = 10
+	var subscriptions []<-chan resourceStore.ResourceJournalEntry
+	for range subscribers {
+		subscriptions = append(subscriptions, store.SubscribeJournal(ctx, 1))
+	}
+	createEmployees(t, store, "Alice")
+	for i, entries := range subscriptions {
+		select {
+		case entry := <-entries:
+			if entry.Clock != 1 {
+				t.Fatalf("Expected subscription %d to get clock 1, got %d", i, entry.Clock)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for subscription %d", i)
+		}
+	}
+	if count := listeners(); count != 1 {
+		t.Fatalf("Expected one listener connection for %d subscriptions, got %d", subscribers, count)
+	}
+
+	// and it is closed after the last one ends
+	cancel()
+	for _, entries := range subscriptions {
+		for range entries {
+			// drain until the subscription closes
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for listeners() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the listener connection to be closed after the last subscription")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPostgresCommandHelperTables(t *testing.T) {
+	// the zero value keeps using the original tables
+	query, _ := (&resourceStore.PostgresCommandHelper{}).GetResourceByIdCommand("id", "owner", false)
+	if !strings.Contains(query, `"public"."Resources"`) {
+		t.Fatalf("Expected the default resources table, got %s", query)
+	}
+
+	tables := resourceStore.NounTableNames("sales", "orders")
+	if tables.Resources != "OrdersResources" || tables.Journal != "OrdersJournal" {
+		t.Fatalf("Expected tables derived from the noun, got %+v", tables)
+	}
+	cmds, err := resourceStore.NewPostgresCommandHelper(tables)
+	if err != nil {
+		t.Fatalf("Error creating PostgresCommandHelper: %v", err)
+	}
+	query, _ = cmds.GetInsertResourceWithJournalCommand(&EmployeeResource{}, []byte("{}"), "US-EAST")
+	if !strings.Contains(query, `INSERT INTO "sales"."OrdersResources"`) || !strings.Contains(query, `INSERT INTO "sales"."OrdersJournal"`) {
+		t.Fatalf("Expected the noun tables, got %s", query)
+	}
+	if strings.Contains(query, `"Resources"`) || strings.Contains(query, `"Journal"`) {
+		t.Fatalf("Expected no references to the default tables, got %s", query)
+	}
+
+	// quotes are escaped rather than ending the identifier
+	cmds, err = resourceStore.NewPostgresCommandHelper(resourceStore.TableNames{Resources: `Bad"; drop table "Resources`})
+	if err != nil {
+		t.Fatalf("Error creating PostgresCommandHelper: %v", err)
+	}
+	query, _ = cmds.GetResourcesByOwnerIdCommand("owner")
+	if !strings.Contains(query, `"public"."Bad""; drop table ""Resources"`) {
+		t.Fatalf("Expected the table name to be escaped, got %s", query)
+	}
+
+	invalid := []resourceStore.TableNames{
+		{Resources: strings.Repeat("x", 64)},
+		{Journal: "bad\x00name"},
+		{Resources: "Same", Journal: "Same"},
+	}
+	for _, tables := range invalid {
+		if _, err := resourceStore.NewPostgresCommandHelper(tables); err == nil {
+			t.Fatalf("Expected an error for tables %+v", tables)
+		}
+	}
+}
+
+func TestResourceStoreWithTables(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+
+	// each store's migrations create its tables
+	employees, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "employees"))
+	if err != nil {
+		t.Fatalf("Error creating employees store: %v", err)
+	}
+	defer employees.Close(context.Background())
+	contractors, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "contractors"))
+	if err != nil {
+		t.Fatalf("Error creating contractors store: %v", err)
+	}
+	defer contractors.Close(context.Background())
+
+	var contractorsMaxClock uint64
+	if err := contractors.GetJournalMaxClock(context.Background(), &contractorsMaxClock); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	resource := &EmployeeResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Employee: Employee{Name: "Alice", Age: 30}}
+	created, status, errmsg := employees.CreateResource(context.Background(), resource, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error creating resource: %d, %v", status, errmsg)
+	}
+
+	// the resource and its journal entry are only visible through the employees tables
+	var found EmployeeResource
+	if status, _ := employees.GetById(context.Background(), "1234", created.GetResourceBase().Id, &found); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Expected to find the resource in the employees store, got %d", status)
+	}
+	if status, _ := contractors.GetById(context.Background(), "1234", created.GetResourceBase().Id, &found); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected the resource to be missing from the contractors store, got %d", status)
+	}
+	if status, _ := gResourceStore.GetById(context.Background(), "1234", created.GetResourceBase().Id, &found); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected the resource to be missing from the default store, got %d", status)
+	}
+
+	var maxClock uint64
+	if err := contractors.GetJournalMaxClock(context.Background(), &maxClock); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+	if maxClock != contractorsMaxClock {
+		t.Fatalf("Expected the contractors journal to be unchanged at %d, got %d", contractorsMaxClock, maxClock)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+
+	// the store migrated at startup, so running the migrations again changes nothing
+	if err := gResourceStore.Migrate(context.Background()); err != nil {
+		t.Fatalf("Error re-running migrations: %v", err)
+	}
+	version, err := gResourceStore.SchemaVersion(context.Background())
+	if err != nil || version != resourceStore.LatestSchemaVersion() {
+		t.Fatalf("Expected schema version %d, got %d, %v", resourceStore.LatestSchemaVersion(), version, err)
+	}
+
+	// a database that is ahead of the binary is refused
+	conn, err := pgx.Connect(context.Background(), gServiceBase.Configuration.GetString(constants.DB_CONNECTION_STRING))
+	if err != nil {
+		t.Fatalf("Error connecting to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+	tables := resourceStore.NounTableNames("public", "futures")
+	recordFuture := `INSERT INTO public."SchemaMigrations" ("Scope", "Version", "Name", "AppliedAt") VALUES (@scope, @version, 'from the future', now()) ON CONFLICT DO NOTHING;`
+	if _, err := conn.Exec(context.Background(), recordFuture, pgx.NamedArgs{"scope": tables.Resources, "version": resourceStore.LatestSchemaVersion() + 1}); err != nil {
+		t.Fatalf("Error recording a future migration: %v", err)
+	}
+	defer conn.Exec(context.Background(), `DELETE FROM public."SchemaMigrations" WHERE "Scope" = @scope;`, pgx.NamedArgs{"scope": tables.Resources})
+
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, tables)
+	if err == nil {
+		store.Close(context.Background())
+		t.Fatal("Expected the store to refuse to start against a newer schema")
+	}
+}
+
+// the scenario is shared with the memory store (see resourceHistory_test.go)
+func TestResourceHistory(t *testing.T) {
+	if gResourceStore == nil {
+		t.Fatal("Expected non-nil store")
+	}
+	testResourceHistory(t, gResourceStore)
+}
+
+func TestJournalRetention(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	ctx := context.Background()
+	window := 500 * time.Millisecond
+
+	// dedicated tables so that retention doesn't delete the journal entries the other tests rely on
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "retention"))
+	if err != nil {
+		t.Fatalf("Error creating retention store: %v", err)
+	}
+	defer store.Close(ctx)
+
+	createEmployees(t, store, "Alice", "Bob")
+	time.Sleep(2 * window)
+	createEmployees(t, store, "Carol")
+	var maxClock uint64
+	if err := store.GetJournalMaxClock(ctx, &maxClock); err != nil {
+		t.Fatalf("Error getting journal max clock: %v", err)
+	}
+
+	removed, err := store.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_DELETE, Window: window})
+	if err != nil || removed < 2 {
+		t.Fatalf("Expected at least 2 entries to be removed, got %d, %v", removed, err)
+	}
+	var minClock uint64
+	if err := store.GetJournalMinClock(ctx, &minClock); err != nil || minClock != maxClock {
+		t.Fatalf("Expected the min clock to be the clock of the newest entry (%d), got %d, %v", maxClock, minClock, err)
+	}
+
+	// compaction keeps the latest entry of each resource
+	compacted, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "compaction"))
+	if err != nil {
+		t.Fatalf("Error creating compaction store: %v", err)
+	}
+	defer compacted.Close(ctx)
+
+	versions, _ := createResourceHistory(t, compacted)
+	time.Sleep(2 * window)
+	updated := versions[3]
+	updated.Employee.Age = 32
+	if _, status, err := compacted.UpdateResource(ctx, &updated, "1234", updated.Id, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error updating resource: %d, %v", status, err)
+	}
+
+	removed, err = compacted.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_COMPACT, Window: window})
+	if err != nil || removed < 3 {
+		t.Fatalf("Expected at least 3 entries to be removed, got %d, %v", removed, err)
+	}
+	var history []EmployeeResource
+	if status, _ := compacted.GetHistory(ctx, "1234", updated.Id, resourceStore.HistoryPage{}, &history); status != constants.RESOURCE_OK_CODE || len(history) != 2 {
+		t.Fatalf("Expected the compacted history to have 2 versions, got %d, %+v", status, history)
+	}
+	if err := compacted.GetJournalMinClock(ctx, &minClock); err != nil || minClock != 0 {
+		t.Fatalf("Expected compaction to leave the min clock at 0, got %d, %v", minClock, err)
+	}
+}
+
+func TestJournalRetentionPartitions(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	ctx := context.Background()
+	window := 500 * time.Millisecond
+
+	// a quiet partition keeps its newest entry even when another partition has written since
+	_, tables := newEmptyStore(t, "retentionPartitions")
+	quiet := newPartitionStore(t, tables, "TEST-QUIET")
+	busy := newPartitionStore(t, tables, "TEST-BUSY")
+	createEmployees(t, quiet, "Alice", "Bob")
+	time.Sleep(2 * window)
+	createEmployees(t, busy, "Carol")
+	var quietMaxClock uint64
+	if err := quiet.GetPartitionJournalMaxClock(ctx, "TEST-QUIET", &quietMaxClock); err != nil {
+		t.Fatalf("Error getting the TEST-QUIET max clock: %v", err)
+	}
+
+	removed, err := quiet.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_DELETE, Window: window})
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected only the oldest TEST-QUIET entry to be removed, got %d, %v", removed, err)
+	}
+	var maxClock uint64
+	if err := quiet.GetPartitionJournalMaxClock(ctx, "TEST-QUIET", &maxClock); err != nil || maxClock != quietMaxClock {
+		t.Fatalf("Expected the TEST-QUIET max clock to stay %d, got %d, %v", quietMaxClock, maxClock, err)
+	}
+	var minClock uint64
+	if err := quiet.GetJournalMinClock(ctx, &minClock); err != nil || minClock != quietMaxClock {
+		t.Fatalf("Expected the TEST-QUIET min clock to be its newest entry (%d), got %d, %v", quietMaxClock, minClock, err)
+	}
+
+	// the other partition is untouched
+	var entries []resourceStore.ResourceJournalEntry
+	if err := busy.GetPartitionJournalChanges(ctx, "TEST-BUSY", 0, 10, &entries); err != nil || len(entries) != 1 {
+		t.Fatalf("Expected the TEST-BUSY entry to be kept, got %+v, %v", entries, err)
+	}
+}
+
+// newEmptyStore is a store over dedicated tables for the noun, emptied (including its journal watermarks) so that
+// the test sees the same clocks on every run
+func newEmptyStore(t *testing.T, noun string) (*resourceStore.PostgresResourceStoreWithJournal[EmployeeResource], resourceStore.TableNames) {
+	tables := resourceStore.NounTableNames("public", noun)
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, tables)
+	if err != nil {
+		t.Fatalf("Error creating %s store: %v", noun, err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+
+	journal := pgx.Identifier{tables.Schema, tables.Journal}.Sanitize()
+	execSQL(t, fmt.Sprintf(`TRUNCATE %s, %s RESTART IDENTITY;`, pgx.Identifier{tables.Schema, tables.Resources}.Sanitize(), journal))
+	execSQL(t, fmt.Sprintf(`DELETE FROM %s WHERE "Journal" = $1;`, pgx.Identifier{tables.Schema, resourceStore.JOURNAL_WATERMARKS_TABLE}.Sanitize()), journal)
+	return store, tables
+}
+
+func execSQL(t *testing.T, query string, args ...any) {
+	t.Helper()
+	dbPool, err := gServiceBase.Stores.Pool(gServiceBase.Configuration.GetString(constants.DB_CONNECTION_STRING))
+	if err != nil {
+		t.Fatalf("Error getting the database pool: %v", err)
+	}
+	if _, err := dbPool.Exec(context.Background(), query, args...); err != nil {
+		t.Fatalf("Error running %s: %v", query, err)
+	}
+}
+
+func TestJournalExportImport(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	ctx := context.Background()
+
+	source, _ := newEmptyStore(t, "exportSource")
+	versions, _ := createResourceHistory(t, source) // clocks 1 to 4 for Alice
+	createEmployees(t, source, "Bob", "Carol")      // clocks 5 and 6
+	var owned []EmployeeResource
+	source.GetByOwnerId(ctx, "1234", &owned)
+	carol := owned[slices.IndexFunc(owned, func(e EmployeeResource) bool { return e.Employee.Name == "Carol" })]
+	if _, status, err := source.DeleteResource(ctx, "1234", carol.Id, carol.Version, "1234:"); status != constants.RESOURCE_OK_CODE { // clock 7
+		t.Fatalf("Error deleting resource: %d, %v", status, err)
+	}
+
+	var export bytes.Buffer
+	if count, err := source.ExportJournal(ctx, resourceStore.JournalRange{}, &export); err != nil || count != 7 {
+		t.Fatalf("Expected 7 entries to be exported, got %d, %v", count, err)
+	}
+	var partial bytes.Buffer
+	if count, err := source.ExportJournal(ctx, resourceStore.JournalRange{FromClock: 2, ToClock: 3}, &partial); err != nil || count != 2 {
+		t.Fatalf("Expected clocks 2 and 3 to be exported, got %d, %v", count, err)
+	}
+
+	target, _ := newEmptyStore(t, "exportTarget")
+	if count, err := target.ImportJournal(ctx, bytes.NewReader(export.Bytes())); err != nil || count != 7 {
+		t.Fatalf("Expected 7 entries to be imported, got %d, %v", count, err)
+	}
+	if clocks := journalClocks(t, target); !slices.Equal(clocks, journalClocks(t, source)) {
+		t.Fatalf("Expected the clocks to be preserved, got %v", clocks)
+	}
+	var inconsistencies []resourceStore.ResourceInconsistency
+	if err := target.CheckConsistency(ctx, &inconsistencies); err != nil || len(inconsistencies) != 0 {
+		t.Fatalf("Expected the imported tables to be consistent, got %+v, %v", inconsistencies, err)
+	}
+
+	// the sequence was moved past the imported clocks (RESTART IDENTITY left it at 1)
+	createEmployees(t, target, "Dave")
+	var maxClock uint64
+	if err := target.GetJournalMaxClock(ctx, &maxClock); err != nil || maxClock != 8 {
+		t.Fatalf("Expected the next write at clock 8, got %d, %v", maxClock, err)
+	}
+
+	_, err := target.ImportJournal(ctx, bytes.NewReader(export.Bytes()))
+	expectHttpStatus(t, err, http.StatusConflict)
+
+	// the deleted resource is imported deleted
+	var deleted EmployeeResource
+	if status, err := target.GetByIdIncludingDeleted(ctx, "1234", carol.Id, &deleted); status != constants.RESOURCE_OK_CODE || !deleted.Deleted {
+		t.Fatalf("Expected Carol to be imported as deleted, got %d, %v, %+v", status, err, deleted)
+	}
+	if status, _ := target.GetById(ctx, "1234", carol.Id, &deleted); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected Carol to be hidden from GetById, got %d", status)
+	}
+	var alice EmployeeResource
+	if status, err := target.GetById(ctx, "1234", versions[3].Id, &alice); status != constants.RESOURCE_OK_CODE || alice.Version != versions[3].Version || alice.Employee.Age != 31 {
+		t.Fatalf("Expected Alice to be imported at version %d, got %d, %v, %+v", versions[3].Version, status, err, alice)
+	}
+
+	// retention that deletes entries leaves a journal that can't be replayed
+	window := 500 * time.Millisecond
+	time.Sleep(2 * window)
+	createEmployees(t, source, "Erin")
+	if _, err := source.ApplyJournalRetention(ctx, resourceStore.RetentionPolicy{Mode: resourceStore.RETENTION_MODE_DELETE, Window: window}); err != nil {
+		t.Fatalf("Error applying retention: %v", err)
+	}
+	_, err = source.RebuildResources(ctx)
+	expectHttpStatus(t, err, http.StatusConflict)
+}
+
+func TestCheckConsistencyAndRebuild(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	ctx := context.Background()
+
+	store, tables := newEmptyStore(t, "consistency")
+	createEmployees(t, store, "Alice", "Bob", "Carol", "Dave")
+	var owned []EmployeeResource
+	store.GetByOwnerId(ctx, "1234", &owned)
+	ids := map[string]string{}
+	for _, employee := range owned {
+		ids[employee.Employee.Name] = employee.Id
+	}
+	if _, status, err := store.DeleteResource(ctx, "1234", ids["Dave"], 1, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error deleting resource: %d, %v", status, err)
+	}
+
+	// one of each inconsistency, made behind the store's back
+	resources := pgx.Identifier{tables.Schema, tables.Resources}.Sanitize()
+	execSQL(t, fmt.Sprintf(`UPDATE %s SET "Version" = 9 WHERE "Id" = $1;`, resources), ids["Alice"])
+	execSQL(t, fmt.Sprintf(`UPDATE %s SET "Resource" = jsonb_set("Resource", '{employee,age}', '99') WHERE "Id" = $1;`, resources), ids["Bob"])
+	execSQL(t, fmt.Sprintf(`DELETE FROM %s WHERE "Id" = $1;`, resources), ids["Carol"])
+	execSQL(t, fmt.Sprintf(`INSERT INTO %s ("Id", "OwnerId", "Version", "UpdatedAt", "Deleted", "Resource")
+		VALUES ('orphan', '1234', 1, now(), false, '{"id": "orphan", "ownerId": "1234", "version": 1}');`, resources))
+
+	var inconsistencies []resourceStore.ResourceInconsistency
+	if err := store.CheckConsistency(ctx, &inconsistencies); err != nil || len(inconsistencies) != 4 {
+		t.Fatalf("Expected 4 inconsistencies, got %+v, %v", inconsistencies, err)
+	}
+	kinds := map[string]resourceStore.ResourceInconsistency{}
+	for _, inconsistency := range inconsistencies {
+		kinds[inconsistency.Id] = inconsistency
+	}
+	for id, expected := range map[string]resourceStore.ResourceInconsistency{
+		ids["Alice"]: {Kind: resourceStore.INCONSISTENCY_VERSION_MISMATCH, ResourceVersion: 9, JournalVersion: 1},
+		ids["Bob"]:   {Kind: resourceStore.INCONSISTENCY_RESOURCE_MISMATCH, ResourceVersion: 1, JournalVersion: 1},
+		ids["Carol"]: {Kind: resourceStore.INCONSISTENCY_MISSING, ResourceVersion: 0, JournalVersion: 1},
+		"orphan":     {Kind: resourceStore.INCONSISTENCY_NOT_JOURNALED, ResourceVersion: 1, JournalVersion: 0},
+	} {
+		got := kinds[id]
+		if got.Kind != expected.Kind || got.ResourceVersion != expected.ResourceVersion || got.JournalVersion != expected.JournalVersion || got.OwnerId != "1234" {
+			t.Fatalf("Expected %+v for %s, got %+v", expected, id, got)
+		}
+		if expected.Kind != resourceStore.INCONSISTENCY_NOT_JOURNALED && got.JournalClock == 0 {
+			t.Fatalf("Expected the journal clock of %s, got %+v", id, got)
+		}
+	}
+
+	// rebuilding repairs them all, keeping Dave deleted
+	if count, err := store.RebuildResources(ctx); err != nil || count != 4 {
+		t.Fatalf("Expected 4 resources to be rebuilt, got %d, %v", count, err)
+	}
+	inconsistencies = nil
+	if err := store.CheckConsistency(ctx, &inconsistencies); err != nil || len(inconsistencies) != 0 {
+		t.Fatalf("Expected the rebuilt tables to be consistent, got %+v, %v", inconsistencies, err)
+	}
+	var dave EmployeeResource
+	if status, err := store.GetByIdIncludingDeleted(ctx, "1234", ids["Dave"], &dave); status != constants.RESOURCE_OK_CODE || !dave.Deleted || dave.Version != 2 {
+		t.Fatalf("Expected Dave to be rebuilt deleted at version 2, got %d, %v, %+v", status, err, dave)
+	}
+	if status, _ := store.GetById(ctx, "1234", ids["Dave"], &dave); status != constants.RESOURCE_NOT_FOUND_ERROR_CODE {
+		t.Fatalf("Expected the rebuilt Dave to be hidden from GetById, got %d", status)
+	}
+}
+
+// newPartitionStore opens a store over tables that writes partitionName of the journal
+func newPartitionStore(t *testing.T, tables resourceStore.TableNames, partitionName string) *resourceStore.PostgresResourceStoreWithJournal[EmployeeResource] {
+	configuration := viper.New()
+	configuration.Set(constants.DB_CONNECTION_STRING, gServiceBase.Configuration.GetString(constants.DB_CONNECTION_STRING))
+	configuration.Set(constants.JOURNAL_PARTITION_NAME, partitionName)
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](configuration, gServiceBase.Logger, tables)
+	if err != nil {
+		t.Fatalf("Error creating %s store: %v", partitionName, err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+	return store
+}
+
+func TestJournalPartitions(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	ctx := context.Background()
+
+	// two shards writing different partitions of the same journal
+	east := newPartitionStore(t, resourceStore.NounTableNames("public", "sharded"), "TEST-EAST")
+	west := newPartitionStore(t, resourceStore.NounTableNames("public", "sharded"), "TEST-WEST")
+
+	var start resourceStore.JournalClockVector
+	if err := east.GetJournalClocks(ctx, &start); err != nil {
+		t.Fatalf("Error getting journal clocks: %v", err)
+	}
+	createEmployees(t, east, "Alice")
+	createEmployees(t, west, "Bob")
+	createEmployees(t, east, "Carol")
+
+	var clocks resourceStore.JournalClockVector
+	if err := west.GetJournalClocks(ctx, &clocks); err != nil || clocks["TEST-EAST"] <= start["TEST-EAST"] || clocks["TEST-WEST"] <= start["TEST-WEST"] {
+		t.Fatalf("Expected both partitions to move forward from %v, got %v, %v", start, clocks, err)
+	}
+	var maxClock uint64
+	if err := west.GetPartitionJournalMaxClock(ctx, "TEST-WEST", &maxClock); err != nil || maxClock != clocks["TEST-WEST"] {
+		t.Fatalf("Expected the TEST-WEST max clock %d, got %d, %v", clocks["TEST-WEST"], maxClock, err)
+	}
+
+	var entries []resourceStore.ResourceJournalEntry
+	if err := west.GetPartitionJournalChanges(ctx, "TEST-EAST", int64(start["TEST-EAST"]+1), 10, &entries); err != nil || len(entries) != 2 {
+		t.Fatalf("Expected the 2 TEST-EAST entries, got %+v, %v", entries, err)
+	}
+	for _, entry := range entries {
+		if entry.PartitionName != "TEST-EAST" {
+			t.Fatalf("Expected only TEST-EAST entries, got %+v", entry)
+		}
+	}
+
+	// the merged read returns all 3 entries in clock order and advances both clocks
+	entries = nil
+	next, err := east.GetMergedJournalChanges(ctx, start, 10, &entries)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Expected 3 merged entries, got %+v, %v", entries, err)
+	}
+	if entries[0].PartitionName != "TEST-EAST" || entries[1].PartitionName != "TEST-WEST" || entries[2].PartitionName != "TEST-EAST" {
+		t.Fatalf("Expected the entries in clock order, got %+v", entries)
+	}
+	if next["TEST-EAST"] != clocks["TEST-EAST"] || next["TEST-WEST"] != clocks["TEST-WEST"] {
+		t.Fatalf("Expected the merged clocks to reach %v, got %v", clocks, next)
+	}
+	entries = nil
+	if _, err := east.GetMergedJournalChanges(ctx, next, 10, &entries); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected no entries after %v, got %+v, %v", next, entries, err)
+	}
+}
+
+func TestResourceStoreBulk(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+
+	// dedicated tables so that the journal clocks the test expects aren't moved by other tests
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "bulk"))
+	if err != nil {
+		t.Fatalf("Error creating bulk store: %v", err)
+	}
+	defer store.Close(context.Background())
+
+	testBulkResources(t, store)
+}
+
+func TestPatchResource(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+
+	// dedicated tables so that the journal clocks the test expects aren't moved by other tests
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "patch"))
+	if err != nil {
+		t.Fatalf("Error creating patch store: %v", err)
+	}
+	defer store.Close(context.Background())
+
+	testPatchResource(t, store)
+}
+
+func TestStoreRegistry(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+
+	registry := resourceStore.NewStoreRegistry(gServiceBase.Configuration, gServiceBase.Logger)
+	tables := resourceStore.TableNamesFromConfig(gServiceBase.Configuration)
+	first, err := resourceStore.SharedStore[EmployeeResource](registry, tables)
+	if err != nil {
+		t.Fatalf("Error getting the shared store: %v", err)
+	}
+	second, err := resourceStore.SharedStore[EmployeeResource](registry, tables)
+	if err != nil || first != second {
+		t.Fatalf("Expected the same store for the same resource type, got %v", err)
+	}
+	if _, err := resourceStore.SharedStore[EmployeeResource](registry, resourceStore.NounTableNames("public", "contractors")); err == nil {
+		t.Fatal("Expected an error asking for the shared store of a type over other tables")
+	}
+	if _, err := resourceStore.SharedStore[ContractorResource](registry, tables); err == nil {
+		t.Fatal("Expected an error asking for a shared store over the tables of another type")
+	}
+
+	pool, err := registry.Pool(gServiceBase.Configuration.GetString(constants.DB_CONNECTION_STRING))
+	if err != nil {
+		t.Fatalf("Error getting the shared pool: %v", err)
+	}
+	contractors, err := resourceStore.NewPostgresResourceStoreWithPool[EmployeeResource](gServiceBase.Configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "contractors"), pool)
+	if err != nil {
+		t.Fatalf("Error creating a store on the shared pool: %v", err)
+	}
+
+	// closing a store on a shared pool leaves the pool to the other stores
+	if err := contractors.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing store: %v", err)
+	}
+	if err := first.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("Expected the shared pool to still be open: %v", err)
+	}
+
+	if err := registry.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing registry: %v", err)
+	}
+	if err := first.HealthCheck(context.Background()); err == nil {
+		t.Fatal("Expected the health check to fail once the registry is closed")
+	}
+}
+
+func TestReadReplicas(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	ctx := context.Background()
+
+	// the primary doubles as a replica with no lag, next to one that can't be reached
+	configuration := viper.New()
+	configuration.Set(constants.DB_CONNECTION_STRING, gServiceBase.Configuration.GetString(constants.DB_CONNECTION_STRING))
+	configuration.Set(constants.JOURNAL_PARTITION_NAME, gServiceBase.Configuration.GetString(constants.JOURNAL_PARTITION_NAME))
+	configuration.Set(constants.DB_REPLICA_CONNECTION_STRINGS, gServiceBase.Configuration.GetString(constants.DB_CONNECTION_STRING)+",postgres://nobody@127.0.0.1:1/unreachable?connect_timeout=1")
+	configuration.Set(constants.DB_REPLICA_CHECK_INTERVAL, "2s")
+
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[EmployeeResource](configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "replicas"))
+	if err != nil {
+		t.Fatalf("Error creating store with replicas: %v", err)
+	}
+	defer store.Close(ctx)
+
+	statuses := store.ReplicaStatuses()
+	if len(statuses) != 2 || !statuses[0].Healthy || statuses[1].Healthy || statuses[1].Error == "" {
+		t.Fatalf("Expected the first replica to be healthy and the second not, got %+v", statuses)
+	}
+
+	// reads go to the healthy replica or the primary, so they all succeed
+	resource := &EmployeeResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Employee: Employee{Name: "Alice", Age: 30}}
+	created, status, errmsg := store.CreateResource(ctx, resource, "1234:")
+	if status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error creating resource: %d, %v", status, errmsg)
+	}
+	for i := 0; i < 4; i++ {
+		var found EmployeeResource
+		if status, err := store.GetById(ctx, "1234", created.GetResourceBase().Id, &found); status != constants.RESOURCE_OK_CODE {
+			t.Fatalf("Error reading resource: %d, %v", status, err)
+		}
+	}
+	var found EmployeeResource
+	if status, err := store.GetById(resourceStore.ReadFromPrimary(ctx), "1234", created.GetResourceBase().Id, &found); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error reading resource from the primary: %d, %v", status, err)
+	}
+	var entries []resourceStore.ResourceJournalEntry
+	if err := store.GetJournalChanges(ctx, 1, 100, &entries); err != nil || len(entries) == 0 {
+		t.Fatalf("Expected journal entries from the replica, got %d, %v", len(entries), err)
+	}
+}
+
+func TestResourceStoreValidationHooks(t *testing.T) {
+	if gServiceBase == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+
+	store, err := resourceStore.NewPostgresResourceStoreWithTables[ContractorResource](gServiceBase.Configuration, gServiceBase.Logger, resourceStore.NounTableNames("public", "contractors"))
+	if err != nil {
+		t.Fatalf("Error creating contractor store: %v", err)
+	}
+	defer store.Close(context.Background())
+
+	testResourceValidationHooks(t, store)
+}
+
 // TODO: add tests to catch if someone has corrupted the JSON stored in the DB tables
 // TODO: add tests to catch if database is down or goes down after successful connection
 // TODO: do auth, helpers, serviceBase tests, etc.
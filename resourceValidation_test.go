@@ -0,0 +1,237 @@
+package unittests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/helpers"
+	"github.com/geraldhinson/siftd-base/pkg/problemDetails"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+type Contractor struct {
+	Name string `json:"name"`
+	Rate int    `json:"rate"`
+}
+
+// ContractorResource has all of the store's hooks
+type ContractorResource struct {
+	resourceStore.ResourceBase
+	Contractor Contractor `json:"contractor"`
+}
+
+// contractorCommits records the AfterCommit calls as "id:lastAction"
+var contractorCommits []string
+
+func (c *ContractorResource) BeforeCreate(ctx context.Context) error {
+	if c.Contractor.Rate == 0 {
+		c.Contractor.Rate = 100
+	}
+	return nil
+}
+
+func (c *ContractorResource) BeforeUpdate(ctx context.Context, old resourceStore.IResource) error {
+	if old.(*ContractorResource).Contractor.Name != c.Contractor.Name {
+		return errors.New("the name of a contractor can't be changed")
+	}
+	return nil
+}
+
+func (c *ContractorResource) Validate() error {
+	if c.Contractor.Name == "" || c.Contractor.Rate < 0 {
+		return resourceStore.NewValidationError("the contractor is invalid",
+			problemDetails.FieldError{Field: "contractor.name", Message: "is required"},
+			problemDetails.FieldError{Field: "contractor.rate", Message: "must not be negative"})
+	}
+	return nil
+}
+
+func (c *ContractorResource) AfterCommit(ctx context.Context) {
+	contractorCommits = append(contractorCommits, c.Id+":"+c.LastAction)
+}
+
+func TestResourceValidationHooks(t *testing.T) {
+	configuration := viper.New()
+	configuration.Set(constants.JOURNAL_PARTITION_NAME, "memory")
+	store, err := resourceStore.NewMemoryResourceStore[ContractorResource](configuration, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating MemoryResourceStore: %v", err)
+	}
+
+	testResourceValidationHooks(t, store)
+}
+
+// testResourceValidationHooks runs the hook checks against either store
+func testResourceValidationHooks(t *testing.T, store resourceStore.IResourceStore[ContractorResource]) {
+	contractorCommits = nil
+	ctx := context.Background()
+
+	invalid := &ContractorResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}}
+	_, status, err := store.CreateResource(ctx, invalid, "1234:")
+	var resourceErr *resourceStore.ResourceError
+	if status != constants.RESOURCE_BAD_REQUEST_CODE || !errors.Is(err, resourceStore.ErrValidation) || !errors.As(err, &resourceErr) || len(resourceErr.FieldErrors()) != 2 {
+		t.Fatalf("Expected a validation error with two field errors, got %d, %v", status, err)
+	}
+
+	// BeforeCreate fills in the rate
+	contractor := &ContractorResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Contractor: Contractor{Name: "Carol"}}
+	if _, status, err := store.CreateResource(ctx, contractor, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Expected the create to succeed, got %d, %v", status, err)
+	}
+	var stored ContractorResource
+	if status, err := store.GetById(ctx, "1234", contractor.Id, &stored); status != constants.RESOURCE_OK_CODE || stored.Contractor.Rate != 100 {
+		t.Fatalf("Expected the default rate to be stored, got %d, %v, %+v", status, err, stored)
+	}
+
+	// BeforeUpdate sees the stored version, and its plain error becomes a validation error
+	renamed := stored
+	renamed.Contractor.Name = "Caroline"
+	if _, status, err := store.UpdateResource(ctx, &renamed, "1234", contractor.Id, "1234:"); status != constants.RESOURCE_BAD_REQUEST_CODE || !errors.Is(err, resourceStore.ErrValidation) {
+		t.Fatalf("Expected the rename to be rejected, got %d, %v", status, err)
+	}
+	stale := stored
+	stale.Version = 7
+	if _, status, err := store.UpdateResource(ctx, &stale, "1234", contractor.Id, "1234:"); status != constants.RESOURCE_BAD_REQUEST_CODE || !errors.Is(err, resourceStore.ErrVersionMismatch) {
+		t.Fatalf("Expected the stale update to fail on its version, got %d, %v", status, err)
+	}
+	raised := stored
+	raised.Contractor.Rate = 150
+	if _, status, err := store.UpdateResource(ctx, &raised, "1234", contractor.Id, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Expected the update to succeed, got %d, %v", status, err)
+	}
+
+	// a patch goes through the same hooks
+	if _, status, err := store.PatchResource(ctx, "1234", contractor.Id, 2, []byte(`{"contractor": {"rate": -1}}`), resourceStore.PATCH_TYPE_MERGE_PATCH, "1234:"); !errors.Is(err, resourceStore.ErrValidation) {
+		t.Fatalf("Expected the patch to be rejected, got %d, %v", status, err)
+	}
+
+	if _, status, err := store.DeleteResource(ctx, "1234", contractor.Id, 2, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Expected the delete to succeed, got %d, %v", status, err)
+	}
+
+	// AfterCommit only runs for the writes of a unit of work that commits
+	store.InTx(ctx, func(tx resourceStore.ResourceTx[ContractorResource]) error {
+		tx.CreateResource(&ContractorResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Contractor: Contractor{Name: "Dan"}}, "1234:")
+		return errors.New("changed my mind")
+	})
+	committed := &ContractorResource{ResourceBase: resourceStore.ResourceBase{OwnerId: "1234"}, Contractor: Contractor{Name: "Erin"}}
+	if status, err := store.InTx(ctx, func(tx resourceStore.ResourceTx[ContractorResource]) error {
+		_, _, err := tx.CreateResource(committed, "1234:")
+		return err
+	}); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Expected the unit of work to commit, got %d, %v", status, err)
+	}
+
+	expected := []string{contractor.Id + ":create", contractor.Id + ":update", contractor.Id + ":delete", committed.Id + ":create"}
+	if len(contractorCommits) != len(expected) {
+		t.Fatalf("Expected AfterCommit calls %v, got %v", expected, contractorCommits)
+	}
+	for i := range expected {
+		if contractorCommits[i] != expected[i] {
+			t.Fatalf("Expected AfterCommit calls %v, got %v", expected, contractorCommits)
+		}
+	}
+}
+
+func TestJsonSchema(t *testing.T) {
+	schema, err := resourceStore.ParseJsonSchema([]byte(`{
+		"type": "object",
+		"required": ["employee"],
+		"properties": {
+			"employee": {
+				"type": "object",
+				"required": ["name", "age"],
+				"additionalProperties": false,
+				"properties": {
+					"name": {"type": "string", "minLength": 1, "maxLength": 10, "pattern": "^[A-Z]"},
+					"age": {"type": "integer", "minimum": 16, "exclusiveMaximum": 100},
+					"grade": {"enum": ["junior", "senior", 3]},
+					"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+				}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Error parsing the schema: %v", err)
+	}
+
+	tests := []struct {
+		document string
+		fields   []string
+	}{
+		{`{"employee": {"name": "Alice", "age": 30, "grade": 3, "tags": ["a"]}}`, nil},
+		{`{"id": "1", "employee": {"name": "Alice", "age": 30.0, "grade": "senior"}}`, nil},
+		{`{}`, []string{"employee"}},
+		{`{"employee": {"name": "alice", "age": 15.5}}`, []string{"employee.age", "employee.name"}},
+		{`{"employee": {"name": "", "age": 100}}`, []string{"employee.age", "employee.name", "employee.name"}},
+		{`{"employee": {"name": "Alexandrina Victoria", "age": "30"}}`, []string{"employee.age", "employee.name"}},
+		{`{"employee": {"age": 30, "grade": "lead", "tags": ["a", 2, "c"], "salary": 1}}`, []string{"employee.name", "employee.grade", "employee.salary", "employee.tags", "employee.tags[1]"}},
+		{`[1, 2]`, []string{""}},
+	}
+	for _, test := range tests {
+		fieldErrors := schema.Validate([]byte(test.document))
+		if len(fieldErrors) != len(test.fields) {
+			t.Fatalf("Expected errors for %v validating %s, got %+v", test.fields, test.document, fieldErrors)
+		}
+		for i, field := range test.fields {
+			if fieldErrors[i].Field != field || fieldErrors[i].Message == "" {
+				t.Fatalf("Expected errors for %v validating %s, got %+v", test.fields, test.document, fieldErrors)
+			}
+		}
+	}
+
+	for _, invalid := range []string{`{"type": "date"}`, `{"properties": {"name": {"pattern": "("}}}`, `{"required": "name"}`, `not json`} {
+		if _, err := resourceStore.ParseJsonSchema([]byte(invalid)); err == nil {
+			t.Fatalf("Expected an error parsing the schema %s", invalid)
+		}
+	}
+}
+
+func TestNounResourceRouterSchemaValidation(t *testing.T) {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+	noAuthModel, err := service.NewAuthModel(security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize AuthModel: %v", err)
+	}
+
+	schema, err := resourceStore.ParseJsonSchema([]byte(`{"properties": {"employee": {"properties": {"age": {"type": "integer", "minimum": 16}}}}}`))
+	if err != nil {
+		t.Fatalf("Error parsing the schema: %v", err)
+	}
+	store := newMemoryStore(t)
+	store.SetSchema(schema)
+	if helpers.NewNounResourceRouterWithStore[EmployeeResource](service, store, "employees",
+		helpers.NounResourceAuthModels{Get: noAuthModel, Post: noAuthModel, Put: noAuthModel, Patch: noAuthModel, Delete: noAuthModel}) == nil {
+		t.Fatal("Expected non-nil NounResourceRouter")
+	}
+	collection := "/v1/identities/1234/employees"
+
+	body, _ := json.Marshal(EmployeeResource{Employee: Employee{Name: "Alice", Age: 12}})
+	response := conditionalCall(service, http.MethodPost, collection, body)
+	var problem problemDetails.ProblemDetails
+	if err := json.Unmarshal(response.Body.Bytes(), &problem); err != nil || response.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected a 422 problem, got %d: %s", response.Code, response.Body.String())
+	}
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "employee.age" {
+		t.Fatalf("Expected the age field error in the problem, got %+v", problem)
+	}
+
+	body, _ = json.Marshal(EmployeeResource{Employee: Employee{Name: "Alice", Age: 30}})
+	if response := conditionalCall(service, http.MethodPost, collection, body); response.Code != http.StatusOK && response.Code != http.StatusCreated {
+		t.Fatalf("Expected the valid employee to be created, got %d: %s", response.Code, response.Body.String())
+	}
+}
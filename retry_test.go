@@ -0,0 +1,167 @@
+package unittests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class resourceStore.FailureClass
+	}{
+		{"nil", nil, resourceStore.FAILURE_PERMANENT},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, resourceStore.FAILURE_TRANSIENT},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, resourceStore.FAILURE_TRANSIENT},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, resourceStore.FAILURE_TRANSIENT},
+		{"connection exception class", &pgconn.PgError{Code: "08006"}, resourceStore.FAILURE_TRANSIENT},
+		{"wrapped in a resource error", fmt.Errorf("query: %w", resourceStore.NewResourceError(resourceStore.ERROR_KIND_INTERNAL, "", &pgconn.PgError{Code: "40001"})), resourceStore.FAILURE_TRANSIENT},
+		{"duplicate key", &pgconn.PgError{Code: constants.PRIMARY_KEY_VIOLATION_SQL_CODE}, resourceStore.FAILURE_PERMANENT},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, resourceStore.FAILURE_PERMANENT},
+		{"lost connection", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), resourceStore.FAILURE_CONNECTION},
+		{"network error", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, resourceStore.FAILURE_CONNECTION},
+		{"cancelled", context.Canceled, resourceStore.FAILURE_PERMANENT},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), resourceStore.FAILURE_PERMANENT},
+		{"not found", resourceStore.ErrNotFound, resourceStore.FAILURE_PERMANENT},
+	}
+
+	for _, test := range tests {
+		if class := resourceStore.ClassifyError(test.err); class != test.class {
+			t.Errorf("%s: expected class %d, got %d", test.name, test.class, class)
+		}
+	}
+}
+
+func TestRetryPolicyFromConfig(t *testing.T) {
+	configuration := viper.New()
+	policy, err := resourceStore.RetryPolicyFromConfig(configuration)
+	if err != nil || policy.MaxAttempts != resourceStore.RETRY_DEFAULT_MAX_ATTEMPTS || policy.InitialBackoff != resourceStore.RETRY_DEFAULT_INITIAL_BACKOFF || policy.MaxBackoff != resourceStore.RETRY_DEFAULT_MAX_BACKOFF {
+		t.Fatalf("Expected the defaults, got %+v, %v", policy, err)
+	}
+
+	configuration.Set(constants.DB_RETRY_MAX_ATTEMPTS, "5")
+	configuration.Set(constants.DB_RETRY_INITIAL_BACKOFF, "10ms")
+	configuration.Set(constants.DB_RETRY_MAX_BACKOFF, "40ms")
+	policy, err = resourceStore.RetryPolicyFromConfig(configuration)
+	if err != nil || policy.MaxAttempts != 5 || policy.InitialBackoff != 10*time.Millisecond || policy.MaxBackoff != 40*time.Millisecond {
+		t.Fatalf("Expected 5 attempts backing off from 10ms to 40ms, got %+v, %v", policy, err)
+	}
+
+	// the backoff doubles up to the max, less up to half of it for jitter
+	for retry, expected := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 10: 40 * time.Millisecond} {
+		if backoff := policy.Backoff(retry); backoff < expected/2 || backoff > expected {
+			t.Fatalf("Expected the backoff before retry %d to be between %v and %v, got %v", retry, expected/2, expected, backoff)
+		}
+	}
+
+	for _, invalid := range []map[string]string{
+		{constants.DB_RETRY_MAX_ATTEMPTS: "lots"},
+		{constants.DB_RETRY_MAX_ATTEMPTS: "0"},
+		{constants.DB_RETRY_INITIAL_BACKOFF: "soon"},
+		{constants.DB_RETRY_INITIAL_BACKOFF: "2s", constants.DB_RETRY_MAX_BACKOFF: "1s"},
+	} {
+		configuration := viper.New()
+		for key, value := range invalid {
+			configuration.Set(key, value)
+		}
+		if _, err := resourceStore.RetryPolicyFromConfig(configuration); err == nil {
+			t.Fatalf("Expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	retrier, err := resourceStore.NewRetrier(resourceStore.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating Retrier: %v", err)
+	}
+	transient := &pgconn.PgError{Code: "40001"}
+	lost := fmt.Errorf("read: %w", io.ErrUnexpectedEOF)
+
+	// failing returns an fn that fails with the errors given and then succeeds, recording whether each attempt
+	// read from the primary
+	failing := func(primary *[]bool, errs ...error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			*primary = append(*primary, resourceStore.IsReadFromPrimary(ctx))
+			if len(*primary) <= len(errs) {
+				return errs[len(*primary)-1]
+			}
+			return nil
+		}
+	}
+
+	var attempts []bool
+	if err := retrier.Do(context.Background(), "Recovers", true, failing(&attempts, transient)); err != nil || len(attempts) != 2 {
+		t.Fatalf("Expected success on the second attempt, got %v after %d", err, len(attempts))
+	}
+	if attempts[0] || !attempts[1] {
+		t.Fatalf("Expected only the retry to read from the primary, got %v", attempts)
+	}
+
+	attempts = nil
+	if err := retrier.Do(context.Background(), "Exhausts", true, failing(&attempts, transient, transient, transient, transient)); !errors.Is(err, transient) || len(attempts) != 3 {
+		t.Fatalf("Expected to give up after 3 attempts, got %v after %d", err, len(attempts))
+	}
+
+	attempts = nil
+	if err := retrier.Do(context.Background(), "Permanent", true, failing(&attempts, errors.New("syntax error"))); err == nil || len(attempts) != 1 {
+		t.Fatalf("Expected a permanent failure not to be retried, got %v after %d", err, len(attempts))
+	}
+
+	// a write whose connection was lost may have been applied, so only idempotent operations retry it
+	attempts = nil
+	if err := retrier.Do(context.Background(), "Write", false, failing(&attempts, lost)); !errors.Is(err, io.ErrUnexpectedEOF) || len(attempts) != 1 {
+		t.Fatalf("Expected a lost connection not to be retried for a write, got %v after %d", err, len(attempts))
+	}
+	attempts = nil
+	if err := retrier.Do(context.Background(), "Read", true, failing(&attempts, lost)); err != nil || len(attempts) != 2 {
+		t.Fatalf("Expected a lost connection to be retried for a read, got %v after %d", err, len(attempts))
+	}
+
+	// cancelling ctx ends the backoff with the cancellation and the last error
+	slow, err := resourceStore.NewRetrier(resourceStore.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating Retrier: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts = nil
+	started := time.Now()
+	err = slow.Do(ctx, "Cancelled", true, func(ctx context.Context) error {
+		attempts = append(attempts, false)
+		cancel()
+		return transient
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, transient) || len(attempts) != 1 || time.Since(started) > time.Minute {
+		t.Fatalf("Expected the cancelled backoff to return the cancellation and the transient error, got %v after %d", err, len(attempts))
+	}
+
+	stats := retrier.Stats()
+	expected := map[string]uint64{"Recovers": 1, "Exhausts": 2, "Read": 1}
+	if stats.Retries != 4 || stats.Recovered != 2 || stats.Exhausted != 1 || len(stats.ByMethod) != len(expected) {
+		t.Fatalf("Expected 4 retries, 2 recovered and 1 exhausted, got %+v", stats)
+	}
+	for methodName, retries := range expected {
+		if stats.ByMethod[methodName] != retries {
+			t.Fatalf("Expected %d retries of %s, got %+v", retries, methodName, stats.ByMethod)
+		}
+	}
+	if stats := slow.Stats(); stats.Retries != 0 || stats.Exhausted != 0 {
+		t.Fatalf("Expected the cancelled operation not to count as a retry, got %+v", stats)
+	}
+
+	if _, err := resourceStore.NewRetrier(resourceStore.RetryPolicy{MaxAttempts: -1}, logrus.New()); err == nil {
+		t.Fatal("Expected an error for an invalid policy")
+	}
+}
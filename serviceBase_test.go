@@ -169,6 +169,79 @@ func TestServiceBase_InstantiateAndCalls(t *testing.T) {
 		}
 
 	})
+
+	t.Run("noun resource router", func(t *testing.T) {
+		identity := "GUID-fake-member-GUID"
+		collectionURL := fmt.Sprintf("v1/identities/%s/testnouns", identity)
+
+		// create
+		newResource := shared.TestNounResource{TestNoun: shared.TestNoun{Name: "Carol", Age: 44}}
+		requestBody, _ := json.Marshal(newResource)
+		body, err, status := shared.CallServiceViaLoopbackWithBody(router.Configuration, http.MethodPost, nil, collectionURL, requestBody)
+		if err != nil {
+			t.Fatalf("Failed to call noun resource router via loopback: %v, %d", err, status)
+		}
+		if status != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, status, string(body))
+		}
+		var created shared.TestNounResource
+		if err = json.Unmarshal(body, &created); err != nil {
+			t.Fatalf("Failed to unmarshal created resource: %v", err)
+		}
+		if created.Id == "" || created.OwnerId != identity || created.Version != 1 {
+			t.Fatalf("unexpected created resource: %s", string(body))
+		}
+		itemURL := fmt.Sprintf("%s/%s", collectionURL, created.Id)
+
+		// get by id
+		body, err, status = shared.CallServiceViaLoopback(router.Configuration, http.MethodGet, nil, itemURL)
+		if err != nil || status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d (%v)", http.StatusOK, status, err)
+		}
+
+		// get by owner
+		body, err, status = shared.CallServiceViaLoopback(router.Configuration, http.MethodGet, nil, collectionURL)
+		if err != nil || status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d (%v)", http.StatusOK, status, err)
+		}
+		var owned []shared.TestNounResource
+		if err = json.Unmarshal(body, &owned); err != nil {
+			t.Fatalf("Failed to unmarshal owned resources: %v", err)
+		}
+		if len(owned) == 0 {
+			t.Fatalf("Expected at least one resource for owner %s", identity)
+		}
+
+		// update
+		created.TestNoun.Age = 45
+		requestBody, _ = json.Marshal(created)
+		body, err, status = shared.CallServiceViaLoopbackWithBody(router.Configuration, http.MethodPut, nil, itemURL, requestBody)
+		if err != nil || status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d (%v): %s", http.StatusOK, status, err, string(body))
+		}
+
+		// update with a stale version is rejected
+		body, err, status = shared.CallServiceViaLoopbackWithBody(router.Configuration, http.MethodPut, nil, itemURL, requestBody)
+		if err != nil || status != http.StatusPreconditionFailed {
+			t.Fatalf("Expected status %d, got %d (%v): %s", http.StatusPreconditionFailed, status, err, string(body))
+		}
+
+		// malformed body
+		body, err, status = shared.CallServiceViaLoopbackWithBody(router.Configuration, http.MethodPost, nil, collectionURL, []byte("{not json"))
+		if err != nil || status != http.StatusBadRequest {
+			t.Fatalf("Expected status %d, got %d (%v): %s", http.StatusBadRequest, status, err, string(body))
+		}
+
+		// delete
+		body, err, status = shared.CallServiceViaLoopback(router.Configuration, http.MethodDelete, nil, itemURL+"?version=2")
+		if err != nil || status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d (%v): %s", http.StatusOK, status, err, string(body))
+		}
+		body, err, status = shared.CallServiceViaLoopback(router.Configuration, http.MethodGet, nil, itemURL)
+		if err != nil || status != http.StatusNotFound {
+			t.Fatalf("Expected status %d, got %d (%v)", http.StatusNotFound, status, err)
+		}
+	})
 }
 
 func TestShutdownListener(t *testing.T) {
@@ -0,0 +1,302 @@
+package unittests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geraldhinson/siftd-base/pkg/constants"
+	"github.com/geraldhinson/siftd-base/pkg/helpers"
+	"github.com/geraldhinson/siftd-base/pkg/journalClient"
+	"github.com/geraldhinson/siftd-base/pkg/resourceStore"
+	"github.com/geraldhinson/siftd-base/pkg/security"
+	"github.com/geraldhinson/siftd-base/pkg/serviceBase"
+	"github.com/geraldhinson/siftd-base/pkg/webhooks"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// webhookReceiver records the names of the employees delivered to it with a valid signature. The first failures
+// deliveries get a 500, and while hold is open every delivery waits for it to be closed.
+type webhookReceiver struct {
+	mutex      sync.Mutex
+	secret     string
+	names      []string
+	deliveries map[string]bool
+	failures   atomic.Int32
+	hold       chan struct{}
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if wr.hold != nil {
+		select {
+		case <-wr.hold:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if wr.failures.Add(-1) >= 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	wr.mutex.Lock()
+	secret := wr.secret
+	wr.mutex.Unlock()
+	if err := webhooks.VerifySignature(secret, r.Header.Get(webhooks.SIGNATURE_HEADER), body, time.Minute); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var entry resourceStore.ResourceJournalEntry
+	var employee EmployeeResource
+	if json.Unmarshal(body, &entry) != nil || json.Unmarshal(entry.Resource, &employee) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	if !wr.deliveries[r.Header.Get(webhooks.DELIVERY_HEADER)] {
+		wr.deliveries[r.Header.Get(webhooks.DELIVERY_HEADER)] = true
+		wr.names = append(wr.names, employee.Employee.Name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (wr *webhookReceiver) setSecret(secret string) {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	wr.secret = secret
+}
+
+func (wr *webhookReceiver) received() []string {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	return append([]string{}, wr.names...)
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	if setupEnvVars(t) == nil {
+		t.Fatal("Failed to read config for service")
+	}
+	service := serviceBase.NewServiceBase()
+	if service == nil {
+		t.Fatal("Expected non-nil serviceBase")
+	}
+
+	configuration := viper.New()
+	configuration.Set(constants.JOURNAL_PARTITION_NAME, "memory")
+	subscriptions, err := resourceStore.NewMemoryResourceStore[webhooks.SubscriptionResource](configuration, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating MemoryResourceStore: %v", err)
+	}
+	deadLetters, err := resourceStore.NewMemoryResourceStore[webhooks.DeadLetterResource](configuration, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating MemoryResourceStore: %v", err)
+	}
+	checkpointDir := t.TempDir()
+	checkpoints := func(subscriptionId string) (journalClient.CheckpointStore, error) {
+		return journalClient.NewFileCheckpointStore(filepath.Join(checkpointDir, subscriptionId))
+	}
+	store := newMemoryStore(t)
+	createEmployees(t, store, "Before") // written before the subscriptions, so never delivered
+
+	policy := webhooks.DeliveryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Timeout: time.Minute, PollInterval: 5 * time.Millisecond}
+	dispatcher, err := webhooks.NewDispatcher(store, subscriptions, deadLetters, checkpoints, policy, logrus.New())
+	if err != nil {
+		t.Fatalf("Error creating dispatcher: %v", err)
+	}
+	if helpers.NewWebhookRouterWithDispatcher(service, dispatcher, security.NO_REALM, security.NO_AUTH, security.NO_EXPIRY, nil) == nil {
+		t.Fatal("Expected non-nil WebhookRouter")
+	}
+
+	// the subscriber has to be a URL
+	body, _ := json.Marshal(webhooks.SubscriptionResource{Subscription: webhooks.Subscription{Url: "not a url"}})
+	if response := conditionalCall(service, http.MethodPost, "/v1/webhooks/subscriptions", body); response.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422 for an invalid URL, got %d: %s", response.Code, response.Body.String())
+	}
+
+	subscribe := func(receiver *webhookReceiver) webhooks.SubscriptionResource {
+		server := httptest.NewServer(receiver)
+		t.Cleanup(server.Close)
+		body, _ := json.Marshal(webhooks.SubscriptionResource{Subscription: webhooks.Subscription{Url: server.URL}})
+		response := conditionalCall(service, http.MethodPost, "/v1/webhooks/subscriptions", body)
+		var subscription webhooks.SubscriptionResource
+		if err := json.Unmarshal(response.Body.Bytes(), &subscription); err != nil || response.Code != http.StatusCreated || subscription.Subscription.Secret == "" {
+			t.Fatalf("Expected the subscription to be created with a secret, got %d: %s", response.Code, response.Body.String())
+		}
+		receiver.setSecret(subscription.Subscription.Secret)
+		return subscription
+	}
+	// one subscriber recovers after two failures, the other hangs and then fails until it is fixed below
+	healthy := &webhookReceiver{deliveries: map[string]bool{}}
+	healthy.failures.Store(2)
+	healthySubscription := subscribe(healthy)
+	broken := &webhookReceiver{deliveries: map[string]bool{}, hold: make(chan struct{})}
+	broken.failures.Store(1000)
+	subscribe(broken)
+
+	createEmployees(t, store, "Alice", "Bob", "Carol")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- dispatcher.Run(ctx) }()
+
+	waitFor := func(description string, done func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if done() {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for %s", description)
+	}
+	waitFor("the first deliveries", func() bool { return len(healthy.received()) == 3 })
+	createEmployees(t, store, "Dave")
+	// the hung subscriber doesn't hold up the healthy one
+	waitFor("Dave to be delivered", func() bool { return len(healthy.received()) == 4 })
+
+	close(broken.hold)
+	waitFor("the dead letters", func() bool {
+		var dead []webhooks.DeadLetterResource
+		deadLetters.GetByOwnerId(context.Background(), webhooks.WEBHOOK_OWNER_ID, &dead)
+		return len(dead) == 4
+	})
+
+	// rotating the secret restarts the worker with the new one
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		t.Fatalf("Error creating a secret: %v", err)
+	}
+	healthySubscription.Subscription.Secret = secret
+	if _, status, err := subscriptions.UpdateResource(context.Background(), &healthySubscription, webhooks.WEBHOOK_OWNER_ID, healthySubscription.Id, "1234:"); status != constants.RESOURCE_OK_CODE {
+		t.Fatalf("Error updating the subscription: %d, %v", status, err)
+	}
+	time.Sleep(20 * policy.PollInterval) // for Run to see the new version
+	healthy.setSecret(secret)
+	createEmployees(t, store, "Erin")
+	waitFor("Erin to be delivered with the new secret", func() bool { return len(healthy.received()) == 5 })
+	waitFor("the dead letter of Erin", func() bool {
+		var dead []webhooks.DeadLetterResource
+		deadLetters.GetByOwnerId(context.Background(), webhooks.WEBHOOK_OWNER_ID, &dead)
+		return len(dead) == 5
+	})
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Run to return context.Canceled, got %v", err)
+	}
+
+	expected := []string{"Alice", "Bob", "Carol", "Dave", "Erin"}
+	received := healthy.received()
+	if len(received) != len(expected) {
+		t.Fatalf("Expected %v to be delivered, got %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("Expected %v to be delivered in order, got %v", expected, received)
+		}
+	}
+
+	// the secrets aren't returned once the subscriptions exist
+	response := conditionalCall(service, http.MethodGet, "/v1/webhooks/subscriptions", nil)
+	var listed []webhooks.SubscriptionResource
+	if err := json.Unmarshal(response.Body.Bytes(), &listed); err != nil || len(listed) != 2 || listed[0].Subscription.Secret != "" || listed[1].Subscription.Secret != "" {
+		t.Fatalf("Expected two subscriptions without secrets, got %d: %s", response.Code, response.Body.String())
+	}
+
+	// every entry was dead-lettered for the broken subscriber
+	response = conditionalCall(service, http.MethodGet, "/v1/webhooks/deadLetters", nil)
+	var dead []webhooks.DeadLetterResource
+	if err := json.Unmarshal(response.Body.Bytes(), &dead); err != nil || len(dead) != 5 {
+		t.Fatalf("Expected five dead letters, got %d: %s", response.Code, response.Body.String())
+	}
+	if dead[0].DeadLetter.Attempts != policy.MaxAttempts || dead[0].DeadLetter.LastStatus != http.StatusInternalServerError {
+		t.Fatalf("Expected the dead letter to record %d attempts ending in a 500, got %+v", policy.MaxAttempts, dead[0].DeadLetter)
+	}
+
+	redeliver := "/v1/webhooks/deadLetters/" + dead[0].Id + "/redeliver"
+	if response := conditionalCall(service, http.MethodPost, redeliver, nil); response.Code != http.StatusBadGateway {
+		t.Fatalf("Expected 502 redelivering to the broken subscriber, got %d: %s", response.Code, response.Body.String())
+	}
+	broken.failures.Store(0)
+	if response := conditionalCall(service, http.MethodPost, redeliver, nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 redelivering to the fixed subscriber, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := conditionalCall(service, http.MethodDelete, "/v1/webhooks/deadLetters/"+dead[1].Id, nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 discarding a dead letter, got %d: %s", response.Code, response.Body.String())
+	}
+	var remaining []webhooks.DeadLetterResource
+	deadLetters.GetByOwnerId(context.Background(), webhooks.WEBHOOK_OWNER_ID, &remaining)
+	if len(remaining) != 3 || len(broken.received()) != 1 {
+		t.Fatalf("Expected three dead letters to remain and one redelivery, got %+v and %v", remaining, broken.received())
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"clock": 1}`)
+	header := webhooks.Sign("secret", time.Now(), body)
+	if err := webhooks.VerifySignature("secret", header, body, time.Minute); err != nil {
+		t.Fatalf("Expected the signature to verify, got %v", err)
+	}
+
+	old := webhooks.Sign("secret", time.Now().Add(-time.Hour), body)
+	for name, check := range map[string]error{
+		"tampered body": webhooks.VerifySignature("secret", header, []byte(`{"clock": 2}`), time.Minute),
+		"wrong secret":  webhooks.VerifySignature("other", header, body, time.Minute),
+		"expired":       webhooks.VerifySignature("secret", old, body, time.Minute),
+		"malformed":     webhooks.VerifySignature("secret", "v1=abc", body, time.Minute),
+	} {
+		if !errors.Is(check, webhooks.ErrInvalidSignature) {
+			t.Fatalf("%s: expected ErrInvalidSignature, got %v", name, check)
+		}
+	}
+	if err := webhooks.VerifySignature("secret", old, body, 0); err != nil {
+		t.Fatalf("Expected an old signature to verify without a tolerance, got %v", err)
+	}
+}
+
+func TestDeliveryPolicyFromConfig(t *testing.T) {
+	configuration := viper.New()
+	policy, err := webhooks.DeliveryPolicyFromConfig(configuration)
+	if err != nil || policy.MaxAttempts != webhooks.WEBHOOK_DEFAULT_MAX_ATTEMPTS || policy.Timeout != webhooks.WEBHOOK_DEFAULT_TIMEOUT || policy.PollInterval != webhooks.WEBHOOK_DEFAULT_POLL_INTERVAL {
+		t.Fatalf("Expected the defaults, got %+v, %v", policy, err)
+	}
+
+	configuration.Set(constants.WEBHOOK_MAX_ATTEMPTS, "8")
+	configuration.Set(constants.WEBHOOK_INITIAL_BACKOFF, "100ms")
+	configuration.Set(constants.WEBHOOK_MAX_BACKOFF, "300ms")
+	policy, err = webhooks.DeliveryPolicyFromConfig(configuration)
+	if err != nil || policy.MaxAttempts != 8 {
+		t.Fatalf("Expected 8 attempts, got %+v, %v", policy, err)
+	}
+	for retry, expected := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: 300 * time.Millisecond} {
+		// jittered between half and all of the exponential backoff
+		if backoff := policy.Backoff(retry); backoff < expected/2 || backoff > expected {
+			t.Fatalf("Expected retry %d to back off between %v and %v, got %v", retry, expected/2, expected, backoff)
+		}
+	}
+
+	for _, invalid := range []map[string]string{
+		{constants.WEBHOOK_MAX_ATTEMPTS: "0"},
+		{constants.WEBHOOK_TIMEOUT: "later"},
+		{constants.WEBHOOK_INITIAL_BACKOFF: "2s", constants.WEBHOOK_MAX_BACKOFF: "1s"},
+	} {
+		configuration := viper.New()
+		for key, value := range invalid {
+			configuration.Set(key, value)
+		}
+		if _, err := webhooks.DeliveryPolicyFromConfig(configuration); err == nil {
+			t.Fatalf("Expected an error for %v", invalid)
+		}
+	}
+}